@@ -0,0 +1,293 @@
+package audit
+
+import (
+	"billow-backend/config"
+	"billow-backend/mailer"
+	"billow-backend/models"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Actions recorded in the audit log
+const (
+	Login               = "auth.login"
+	Signup              = "auth.signup"
+	AccountDeleted      = "account.deleted"
+	ProfileUpdated      = "profile.updated"
+	PreferencesUpdated  = "preferences.updated"
+	SubscriptionChanged = "subscription.changed" // by the user
+	SubscriptionUpdated = "subscription.updated" // by the lifecycle worker or the billing provider
+	ClientUpdated       = "client.updated"
+	ClientDeleted       = "client.deleted"
+	InvoiceDeleted      = "invoice.deleted"
+	InvoiceShared       = "invoice.shared"
+	InvoiceUnshared     = "invoice.unshared"
+	PaymentRecorded     = "payment.recorded"
+	PlanChanged         = "plan.changed"
+	EntitlementChanged  = "entitlement.changed"
+)
+
+// highRisk actions trigger a security alert email when the user has alerts enabled
+var highRisk = map[string]bool{
+	AccountDeleted:      true,
+	SubscriptionChanged: true,
+	ClientDeleted:       true,
+	InvoiceDeleted:      true,
+}
+
+// ignoredFields never show up in a diff. Relationships are recorded by their
+// foreign keys rather than as nested objects.
+var ignoredFields = map[string]bool{
+	"created_at":   true,
+	"updated_at":   true,
+	"user":         true,
+	"client":       true,
+	"plan":         true,
+	"subscription": true,
+	"preferences":  true,
+	"invoices":     true,
+	"clients":      true,
+	"usage_logs":   true,
+}
+
+// Entry describes one change to record
+type Entry struct {
+	UserID     string // tenant; defaults to the authenticated user
+	ActorID    string // defaults to the authenticated user
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     interface{} // record before the change, nil for creations
+	After      interface{} // record after the change, nil for deletions
+}
+
+// Record appends an audit event for the current request. Failures are logged
+// and never fail the request itself: in a transaction the event is written
+// under a savepoint, so a failed insert doesn't abort the request's own
+// writes. Security alerts are then held until Committed is called so a rolled
+// back change alerts no one.
+func Record(c *fiber.Ctx, db *gorm.DB, entry Entry) {
+	userID, _ := c.Locals("user_id").(string)
+	if entry.UserID == "" {
+		entry.UserID = userID
+	}
+	if entry.ActorID == "" {
+		entry.ActorID = userID
+	}
+
+	a := write(db, entry, ClientIP(c), c.Get(fiber.HeaderUserAgent))
+	if a == nil {
+		return
+	}
+	if inTransaction(db) {
+		held, _ := c.Locals(heldAlerts).([]*alert)
+		c.Locals(heldAlerts, append(held, a))
+		return
+	}
+	a.send()
+}
+
+// Committed sends the security alerts held back while the request's
+// transaction was open. Alerts of a request that rolls back are never sent.
+func Committed(c *fiber.Ctx) {
+	held, _ := c.Locals(heldAlerts).([]*alert)
+	c.Locals(heldAlerts, nil)
+	for _, a := range held {
+		a.send()
+	}
+}
+
+// RecordSystem appends an audit event for a change made by a background job
+// rather than a request. ActorID defaults to "system". No security alert is
+// sent: the change wasn't made by someone signing in to the account.
+func RecordSystem(db *gorm.DB, entry Entry) {
+	if entry.ActorID == "" {
+		entry.ActorID = "system"
+	}
+	write(db, entry, "", "")
+}
+
+// write stores the event and returns the security alert it calls for, if any
+func write(db *gorm.DB, entry Entry, ip, userAgent string) *alert {
+	before, after := Diff(entry.Before, entry.After)
+
+	event := models.AuditEvent{
+		ID:         models.GenerateAuditEventID(),
+		UserID:     entry.UserID,
+		ActorID:    entry.ActorID,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Risk:       "low",
+		IP:         ip,
+		UserAgent:  userAgent,
+		Before:     before,
+		After:      after,
+	}
+	if highRisk[entry.Action] {
+		event.Risk = "high"
+	}
+
+	create := func(tx *gorm.DB) error { return tx.Create(&event).Error }
+	var err error
+	if inTransaction(db) {
+		// Postgres aborts a transaction on any failed statement; rolling back
+		// to the savepoint keeps the caller's transaction usable
+		err = db.Transaction(create)
+	} else {
+		err = create(db)
+	}
+	if err != nil {
+		fmt.Printf("Error recording audit event %s: %v\n", entry.Action, err)
+		return nil
+	}
+
+	if event.Risk != "high" {
+		return nil
+	}
+	// Looked up now, while the user still exists when the event is the
+	// deletion of their account
+	to := alertRecipient(event.UserID)
+	if to == "" {
+		return nil
+	}
+	return &alert{event: event, to: to}
+}
+
+func inTransaction(db *gorm.DB) bool {
+	_, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok
+}
+
+// Diff reduces two versions of a record to the JSON fields that differ. Either
+// side may be nil, in which case every field of the other side is kept.
+func Diff(before, after interface{}) (string, string) {
+	b := toMap(before)
+	a := toMap(after)
+
+	changedBefore := map[string]interface{}{}
+	changedAfter := map[string]interface{}{}
+	for key, value := range b {
+		if ignoredFields[key] {
+			continue
+		}
+		if other, ok := a[key]; !ok || !reflect.DeepEqual(value, other) {
+			changedBefore[key] = value
+		}
+	}
+	for key, value := range a {
+		if ignoredFields[key] {
+			continue
+		}
+		if other, ok := b[key]; !ok || !reflect.DeepEqual(value, other) {
+			changedAfter[key] = value
+		}
+	}
+
+	return encode(before, changedBefore), encode(after, changedAfter)
+}
+
+func toMap(v interface{}) map[string]interface{} {
+	m := map[string]interface{}{}
+	if v == nil {
+		return m
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return m
+	}
+	json.Unmarshal(data, &m)
+	return m
+}
+
+func encode(original interface{}, fields map[string]interface{}) string {
+	if original == nil || len(fields) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// ClientIP returns the address of the client. When the request comes through
+// one of the app's trusted proxies (fiber.Config.TrustedProxies) it is the
+// last X-Forwarded-For entry not added by a trusted proxy; earlier entries are
+// whatever the client sent and can't be relied on.
+func ClientIP(c *fiber.Ctx) string {
+	config := c.App().Config()
+	peer := c.Context().RemoteIP().String()
+	if !config.EnableTrustedProxyCheck || !c.IsProxyTrusted() {
+		return peer
+	}
+
+	ips := c.IPs()
+	for i := len(ips) - 1; i >= 0; i-- {
+		if !trustedProxy(config.TrustedProxies, ips[i]) {
+			return ips[i]
+		}
+	}
+	if len(ips) > 0 {
+		return ips[0]
+	}
+	return peer
+}
+
+// trustedProxy reports whether ip is one of proxies, given as addresses or
+// CIDR ranges
+func trustedProxy(proxies []string, ip string) bool {
+	addr := net.ParseIP(ip)
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if addr != nil && network.Contains(addr) {
+				return true
+			}
+		} else if proxy == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// heldAlerts is the context key of the alerts waiting for Committed
+const heldAlerts = "audit_alerts"
+
+// alert is a security alert email about one event
+type alert struct {
+	event models.AuditEvent
+	to    string
+}
+
+// alertRecipient returns the address of a user who has security alerts
+// enabled, or "" when they don't
+var alertRecipient = func(userID string) string {
+	var preferences models.UserPreferences
+	if err := config.DB.First(&preferences, "user_id = ?", userID).Error; err != nil || !preferences.SecurityAlerts {
+		return ""
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return ""
+	}
+	return user.Email
+}
+
+func (a *alert) send() {
+	event := a.event
+	mailer.SendAsync(mailer.Message{
+		To:      []string{a.to},
+		Subject: "Security alert: " + event.Action,
+		Body: fmt.Sprintf("A sensitive change was made to your Billow account.\n\n"+
+			"Action: %s\nTarget: %s %s\nIP address: %s\nDevice: %s\nTime: %s\n\n"+
+			"If this wasn't you, please secure your account immediately.\n",
+			event.Action, event.TargetType, event.TargetID, event.IP, event.UserAgent,
+			event.CreatedAt.UTC().Format("2006-01-02 15:04:05 UTC")),
+	})
+}
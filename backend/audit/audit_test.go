@@ -0,0 +1,215 @@
+package audit
+
+import (
+	"billow-backend/mailer"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestDiff(t *testing.T) {
+	type client struct {
+		Name      string    `json:"name"`
+		Email     string    `json:"email"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	before := client{Name: "Acme", Email: "old@acme.test", UpdatedAt: time.Unix(1, 0)}
+	after := client{Name: "Acme", Email: "new@acme.test", UpdatedAt: time.Unix(2, 0)}
+
+	b, a := Diff(before, after)
+	if b != `{"email":"old@acme.test"}` || a != `{"email":"new@acme.test"}` {
+		t.Errorf("Diff = %s, %s", b, a)
+	}
+	if b, a := Diff(nil, after); b != "" || a != `{"email":"new@acme.test","name":"Acme"}` {
+		t.Errorf("Diff of a creation = %q, %q", b, a)
+	}
+	if b, a := Diff(before, before); b != "" || a != "" {
+		t.Errorf("Diff of an unchanged record = %q, %q", b, a)
+	}
+}
+
+// pool stands in for the database connection; in dry-run mode no query reaches it
+type pool struct{ gorm.ConnPool }
+
+// txPool is a pool inside a transaction
+type txPool struct{ pool }
+
+func (txPool) Commit() error   { return nil }
+func (txPool) Rollback() error { return nil }
+
+func dryRun(t *testing.T, conn gorm.ConnPool) *gorm.DB {
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+type captureMailer chan mailer.Message
+
+func (m captureMailer) Send(msg mailer.Message) error {
+	m <- msg
+	return nil
+}
+
+// captureAlerts collects the alert emails sent during the test. Every user
+// has alerts enabled.
+func captureAlerts(t *testing.T) captureMailer {
+	sent := make(captureMailer, 10)
+	defaultMailer, defaultRecipient := mailer.Default, alertRecipient
+	mailer.Default = sent
+	alertRecipient = func(userID string) string { return userID + "@example.com" }
+	t.Cleanup(func() { mailer.Default, alertRecipient = defaultMailer, defaultRecipient })
+	return sent
+}
+
+func TestAlertsWaitForCommit(t *testing.T) {
+	sent := captureAlerts(t)
+
+	db, tx := dryRun(t, pool{}), dryRun(t, txPool{})
+	held := func(c *fiber.Ctx) int {
+		alerts, _ := c.Locals(heldAlerts).([]*alert)
+		return len(alerts)
+	}
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		Record(c, tx, Entry{UserID: "owner", Action: ClientDeleted, TargetType: "client", TargetID: "CLI-1"})
+		Record(c, tx, Entry{UserID: "owner", Action: ClientUpdated, TargetType: "client", TargetID: "CLI-2"})
+		if n := held(c); n != 1 {
+			t.Errorf("%d alerts held inside the transaction, want 1", n)
+		}
+		Committed(c)
+		if n := held(c); n != 0 {
+			t.Errorf("%d alerts still held after commit", n)
+		}
+
+		Record(c, db, Entry{UserID: "owner", Action: InvoiceDeleted, TargetType: "invoice", TargetID: "INV-1"})
+		if n := held(c); n != 0 {
+			t.Errorf("alert held without a transaction")
+		}
+		return nil
+	})
+	app.Get("/rollback", func(c *fiber.Ctx) error {
+		Record(c, tx, Entry{UserID: "owner", Action: AccountDeleted, TargetType: "user", TargetID: "owner"})
+		return nil
+	})
+
+	for _, path := range []string{"/", "/rollback"} {
+		if _, err := app.Test(httptest.NewRequest("GET", path, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var subjects []string
+	for len(subjects) < 2 {
+		select {
+		case msg := <-sent:
+			if len(msg.To) != 1 || msg.To[0] != "owner@example.com" {
+				t.Errorf("alert sent to %v", msg.To)
+			}
+			subjects = append(subjects, msg.Subject)
+		case <-time.After(time.Second):
+			t.Fatalf("alerts sent: %v, want 2", subjects)
+		}
+	}
+	select {
+	case msg := <-sent:
+		subjects = append(subjects, msg.Subject)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := strings.Join(subjects, ", "); strings.Count(got, ClientDeleted) != 1 || strings.Count(got, InvoiceDeleted) != 1 || len(subjects) != 2 {
+		t.Errorf("alerts sent: %s", got)
+	}
+}
+
+// A failed insert rolls back to its savepoint rather than aborting the
+// request's transaction, and alerts no one
+func TestFailedRecordKeepsTransaction(t *testing.T) {
+	sent := captureAlerts(t)
+	tx := dryRun(t, txPool{})
+	var statements []string
+	tx.Callback().Raw().After("gorm:raw").Register("test:capture", func(db *gorm.DB) {
+		statements = append(statements, db.Statement.SQL.String())
+	})
+	tx.Callback().Create().Before("gorm:create").Register("test:fail", func(db *gorm.DB) {
+		db.AddError(errors.New("insert failed"))
+	})
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		c.Locals("user_id", "owner")
+		Record(c, tx, Entry{Action: InvoiceDeleted, TargetType: "invoice", TargetID: "INV-1"})
+		Committed(c)
+		return nil
+	})
+	if _, err := app.Test(httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Join(statements, "; ")
+	if !strings.Contains(got, "SAVEPOINT") || !strings.Contains(got, "ROLLBACK TO SAVEPOINT") {
+		t.Errorf("statements = %q, want the insert rolled back to a savepoint", got)
+	}
+	if tx.Error != nil {
+		t.Errorf("transaction error = %v", tx.Error)
+	}
+	select {
+	case msg := <-sent:
+		t.Errorf("alert sent for an event that wasn't recorded: %s", msg.Subject)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRecordSystemDoesNotAlert(t *testing.T) {
+	sent := captureAlerts(t)
+	RecordSystem(dryRun(t, pool{}), Entry{UserID: "owner", Action: InvoiceDeleted, TargetType: "invoice", TargetID: "INV-1"})
+
+	select {
+	case msg := <-sent:
+		t.Errorf("background change sent an alert: %s", msg.Subject)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		header  string
+		want    string
+	}{
+		{"no proxy configured", nil, "203.0.113.7", "0.0.0.0"},
+		{"peer isn't a trusted proxy", []string{"10.0.0.0/8"}, "203.0.113.7", "0.0.0.0"},
+		{"trusted proxy", []string{"0.0.0.0"}, "203.0.113.7", "203.0.113.7"},
+		{"entries sent by the client are skipped", []string{"0.0.0.0"}, "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"proxy chain", []string{"0.0.0.0", "10.0.0.0/8"}, "198.51.100.1, 203.0.113.7, 10.1.2.3", "203.0.113.7"},
+		{"no header", []string{"0.0.0.0"}, "", "0.0.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{EnableTrustedProxyCheck: tt.proxies != nil, TrustedProxies: tt.proxies})
+			var got string
+			app.Get("/", func(c *fiber.Ctx) error {
+				got = ClientIP(c)
+				return nil
+			})
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set(fiber.HeaderXForwardedFor, tt.header)
+			}
+			if _, err := app.Test(req); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
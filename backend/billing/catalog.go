@@ -0,0 +1,292 @@
+package billing
+
+import (
+	"billow-backend/entitlements"
+	"billow-backend/models"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// defaultCatalog is used when PLAN_CATALOG doesn't point at a catalog file
+//
+//go:embed catalog.json
+var defaultCatalog []byte
+
+// Catalog change actions
+const (
+	CatalogCreate    = "create"    // version is in the file but not the database
+	CatalogUpdate    = "update"    // name, provider price or retirement changed
+	CatalogConflict  = "conflict"  // version exists with different pricing or entitlements
+	CatalogUntracked = "untracked" // version exists only in the database
+)
+
+// PlanDefinition declares one version of a plan in the catalog file.
+// Versions are immutable: changing a plan's price, limits or features means
+// adding a new version, and subscribers stay on the version they bought.
+type PlanDefinition struct {
+	Code            string         `json:"code"`
+	Version         int            `json:"version"`
+	Name            string         `json:"name"`
+	Price           float64        `json:"price"`
+	Currency        string         `json:"currency"`
+	Interval        string         `json:"interval"`
+	InvoiceLimit    int            `json:"invoice_limit"`
+	ClientLimit     int            `json:"client_limit"`
+	MessagesPerDay  int            `json:"messages_per_day"`
+	Features        []string       `json:"features"`
+	Meters          []models.Meter `json:"meters"`
+	ProviderPriceID string         `json:"provider_price_id"`
+	Retired         bool           `json:"retired"`
+}
+
+// Plan builds the plan row for the definition
+func (d PlanDefinition) Plan() (models.Plan, error) {
+	plan := models.Plan{
+		ID:              models.PlanVersionID(d.Code, d.Version),
+		Code:            d.Code,
+		Version:         d.Version,
+		Retired:         d.Retired,
+		Name:            d.Name,
+		Price:           d.Price,
+		Currency:        d.Currency,
+		Interval:        d.Interval,
+		InvoiceLimit:    d.InvoiceLimit,
+		ClientLimit:     d.ClientLimit,
+		MessagesPerDay:  d.MessagesPerDay,
+		ProviderPriceID: d.ProviderPriceID,
+	}
+	if plan.Currency == "" {
+		plan.Currency = "USD"
+	}
+	if err := plan.SetMeters(d.Meters); err != nil {
+		return plan, err
+	}
+	return plan, plan.SetFeatures(d.Features)
+}
+
+// Validate checks a definition before it is applied
+func (d PlanDefinition) Validate() error {
+	switch {
+	case d.Code == "":
+		return errors.New("plan code is required")
+	case d.Version < 1:
+		return fmt.Errorf("%s: version must be 1 or more", d.Code)
+	case d.Name == "":
+		return fmt.Errorf("%s v%d: name is required", d.Code, d.Version)
+	case d.Price < 0:
+		return fmt.Errorf("%s v%d: price can't be negative", d.Code, d.Version)
+	case d.Interval != "month" && d.Interval != "year":
+		return fmt.Errorf("%s v%d: interval must be month or year", d.Code, d.Version)
+	}
+	if _, err := d.Plan(); err != nil {
+		return fmt.Errorf("%s v%d: %w", d.Code, d.Version, err)
+	}
+	for _, meter := range d.Meters {
+		if !entitlements.IsQuota(meter.Quota) {
+			return fmt.Errorf("%s v%d: meter %s has unknown quota %q", d.Code, d.Version, meter.FeatureType, meter.Quota)
+		}
+	}
+	return nil
+}
+
+// CatalogChange is one difference between the catalog file and the database
+type CatalogChange struct {
+	Action  string   `json:"action"`
+	PlanID  string   `json:"plan_id"`
+	Code    string   `json:"code"`
+	Version int      `json:"version"`
+	Fields  []string `json:"fields,omitempty"`
+}
+
+// LoadCatalog reads plan definitions from path, or the built-in catalog when
+// path is empty
+func LoadCatalog(path string) ([]PlanDefinition, error) {
+	data := defaultCatalog
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var file struct {
+		Plans []PlanDefinition `json:"plans"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing plan catalog: %w", err)
+	}
+
+	seen := map[string]bool{}
+	for _, def := range file.Plans {
+		if err := def.Validate(); err != nil {
+			return nil, err
+		}
+		id := models.PlanVersionID(def.Code, def.Version)
+		if seen[id] {
+			return nil, fmt.Errorf("%s v%d is defined twice", def.Code, def.Version)
+		}
+		seen[id] = true
+	}
+	return file.Plans, nil
+}
+
+// DiffCatalog compares the definitions with the plans in the database
+func DiffCatalog(db *gorm.DB, defs []PlanDefinition) ([]CatalogChange, error) {
+	var existing []models.Plan
+	if err := db.Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	byID := map[string]models.Plan{}
+	for _, plan := range existing {
+		byID[plan.ID] = plan
+	}
+
+	changes := []CatalogChange{}
+	declared := map[string]bool{}
+	for _, def := range defs {
+		want, err := def.Plan()
+		if err != nil {
+			return nil, err
+		}
+		declared[want.ID] = true
+
+		change := CatalogChange{PlanID: want.ID, Code: def.Code, Version: def.Version}
+		have, ok := byID[want.ID]
+		if !ok {
+			change.Action = CatalogCreate
+			changes = append(changes, change)
+			continue
+		}
+
+		if fields := planDifferences(have, want, versionedFields); len(fields) > 0 {
+			change.Action = CatalogConflict
+			change.Fields = fields
+			changes = append(changes, change)
+			continue
+		}
+		if fields := planDifferences(have, want, mutableFields); len(fields) > 0 {
+			change.Action = CatalogUpdate
+			change.Fields = fields
+			changes = append(changes, change)
+		}
+	}
+
+	for _, plan := range existing {
+		if !declared[plan.ID] {
+			changes = append(changes, CatalogChange{Action: CatalogUntracked, PlanID: plan.ID, Code: plan.Code, Version: plan.Version})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].PlanID < changes[j].PlanID })
+	return changes, nil
+}
+
+// ApplyCatalog creates missing plan versions and applies changes that don't
+// affect what subscribers pay for. Conflicting versions are left alone and
+// reported, since changing them would alter existing subscriptions.
+func ApplyCatalog(db *gorm.DB, defs []PlanDefinition) ([]CatalogChange, error) {
+	var changes []CatalogChange
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Plans from before versioning are the first version of themselves
+		if err := tx.Model(&models.Plan{}).Where("code = '' OR code IS NULL").
+			Updates(map[string]interface{}{"code": gorm.Expr("id"), "version": 1}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		if changes, err = DiffCatalog(tx, defs); err != nil {
+			return err
+		}
+
+		plans := map[string]models.Plan{}
+		for _, def := range defs {
+			plan, err := def.Plan()
+			if err != nil {
+				return err
+			}
+			plans[plan.ID] = plan
+		}
+
+		for _, change := range changes {
+			plan := plans[change.PlanID]
+			switch change.Action {
+			case CatalogCreate:
+				err = tx.Create(&plan).Error
+			case CatalogUpdate:
+				err = tx.Model(&models.Plan{}).Where("id = ?", plan.ID).Updates(map[string]interface{}{
+					"name":              plan.Name,
+					"provider_price_id": plan.ProviderPriceID,
+					"retired":           plan.Retired,
+				}).Error
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return changes, err
+}
+
+// CreatePlanVersion adds the next version of a plan, or its first version
+// when the code is new. Subscribers of earlier versions keep them.
+func CreatePlanVersion(db *gorm.DB, def PlanDefinition) (models.Plan, error) {
+	var latest models.Plan
+	err := db.Where("code = ?", def.Code).Order("version DESC").Limit(1).Find(&latest).Error
+	if err != nil {
+		return models.Plan{}, err
+	}
+	def.Version = latest.Version + 1
+	if err := def.Validate(); err != nil {
+		return models.Plan{}, err
+	}
+
+	plan, err := def.Plan()
+	if err != nil {
+		return models.Plan{}, err
+	}
+	if err := db.Create(&plan).Error; err != nil {
+		return models.Plan{}, err
+	}
+	return plan, nil
+}
+
+// Offered limits a plan query to what new subscribers can choose: the latest
+// version of each plan that hasn't been retired
+func Offered(db *gorm.DB) *gorm.DB {
+	return db.Where("retired = ? AND version = (SELECT MAX(p.version) FROM plans p WHERE p.code = plans.code AND p.retired = ?)", false, false)
+}
+
+// IsOffered reports whether plan can be chosen by new subscribers
+func IsOffered(db *gorm.DB, plan models.Plan) (bool, error) {
+	var count int64
+	err := db.Model(&models.Plan{}).Scopes(Offered).Where("id = ?", plan.ID).Count(&count).Error
+	return count > 0, err
+}
+
+// versionedFields can only change by adding a new version
+var versionedFields = []string{
+	"Price", "Currency", "Interval", "InvoiceLimit", "ClientLimit", "MessagesPerDay",
+	"ImageGeneration", "CustomVoice", "PrioritySupport", "AdvancedAnalytics", "APIAccess", "WhiteLabel", "Meters",
+}
+
+// mutableFields can change in place
+var mutableFields = []string{"Name", "ProviderPriceID", "Retired"}
+
+func planDifferences(have, want models.Plan, fields []string) []string {
+	h, w := reflect.ValueOf(have), reflect.ValueOf(want)
+	var differences []string
+	for _, field := range fields {
+		if !reflect.DeepEqual(h.FieldByName(field).Interface(), w.FieldByName(field).Interface()) {
+			differences = append(differences, field)
+		}
+	}
+	return differences
+}
@@ -0,0 +1,76 @@
+package billing
+
+import (
+	"billow-backend/models"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDefaultCatalogIsValid(t *testing.T) {
+	defs, err := LoadCatalog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) == 0 {
+		t.Fatal("built-in catalog has no plans")
+	}
+
+	for _, def := range defs {
+		plan, err := def.Plan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if models.BasePlanID(plan.ID) == "" {
+			t.Errorf("%s has no base plan", plan.ID)
+		}
+	}
+}
+
+func TestLoadCatalogRejectsInvalidPlans(t *testing.T) {
+	tests := map[string]string{
+		"unknown feature":     `{"plans":[{"code":"PLN-X","version":1,"name":"X","interval":"month","features":["teleport"]}]}`,
+		"bad interval":        `{"plans":[{"code":"PLN-X","version":1,"name":"X","interval":"week"}]}`,
+		"missing version":     `{"plans":[{"code":"PLN-X","name":"X","interval":"month"}]}`,
+		"unknown meter quota": `{"plans":[{"code":"PLN-X","version":1,"name":"X","interval":"month","meters":[{"feature_type":"message_sent","quota":"pigeons","aggregation":"day"}]}]}`,
+		"bad aggregation":     `{"plans":[{"code":"PLN-X","version":1,"name":"X","interval":"month","meters":[{"feature_type":"message_sent","quota":"messages_per_day","aggregation":"week"}]}]}`,
+		"duplicate":           `{"plans":[{"code":"PLN-X","version":1,"name":"X","interval":"month"},{"code":"PLN-X","version":1,"name":"X","interval":"month"}]}`,
+	}
+
+	for name, catalog := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "plans.json")
+			if err := os.WriteFile(path, []byte(catalog), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadCatalog(path); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestPlanVersions(t *testing.T) {
+	def := PlanDefinition{Code: "PLN-PRO-ANNUAL", Version: 3, Name: "Pro", Price: 290, Interval: "year", Features: []string{"api_access"}}
+	plan, err := def.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.ID != "PLN-PRO-ANNUAL-V3" || models.BasePlanID(plan.ID) != "PLN-PRO" {
+		t.Errorf("id = %s, base = %s", plan.ID, models.BasePlanID(plan.ID))
+	}
+	if !reflect.DeepEqual(plan.Features(), []string{"api_access"}) {
+		t.Errorf("features = %v", plan.Features())
+	}
+
+	changed := plan
+	changed.Price = 300
+	changed.Name = "Pro yearly"
+	if got := planDifferences(plan, changed, versionedFields); !reflect.DeepEqual(got, []string{"Price"}) {
+		t.Errorf("versioned differences = %v", got)
+	}
+	if got := planDifferences(plan, changed, mutableFields); !reflect.DeepEqual(got, []string{"Name"}) {
+		t.Errorf("mutable differences = %v", got)
+	}
+}
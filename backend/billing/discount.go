@@ -0,0 +1,119 @@
+package billing
+
+import (
+	"billow-backend/models"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reasons a promotion code can't be redeemed
+var (
+	ErrCodeNotFound      = errors.New("promotion code not found")
+	ErrCodeExpired       = errors.New("promotion code has expired")
+	ErrCodeExhausted     = errors.New("promotion code has been fully redeemed")
+	ErrCodeNotApplicable = errors.New("promotion code does not apply to this plan")
+	ErrAlreadyDiscounted = errors.New("subscription already has a discount")
+)
+
+// ActiveDiscount returns the discount on the subscription at now, or nil
+func ActiveDiscount(db *gorm.DB, subscriptionID string, now time.Time) (*models.Discount, error) {
+	var discounts []models.Discount
+	err := db.Preload("Coupon").
+		Where("subscription_id = ? AND starts_at <= ? AND (ends_at IS NULL OR ends_at > ?)", subscriptionID, now, now).
+		Order("created_at DESC").Limit(1).
+		Find(&discounts).Error
+	if err != nil || len(discounts) == 0 {
+		return nil, err
+	}
+	return &discounts[0], nil
+}
+
+// DiscountedPrice is the plan price after the coupon, never below zero.
+// Coupons restricted to other plans, or with an amount in another currency,
+// leave the price unchanged.
+func DiscountedPrice(plan models.Plan, coupon models.Coupon) float64 {
+	if !coupon.AppliesTo(plan.ID) {
+		return plan.Price
+	}
+
+	price := plan.Price * (1 - coupon.PercentOff/100)
+	if coupon.AmountOff > 0 && strings.EqualFold(coupon.Currency, plan.Currency) {
+		price -= coupon.AmountOff
+	}
+	return math.Max(0, roundMoney(price))
+}
+
+// Redeem applies a promotion code to the subscription, whose plan must be
+// loaded. The code and coupon rows are locked while their redemption counts
+// are checked and incremented, so limits hold under concurrent redemptions.
+// db should be a transaction.
+func Redeem(db *gorm.DB, sub models.Subscription, code string, now time.Time) (*models.Discount, error) {
+	var promo models.PromotionCode
+	err := db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&promo, "code = ? AND active = ?", strings.ToUpper(strings.TrimSpace(code)), true).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var coupon models.Coupon
+	if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&coupon, "id = ?", promo.CouponID).Error; err != nil {
+		return nil, err
+	}
+
+	switch {
+	case promo.ExpiresAt != nil && !now.Before(*promo.ExpiresAt),
+		coupon.RedeemBy != nil && !now.Before(*coupon.RedeemBy):
+		return nil, ErrCodeExpired
+	case promo.MaxRedemptions > 0 && promo.TimesRedeemed >= promo.MaxRedemptions,
+		coupon.MaxRedemptions > 0 && coupon.TimesRedeemed >= coupon.MaxRedemptions:
+		return nil, ErrCodeExhausted
+	case !coupon.AppliesTo(sub.PlanID),
+		coupon.AmountOff > 0 && !strings.EqualFold(coupon.Currency, sub.Plan.Currency):
+		return nil, ErrCodeNotApplicable
+	}
+
+	existing, err := ActiveDiscount(db, sub.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrAlreadyDiscounted
+	}
+
+	discount := models.Discount{
+		ID:              models.GenerateDiscountID(),
+		UserID:          sub.UserID,
+		SubscriptionID:  sub.ID,
+		CouponID:        coupon.ID,
+		PromotionCodeID: promo.ID,
+		StartsAt:        now,
+		Coupon:          coupon,
+	}
+	switch coupon.Duration {
+	case models.CouponOnce:
+		_, end := CurrentPeriod(sub, now)
+		discount.EndsAt = &end
+	case models.CouponRepeating:
+		end := now.AddDate(0, coupon.DurationInMonths, 0)
+		discount.EndsAt = &end
+	}
+
+	if err := db.Omit(clause.Associations).Create(&discount).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&promo).UpdateColumn("times_redeemed", gorm.Expr("times_redeemed + 1")).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&coupon).UpdateColumn("times_redeemed", gorm.Expr("times_redeemed + 1")).Error; err != nil {
+		return nil, err
+	}
+	return &discount, nil
+}
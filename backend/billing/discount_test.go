@@ -0,0 +1,34 @@
+package billing
+
+import (
+	"billow-backend/models"
+	"testing"
+)
+
+func TestDiscountedPrice(t *testing.T) {
+	pro := models.Plan{ID: "PLN-PRO", Price: 29, Currency: "USD"}
+	proAnnual := models.Plan{ID: "PLN-PRO-ANNUAL-V2", Price: 290, Currency: "USD"}
+
+	tests := []struct {
+		name   string
+		plan   models.Plan
+		coupon models.Coupon
+		want   float64
+	}{
+		{"percent off", pro, models.Coupon{PercentOff: 20}, 23.2},
+		{"amount off", pro, models.Coupon{AmountOff: 5, Currency: "USD"}, 24},
+		{"amount off never goes negative", pro, models.Coupon{AmountOff: 50, Currency: "USD"}, 0},
+		{"amount off in another currency is ignored", pro, models.Coupon{AmountOff: 5, Currency: "EUR"}, 29},
+		{"restricted to the plan", pro, models.Coupon{PercentOff: 50, PlanIDs: "PLN-STARTER, PLN-PRO"}, 14.5},
+		{"restriction covers the annual variant", proAnnual, models.Coupon{PercentOff: 50, PlanIDs: "PLN-PRO"}, 145},
+		{"restricted to another plan", pro, models.Coupon{PercentOff: 50, PlanIDs: "PLN-BUSINESS"}, 29},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiscountedPrice(tt.plan, tt.coupon); got != tt.want {
+				t.Errorf("DiscountedPrice = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
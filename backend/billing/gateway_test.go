@@ -0,0 +1,165 @@
+package billing
+
+import (
+	"billow-backend/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStripePaymentCheckout(t *testing.T) {
+	provider, requests := mockStripe(t)
+
+	session, err := provider.CreatePaymentCheckout(context.Background(), PaymentParams{
+		InvoiceID:  "INV-1",
+		UserID:     "USR-1",
+		Amount:     1250.5,
+		Currency:   "EUR",
+		Email:      "client@example.com",
+		SuccessURL: "https://app.test/paid",
+		CancelURL:  "https://app.test/cancel",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.URL != "https://checkout.test/cs_123" {
+		t.Errorf("url = %q", session.URL)
+	}
+
+	form := requests["POST /v1/checkout/sessions"]
+	want := map[string]string{
+		"mode":                                   "payment",
+		"line_items[0][price_data][currency]":    "eur",
+		"line_items[0][price_data][unit_amount]": "125050",
+		"metadata[invoice_id]":                   "INV-1",
+		"payment_intent_data[metadata][user_id]": "USR-1",
+		"customer_email":                         "client@example.com",
+	}
+	for key, value := range want {
+		if form[key] != value {
+			t.Errorf("%s = %q, want %q", key, form[key], value)
+		}
+	}
+}
+
+func TestStripePaymentWebhook(t *testing.T) {
+	provider := NewStripeProvider("sk_test", "whsec_test")
+
+	payload := func(eventType string, session map[string]interface{}) []byte {
+		data, _ := json.Marshal(session)
+		payload, _ := json.Marshal(map[string]interface{}{
+			"id": "evt_1", "type": eventType, "created": 1700000000,
+			"data": map[string]json.RawMessage{"object": data},
+		})
+		return payload
+	}
+	paid := map[string]interface{}{
+		"id": "cs_1", "mode": "payment", "payment_status": "paid", "payment_intent": "pi_1",
+		"amount_total": 125050, "currency": "eur",
+		"metadata": map[string]string{"invoice_id": "INV-1", "user_id": "USR-1"},
+	}
+
+	tests := []struct {
+		name      string
+		eventType string
+		session   map[string]interface{}
+		want      string
+	}{
+		{"paid checkout", "checkout.session.completed", paid, PaymentSucceeded},
+		{"delayed payment succeeds", "checkout.session.async_payment_succeeded", paid, PaymentSucceeded},
+		{"delayed payment fails", "checkout.session.async_payment_failed", paid, PaymentFailed},
+		{"still processing", "checkout.session.completed", map[string]interface{}{
+			"mode": "payment", "payment_status": "unpaid", "metadata": map[string]string{"invoice_id": "INV-1"},
+		}, ""},
+		{"subscription checkout", "checkout.session.completed", map[string]interface{}{
+			"mode": "subscription", "payment_status": "paid",
+		}, ""},
+		{"unrelated event", "invoice.paid", paid, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := payload(tt.eventType, tt.session)
+			event, err := provider.ParsePaymentWebhook(body, SignWebhook("whsec_test", body, time.Now()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.Type != tt.want {
+				t.Fatalf("type = %q, want %q", event.Type, tt.want)
+			}
+			if tt.want == PaymentSucceeded && (event.InvoiceID != "INV-1" || event.UserID != "USR-1" ||
+				event.Reference != "pi_1" || event.Amount != 1250.5 || event.Currency != "EUR" || event.Gateway != "stripe") {
+				t.Errorf("unexpected event %+v", event)
+			}
+		})
+	}
+
+	t.Run("subscription webhook ignores invoice payments", func(t *testing.T) {
+		body := payload(EventCheckoutCompleted, paid)
+		event, err := provider.ParseWebhook(body, SignWebhook("whsec_test", body, time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.UserID != "" || event.CustomerID != "" {
+			t.Errorf("unexpected event %+v", event)
+		}
+	})
+}
+
+func TestFakeGateway(t *testing.T) {
+	gateway := NewFakeGateway("secret")
+
+	session, err := gateway.CreatePaymentCheckout(context.Background(), PaymentParams{InvoiceID: "INV-1", Amount: 10})
+	if err != nil || session.URL == "" || len(gateway.Checkouts) != 1 {
+		t.Fatalf("session = %+v, err = %v", session, err)
+	}
+
+	body := []byte(`{"id":"evt_1","type":"payment.succeeded","invoice_id":"INV-1","reference":"pay_1","amount":10,"currency":"USD"}`)
+	event, err := gateway.ParsePaymentWebhook(body, gateway.Sign(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != PaymentSucceeded || event.InvoiceID != "INV-1" || event.Gateway != "fake" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	if _, err := gateway.ParsePaymentWebhook(body, NewFakeGateway("other").Sign(body)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("err = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestMinorUnits(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		units    int64
+	}{
+		{19.99, "USD", 1999},
+		{0.1 + 0.2, "EUR", 30},
+		{1500, "JPY", 1500},
+		{1500.4, "jpy", 1500},
+	}
+	for _, tt := range tests {
+		if got := MinorUnits(tt.amount, tt.currency); got != tt.units {
+			t.Errorf("MinorUnits(%v, %s) = %d, want %d", tt.amount, tt.currency, got, tt.units)
+		}
+		if back := FromMinorUnits(tt.units, tt.currency); MinorUnits(back, tt.currency) != tt.units {
+			t.Errorf("FromMinorUnits(%d, %s) = %v", tt.units, tt.currency, back)
+		}
+	}
+}
+
+func TestInvoiceStatusAfterPayment(t *testing.T) {
+	invoice := models.Invoice{Amount: 100.10, Status: "overdue"}
+	if got := InvoiceStatusAfterPayment(invoice, 50); got != "overdue" {
+		t.Errorf("part payment status = %q, want overdue", got)
+	}
+	if got := InvoiceStatusAfterPayment(invoice, 100.1); got != "paid" {
+		t.Errorf("full payment status = %q, want paid", got)
+	}
+	if got := InvoiceStatusAfterPayment(invoice, 120); got != "paid" {
+		t.Errorf("overpayment status = %q, want paid", got)
+	}
+}
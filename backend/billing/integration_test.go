@@ -0,0 +1,48 @@
+//go:build integration
+
+package billing
+
+import (
+	"billow-backend/models"
+	"os"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// openTestDB connects to a disposable local database and migrates the billing
+// models:
+//
+//	TEST_DATABASE_URL="host=localhost user=postgres password=postgres dbname=billow_test sslmode=disable" \
+//	  go test -tags integration ./billing
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Plan{}, &models.Subscription{}, &models.Client{},
+		&models.Invoice{}, &models.AuditEvent{}, &models.BillingEvent{}, &models.Discount{},
+		&models.UsageLog{}, &models.UsageCounter{}, &models.UsageRecord{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// seed creates records in order and deletes them in reverse when the test ends
+func seed(t *testing.T, db *gorm.DB, records ...interface{}) {
+	t.Helper()
+	for _, record := range records {
+		record := record
+		if err := db.Create(record).Error; err != nil {
+			t.Fatalf("seed %T: %v", record, err)
+		}
+		t.Cleanup(func() { db.Delete(record) })
+	}
+}
@@ -0,0 +1,162 @@
+package billing
+
+import (
+	"billow-backend/audit"
+	"billow-backend/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Lifecycle moves subscriptions through their states as time passes: trials
+// expire, periods renew, failed renewals go past due and scheduled
+// cancellations take effect. Every transition runs in its own transaction on a
+// row locked with SKIP LOCKED, so several instances can run it side by side.
+type Lifecycle struct {
+	db       *gorm.DB
+	interval time.Duration
+	now      func() time.Time
+
+	// Charge collects payment for a renewal. When nil renewals always succeed,
+	// which is the behaviour without a payment processor.
+	Charge func(sub models.Subscription) error
+}
+
+// NewLifecycle returns a worker that checks subscriptions every interval
+func NewLifecycle(db *gorm.DB, interval time.Duration) *Lifecycle {
+	return &Lifecycle{db: db, interval: interval, now: time.Now}
+}
+
+// Run processes subscriptions until ctx is canceled
+func (l *Lifecycle) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		l.RunOnce()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce applies every transition that is due
+func (l *Lifecycle) RunOnce() {
+	now := l.now()
+
+	// Scheduled cancellations go first so they aren't renewed
+	l.each("cancel_at_period_end = ? AND current_period_end <= ? AND status IN ?",
+		[]interface{}{true, now, []string{models.SubscriptionActive, models.SubscriptionPastDue, models.SubscriptionTrialing}},
+		l.cancel)
+
+	l.each("status = ? AND trial_end <= ?",
+		[]interface{}{models.SubscriptionTrialing, now},
+		l.expireTrial)
+
+	l.each("status IN ? AND current_period_end <= ?",
+		[]interface{}{[]string{models.SubscriptionActive, models.SubscriptionPastDue}, now},
+		l.renew)
+}
+
+// each runs transition on every subscription matching the condition, one
+// transaction per row. The condition is checked again under the row lock.
+func (l *Lifecycle) each(condition string, args []interface{}, transition func(tx *gorm.DB, sub *models.Subscription, now time.Time) error) {
+	var ids []string
+	if err := l.db.Model(&models.Subscription{}).Where(condition, args...).Pluck("id", &ids).Error; err != nil {
+		fmt.Printf("Subscription lifecycle query failed: %v\n", err)
+		return
+	}
+
+	for _, id := range ids {
+		err := l.db.Transaction(func(tx *gorm.DB) error {
+			var sub models.Subscription
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Preload("Plan").
+				Where("id = ?", id).Where(condition, args...).
+				First(&sub).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil // another worker has it or it no longer applies
+			}
+			if err != nil {
+				return err
+			}
+
+			before := sub
+			if err := transition(tx, &sub, l.now()); err != nil {
+				return err
+			}
+			if err := tx.Omit(clause.Associations).Save(&sub).Error; err != nil {
+				return err
+			}
+
+			audit.RecordSystem(tx, audit.Entry{
+				UserID:     sub.UserID,
+				Action:     audit.SubscriptionUpdated,
+				TargetType: "subscription",
+				TargetID:   sub.ID,
+				Before:     before,
+				After:      sub,
+			})
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Subscription lifecycle failed for %s: %v\n", id, err)
+		}
+	}
+}
+
+func (l *Lifecycle) cancel(tx *gorm.DB, sub *models.Subscription, now time.Time) error {
+	sub.Status = models.SubscriptionCanceled
+	sub.CanceledAt = &now
+	sub.ScheduledPlanID = ""
+	return nil
+}
+
+// expireTrial puts a trial that ended without a paid plan into the restricted
+// expired state. The plan is kept so the user can resume on it.
+func (l *Lifecycle) expireTrial(tx *gorm.DB, sub *models.Subscription, now time.Time) error {
+	sub.Status = models.SubscriptionExpired
+	return nil
+}
+
+// renew charges for the next period, on the plan scheduled for it if a
+// downgrade is pending. A failed charge marks the subscription past due; it is
+// retried on every run until the grace period runs out, at which point the
+// subscription is canceled.
+func (l *Lifecycle) renew(tx *gorm.DB, sub *models.Subscription, now time.Time) error {
+	if err := ApplyScheduledPlan(tx, sub); err != nil {
+		return err
+	}
+
+	var err error
+	if l.Charge != nil {
+		err = l.Charge(*sub)
+	}
+
+	if err == nil {
+		sub.Status = models.SubscriptionActive
+		for !sub.CurrentPeriodEnd.After(now) {
+			sub.CurrentPeriodEnd = AddInterval(sub.CurrentPeriodEnd, sub.Plan.Interval, 1)
+		}
+		return nil
+	}
+
+	if !errors.Is(err, ErrPaymentPending) {
+		fmt.Printf("Renewal charge failed for %s: %v\n", sub.ID, err)
+	}
+	if now.Before(sub.CurrentPeriodEnd.Add(models.PastDueGracePeriod)) {
+		sub.Status = models.SubscriptionPastDue
+		return nil
+	}
+
+	sub.Status = models.SubscriptionCanceled
+	sub.CanceledAt = &now
+	return nil
+}
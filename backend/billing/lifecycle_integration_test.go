@@ -0,0 +1,70 @@
+//go:build integration
+
+package billing
+
+import (
+	"billow-backend/audit"
+	"billow-backend/models"
+	"testing"
+	"time"
+)
+
+func TestLifecycleRunOnce(t *testing.T) {
+	db := openTestDB(t)
+	started := time.Now()
+	now := time.Date(2026, 4, 16, 12, 0, 0, 0, time.UTC)
+	ended := now.Add(-time.Hour)
+	later := now.AddDate(0, 0, 10)
+
+	seed(t, db,
+		&models.Plan{ID: "PLN-LC", Code: "PLN-LC", Name: "Lifecycle", Interval: "month"},
+		&models.User{ID: "USR-LC", ClerkID: "clerk_lc", Email: "lc@lifecycle.test"},
+		&models.Subscription{ID: "SUB-LC-TRIAL", UserID: "USR-LC", PlanID: "PLN-LC", Status: models.SubscriptionTrialing, TrialEnd: &ended, CurrentPeriodEnd: ended},
+		&models.Subscription{ID: "SUB-LC-RENEW", UserID: "USR-LC", PlanID: "PLN-LC", Status: models.SubscriptionActive, CurrentPeriodEnd: ended},
+		&models.Subscription{ID: "SUB-LC-CANCEL", UserID: "USR-LC", PlanID: "PLN-LC", Status: models.SubscriptionActive, CurrentPeriodEnd: ended, CancelAtPeriodEnd: true},
+		&models.Subscription{ID: "SUB-LC-CURRENT", UserID: "USR-LC", PlanID: "PLN-LC", Status: models.SubscriptionActive, CurrentPeriodEnd: later},
+	)
+
+	l := NewLifecycle(db, time.Hour)
+	l.now = func() time.Time { return now }
+	l.RunOnce()
+
+	want := map[string]struct {
+		status    string
+		periodEnd time.Time
+	}{
+		"SUB-LC-TRIAL":   {models.SubscriptionExpired, ended},
+		"SUB-LC-RENEW":   {models.SubscriptionActive, ended.AddDate(0, 1, 0)},
+		"SUB-LC-CANCEL":  {models.SubscriptionCanceled, ended},
+		"SUB-LC-CURRENT": {models.SubscriptionActive, later},
+	}
+	for id, w := range want {
+		var sub models.Subscription
+		if err := db.First(&sub, "id = ?", id).Error; err != nil {
+			t.Fatal(err)
+		}
+		if sub.Status != w.status || !sub.CurrentPeriodEnd.Equal(w.periodEnd) {
+			t.Errorf("%s: status %s, period end %v; want %s, %v", id, sub.Status, sub.CurrentPeriodEnd, w.status, w.periodEnd)
+		}
+	}
+
+	// Automatic changes are audited without alerting the user
+	var events []models.AuditEvent
+	db.Where("target_id LIKE ? AND created_at >= ?", "SUB-LC-%", started).Find(&events)
+	if len(events) != 3 {
+		t.Errorf("%d audit events, want 3", len(events))
+	}
+	for _, event := range events {
+		if event.Action != audit.SubscriptionUpdated || event.Risk != "low" || event.ActorID != "system" {
+			t.Errorf("audit event %+v", event)
+		}
+	}
+
+	// Running again changes nothing
+	l.RunOnce()
+	var count int64
+	db.Model(&models.AuditEvent{}).Where("target_id LIKE ? AND created_at >= ?", "SUB-LC-%", started).Count(&count)
+	if count != 3 {
+		t.Errorf("%d audit events after a second run, want 3", count)
+	}
+}
@@ -0,0 +1,70 @@
+package billing
+
+import (
+	"billow-backend/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLifecycleTransitions(t *testing.T) {
+	now := time.Date(2026, 4, 16, 12, 0, 0, 0, time.UTC)
+	due := time.Date(2026, 4, 15, 0, 0, 0, 0, time.UTC)
+	monthly := models.Plan{ID: "PLN-PRO", Interval: "month"}
+	declined := errors.New("card declined")
+
+	tests := []struct {
+		name       string
+		transition string
+		sub        models.Subscription
+		charge     error
+		status     string
+		periodEnd  time.Time
+		canceled   bool
+	}{
+		{"trial expires", "expireTrial", models.Subscription{Status: models.SubscriptionTrialing, CurrentPeriodEnd: due}, nil, models.SubscriptionExpired, due, false},
+		{"renewal starts the next period", "renew", models.Subscription{Status: models.SubscriptionActive, CurrentPeriodEnd: due}, nil, models.SubscriptionActive, due.AddDate(0, 1, 0), false},
+		{"late renewal skips missed periods", "renew", models.Subscription{Status: models.SubscriptionActive, CurrentPeriodEnd: due.AddDate(0, -3, 0)}, nil, models.SubscriptionActive, due.AddDate(0, 1, 0), false},
+		{"yearly renewal", "renew", models.Subscription{Status: models.SubscriptionActive, CurrentPeriodEnd: due, Plan: models.Plan{Interval: "year"}}, nil, models.SubscriptionActive, due.AddDate(1, 0, 0), false},
+		{"past due renewal recovers", "renew", models.Subscription{Status: models.SubscriptionPastDue, CurrentPeriodEnd: due}, nil, models.SubscriptionActive, due.AddDate(0, 1, 0), false},
+		{"declined renewal goes past due", "renew", models.Subscription{Status: models.SubscriptionActive, CurrentPeriodEnd: due}, declined, models.SubscriptionPastDue, due, false},
+		{"pending payment goes past due", "renew", models.Subscription{Status: models.SubscriptionActive, CurrentPeriodEnd: due}, ErrPaymentPending, models.SubscriptionPastDue, due, false},
+		{"declined after the grace period cancels", "renew", models.Subscription{Status: models.SubscriptionPastDue, CurrentPeriodEnd: now.Add(-models.PastDueGracePeriod)}, declined, models.SubscriptionCanceled, now.Add(-models.PastDueGracePeriod), true},
+		{"cancellation at period end", "cancel", models.Subscription{Status: models.SubscriptionActive, CurrentPeriodEnd: due, CancelAtPeriodEnd: true, ScheduledPlanID: "PLN-STARTER"}, nil, models.SubscriptionCanceled, due, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			charged := 0
+			l := &Lifecycle{now: func() time.Time { return now }, Charge: func(models.Subscription) error {
+				charged++
+				return tt.charge
+			}}
+			transitions := map[string]func(sub *models.Subscription) error{
+				"expireTrial": func(sub *models.Subscription) error { return l.expireTrial(nil, sub, now) },
+				"renew":       func(sub *models.Subscription) error { return l.renew(nil, sub, now) },
+				"cancel":      func(sub *models.Subscription) error { return l.cancel(nil, sub, now) },
+			}
+
+			sub := tt.sub
+			if sub.Plan.Interval == "" {
+				sub.Plan = monthly
+			}
+			if err := transitions[tt.transition](&sub); err != nil {
+				t.Fatal(err)
+			}
+			if sub.Status != tt.status || !sub.CurrentPeriodEnd.Equal(tt.periodEnd) {
+				t.Errorf("status %s, period end %v; want %s, %v", sub.Status, sub.CurrentPeriodEnd, tt.status, tt.periodEnd)
+			}
+			if canceled := sub.CanceledAt != nil && sub.CanceledAt.Equal(now); canceled != tt.canceled {
+				t.Errorf("canceled at %v", sub.CanceledAt)
+			}
+			if tt.transition == "renew" && charged != 1 {
+				t.Errorf("charged %d times", charged)
+			}
+			if tt.transition == "cancel" && sub.ScheduledPlanID != "" {
+				t.Errorf("scheduled plan %s kept after cancellation", sub.ScheduledPlanID)
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package billing
+
+import (
+	"billow-backend/entitlements"
+	"billow-backend/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LateUsageWindow is how long after a period ends its usage records stay open.
+// Usage logged within it is folded into the period; usage logged later is
+// billed as an adjustment in the next open period.
+const LateUsageWindow = 72 * time.Hour
+
+// lateUsageHorizon is how far back closed periods are checked for late usage
+const lateUsageHorizon = 90 * 24 * time.Hour
+
+// Metering rolls usage logs of subscriptions with metered plans into priced
+// usage records
+type Metering struct {
+	db       *gorm.DB
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewMetering returns a worker that aggregates usage every interval
+func NewMetering(db *gorm.DB, interval time.Duration) *Metering {
+	return &Metering{db: db, interval: interval, now: time.Now}
+}
+
+// Run aggregates usage until ctx is canceled
+func (m *Metering) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.RunOnce()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce aggregates usage of every subscription on a metered plan
+func (m *Metering) RunOnce() {
+	var subs []models.Subscription
+	err := m.db.Preload("Plan").
+		Joins("JOIN plans ON plans.id = subscriptions.plan_id").
+		Where("plans.meters <> ''").
+		Find(&subs).Error
+	if err != nil {
+		fmt.Printf("Usage metering query failed: %v\n", err)
+		return
+	}
+
+	now := m.now()
+	for _, sub := range subs {
+		ents, err := entitlements.Load(m.db, sub.UserID, &sub, now)
+		if err == nil {
+			err = m.db.Transaction(func(tx *gorm.DB) error {
+				return AggregateUsage(tx, sub, ents, now)
+			})
+		}
+		if err != nil {
+			fmt.Printf("Usage metering failed for %s: %v\n", sub.ID, err)
+		}
+	}
+}
+
+// PeriodContaining returns the billing period of sub that contains t
+func PeriodContaining(sub models.Subscription, t, now time.Time) (time.Time, time.Time) {
+	start, end := CurrentPeriod(sub, now)
+	for start.After(t) {
+		start, end = AddInterval(start, sub.Plan.Interval, -1), start
+	}
+	for !end.After(t) {
+		start, end = end, AddInterval(end, sub.Plan.Interval, 1)
+	}
+	return start, end
+}
+
+// AggregateUsage brings the subscription's usage records up to date: the
+// current and previous periods are recomputed from the usage logs, the
+// previous period is closed once LateUsageWindow has passed, and usage that
+// reached already closed periods is added to adjustments in the current
+// period. Allowances come from ents so add-ons and overrides count. db must
+// be a transaction; a per-tenant advisory lock keeps concurrent runs apart.
+func AggregateUsage(db *gorm.DB, sub models.Subscription, ents *entitlements.Entitlements, now time.Time) error {
+	meters := sub.Plan.MeterList()
+	if len(meters) == 0 {
+		return nil
+	}
+	if err := db.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "metering:"+sub.UserID).Error; err != nil {
+		return err
+	}
+
+	start, end := CurrentPeriod(sub, now)
+	prevStart := AddInterval(start, sub.Plan.Interval, -1)
+
+	for _, meter := range meters {
+		if err := reconcileLateUsage(db, sub, meter, start, end, now); err != nil {
+			return err
+		}
+
+		// The previous period may not have been metered while it was current,
+		// for instance when the worker was down over the period end. Its
+		// record is created now so its usage is still billed and closed.
+		prev, err := findUsageRecord(db, sub.UserID, meter.FeatureType, prevStart)
+		if err != nil {
+			return err
+		}
+		if prev == nil && sub.CreatedAt.Before(start) {
+			prev = newUsageRecord(sub, meter, prevStart, start)
+			prev.Included = int64(ents.Limit(meter.Quota))
+		}
+		if prev != nil && prev.ClosedAt == nil {
+			if err := refreshUsageRecord(db, prev, meter); err != nil {
+				return err
+			}
+			if !now.Before(start.Add(LateUsageWindow)) {
+				prev.ClosedAt = &now
+			}
+			if err := db.Save(prev).Error; err != nil {
+				return err
+			}
+		}
+
+		current, err := findUsageRecord(db, sub.UserID, meter.FeatureType, start)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			current = newUsageRecord(sub, meter, start, end)
+		}
+		// The allowance follows entitlement changes until the period closes
+		current.Included = int64(ents.Limit(meter.Quota))
+		current.UnitPrice = meter.UnitPrice
+		current.PlanID = sub.PlanID
+		if err := refreshUsageRecord(db, current, meter); err != nil {
+			return err
+		}
+		if err := db.Save(current).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newUsageRecord starts the usage record of a meter for the period [start, end)
+func newUsageRecord(sub models.Subscription, meter models.Meter, start, end time.Time) *models.UsageRecord {
+	return &models.UsageRecord{
+		ID:             models.GenerateUsageRecordID(),
+		UserID:         sub.UserID,
+		SubscriptionID: sub.ID,
+		PlanID:         sub.PlanID,
+		FeatureType:    meter.FeatureType,
+		Kind:           models.UsageRecordUsage,
+		Aggregation:    meter.Aggregation,
+		PeriodStart:    start,
+		PeriodEnd:      end,
+		UnitPrice:      meter.UnitPrice,
+		Currency:       sub.Plan.Currency,
+	}
+}
+
+// findUsageRecord loads the usage record of a meter for the period starting
+// at start, or nil when there is none
+func findUsageRecord(db *gorm.DB, userID, featureType string, start time.Time) (*models.UsageRecord, error) {
+	var record models.UsageRecord
+	err := db.Where("user_id = ? AND feature_type = ? AND kind = ? AND period_start = ?",
+		userID, featureType, models.UsageRecordUsage, start).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// refreshUsageRecord recomputes an open record from the usage logs
+func refreshUsageRecord(db *gorm.DB, record *models.UsageRecord, meter models.Meter) error {
+	days, err := dailyUsage(db, record.UserID, meter.FeatureType, record.PeriodStart, record.PeriodEnd)
+	if err != nil {
+		return err
+	}
+	record.Quantity, record.Overage = MeasureOverage(meter.Aggregation, record.Included, days)
+	record.Amount = roundMoney(float64(record.Overage) * record.UnitPrice)
+	return nil
+}
+
+// reconcileLateUsage looks for usage that reached closed periods after they
+// closed and bills it through an adjustment in the current period. Closed
+// records keep what they billed, at the allowance and price of their period.
+func reconcileLateUsage(db *gorm.DB, sub models.Subscription, meter models.Meter, start, end, now time.Time) error {
+	var closed []models.UsageRecord
+	err := db.Where("user_id = ? AND feature_type = ? AND kind = ? AND closed_at IS NOT NULL AND period_end > ?",
+		sub.UserID, meter.FeatureType, models.UsageRecordUsage, now.Add(-lateUsageHorizon)).
+		Find(&closed).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range closed {
+		record := &closed[i]
+		days, err := dailyUsage(db, record.UserID, record.FeatureType, record.PeriodStart, record.PeriodEnd)
+		if err != nil {
+			return err
+		}
+		quantity, overage := MeasureOverage(record.Aggregation, record.Included, days)
+		lateQuantity := quantity - record.Quantity - record.LateQuantity
+		lateOverage := overage - record.Overage - record.LateOverage
+		if lateQuantity <= 0 {
+			continue
+		}
+
+		// Late usage within the allowance costs nothing and needs no line
+		if lateOverage > 0 {
+			adjustment, err := adjustmentRecord(db, sub, *record, start, end)
+			if err != nil {
+				return err
+			}
+			adjustment.Quantity += lateQuantity
+			adjustment.Overage += lateOverage
+			adjustment.Amount = roundMoney(float64(adjustment.Overage) * adjustment.UnitPrice)
+			if err := db.Save(adjustment).Error; err != nil {
+				return err
+			}
+		}
+
+		record.LateQuantity += lateQuantity
+		record.LateOverage += lateOverage
+		if err := db.Save(record).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adjustmentRecord loads the current period's adjustment for a closed
+// record, or starts one
+func adjustmentRecord(db *gorm.DB, sub models.Subscription, closed models.UsageRecord, start, end time.Time) (*models.UsageRecord, error) {
+	var adjustment models.UsageRecord
+	err := db.Where("user_id = ? AND feature_type = ? AND kind = ? AND period_start = ? AND adjusts_period = ?",
+		sub.UserID, closed.FeatureType, models.UsageRecordAdjustment, start, closed.PeriodStart).First(&adjustment).Error
+	if err == nil {
+		return &adjustment, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	adjusts := closed.PeriodStart
+	return &models.UsageRecord{
+		ID:             models.GenerateUsageRecordID(),
+		UserID:         sub.UserID,
+		SubscriptionID: sub.ID,
+		PlanID:         closed.PlanID,
+		FeatureType:    closed.FeatureType,
+		Kind:           models.UsageRecordAdjustment,
+		Aggregation:    closed.Aggregation,
+		PeriodStart:    start,
+		PeriodEnd:      end,
+		AdjustsPeriod:  &adjusts,
+		Included:       closed.Included,
+		UnitPrice:      closed.UnitPrice,
+		Currency:       closed.Currency,
+	}, nil
+}
+
+// dailyUsage sums a feature's usage logs per UTC day of [start, end)
+func dailyUsage(db *gorm.DB, userID, featureType string, start, end time.Time) ([]int64, error) {
+	var days []int64
+	err := db.Model(&models.UsageLog{}).
+		Where("user_id = ? AND feature_type = ? AND timestamp >= ? AND timestamp < ?", userID, featureType, start, end).
+		Group("DATE(timestamp AT TIME ZONE 'UTC')").
+		Pluck("SUM(count)", &days).Error
+	return days, err
+}
+
+// MeasureOverage returns the total usage and the part of it beyond the
+// allowance. Daily meters apply the allowance to each day separately.
+func MeasureOverage(aggregation string, included int64, days []int64) (quantity, overage int64) {
+	for _, day := range days {
+		quantity += day
+		if aggregation == models.MeterPerDay && included >= 0 && day > included {
+			overage += day - included
+		}
+	}
+	if aggregation != models.MeterPerDay && included >= 0 && quantity > included {
+		overage = quantity - included
+	}
+	return quantity, overage
+}
+
+// StatementLine is one priced meter or late usage adjustment
+type StatementLine struct {
+	FeatureType   string     `json:"feature_type"`
+	Kind          string     `json:"kind"`
+	Aggregation   string     `json:"aggregation"`
+	AdjustsPeriod *time.Time `json:"adjusts_period,omitempty"`
+	Quantity      int64      `json:"quantity"`
+	Included      int64      `json:"included"`
+	Overage       int64      `json:"overage"`
+	UnitPrice     float64    `json:"unit_price"`
+	Amount        float64    `json:"amount"`
+}
+
+// Statement is what a subscriber owes for one billing period
+type Statement struct {
+	PlanID      string          `json:"plan_id"`
+	PlanName    string          `json:"plan_name"`
+	PeriodStart time.Time       `json:"period_start"`
+	PeriodEnd   time.Time       `json:"period_end"`
+	Closed      bool            `json:"closed"` // later usage no longer changes the period's own lines
+	Currency    string          `json:"currency"`
+	BaseFee     float64         `json:"base_fee"`
+	Lines       []StatementLine `json:"lines"`
+	UsageTotal  float64         `json:"usage_total"`
+	Total       float64         `json:"total"`
+}
+
+// BuildStatement assembles the statement of the period starting at start from
+// the usage records
+func BuildStatement(db *gorm.DB, sub models.Subscription, start, end time.Time) (*Statement, error) {
+	var records []models.UsageRecord
+	err := db.Where("user_id = ? AND period_start = ?", sub.UserID, start).
+		Order("kind DESC, feature_type ASC, adjusts_period ASC").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &Statement{
+		PlanID:      sub.PlanID,
+		PlanName:    sub.Plan.Name,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Currency:    sub.Plan.Currency,
+		BaseFee:     sub.Plan.Price,
+		Lines:       []StatementLine{},
+	}
+	for _, record := range records {
+		if record.Kind == models.UsageRecordUsage && record.ClosedAt != nil {
+			statement.Closed = true
+		}
+		statement.Lines = append(statement.Lines, StatementLine{
+			FeatureType:   record.FeatureType,
+			Kind:          record.Kind,
+			Aggregation:   record.Aggregation,
+			AdjustsPeriod: record.AdjustsPeriod,
+			Quantity:      record.Quantity,
+			Included:      record.Included,
+			Overage:       record.Overage,
+			UnitPrice:     record.UnitPrice,
+			Amount:        record.Amount,
+		})
+		statement.UsageTotal += record.Amount
+	}
+	statement.UsageTotal = roundMoney(statement.UsageTotal)
+	statement.Total = roundMoney(statement.BaseFee + statement.UsageTotal)
+	return statement, nil
+}
@@ -0,0 +1,93 @@
+//go:build integration
+
+package billing
+
+import (
+	"billow-backend/entitlements"
+	"billow-backend/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestAggregateUsage(t *testing.T) {
+	db := openTestDB(t)
+
+	plan := models.Plan{ID: "PLN-MT", Code: "PLN-MT", Name: "Metered", Price: 10, Currency: "USD", Interval: "month", InvoiceLimit: 2}
+	if err := plan.SetMeters([]models.Meter{{FeatureType: "invoice_created", Quota: InvoiceQuota, Aggregation: models.MeterPerPeriod, UnitPrice: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	sub := models.Subscription{ID: "SUB-MT", UserID: "USR-MT", PlanID: plan.ID, Plan: plan, Status: "active", CurrentPeriodEnd: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}
+
+	seed(t, db,
+		&plan,
+		&models.User{ID: "USR-MT", ClerkID: "clerk_mt", Email: "mt@metering.test"},
+		&models.UsageLog{ID: "LOG-MT-1", UserID: "USR-MT", FeatureType: "invoice_created", Count: 3, Timestamp: march.AddDate(0, 0, 9)},
+		&models.UsageLog{ID: "LOG-MT-2", UserID: "USR-MT", FeatureType: "invoice_created", Count: 1, Timestamp: april.AddDate(0, 0, 9)},
+	)
+	t.Cleanup(func() { db.Where("user_id = ?", "USR-MT").Delete(&models.UsageRecord{}) })
+
+	aggregate := func(now time.Time) {
+		t.Helper()
+		ents := entitlements.Resolve(&sub, nil, nil, now)
+		if err := db.Transaction(func(tx *gorm.DB) error { return AggregateUsage(tx, sub, ents, now) }); err != nil {
+			t.Fatalf("aggregate at %v: %v", now, err)
+		}
+	}
+	record := func(kind string, start time.Time) models.UsageRecord {
+		t.Helper()
+		var record models.UsageRecord
+		if err := db.First(&record, "user_id = ? AND kind = ? AND period_start = ?", "USR-MT", kind, start).Error; err != nil {
+			t.Fatalf("%s record of %v: %v", kind, start, err)
+		}
+		return record
+	}
+
+	// The worker first runs after the period ended: the March record is
+	// created for it and stays open through the late usage window
+	aggregate(april.Add(time.Hour))
+	if prev := record(models.UsageRecordUsage, march); prev.Quantity != 3 || prev.Overage != 1 || prev.Amount != 1 || prev.ClosedAt != nil {
+		t.Errorf("previous period within the window = %+v", prev)
+	}
+
+	// Usage logged within the window is folded into the period, which closes
+	// once the window has passed
+	seed(t, db, &models.UsageLog{ID: "LOG-MT-3", UserID: "USR-MT", FeatureType: "invoice_created", Count: 1, Timestamp: march.AddDate(0, 0, 19)})
+	aggregate(april.Add(LateUsageWindow))
+	prev := record(models.UsageRecordUsage, march)
+	if prev.Quantity != 4 || prev.Overage != 2 || prev.Amount != 2 || prev.ClosedAt == nil {
+		t.Errorf("closed previous period = %+v", prev)
+	}
+	if current := record(models.UsageRecordUsage, april); current.Quantity != 1 || current.Overage != 0 || current.Included != 2 {
+		t.Errorf("current period = %+v", current)
+	}
+
+	// Later usage leaves the closed record as billed and is charged by an
+	// adjustment in the open period
+	seed(t, db, &models.UsageLog{ID: "LOG-MT-4", UserID: "USR-MT", FeatureType: "invoice_created", Count: 2, Timestamp: march.AddDate(0, 0, 25)})
+	now := april.AddDate(0, 0, 15)
+	aggregate(now)
+	aggregate(now) // reconciling again doesn't bill the same usage twice
+
+	adjustment := record(models.UsageRecordAdjustment, april)
+	if adjustment.AdjustsPeriod == nil || !adjustment.AdjustsPeriod.Equal(march) {
+		t.Errorf("adjustment adjusts %v, want %v", adjustment.AdjustsPeriod, march)
+	}
+	if adjustment.Quantity != 2 || adjustment.Overage != 2 || adjustment.Amount != 2 {
+		t.Errorf("adjustment = %+v", adjustment)
+	}
+	if prev := record(models.UsageRecordUsage, march); prev.Quantity != 4 || prev.Amount != 2 || prev.LateQuantity != 2 || prev.LateOverage != 2 {
+		t.Errorf("closed period after late usage = %+v", prev)
+	}
+
+	statement, err := BuildStatement(db, sub, april, sub.CurrentPeriodEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statement.Lines) != 2 || statement.UsageTotal != 2 || statement.Total != 12 {
+		t.Errorf("statement = %+v", statement)
+	}
+}
@@ -0,0 +1,57 @@
+package billing
+
+import (
+	"billow-backend/models"
+	"testing"
+	"time"
+)
+
+func TestMeasureOverage(t *testing.T) {
+	days := []int64{1500, 2500, 1900}
+
+	tests := []struct {
+		name        string
+		aggregation string
+		included    int64
+		quantity    int64
+		overage     int64
+	}{
+		{"period allowance", models.MeterPerPeriod, 5000, 5900, 900},
+		{"period within allowance", models.MeterPerPeriod, 6000, 5900, 0},
+		{"daily allowance counts each day", models.MeterPerDay, 2000, 5900, 500},
+		{"unlimited", models.MeterPerDay, -1, 5900, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quantity, overage := MeasureOverage(tt.aggregation, tt.included, days)
+			if quantity != tt.quantity || overage != tt.overage {
+				t.Errorf("got %d/%d, want %d/%d", quantity, overage, tt.quantity, tt.overage)
+			}
+		})
+	}
+}
+
+func TestPeriodContaining(t *testing.T) {
+	sub := models.Subscription{
+		CurrentPeriodEnd: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Plan:             models.Plan{Interval: "month"},
+	}
+	now := time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		at    time.Time
+		start time.Time
+	}{
+		{now, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		start, end := PeriodContaining(sub, tt.at, now)
+		if !start.Equal(tt.start) || !end.Equal(tt.start.AddDate(0, 1, 0)) {
+			t.Errorf("PeriodContaining(%v) = %v - %v", tt.at, start, end)
+		}
+	}
+}
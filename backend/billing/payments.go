@@ -0,0 +1,116 @@
+package billing
+
+import (
+	"billow-backend/audit"
+	"billow-backend/models"
+	"errors"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrUnknownInvoice is returned for payment webhooks that can't be matched to
+// an invoice
+var ErrUnknownInvoice = errors.New("no invoice matches the payment event")
+
+// InvoiceStatusAfterPayment is the status of an invoice once paid has been
+// received against it. Part payments leave the status alone.
+func InvoiceStatusAfterPayment(invoice models.Invoice, paid float64) string {
+	if math.Round(paid*100) >= math.Round(invoice.Amount*100) {
+		return "paid"
+	}
+	return invoice.Status
+}
+
+// Outstanding is what is still to be paid on invoice
+func Outstanding(db *gorm.DB, invoice models.Invoice) (float64, error) {
+	paid, err := paidAmount(db, invoice.ID)
+	if err != nil {
+		return 0, err
+	}
+	return math.Max(0, math.Round((invoice.Amount-paid)*100)/100), nil
+}
+
+// ApplyPayment records a successful payment against its invoice and marks the
+// invoice paid once it is settled. Each event and each gateway payment is
+// recorded at most once.
+func ApplyPayment(db *gorm.DB, event *PaymentEvent) error {
+	if event.Type != PaymentSucceeded {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		record := models.BillingEvent{ID: event.ID, Type: event.Type}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil // already applied
+		}
+
+		var invoice models.Invoice
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&invoice, "id = ? AND user_id = ?", event.InvoiceID, event.UserID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUnknownInvoice
+		}
+		if err != nil {
+			return err
+		}
+
+		paidAt := event.PaidAt
+		if paidAt.IsZero() {
+			paidAt = time.Now()
+		}
+		payment := models.Payment{
+			ID:        models.GeneratePaymentID(),
+			UserID:    invoice.UserID,
+			InvoiceID: invoice.ID,
+			Amount:    event.Amount,
+			Currency:  event.Currency,
+			Gateway:   event.Gateway,
+			Reference: event.Reference,
+			PaidAt:    paidAt,
+		}
+		result = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&payment)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil // the same payment arrived in another event
+		}
+
+		paid, err := paidAmount(tx, invoice.ID)
+		if err != nil {
+			return err
+		}
+		before := invoice
+		invoice.Status = InvoiceStatusAfterPayment(invoice, paid)
+		if invoice.Status != before.Status {
+			if err := tx.Model(&invoice).Update("status", invoice.Status).Error; err != nil {
+				return err
+			}
+		}
+
+		audit.RecordSystem(tx, audit.Entry{
+			UserID:     invoice.UserID,
+			ActorID:    "payments",
+			Action:     audit.PaymentRecorded,
+			TargetType: "invoice",
+			TargetID:   invoice.ID,
+			Before:     before,
+			After:      invoice,
+		})
+		return nil
+	})
+}
+
+func paidAmount(db *gorm.DB, invoiceID string) (float64, error) {
+	var paid float64
+	err := db.Model(&models.Payment{}).Where("invoice_id = ?", invoiceID).
+		Select("COALESCE(SUM(amount), 0)").Scan(&paid).Error
+	return paid, err
+}
@@ -0,0 +1,152 @@
+package billing
+
+import (
+	"billow-backend/models"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// When a plan change takes effect
+const (
+	ChangeImmediate   = "immediate"
+	ChangeAtPeriodEnd = "period_end"
+)
+
+// ProrationLine is one item of a plan change breakdown. Credits are negative.
+type ProrationLine struct {
+	Description string  `json:"description"`
+	Amount      float64 `json:"amount"`
+}
+
+// ChangePreview is what switching to another plan costs and when it happens
+type ChangePreview struct {
+	CurrentPlan   models.Plan     `json:"current_plan"`
+	NewPlan       models.Plan     `json:"new_plan"`
+	Timing        string          `json:"timing"` // immediate, period_end
+	EffectiveAt   time.Time       `json:"effective_at"`
+	PeriodStart   time.Time       `json:"period_start"`
+	PeriodEnd     time.Time       `json:"period_end"`
+	NewPeriodEnd  time.Time       `json:"new_period_end"`
+	UnusedPercent float64         `json:"unused_percent"` // of the current period
+	Lines         []ProrationLine `json:"lines"`
+	Credit        float64         `json:"credit"` // for unused time on the current plan
+	Charge        float64         `json:"charge"` // for the new plan until NewPeriodEnd
+	AmountDue     float64         `json:"amount_due"`
+	Currency      string          `json:"currency"`
+	Coupon        string          `json:"coupon,omitempty"` // the discount the amounts are after
+}
+
+// IsUpgrade reports whether moving from one plan to another takes effect
+// immediately. Longer intervals and pricier plans on the same interval are
+// upgrades; everything else waits for the end of the period so the user keeps
+// what they paid for.
+func IsUpgrade(from, to models.Plan) bool {
+	fromLength, toLength := intervalMonths(from.Interval), intervalMonths(to.Interval)
+	if toLength != fromLength {
+		return toLength > fromLength
+	}
+	return to.Price > from.Price
+}
+
+// PreviewChange works out a change from the subscription's plan (which must
+// be loaded) to plan at now.
+//
+// Upgrades on the same interval keep the billing date: the unused part of the
+// current period is credited and the new plan is charged for the same part.
+// Upgrades to a longer interval start a new period now, charging the full new
+// price less the credit. Downgrades cost nothing now and apply at period end.
+//
+// Amounts are after the subscription's discount, which may be nil. Whether a
+// change is an upgrade is decided on list prices.
+func PreviewChange(sub models.Subscription, plan models.Plan, discount *models.Discount, now time.Time) ChangePreview {
+	start, end := CurrentPeriod(sub, now)
+	preview := ChangePreview{
+		CurrentPlan: sub.Plan,
+		NewPlan:     plan,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Currency:    plan.Currency,
+		Lines:       []ProrationLine{},
+	}
+	price := func(plan models.Plan) float64 { return plan.Price }
+	if discount != nil {
+		price = func(plan models.Plan) float64 { return DiscountedPrice(plan, discount.Coupon) }
+		preview.Coupon = discount.Coupon.Name
+	}
+
+	// Outside a paid period there is nothing to prorate; the new plan starts
+	// now. Choosing the current plan again only drops a scheduled change.
+	if !sub.HasAccess(now) || sub.Status == models.SubscriptionTrialing || sub.PlanID == plan.ID {
+		preview.Timing = ChangeImmediate
+		preview.EffectiveAt = now
+		preview.NewPeriodEnd = end
+		if !sub.HasAccess(now) {
+			preview.NewPeriodEnd = AddInterval(now, plan.Interval, 1)
+			preview.Charge = roundMoney(price(plan))
+			preview.Lines = append(preview.Lines, ProrationLine{Description: plan.Name + " (" + plan.Interval + ")", Amount: preview.Charge})
+		}
+		preview.AmountDue = preview.Charge
+		return preview
+	}
+
+	if !IsUpgrade(sub.Plan, plan) {
+		preview.Timing = ChangeAtPeriodEnd
+		preview.EffectiveAt = end
+		preview.NewPeriodEnd = AddInterval(end, plan.Interval, 1)
+		return preview
+	}
+
+	unused := float64(end.Sub(now)) / float64(end.Sub(start))
+	unused = math.Max(0, math.Min(1, unused))
+
+	preview.Timing = ChangeImmediate
+	preview.EffectiveAt = now
+	preview.UnusedPercent = math.Round(unused*10000) / 100
+	preview.Credit = roundMoney(price(sub.Plan) * unused)
+
+	charge := "Remaining time on " + plan.Name
+	if plan.Interval == sub.Plan.Interval {
+		preview.NewPeriodEnd = end
+		preview.Charge = roundMoney(price(plan) * unused)
+	} else {
+		charge = plan.Name + " (" + plan.Interval + ")"
+		preview.NewPeriodEnd = AddInterval(now, plan.Interval, 1)
+		preview.Charge = roundMoney(price(plan))
+	}
+
+	preview.Lines = append(preview.Lines,
+		ProrationLine{Description: "Unused time on " + sub.Plan.Name, Amount: -preview.Credit},
+		ProrationLine{Description: charge, Amount: preview.Charge},
+	)
+	preview.AmountDue = math.Max(0, roundMoney(preview.Charge-preview.Credit))
+	return preview
+}
+
+// ApplyScheduledPlan switches to the plan scheduled for the end of the period,
+// if any. It runs when a new period starts.
+func ApplyScheduledPlan(db *gorm.DB, sub *models.Subscription) error {
+	if sub.ScheduledPlanID == "" {
+		return nil
+	}
+	var plan models.Plan
+	if err := db.First(&plan, "id = ?", sub.ScheduledPlanID).Error; err != nil {
+		return err
+	}
+	sub.PlanID = plan.ID
+	sub.Plan = plan
+	sub.ScheduledPlanID = ""
+	return nil
+}
+
+func intervalMonths(interval string) int {
+	if interval == "year" {
+		return 12
+	}
+	return 1
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
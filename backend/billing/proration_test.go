@@ -0,0 +1,56 @@
+package billing
+
+import (
+	"billow-backend/models"
+	"testing"
+	"time"
+)
+
+func TestPreviewChange(t *testing.T) {
+	starter := models.Plan{ID: "PLN-STARTER", Name: "Starter", Price: 10, Interval: "month", Currency: "USD"}
+	pro := models.Plan{ID: "PLN-PRO", Name: "Pro", Price: 30, Interval: "month", Currency: "USD"}
+	proAnnual := models.Plan{ID: "PLN-PRO-ANNUAL", Name: "Pro (Annual)", Price: 300, Interval: "year", Currency: "USD"}
+
+	periodEnd := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	halfway := time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC) // 15 of 30 days left
+	active := func(plan models.Plan) models.Subscription {
+		return models.Subscription{PlanID: plan.ID, Plan: plan, Status: models.SubscriptionActive, CurrentPeriodEnd: periodEnd}
+	}
+	half := &models.Discount{Coupon: models.Coupon{Name: "Half off", PercentOff: 50}}
+	proOnly := &models.Discount{Coupon: models.Coupon{Name: "Pro launch", AmountOff: 20, Currency: "USD", PlanIDs: "PLN-PRO"}}
+
+	tests := []struct {
+		name      string
+		sub       models.Subscription
+		plan      models.Plan
+		discount  *models.Discount
+		timing    string
+		credit    float64
+		charge    float64
+		due       float64
+		newPeriod time.Time
+	}{
+		{"upgrade keeps billing date", active(starter), pro, nil, ChangeImmediate, 5, 15, 10, periodEnd},
+		{"downgrade waits for period end", active(pro), starter, nil, ChangeAtPeriodEnd, 0, 0, 0, periodEnd.AddDate(0, 1, 0)},
+		{"longer interval starts a new period", active(pro), proAnnual, nil, ChangeImmediate, 15, 300, 285, halfway.AddDate(1, 0, 0)},
+		{"shorter interval waits for period end", active(proAnnual), pro, nil, ChangeAtPeriodEnd, 0, 0, 0, periodEnd.AddDate(0, 1, 0)},
+		{"same plan", active(pro), pro, nil, ChangeImmediate, 0, 0, 0, periodEnd},
+		{"no subscription pays in full", models.Subscription{}, pro, nil, ChangeImmediate, 0, 30, 30, halfway.AddDate(0, 1, 0)},
+		{"upgrade after discount", active(starter), pro, half, ChangeImmediate, 2.5, 7.5, 5, periodEnd},
+		{"discount on the new plan is still an upgrade", active(starter), pro, proOnly, ChangeImmediate, 5, 5, 0, periodEnd},
+		{"discount covers the yearly variant", active(starter), proAnnual, proOnly, ChangeImmediate, 5, 280, 275, halfway.AddDate(1, 0, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PreviewChange(tt.sub, tt.plan, tt.discount, halfway)
+			if got.Timing != tt.timing || got.Credit != tt.credit || got.Charge != tt.charge || got.AmountDue != tt.due {
+				t.Errorf("got timing=%s credit=%v charge=%v due=%v, want %s %v %v %v",
+					got.Timing, got.Credit, got.Charge, got.AmountDue, tt.timing, tt.credit, tt.charge, tt.due)
+			}
+			if !got.NewPeriodEnd.Equal(tt.newPeriod) {
+				t.Errorf("new period end = %v, want %v", got.NewPeriodEnd, tt.newPeriod)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package billing
+
+import (
+	"billow-backend/models"
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// Webhook event types, named after the Stripe events they come from
+const (
+	EventCheckoutCompleted    = "checkout.session.completed"
+	EventInvoicePaid          = "invoice.paid"
+	EventPaymentFailed        = "invoice.payment_failed"
+	EventSubscriptionUpdated  = "customer.subscription.updated"
+	EventSubscriptionCanceled = "customer.subscription.deleted"
+)
+
+var (
+	// ErrInvalidSignature is returned for webhooks that weren't signed with the
+	// endpoint secret or whose timestamp is outside the tolerance
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+
+	// ErrPaymentPending is returned as the renewal charge for subscriptions the
+	// provider bills itself. The period is advanced when invoice.paid arrives.
+	ErrPaymentPending = errors.New("renewal is collected by the payment provider")
+)
+
+// Provider is a payment processor that bills subscriptions on our behalf
+type Provider interface {
+	// CreateCheckoutSession starts a hosted checkout for a new subscription
+	CreateCheckoutSession(ctx context.Context, params CheckoutParams) (*Session, error)
+
+	// CreatePortalSession opens the hosted page where a customer manages
+	// payment methods and invoices
+	CreatePortalSession(ctx context.Context, customerID, returnURL string) (*Session, error)
+
+	// ChangePrice moves an existing subscription to another price. With
+	// prorate the difference for the rest of the period is billed now;
+	// otherwise the new price applies from the next renewal.
+	ChangePrice(ctx context.Context, subscriptionID, priceID string, prorate bool) error
+
+	// ApplyCoupon discounts an existing subscription with a provider coupon
+	ApplyCoupon(ctx context.Context, subscriptionID, couponID string) error
+
+	// ParseWebhook verifies the signature header and decodes the event
+	ParseWebhook(payload []byte, signature string) (*Event, error)
+}
+
+// CheckoutParams describes the subscription a checkout session creates
+type CheckoutParams struct {
+	UserID     string
+	PlanID     string
+	PriceID    string
+	CustomerID string // reuse an existing customer when set
+	CouponID   string // provider coupon to discount the subscription with
+	Email      string
+	SuccessURL string
+	CancelURL  string
+}
+
+// Session is a hosted provider page the user is redirected to
+type Session struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// Event is a webhook normalised to the fields Billow needs. Fields the event
+// type doesn't carry are left zero.
+type Event struct {
+	ID                string
+	Type              string
+	CustomerID        string
+	SubscriptionID    string
+	UserID            string // from the metadata set at checkout
+	PlanID            string // from the metadata set at checkout
+	PriceID           string
+	Status            string // mapped to models.Subscription* statuses
+	CurrentPeriodEnd  time.Time
+	TrialEnd          *time.Time
+	CancelAtPeriodEnd bool
+}
+
+// DefaultProvider bills subscriptions. It is nil when no payment processor is
+// configured, in which case plan changes apply immediately without charging.
+var DefaultProvider = ProviderFromEnv()
+
+// ProviderFromEnv returns a Stripe provider when STRIPE_SECRET_KEY is set.
+// STRIPE_API_BASE points it at another Stripe-compatible API such as a mock.
+func ProviderFromEnv() Provider {
+	key := os.Getenv("STRIPE_SECRET_KEY")
+	if key == "" {
+		return nil
+	}
+
+	provider := NewStripeProvider(key, os.Getenv("STRIPE_WEBHOOK_SECRET"))
+	if base := os.Getenv("STRIPE_API_BASE"); base != "" {
+		provider.BaseURL = base
+	}
+	return provider
+}
+
+// ProviderCharge is the lifecycle charge used with a payment provider.
+// Subscriptions the provider bills are left to its webhooks; others renew as
+// before.
+func ProviderCharge(sub models.Subscription) error {
+	if sub.ProviderSubscriptionID != "" {
+		return ErrPaymentPending
+	}
+	return nil
+}
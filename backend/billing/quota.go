@@ -0,0 +1,136 @@
+package billing
+
+import (
+	"billow-backend/entitlements"
+	"billow-backend/models"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Quota names
+const (
+	InvoiceQuota = entitlements.InvoiceQuota
+	ClientQuota  = entitlements.ClientQuota
+)
+
+// Quota is a plan limit and how much of it has been used
+type Quota struct {
+	Name        string    `json:"name"`
+	Limit       int       `json:"limit"` // -1 for unlimited
+	Used        int64     `json:"used"`
+	Remaining   int64     `json:"remaining"` // -1 for unlimited
+	PeriodStart time.Time `json:"period_start,omitempty"`
+	PeriodEnd   time.Time `json:"period_end,omitempty"`
+}
+
+// Exceeded reports whether creating one more record would go over the limit
+func (q Quota) Exceeded() bool {
+	return q.Limit >= 0 && q.Used >= int64(q.Limit)
+}
+
+// QuotaExceededError is returned when a create would go over a plan limit. The
+// fiber error handler renders it as a 402 with the upgrade options.
+type QuotaExceededError struct {
+	Quota          Quota         `json:"quota"`
+	CurrentPlan    string        `json:"current_plan"`
+	UpgradeOptions []models.Plan `json:"upgrade_options"`
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s limit of %d reached", e.Quota.Name, e.Quota.Limit)
+}
+
+// AddInterval moves t forward by n plan intervals
+func AddInterval(t time.Time, interval string, n int) time.Time {
+	if interval == "year" {
+		return t.AddDate(n, 0, 0)
+	}
+	return t.AddDate(0, n, 0)
+}
+
+// CurrentPeriod returns the billing period containing now. Periods end at
+// CurrentPeriodEnd and last one plan interval; if the subscription hasn't been
+// renewed yet the period is rolled forward so usage is never counted against
+// a period that has already ended.
+func CurrentPeriod(sub models.Subscription, now time.Time) (time.Time, time.Time) {
+	end := sub.CurrentPeriodEnd
+	if end.IsZero() {
+		end = now
+	}
+	for !end.After(now) {
+		end = AddInterval(end, sub.Plan.Interval, 1)
+	}
+	return AddInterval(end, sub.Plan.Interval, -1), end
+}
+
+// LoadQuota counts usage of the named quota for the subscription's tenant
+// against limit, which comes from their entitlements. Invoices are limited per
+// billing period, clients in total.
+func LoadQuota(db *gorm.DB, sub models.Subscription, name string, limit int) (Quota, error) {
+	q := Quota{Name: name, Limit: limit}
+
+	switch name {
+	case InvoiceQuota:
+		q.PeriodStart, q.PeriodEnd = CurrentPeriod(sub, time.Now())
+		err := db.Model(&models.Invoice{}).
+			Where("user_id = ? AND created_at >= ? AND created_at < ?", sub.UserID, q.PeriodStart, q.PeriodEnd).
+			Count(&q.Used).Error
+		if err != nil {
+			return q, err
+		}
+	case ClientQuota:
+		if err := db.Model(&models.Client{}).Where("user_id = ?", sub.UserID).Count(&q.Used).Error; err != nil {
+			return q, err
+		}
+	default:
+		return q, fmt.Errorf("unknown quota %q", name)
+	}
+
+	q.Remaining = -1
+	if q.Limit >= 0 {
+		q.Remaining = int64(q.Limit) - q.Used
+		if q.Remaining < 0 {
+			q.Remaining = 0
+		}
+	}
+	return q, nil
+}
+
+// Reserve checks the named quota before a create. It takes a transaction-scoped
+// advisory lock per tenant and quota first, so concurrent creates are counted
+// one after another and can't both slip under the limit. db must be a
+// transaction for the lock to be held until the insert commits.
+func Reserve(db *gorm.DB, sub models.Subscription, name string, limit int) error {
+	if err := db.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "quota:"+sub.UserID+":"+name).Error; err != nil {
+		return err
+	}
+
+	q, err := LoadQuota(db, sub, name, limit)
+	if err != nil {
+		return err
+	}
+	// Metered plans bill usage beyond the limit as overage instead of blocking it
+	if _, metered := sub.Plan.MeterFor(name); !q.Exceeded() || metered {
+		return nil
+	}
+
+	return &QuotaExceededError{
+		Quota:          q,
+		CurrentPlan:    sub.Plan.Name,
+		UpgradeOptions: upgradeOptions(db, name, limit),
+	}
+}
+
+// upgradeOptions lists the plans with a higher limit for the quota
+func upgradeOptions(db *gorm.DB, name string, limit int) []models.Plan {
+	column := "invoice_limit"
+	if name == ClientQuota {
+		column = "client_limit"
+	}
+
+	plans := []models.Plan{}
+	db.Scopes(Offered).Where(column+" = -1 OR "+column+" > ?", limit).Order("price ASC").Find(&plans)
+	return plans
+}
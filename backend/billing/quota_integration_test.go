@@ -0,0 +1,92 @@
+//go:build integration
+
+package billing
+
+import (
+	"billow-backend/models"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestReserve(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	// The subscription hasn't been renewed past its last period end yet
+	staleEnd := now.AddDate(0, 0, -20)
+
+	metered := models.Plan{ID: "PLN-QT-METERED", Code: "PLN-QT-METERED", Name: "Metered", Interval: "month", InvoiceLimit: 2, ClientLimit: 1}
+	if err := metered.SetMeters([]models.Meter{{FeatureType: "invoice_created", Quota: InvoiceQuota, Aggregation: models.MeterPerPeriod, UnitPrice: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	small := models.Plan{ID: "PLN-QT-SMALL", Code: "PLN-QT-SMALL", Name: "Small", Interval: "month", InvoiceLimit: 2, ClientLimit: 1}
+	seed(t, db,
+		&small,
+		&models.Plan{ID: "PLN-QT-LARGE", Code: "PLN-QT-LARGE", Name: "Large", Price: 99, Interval: "month", InvoiceLimit: 100, ClientLimit: 50},
+		&metered,
+		&models.User{ID: "USR-QT", ClerkID: "clerk_qt", Email: "qt@quota.test"},
+		&models.Client{ID: "CLI-QT", UserID: "USR-QT", Name: "Quota client"},
+		&models.Invoice{ID: "INV-QT-OLD", UserID: "USR-QT", ClientID: "CLI-QT", CreatedAt: staleEnd.AddDate(0, 0, -1)},
+		&models.Invoice{ID: "INV-QT-1", UserID: "USR-QT", ClientID: "CLI-QT", CreatedAt: now.Add(-time.Hour)},
+	)
+	sub := models.Subscription{UserID: "USR-QT", PlanID: small.ID, Status: "active", CurrentPeriodEnd: staleEnd, Plan: small}
+
+	reserve := func(sub models.Subscription, name string, limit int) error {
+		return db.Transaction(func(tx *gorm.DB) error { return Reserve(tx, sub, name, limit) })
+	}
+
+	if err := reserve(sub, InvoiceQuota, 2); err != nil {
+		t.Errorf("second invoice of the period: %v", err)
+	}
+	seed(t, db, &models.Invoice{ID: "INV-QT-2", UserID: "USR-QT", ClientID: "CLI-QT", CreatedAt: now.Add(-time.Minute)})
+
+	err := reserve(sub, InvoiceQuota, 2)
+	var exceeded *QuotaExceededError
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("third invoice of the period: %v, want a QuotaExceededError", err)
+	}
+	if exceeded.Quota.Used != 2 || exceeded.Quota.Remaining != 0 || exceeded.CurrentPlan != "Small" {
+		t.Errorf("exceeded = %+v", exceeded)
+	}
+	if want := staleEnd.AddDate(0, 1, 0); !exceeded.Quota.PeriodStart.Equal(staleEnd) || !exceeded.Quota.PeriodEnd.Equal(want) {
+		t.Errorf("period = %v - %v, want %v - %v", exceeded.Quota.PeriodStart, exceeded.Quota.PeriodEnd, staleEnd, want)
+	}
+	if contains(exceeded.UpgradeOptions, "PLN-QT-SMALL") || !contains(exceeded.UpgradeOptions, "PLN-QT-LARGE") {
+		t.Errorf("upgrade options = %v", planIDs(exceeded.UpgradeOptions))
+	}
+
+	if err := reserve(sub, ClientQuota, 1); !errors.As(err, &exceeded) || exceeded.Quota.Used != 1 {
+		t.Errorf("second client: %v", err)
+	}
+	if err := reserve(sub, InvoiceQuota, -1); err != nil {
+		t.Errorf("unlimited invoices: %v", err)
+	}
+
+	// Metered plans bill the overage instead
+	sub.PlanID, sub.Plan = metered.ID, metered
+	if err := reserve(sub, InvoiceQuota, 2); err != nil {
+		t.Errorf("metered plan over its allowance: %v", err)
+	}
+	if err := reserve(sub, ClientQuota, 1); !errors.As(err, &exceeded) {
+		t.Errorf("metered plan over an unmetered limit: %v", err)
+	}
+}
+
+func planIDs(plans []models.Plan) []string {
+	ids := []string{}
+	for _, plan := range plans {
+		ids = append(ids, plan.ID)
+	}
+	return ids
+}
+
+func contains(plans []models.Plan, id string) bool {
+	for _, plan := range plans {
+		if plan.ID == id {
+			return true
+		}
+	}
+	return false
+}
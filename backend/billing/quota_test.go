@@ -0,0 +1,55 @@
+package billing
+
+import (
+	"billow-backend/models"
+	"testing"
+	"time"
+)
+
+func TestCurrentPeriod(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	monthly := models.Plan{Interval: "month"}
+	yearly := models.Plan{Interval: "year"}
+
+	tests := []struct {
+		name      string
+		end       time.Time
+		plan      models.Plan
+		now       time.Time
+		start, to time.Time
+	}{
+		{"inside the period", day(2026, 5, 1), monthly, day(2026, 4, 16), day(2026, 4, 1), day(2026, 5, 1)},
+		{"renewal not applied yet", day(2026, 2, 1), monthly, day(2026, 4, 16), day(2026, 4, 1), day(2026, 5, 1)},
+		{"period ends now", day(2026, 5, 1), monthly, day(2026, 5, 1), day(2026, 5, 1), day(2026, 6, 1)},
+		{"yearly plan rolls forward a year", day(2025, 1, 10), yearly, day(2026, 4, 16), day(2026, 1, 10), day(2027, 1, 10)},
+		{"no period end", time.Time{}, monthly, day(2026, 4, 16), day(2026, 4, 16), day(2026, 5, 16)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := CurrentPeriod(models.Subscription{CurrentPeriodEnd: tt.end, Plan: tt.plan}, tt.now)
+			if !start.Equal(tt.start) || !end.Equal(tt.to) {
+				t.Errorf("CurrentPeriod = %v - %v, want %v - %v", start, end, tt.start, tt.to)
+			}
+		})
+	}
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	tests := []struct {
+		limit    int
+		used     int64
+		exceeded bool
+	}{
+		{10, 9, false},
+		{10, 10, true},
+		{0, 0, true},
+		{-1, 1000, false},
+	}
+	for _, tt := range tests {
+		if got := (Quota{Limit: tt.limit, Used: tt.used}).Exceeded(); got != tt.exceeded {
+			t.Errorf("limit %d, used %d: Exceeded = %v", tt.limit, tt.used, got)
+		}
+	}
+}
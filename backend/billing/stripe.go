@@ -0,0 +1,488 @@
+package billing
+
+import (
+	"billow-backend/models"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webhookTolerance is how old a signed webhook may be before it is rejected
+// as a possible replay
+const webhookTolerance = 5 * time.Minute
+
+// StripeProvider talks to the Stripe API, or anything that speaks it
+type StripeProvider struct {
+	APIKey        string
+	WebhookSecret string
+	BaseURL       string
+	HTTPClient    *http.Client
+
+	now func() time.Time
+}
+
+// NewStripeProvider returns a provider for the live Stripe API
+func NewStripeProvider(apiKey, webhookSecret string) *StripeProvider {
+	return &StripeProvider{
+		APIKey:        apiKey,
+		WebhookSecret: webhookSecret,
+		BaseURL:       "https://api.stripe.com",
+		HTTPClient:    &http.Client{Timeout: 15 * time.Second},
+		now:           time.Now,
+	}
+}
+
+// StripeError is an error response from the Stripe API
+type StripeError struct {
+	Status  int
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *StripeError) Error() string {
+	return fmt.Sprintf("stripe: %s (%d %s)", e.Message, e.Status, e.Type)
+}
+
+func (p *StripeProvider) CreateCheckoutSession(ctx context.Context, params CheckoutParams) (*Session, error) {
+	form := url.Values{
+		"mode":                                 {"subscription"},
+		"line_items[0][price]":                 {params.PriceID},
+		"line_items[0][quantity]":              {"1"},
+		"success_url":                          {params.SuccessURL},
+		"cancel_url":                           {params.CancelURL},
+		"client_reference_id":                  {params.UserID},
+		"metadata[user_id]":                    {params.UserID},
+		"metadata[plan_id]":                    {params.PlanID},
+		"subscription_data[metadata][user_id]": {params.UserID},
+		"subscription_data[metadata][plan_id]": {params.PlanID},
+	}
+	if params.CustomerID != "" {
+		form.Set("customer", params.CustomerID)
+	} else if params.Email != "" {
+		form.Set("customer_email", params.Email)
+	}
+	if params.CouponID != "" {
+		form.Set("discounts[0][coupon]", params.CouponID)
+	}
+
+	var session Session
+	if err := p.do(ctx, http.MethodPost, "/v1/checkout/sessions", form, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (p *StripeProvider) CreatePortalSession(ctx context.Context, customerID, returnURL string) (*Session, error) {
+	form := url.Values{
+		"customer":   {customerID},
+		"return_url": {returnURL},
+	}
+
+	var session Session
+	if err := p.do(ctx, http.MethodPost, "/v1/billing_portal/sessions", form, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (p *StripeProvider) ChangePrice(ctx context.Context, subscriptionID, priceID string, prorate bool) error {
+	path := "/v1/subscriptions/" + url.PathEscape(subscriptionID)
+
+	var sub stripeSubscription
+	if err := p.do(ctx, http.MethodGet, path, nil, &sub); err != nil {
+		return err
+	}
+	if len(sub.Items.Data) == 0 {
+		return fmt.Errorf("stripe: subscription %s has no items", subscriptionID)
+	}
+
+	form := url.Values{
+		"items[0][id]":       {sub.Items.Data[0].ID},
+		"items[0][price]":    {priceID},
+		"proration_behavior": {"none"},
+	}
+	if prorate {
+		form.Set("proration_behavior", "always_invoice")
+	}
+	return p.do(ctx, http.MethodPost, path, form, nil)
+}
+
+func (p *StripeProvider) ApplyCoupon(ctx context.Context, subscriptionID, couponID string) error {
+	form := url.Values{"discounts[0][coupon]": {couponID}}
+	return p.do(ctx, http.MethodPost, "/v1/subscriptions/"+url.PathEscape(subscriptionID), form, nil)
+}
+
+func (p *StripeProvider) Name() string {
+	return "stripe"
+}
+
+// CreatePaymentCheckout starts a one-off checkout for an invoice. The invoice
+// is carried in the metadata of the session and of its payment intent.
+func (p *StripeProvider) CreatePaymentCheckout(ctx context.Context, params PaymentParams) (*Session, error) {
+	form := url.Values{
+		"mode":                                          {"payment"},
+		"line_items[0][price_data][currency]":           {strings.ToLower(params.Currency)},
+		"line_items[0][price_data][unit_amount]":        {strconv.FormatInt(MinorUnits(params.Amount, params.Currency), 10)},
+		"line_items[0][price_data][product_data][name]": {"Invoice " + params.InvoiceID},
+		"line_items[0][quantity]":                       {"1"},
+		"success_url":                                   {params.SuccessURL},
+		"cancel_url":                                    {params.CancelURL},
+		"client_reference_id":                           {params.InvoiceID},
+		"metadata[invoice_id]":                          {params.InvoiceID},
+		"metadata[user_id]":                             {params.UserID},
+		"payment_intent_data[metadata][invoice_id]":     {params.InvoiceID},
+		"payment_intent_data[metadata][user_id]":        {params.UserID},
+	}
+	if params.Email != "" {
+		form.Set("customer_email", params.Email)
+	}
+
+	var session Session
+	if err := p.do(ctx, http.MethodPost, "/v1/checkout/sessions", form, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (p *StripeProvider) SignatureHeader() string {
+	return "Stripe-Signature"
+}
+
+// ParsePaymentWebhook decodes the checkout events of invoice payments.
+// Delayed methods such as bank debits complete unpaid and report the outcome
+// in a later async_payment event.
+func (p *StripeProvider) ParsePaymentWebhook(payload []byte, signature string) (*PaymentEvent, error) {
+	if err := p.verifySignature(payload, signature); err != nil {
+		return nil, err
+	}
+
+	var envelope struct {
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		Created int64  `json:"created"`
+		Data    struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, fmt.Errorf("stripe: decoding event: %w", err)
+	}
+
+	event := &PaymentEvent{ID: envelope.ID, Gateway: p.Name(), PaidAt: unixTime(envelope.Created)}
+	switch envelope.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded", "checkout.session.async_payment_failed":
+	default:
+		return event, nil
+	}
+
+	var session struct {
+		ID            string            `json:"id"`
+		Mode          string            `json:"mode"`
+		PaymentStatus string            `json:"payment_status"`
+		PaymentIntent string            `json:"payment_intent"`
+		AmountTotal   int64             `json:"amount_total"`
+		Currency      string            `json:"currency"`
+		Metadata      map[string]string `json:"metadata"`
+	}
+	if err := json.Unmarshal(envelope.Data.Object, &session); err != nil {
+		return nil, fmt.Errorf("stripe: decoding checkout session: %w", err)
+	}
+	if session.Mode != "payment" || session.Metadata["invoice_id"] == "" {
+		return event, nil
+	}
+
+	switch {
+	case envelope.Type == "checkout.session.async_payment_failed":
+		event.Type = PaymentFailed
+	case session.PaymentStatus == "paid":
+		event.Type = PaymentSucceeded
+	default:
+		return event, nil // still processing
+	}
+	event.InvoiceID = session.Metadata["invoice_id"]
+	event.UserID = session.Metadata["user_id"]
+	event.Reference = session.PaymentIntent
+	if event.Reference == "" {
+		event.Reference = session.ID
+	}
+	event.Currency = strings.ToUpper(session.Currency)
+	event.Amount = FromMinorUnits(session.AmountTotal, event.Currency)
+	return event, nil
+}
+
+// do sends a form-encoded request and decodes the JSON response into out
+func (p *StripeProvider) do(ctx context.Context, method, path string, form url.Values, out interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(p.BaseURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var envelope struct {
+			Error StripeError `json:"error"`
+		}
+		json.Unmarshal(data, &envelope)
+		envelope.Error.Status = resp.StatusCode
+		if envelope.Error.Message == "" {
+			envelope.Error.Message = http.StatusText(resp.StatusCode)
+		}
+		return &envelope.Error
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// ParseWebhook checks the Stripe-Signature header, which holds a timestamp and
+// one or more HMAC-SHA256 signatures of "<timestamp>.<payload>", then decodes
+// the event. Event types Billow doesn't handle are returned with only ID and
+// Type set.
+func (p *StripeProvider) ParseWebhook(payload []byte, signature string) (*Event, error) {
+	if err := p.verifySignature(payload, signature); err != nil {
+		return nil, err
+	}
+
+	var envelope struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, fmt.Errorf("stripe: decoding event: %w", err)
+	}
+
+	event := &Event{ID: envelope.ID, Type: envelope.Type}
+	object := envelope.Data.Object
+
+	switch envelope.Type {
+	case EventCheckoutCompleted:
+		var session struct {
+			Mode              string            `json:"mode"`
+			Customer          string            `json:"customer"`
+			Subscription      string            `json:"subscription"`
+			ClientReferenceID string            `json:"client_reference_id"`
+			Metadata          map[string]string `json:"metadata"`
+		}
+		if err := json.Unmarshal(object, &session); err != nil {
+			return nil, fmt.Errorf("stripe: decoding checkout session: %w", err)
+		}
+		// Invoice payments are applied by the payment gateway
+		if session.Mode == "payment" {
+			return event, nil
+		}
+		event.CustomerID = session.Customer
+		event.SubscriptionID = session.Subscription
+		event.UserID = session.ClientReferenceID
+		if event.UserID == "" {
+			event.UserID = session.Metadata["user_id"]
+		}
+		event.PlanID = session.Metadata["plan_id"]
+
+	case EventSubscriptionUpdated, EventSubscriptionCanceled:
+		var sub stripeSubscription
+		if err := json.Unmarshal(object, &sub); err != nil {
+			return nil, fmt.Errorf("stripe: decoding subscription: %w", err)
+		}
+		event.CustomerID = sub.Customer
+		event.SubscriptionID = sub.ID
+		event.UserID = sub.Metadata["user_id"]
+		event.PlanID = sub.Metadata["plan_id"]
+		event.Status = stripeStatus(sub.Status)
+		event.CancelAtPeriodEnd = sub.CancelAtPeriodEnd
+		event.CurrentPeriodEnd = unixTime(sub.CurrentPeriodEnd)
+		if len(sub.Items.Data) > 0 {
+			item := sub.Items.Data[0]
+			event.PriceID = item.Price.ID
+			// Newer API versions report the period per item
+			if sub.CurrentPeriodEnd == 0 {
+				event.CurrentPeriodEnd = unixTime(item.CurrentPeriodEnd)
+			}
+		}
+		if sub.TrialEnd > 0 {
+			trialEnd := unixTime(sub.TrialEnd)
+			event.TrialEnd = &trialEnd
+		}
+
+	case EventInvoicePaid, EventPaymentFailed:
+		var invoice stripeInvoice
+		if err := json.Unmarshal(object, &invoice); err != nil {
+			return nil, fmt.Errorf("stripe: decoding invoice: %w", err)
+		}
+		event.CustomerID = invoice.Customer
+		event.SubscriptionID = invoice.Subscription
+		event.UserID = invoice.SubscriptionDetails.Metadata["user_id"]
+		// Newer API versions nest the subscription under parent
+		if event.SubscriptionID == "" {
+			details := invoice.Parent.SubscriptionDetails
+			event.SubscriptionID = details.Subscription
+			event.UserID = details.Metadata["user_id"]
+		}
+		// The price isn't taken from the lines: proration invoices carry the
+		// old price as well as the new one
+		for _, line := range invoice.Lines.Data {
+			if end := unixTime(line.Period.End); end.After(event.CurrentPeriodEnd) {
+				event.CurrentPeriodEnd = end
+			}
+		}
+	}
+
+	return event, nil
+}
+
+func (p *StripeProvider) verifySignature(payload []byte, header string) error {
+	if p.WebhookSecret == "" {
+		return fmt.Errorf("%w: no webhook secret configured", ErrInvalidSignature)
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	now := time.Now
+	if p.now != nil {
+		now = p.now
+	}
+	if age := now().Sub(time.Unix(seconds, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+
+	mac := hmac.New(sha256.New, []byte(p.WebhookSecret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	for _, signature := range signatures {
+		given, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(given, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// SignWebhook builds a Stripe-Signature header for payload. It is what a mock
+// server uses to send webhooks the provider accepts.
+func SignWebhook(secret string, payload []byte, at time.Time) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type stripeSubscription struct {
+	ID                string            `json:"id"`
+	Customer          string            `json:"customer"`
+	Status            string            `json:"status"`
+	CurrentPeriodEnd  int64             `json:"current_period_end"`
+	TrialEnd          int64             `json:"trial_end"`
+	CancelAtPeriodEnd bool              `json:"cancel_at_period_end"`
+	Metadata          map[string]string `json:"metadata"`
+	Items             struct {
+		Data []struct {
+			ID               string `json:"id"`
+			CurrentPeriodEnd int64  `json:"current_period_end"`
+			Price            struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+type stripeSubscriptionDetails struct {
+	Subscription string            `json:"subscription"`
+	Metadata     map[string]string `json:"metadata"`
+}
+
+type stripeInvoice struct {
+	Customer            string                    `json:"customer"`
+	Subscription        string                    `json:"subscription"`
+	SubscriptionDetails stripeSubscriptionDetails `json:"subscription_details"`
+	Parent              struct {
+		SubscriptionDetails stripeSubscriptionDetails `json:"subscription_details"`
+	} `json:"parent"`
+	Lines struct {
+		Data []struct {
+			Period struct {
+				End int64 `json:"end"`
+			} `json:"period"`
+		} `json:"data"`
+	} `json:"lines"`
+}
+
+// stripeStatus maps a Stripe subscription status onto ours. Statuses with no
+// equivalent, like incomplete, map to "" and leave the local status alone.
+func stripeStatus(status string) string {
+	switch status {
+	case "trialing":
+		return models.SubscriptionTrialing
+	case "active":
+		return models.SubscriptionActive
+	case "past_due", "unpaid":
+		return models.SubscriptionPastDue
+	case "canceled", "incomplete_expired":
+		return models.SubscriptionCanceled
+	default:
+		return ""
+	}
+}
+
+func unixTime(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
@@ -0,0 +1,213 @@
+package billing
+
+import (
+	"billow-backend/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// mockStripe serves the parts of the Stripe API the provider uses and
+// records the form of every request
+func mockStripe(t *testing.T) (*StripeProvider, map[string]map[string]string) {
+	t.Helper()
+	requests := map[string]map[string]string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk_test" {
+			w.WriteHeader(401)
+			w.Write([]byte(`{"error":{"type":"invalid_request_error","message":"Invalid API Key"}}`))
+			return
+		}
+		r.ParseForm()
+		form := map[string]string{}
+		for key := range r.PostForm {
+			form[key] = r.PostForm.Get(key)
+		}
+		requests[r.Method+" "+r.URL.Path] = form
+
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v1/checkout/sessions":
+			w.Write([]byte(`{"id":"cs_123","url":"https://checkout.test/cs_123"}`))
+		case "POST /v1/billing_portal/sessions":
+			w.Write([]byte(`{"id":"bps_123","url":"https://portal.test/bps_123"}`))
+		case "GET /v1/subscriptions/sub_123":
+			w.Write([]byte(`{"id":"sub_123","items":{"data":[{"id":"si_1","price":{"id":"price_old"}}]}}`))
+		case "POST /v1/subscriptions/sub_123":
+			w.Write([]byte(`{"id":"sub_123"}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":{"type":"invalid_request_error","message":"No such resource"}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewStripeProvider("sk_test", "whsec_test")
+	provider.BaseURL = server.URL
+	return provider, requests
+}
+
+func TestStripeCheckoutSession(t *testing.T) {
+	provider, requests := mockStripe(t)
+
+	session, err := provider.CreateCheckoutSession(context.Background(), CheckoutParams{
+		UserID:     "USR-1",
+		PlanID:     "PLN-PRO",
+		PriceID:    "price_pro",
+		Email:      "a@example.com",
+		SuccessURL: "https://app.test/ok",
+		CancelURL:  "https://app.test/cancel",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.URL != "https://checkout.test/cs_123" {
+		t.Errorf("url = %q", session.URL)
+	}
+
+	form := requests["POST /v1/checkout/sessions"]
+	want := map[string]string{
+		"mode":                                 "subscription",
+		"line_items[0][price]":                 "price_pro",
+		"client_reference_id":                  "USR-1",
+		"customer_email":                       "a@example.com",
+		"subscription_data[metadata][plan_id]": "PLN-PRO",
+	}
+	for key, value := range want {
+		if form[key] != value {
+			t.Errorf("%s = %q, want %q", key, form[key], value)
+		}
+	}
+}
+
+func TestStripePortalAndChangePrice(t *testing.T) {
+	provider, requests := mockStripe(t)
+
+	session, err := provider.CreatePortalSession(context.Background(), "cus_1", "https://app.test/settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.URL != "https://portal.test/bps_123" || requests["POST /v1/billing_portal/sessions"]["customer"] != "cus_1" {
+		t.Errorf("unexpected portal session %+v", session)
+	}
+
+	if err := provider.ChangePrice(context.Background(), "sub_123", "price_new", true); err != nil {
+		t.Fatal(err)
+	}
+	form := requests["POST /v1/subscriptions/sub_123"]
+	if form["items[0][id]"] != "si_1" || form["items[0][price]"] != "price_new" || form["proration_behavior"] != "always_invoice" {
+		t.Errorf("unexpected update %v", form)
+	}
+}
+
+func TestStripeAPIError(t *testing.T) {
+	provider, _ := mockStripe(t)
+	provider.APIKey = "sk_wrong"
+
+	_, err := provider.CreatePortalSession(context.Background(), "cus_1", "https://app.test")
+	var stripeErr *StripeError
+	if !errors.As(err, &stripeErr) || stripeErr.Status != 401 || stripeErr.Message != "Invalid API Key" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestStripeWebhookSignature(t *testing.T) {
+	provider := NewStripeProvider("sk_test", "whsec_test")
+	now := time.Unix(1700000000, 0)
+	provider.now = func() time.Time { return now }
+	payload := []byte(`{"id":"evt_1","type":"invoice.payment_failed","data":{"object":{"customer":"cus_1","subscription":"sub_1"}}}`)
+
+	tests := []struct {
+		name      string
+		signature string
+		valid     bool
+	}{
+		{"valid", SignWebhook("whsec_test", payload, now), true},
+		{"wrong secret", SignWebhook("whsec_other", payload, now), false},
+		{"too old", SignWebhook("whsec_test", payload, now.Add(-10*time.Minute)), false},
+		{"missing", "", false},
+		{"garbage", "t=abc,v1=zz", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := provider.ParseWebhook(payload, tt.signature)
+			if tt.valid {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if event.Type != EventPaymentFailed || event.SubscriptionID != "sub_1" || event.CustomerID != "cus_1" {
+					t.Errorf("unexpected event %+v", event)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("err = %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
+
+func TestStripeWebhookEvents(t *testing.T) {
+	provider := NewStripeProvider("sk_test", "whsec_test")
+
+	parse := func(t *testing.T, eventType string, object interface{}) *Event {
+		t.Helper()
+		data, _ := json.Marshal(object)
+		payload, _ := json.Marshal(map[string]interface{}{
+			"id":   "evt_" + eventType,
+			"type": eventType,
+			"data": map[string]json.RawMessage{"object": data},
+		})
+		event, err := provider.ParseWebhook(payload, SignWebhook("whsec_test", payload, time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return event
+	}
+
+	t.Run("subscription updated", func(t *testing.T) {
+		event := parse(t, EventSubscriptionUpdated, map[string]interface{}{
+			"id": "sub_1", "customer": "cus_1", "status": "past_due",
+			"cancel_at_period_end": true,
+			"metadata":             map[string]string{"user_id": "USR-1"},
+			"items": map[string]interface{}{"data": []interface{}{
+				map[string]interface{}{"id": "si_1", "current_period_end": 1700000000, "price": map[string]string{"id": "price_pro"}},
+			}},
+		})
+		if event.Status != models.SubscriptionPastDue || !event.CancelAtPeriodEnd || event.PriceID != "price_pro" ||
+			event.UserID != "USR-1" || !event.CurrentPeriodEnd.Equal(time.Unix(1700000000, 0)) {
+			t.Errorf("unexpected event %+v", event)
+		}
+	})
+
+	t.Run("invoice paid", func(t *testing.T) {
+		event := parse(t, EventInvoicePaid, map[string]interface{}{
+			"customer": "cus_1",
+			"parent": map[string]interface{}{"subscription_details": map[string]interface{}{
+				"subscription": "sub_1", "metadata": map[string]string{"user_id": "USR-1"},
+			}},
+			"lines": map[string]interface{}{"data": []interface{}{
+				map[string]interface{}{"period": map[string]int64{"end": 1700000000}},
+				map[string]interface{}{"period": map[string]int64{"end": 1702592000}},
+			}},
+		})
+		if event.SubscriptionID != "sub_1" || !event.CurrentPeriodEnd.Equal(time.Unix(1702592000, 0)) {
+			t.Errorf("unexpected event %+v", event)
+		}
+	})
+
+	t.Run("checkout completed", func(t *testing.T) {
+		event := parse(t, EventCheckoutCompleted, map[string]interface{}{
+			"customer": "cus_1", "subscription": "sub_1", "client_reference_id": "USR-1",
+			"metadata": map[string]string{"plan_id": "PLN-PRO"},
+		})
+		if event.UserID != "USR-1" || event.PlanID != "PLN-PRO" || event.SubscriptionID != "sub_1" {
+			t.Errorf("unexpected event %+v", event)
+		}
+	})
+}
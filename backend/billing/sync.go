@@ -0,0 +1,197 @@
+package billing
+
+import (
+	"billow-backend/audit"
+	"billow-backend/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrUnknownSubscription is returned for webhooks that can't be matched to a
+// local subscription
+var ErrUnknownSubscription = errors.New("no subscription matches the billing event")
+
+// ApplyEvent brings the local subscription in line with a provider webhook.
+// Each event is applied at most once; redelivered events are ignored.
+func ApplyEvent(db *gorm.DB, event *Event) error {
+	switch event.Type {
+	case EventCheckoutCompleted, EventInvoicePaid, EventPaymentFailed,
+		EventSubscriptionUpdated, EventSubscriptionCanceled:
+	default:
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		record := models.BillingEvent{ID: event.ID, Type: event.Type}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil // already applied
+		}
+
+		sub, err := findEventSubscription(tx, event)
+		if err != nil {
+			return err
+		}
+
+		before := *sub
+		if err := applyEvent(tx, sub, event, time.Now()); err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Save(sub).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&record).Update("subscription_id", sub.ID).Error; err != nil {
+			return err
+		}
+
+		audit.RecordSystem(tx, audit.Entry{
+			UserID:     sub.UserID,
+			ActorID:    "billing",
+			Action:     audit.SubscriptionUpdated,
+			TargetType: "subscription",
+			TargetID:   sub.ID,
+			Before:     before,
+			After:      *sub,
+		})
+		return nil
+	})
+}
+
+// findEventSubscription locks the subscription an event is about, matching on
+// the provider subscription, then the customer, then the user from metadata.
+// A completed checkout for a user without a subscription starts a new one.
+func findEventSubscription(tx *gorm.DB, event *Event) (*models.Subscription, error) {
+	lookups := []struct {
+		column string
+		value  string
+	}{
+		{"provider_subscription_id", event.SubscriptionID},
+		{"provider_customer_id", event.CustomerID},
+		{"user_id", event.UserID},
+	}
+
+	var sub models.Subscription
+	for _, lookup := range lookups {
+		if lookup.value == "" {
+			continue
+		}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(lookup.column+" = ?", lookup.value).
+			Order("updated_at DESC").
+			First(&sub).Error
+		if err == nil {
+			return &sub, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	if event.Type != EventCheckoutCompleted || event.UserID == "" {
+		return nil, ErrUnknownSubscription
+	}
+
+	var user models.User
+	if err := tx.First(&user, "id = ?", event.UserID).Error; err != nil {
+		return nil, ErrUnknownSubscription
+	}
+	sub = models.Subscription{
+		ID:     models.GenerateSubscriptionID(),
+		UserID: user.ID,
+		PlanID: event.PlanID,
+	}
+	if err := tx.Create(&sub).Error; err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func applyEvent(tx *gorm.DB, sub *models.Subscription, event *Event, now time.Time) error {
+	if event.CustomerID != "" {
+		sub.ProviderCustomerID = event.CustomerID
+	}
+	if event.SubscriptionID != "" {
+		sub.ProviderSubscriptionID = event.SubscriptionID
+	}
+	if err := applyEventPlan(tx, sub, event); err != nil {
+		return err
+	}
+
+	switch event.Type {
+	case EventCheckoutCompleted:
+		sub.Status = models.SubscriptionActive
+		sub.CancelAtPeriodEnd = false
+		sub.CanceledAt = nil
+		// Provisional until the first invoice.paid reports the real period
+		if !sub.CurrentPeriodEnd.After(now) {
+			var plan models.Plan
+			if err := tx.First(&plan, "id = ?", sub.PlanID).Error; err != nil {
+				return err
+			}
+			sub.CurrentPeriodEnd = AddInterval(now, plan.Interval, 1)
+		}
+
+	case EventSubscriptionUpdated:
+		if event.Status != "" {
+			sub.Status = event.Status
+		}
+		if !event.CurrentPeriodEnd.IsZero() {
+			sub.CurrentPeriodEnd = event.CurrentPeriodEnd
+		}
+		sub.TrialEnd = event.TrialEnd
+		sub.CancelAtPeriodEnd = event.CancelAtPeriodEnd
+		if sub.Status != models.SubscriptionCanceled {
+			sub.CanceledAt = nil
+		}
+
+	case EventSubscriptionCanceled:
+		sub.Status = models.SubscriptionCanceled
+		sub.CancelAtPeriodEnd = false
+		sub.CanceledAt = &now
+
+	case EventInvoicePaid:
+		sub.Status = models.SubscriptionActive
+		sub.CanceledAt = nil
+		if event.CurrentPeriodEnd.After(sub.CurrentPeriodEnd) {
+			// A new period has started; a scheduled downgrade applies now
+			sub.CurrentPeriodEnd = event.CurrentPeriodEnd
+			if err := ApplyScheduledPlan(tx, sub); err != nil {
+				return err
+			}
+		}
+
+	case EventPaymentFailed:
+		sub.Status = models.SubscriptionPastDue
+	}
+	return nil
+}
+
+// applyEventPlan switches the plan when the event names one we know, by
+// provider price first and checkout metadata second. A price that is only
+// scheduled for the next period leaves the plan alone until then.
+func applyEventPlan(tx *gorm.DB, sub *models.Subscription, event *Event) error {
+	if event.PriceID != "" {
+		var plan models.Plan
+		err := tx.First(&plan, "provider_price_id = ?", event.PriceID).Error
+		if err == nil {
+			if plan.ID != sub.ScheduledPlanID {
+				sub.PlanID = plan.ID
+				sub.ScheduledPlanID = ""
+			}
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+	if event.PlanID != "" {
+		sub.PlanID = event.PlanID
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"billow-backend/models"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// MigrateBusinessEntities lifts the one-per-user limit business profiles and
+// bank accounts started with, and moves clients and invoices that belong to no
+// business onto their owner's default one. It runs in one transaction, so a
+// failed step leaves the tables as they were.
+func MigrateBusinessEntities(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range []string{"business_profiles", "bank_accounts"} {
+			if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s_user_id_key", table, table)).Error; err != nil {
+				return err
+			}
+		}
+		err := tx.Exec(`UPDATE business_profiles SET is_default = true WHERE id IN (
+			SELECT DISTINCT ON (user_id) id FROM business_profiles b
+			WHERE NOT EXISTS (SELECT 1 FROM business_profiles d WHERE d.user_id = b.user_id AND d.is_default)
+			ORDER BY user_id, created_at)`).Error
+		if err != nil {
+			return err
+		}
+		for _, table := range []string{"clients", "invoices"} {
+			err := tx.Exec(fmt.Sprintf(`UPDATE %[1]s SET business_id = b.id FROM business_profiles b
+				WHERE b.user_id = %[1]s.user_id AND b.is_default AND COALESCE(%[1]s.business_id, '') = ''`, table)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateClientAddresses moves the free-text addresses clients started with
+// into their structured billing address, see parseLegacyAddress. The old
+// column is kept so the result can be checked against it; drop it by hand
+// once it has been.
+func MigrateClientAddresses(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("clients", "address") {
+		return nil
+	}
+	var legacy []struct{ ID, Address string }
+	err := db.Table("clients").Select("id", "address").
+		Where("btrim(COALESCE(address, '')) <> '' AND COALESCE(billing_address_line1, '') = ''").
+		Find(&legacy).Error
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, client := range legacy {
+			address := parseLegacyAddress(client.Address)
+			err := tx.Model(&models.Client{}).Where("id = ?", client.ID).Updates(map[string]interface{}{
+				"billing_address_line1":       address.Line1,
+				"billing_address_line2":       address.Line2,
+				"billing_address_city":        address.City,
+				"billing_address_region":      address.Region,
+				"billing_address_postal_code": address.PostalCode,
+				"billing_address_country":     address.Country,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// legacyCountries are the country names free-text addresses tend to end with
+var legacyCountries = map[string]string{
+	"usa": "US", "united states": "US", "united states of america": "US",
+	"uk": "GB", "united kingdom": "GB", "great britain": "GB", "england": "GB",
+	"germany": "DE", "deutschland": "DE", "france": "FR", "switzerland": "CH",
+	"schweiz": "CH", "suisse": "CH", "austria": "AT", "österreich": "AT",
+	"netherlands": "NL", "the netherlands": "NL", "belgium": "BE", "spain": "ES",
+	"italy": "IT", "ireland": "IE", "canada": "CA", "australia": "AU", "india": "IN",
+}
+
+// Postal lines of free-text addresses: "10115 Berlin", "San Francisco, CA
+// 94105" and "London SW1A 1AA"
+var (
+	postalFirst  = regexp.MustCompile(`^(\d{4,5}|[A-Z]{1,2}-\d{4,5})\s+(\D.*)$`)
+	regionPostal = regexp.MustCompile(`^(.+?),?\s+([A-Z]{2})\s+(\d{5}(?:-\d{4})?)$`)
+	cityPostal   = regexp.MustCompile(`^(.+?),?\s+([A-Z]{1,2}\d[A-Z\d]?\s*\d[A-Z]{2}|[A-Z]\d[A-Z]\s*\d[A-Z]\d)$`)
+)
+
+// parseLegacyAddress splits a free-text address into its parts. A last line
+// naming a country becomes the country, and the line before it the postal
+// code and city when it looks like one; the lines left become line 1 and,
+// joined with commas, line 2. Text that doesn't fit stays in the lines.
+func parseLegacyAddress(text string) models.Address {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	var address models.Address
+	if n := len(lines); n > 1 {
+		last := strings.TrimRight(lines[n-1], ".")
+		if code, ok := legacyCountries[strings.ToLower(last)]; ok {
+			address.Country, lines = code, lines[:n-1]
+		} else if len(last) == 2 && strings.ToUpper(last) == last && !strings.ContainsAny(last, "0123456789") {
+			address.Country, lines = last, lines[:n-1]
+		}
+	}
+	if n := len(lines); n > 1 {
+		postal := lines[n-1]
+		if m := postalFirst.FindStringSubmatch(postal); m != nil {
+			address.PostalCode, address.City = m[1], m[2]
+		} else if m := regionPostal.FindStringSubmatch(postal); m != nil {
+			address.City, address.Region, address.PostalCode = m[1], m[2], m[3]
+		} else if m := cityPostal.FindStringSubmatch(postal); m != nil {
+			address.City, address.PostalCode = m[1], m[2]
+		}
+		if address.City != "" {
+			lines = lines[:n-1]
+		}
+	}
+	if len(lines) > 0 {
+		address.Line1 = lines[0]
+		address.Line2 = strings.Join(lines[1:], ", ")
+	}
+	return address
+}
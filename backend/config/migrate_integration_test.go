@@ -0,0 +1,76 @@
+//go:build integration
+
+package config
+
+import (
+	"billow-backend/models"
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestMigrateBusinessEntities(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.BusinessProfile{}, &models.BankAccount{}, &models.Client{}, &models.Invoice{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	older := time.Now().Add(-time.Hour)
+	records := []interface{}{
+		&models.User{ID: "USR-MB", ClerkID: "clerk_mb", Email: "mb@migrate.test"},
+		&models.User{ID: "USR-MB-SET", ClerkID: "clerk_mb_set", Email: "set@migrate.test"},
+		&models.BusinessProfile{ID: "BIZ-MB-OLD", UserID: "USR-MB", LegalName: "First", CreatedAt: older},
+		&models.BusinessProfile{ID: "BIZ-MB-NEW", UserID: "USR-MB", LegalName: "Second"},
+		&models.BusinessProfile{ID: "BIZ-MB-SET-OLD", UserID: "USR-MB-SET", LegalName: "First", CreatedAt: older},
+		&models.BusinessProfile{ID: "BIZ-MB-SET", UserID: "USR-MB-SET", LegalName: "Chosen", IsDefault: true},
+		&models.Client{ID: "CLI-MB", UserID: "USR-MB", Name: "Unassigned"},
+		&models.Client{ID: "CLI-MB-NEW", UserID: "USR-MB", BusinessID: "BIZ-MB-NEW", Name: "Assigned"},
+		&models.Invoice{ID: "INV-MB", UserID: "USR-MB", ClientID: "CLI-MB"},
+	}
+	for _, record := range records {
+		record := record
+		if err := db.Create(record).Error; err != nil {
+			t.Fatalf("seed %T: %v", record, err)
+		}
+		t.Cleanup(func() { db.Delete(record) })
+	}
+
+	// Running again on every start changes nothing more
+	for i := 0; i < 2; i++ {
+		if err := MigrateBusinessEntities(db); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+
+	defaults := map[string]bool{}
+	var businesses []models.BusinessProfile
+	db.Where("user_id IN ?", []string{"USR-MB", "USR-MB-SET"}).Find(&businesses)
+	for _, business := range businesses {
+		defaults[business.ID] = business.IsDefault
+	}
+	want := map[string]bool{"BIZ-MB-OLD": true, "BIZ-MB-NEW": false, "BIZ-MB-SET-OLD": false, "BIZ-MB-SET": true}
+	for id, isDefault := range want {
+		if defaults[id] != isDefault {
+			t.Errorf("%s default = %v, want %v", id, defaults[id], isDefault)
+		}
+	}
+
+	var client, assigned models.Client
+	db.First(&client, "id = ?", "CLI-MB")
+	db.First(&assigned, "id = ?", "CLI-MB-NEW")
+	var invoice models.Invoice
+	db.First(&invoice, "id = ?", "INV-MB")
+	if client.BusinessID != "BIZ-MB-OLD" || invoice.BusinessID != "BIZ-MB-OLD" || assigned.BusinessID != "BIZ-MB-NEW" {
+		t.Errorf("businesses: client %q, invoice %q, assigned client %q", client.BusinessID, invoice.BusinessID, assigned.BusinessID)
+	}
+}
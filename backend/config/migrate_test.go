@@ -0,0 +1,32 @@
+package config
+
+import (
+	"billow-backend/models"
+	"testing"
+)
+
+func TestParseLegacyAddress(t *testing.T) {
+	tests := []struct {
+		text string
+		want models.Address
+	}{
+		{"1 Market Street\nSan Francisco, CA 94105\nUSA",
+			models.Address{Line1: "1 Market Street", City: "San Francisco", Region: "CA", PostalCode: "94105", Country: "US"}},
+		{"Hauptstr. 1\n10115 Berlin\nGermany",
+			models.Address{Line1: "Hauptstr. 1", City: "Berlin", PostalCode: "10115", Country: "DE"}},
+		{"10 Downing Street\nLondon SW1A 2AA\nUnited Kingdom",
+			models.Address{Line1: "10 Downing Street", City: "London", PostalCode: "SW1A 2AA", Country: "GB"}},
+		{"Acme SARL\n Floor 3 \n12 Rue de Rivoli\n75001 Paris\nFR",
+			models.Address{Line1: "Acme SARL", Line2: "Floor 3, 12 Rue de Rivoli", City: "Paris", PostalCode: "75001", Country: "FR"}},
+		{"Bahnhofstrasse 5\r\n8001 Zürich\r\nCH\r\n",
+			models.Address{Line1: "Bahnhofstrasse 5", City: "Zürich", PostalCode: "8001", Country: "CH"}},
+		{"PO Box 12\nSpringfield\nSomewhere",
+			models.Address{Line1: "PO Box 12", Line2: "Springfield, Somewhere"}},
+		{"Just one line, 12345 Town", models.Address{Line1: "Just one line, 12345 Town"}},
+	}
+	for _, tt := range tests {
+		if got := parseLegacyAddress(tt.text); got != tt.want {
+			t.Errorf("parseLegacyAddress(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+)
+
+// TenantRole is the restricted Postgres role request transactions switch to.
+// Row-level security policies only apply to this role, so background jobs and
+// migrations running as the connection owner are unaffected.
+const TenantRole = "billow_tenant"
+
+// TenantTables are the tables isolated by row-level security on user_id
+var TenantTables = []string{
+	"clients",
+	"client_contacts",
+	"invoices",
+	"subscriptions",
+	"user_preferences",
+	"usage_logs",
+	"analytics_data",
+	"audit_events",
+	"usage_counters",
+	"discounts",
+	"user_add_ons",
+	"entitlement_overrides",
+	"usage_records",
+	"portal_links",
+	"portal_sessions",
+	"invoice_shares",
+	"invoice_views",
+	"payments",
+	"bank_accounts",
+	"business_profiles",
+	"brandings",
+	"invoice_templates",
+}
+
+// AppendOnlyTables can be inserted into and read by tenants but never changed
+var AppendOnlyTables = []string{
+	"audit_events",
+}
+
+// RLSEnabled reports whether row-level security was set up successfully
+var RLSEnabled bool
+
+// EnableRowLevelSecurity creates the tenant role and the isolation policies.
+// It is safe to run on every start. It fails when the database user lacks the
+// privileges to create roles, and the server doesn't start without it.
+func EnableRowLevelSecurity() error {
+	statements := []string{
+		fmt.Sprintf(`DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = '%s') THEN
+				CREATE ROLE %s NOLOGIN;
+			END IF;
+		END $$`, TenantRole, TenantRole),
+		fmt.Sprintf("GRANT %s TO CURRENT_USER", TenantRole),
+		fmt.Sprintf("GRANT USAGE ON SCHEMA public TO %s", TenantRole),
+		fmt.Sprintf("GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO %s", TenantRole),
+	}
+
+	for _, table := range TenantTables {
+		statements = append(statements,
+			fmt.Sprintf("ALTER TABLE %s ENABLE ROW LEVEL SECURITY", table),
+			fmt.Sprintf("DROP POLICY IF EXISTS tenant_isolation ON %s", table),
+			fmt.Sprintf(`CREATE POLICY tenant_isolation ON %s TO %s
+				USING (user_id = current_setting('app.tenant_id', true))
+				WITH CHECK (user_id = current_setting('app.tenant_id', true))`, table, TenantRole),
+		)
+	}
+
+	for _, table := range AppendOnlyTables {
+		statements = append(statements, fmt.Sprintf("REVOKE UPDATE, DELETE ON %s FROM %s", table, TenantRole))
+	}
+
+	for _, stmt := range statements {
+		if err := DB.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+
+	RLSEnabled = true
+	return nil
+}
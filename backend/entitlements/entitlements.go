@@ -0,0 +1,196 @@
+// Package entitlements resolves what a tenant may use: the features and quota
+// limits of their plan, extended by add-ons and replaced by per-user overrides.
+package entitlements
+
+import (
+	"billow-backend/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Quota names
+const (
+	InvoiceQuota = "invoices"
+	ClientQuota  = "clients"
+	MessageQuota = "messages_per_day"
+)
+
+// Quotas lists every quota a tenant can be limited on
+var Quotas = []string{InvoiceQuota, ClientQuota, MessageQuota}
+
+// Where an entitlement came from
+const (
+	SourceNone     = "none"
+	SourcePlan     = "plan"
+	SourceAddOn    = "add_on"
+	SourceOverride = "override"
+)
+
+// Feature is the resolved state of one feature
+type Feature struct {
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source"`
+}
+
+// Quota is the resolved limit of one quota
+type Quota struct {
+	Limit  int    `json:"limit"` // -1 for unlimited
+	Source string `json:"source"`
+}
+
+// Entitlements are everything a tenant may use. Features and quotas that
+// aren't listed are denied.
+type Entitlements struct {
+	PlanID   string             `json:"plan_id,omitempty"`
+	Active   bool               `json:"active"` // the subscription currently grants access to its plan
+	Features map[string]Feature `json:"features"`
+	Quotas   map[string]Quota   `json:"quotas"`
+	AddOns   []string           `json:"add_ons"`
+}
+
+// Has reports whether feature is enabled. Unknown features are denied.
+func (e *Entitlements) Has(feature string) bool {
+	if e == nil {
+		return false
+	}
+	return e.Features[feature].Enabled
+}
+
+// Limit returns the limit of the named quota, -1 for unlimited. Unknown
+// quotas have a limit of 0.
+func (e *Entitlements) Limit(quota string) int {
+	if e == nil {
+		return 0
+	}
+	return e.Quotas[quota].Limit
+}
+
+// Resolve combines a subscription's plan with add-ons and overrides. Plan
+// features and add-ons only count while the subscription grants access; plan
+// limits always apply so existing records stay counted. Overrides apply
+// whatever the subscription state.
+func Resolve(sub *models.Subscription, addOns []models.UserAddOn, overrides []models.EntitlementOverride, now time.Time) *Entitlements {
+	e := &Entitlements{Features: map[string]Feature{}, Quotas: map[string]Quota{}, AddOns: []string{}}
+	for _, feature := range models.PlanFeatures {
+		e.Features[feature] = Feature{Source: SourceNone}
+	}
+	for _, quota := range Quotas {
+		e.Quotas[quota] = Quota{Source: SourceNone}
+	}
+
+	if sub != nil {
+		e.PlanID = sub.PlanID
+		e.Active = sub.HasAccess(now)
+		for _, quota := range Quotas {
+			e.Quotas[quota] = Quota{Limit: planLimit(sub.Plan, quota), Source: SourcePlan}
+		}
+		if e.Active {
+			for _, feature := range sub.Plan.Features() {
+				e.Features[feature] = Feature{Enabled: true, Source: SourcePlan}
+			}
+		}
+	}
+
+	if e.Active {
+		for _, owned := range addOns {
+			if !owned.ActiveAt(now) {
+				continue
+			}
+			e.AddOns = append(e.AddOns, owned.AddOnID)
+			for _, feature := range owned.AddOn.FeatureList() {
+				if !e.Features[feature].Enabled {
+					e.Features[feature] = Feature{Enabled: true, Source: SourceAddOn}
+				}
+			}
+			for _, quota := range Quotas {
+				extra := planLimit(models.Plan{
+					InvoiceLimit:   owned.AddOn.InvoiceLimit,
+					ClientLimit:    owned.AddOn.ClientLimit,
+					MessagesPerDay: owned.AddOn.MessagesPerDay,
+				}, quota)
+				if extra != 0 {
+					e.Quotas[quota] = Quota{Limit: addLimit(e.Quotas[quota].Limit, extra, owned.Quantity), Source: SourceAddOn}
+				}
+			}
+		}
+		sort.Strings(e.AddOns)
+	}
+
+	for _, override := range overrides {
+		if !override.ActiveAt(now) {
+			continue
+		}
+		if _, ok := e.Features[override.Key]; ok && override.Enabled != nil {
+			e.Features[override.Key] = Feature{Enabled: *override.Enabled, Source: SourceOverride}
+		}
+		if _, ok := e.Quotas[override.Key]; ok && override.Limit != nil {
+			e.Quotas[override.Key] = Quota{Limit: *override.Limit, Source: SourceOverride}
+		}
+	}
+	return e
+}
+
+// Load resolves the entitlements of userID, whose subscription is sub or nil
+// when they have none
+func Load(db *gorm.DB, userID string, sub *models.Subscription, now time.Time) (*Entitlements, error) {
+	var addOns []models.UserAddOn
+	if err := db.Preload("AddOn").Where("user_id = ?", userID).Find(&addOns).Error; err != nil {
+		return nil, err
+	}
+	var overrides []models.EntitlementOverride
+	if err := db.Where("user_id = ?", userID).Find(&overrides).Error; err != nil {
+		return nil, err
+	}
+	return Resolve(sub, addOns, overrides, now), nil
+}
+
+// IsKey reports whether key names a feature or quota
+func IsKey(key string) bool {
+	for _, feature := range models.PlanFeatures {
+		if key == feature {
+			return true
+		}
+	}
+	for _, quota := range Quotas {
+		if key == quota {
+			return true
+		}
+	}
+	return false
+}
+
+// IsQuota reports whether key names a quota
+func IsQuota(key string) bool {
+	for _, quota := range Quotas {
+		if key == quota {
+			return true
+		}
+	}
+	return false
+}
+
+func planLimit(plan models.Plan, quota string) int {
+	switch quota {
+	case InvoiceQuota:
+		return plan.InvoiceLimit
+	case ClientQuota:
+		return plan.ClientLimit
+	case MessageQuota:
+		return plan.MessagesPerDay
+	}
+	return 0
+}
+
+// addLimit raises limit by quantity units of extra; either being unlimited
+// makes the result unlimited
+func addLimit(limit, extra, quantity int) int {
+	if quantity < 1 {
+		quantity = 1
+	}
+	if limit < 0 || extra < 0 {
+		return -1
+	}
+	return limit + extra*quantity
+}
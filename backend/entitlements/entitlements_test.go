@@ -0,0 +1,73 @@
+package entitlements
+
+import (
+	"billow-backend/models"
+	"testing"
+	"time"
+)
+
+func TestResolve(t *testing.T) {
+	now := time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC)
+	yesterday := now.AddDate(0, 0, -1)
+	on, off := true, false
+	unlimited, ten := -1, 10
+
+	starter := models.Plan{ID: "PLN-STARTER", InvoiceLimit: 5, ClientLimit: 3, MessagesPerDay: 50}
+	active := &models.Subscription{PlanID: starter.ID, Plan: starter, Status: models.SubscriptionActive}
+	canceled := &models.Subscription{PlanID: starter.ID, Plan: starter, Status: models.SubscriptionCanceled}
+
+	extraClients := models.UserAddOn{AddOnID: "ADD-CLIENTS", Quantity: 2, AddOn: models.AddOn{Active: true, ClientLimit: 10}}
+	whiteLabel := models.UserAddOn{AddOnID: "ADD-WHITE", AddOn: models.AddOn{Active: true, Features: "white_label"}}
+	expiredAddOn := whiteLabel
+	expiredAddOn.ExpiresAt = &yesterday
+
+	tests := []struct {
+		name      string
+		sub       *models.Subscription
+		addOns    []models.UserAddOn
+		overrides []models.EntitlementOverride
+		feature   string
+		enabled   bool
+		quota     string
+		limit     int
+		source    string
+	}{
+		{"plan limit", active, nil, nil, "white_label", false, ClientQuota, 3, SourcePlan},
+		{"add-on adds per unit", active, []models.UserAddOn{extraClients}, nil, "white_label", false, ClientQuota, 23, SourceAddOn},
+		{"add-on grants a feature", active, []models.UserAddOn{whiteLabel}, nil, "white_label", true, ClientQuota, 3, SourcePlan},
+		{"expired add-on is ignored", active, []models.UserAddOn{expiredAddOn}, nil, "white_label", false, ClientQuota, 3, SourcePlan},
+		{"inactive subscription ignores add-ons", canceled, []models.UserAddOn{whiteLabel, extraClients}, nil, "white_label", false, ClientQuota, 3, SourcePlan},
+		{"override replaces a limit", active, []models.UserAddOn{extraClients},
+			[]models.EntitlementOverride{{Key: ClientQuota, Limit: &unlimited}}, "white_label", false, ClientQuota, -1, SourceOverride},
+		{"override revokes a feature", active, []models.UserAddOn{whiteLabel},
+			[]models.EntitlementOverride{{Key: "white_label", Enabled: &off}}, "white_label", false, ClientQuota, 3, SourcePlan},
+		{"override applies without a subscription", nil, nil,
+			[]models.EntitlementOverride{{Key: "white_label", Enabled: &on}, {Key: InvoiceQuota, Limit: &ten}}, "white_label", true, InvoiceQuota, 10, SourceOverride},
+		{"expired override is ignored", active, nil,
+			[]models.EntitlementOverride{{Key: "white_label", Enabled: &on, ExpiresAt: &yesterday}}, "white_label", false, ClientQuota, 3, SourcePlan},
+		{"unknown feature is denied", active, nil,
+			[]models.EntitlementOverride{{Key: "teleportation", Enabled: &on}}, "teleportation", false, "teleportation", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := Resolve(tt.sub, tt.addOns, tt.overrides, now)
+			if got := e.Has(tt.feature); got != tt.enabled {
+				t.Errorf("Has(%s) = %v, want %v", tt.feature, got, tt.enabled)
+			}
+			if got := e.Limit(tt.quota); got != tt.limit {
+				t.Errorf("Limit(%s) = %d, want %d", tt.quota, got, tt.limit)
+			}
+			if got := e.Quotas[tt.quota].Source; got != tt.source {
+				t.Errorf("source = %q, want %q", got, tt.source)
+			}
+		})
+	}
+}
+
+func TestNilEntitlementsDenyEverything(t *testing.T) {
+	var e *Entitlements
+	if e.Has("advanced_analytics") || e.Limit(InvoiceQuota) != 0 {
+		t.Fatal("nil entitlements granted something")
+	}
+}
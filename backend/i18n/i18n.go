@@ -0,0 +1,280 @@
+// Package i18n translates the text Billow writes for users and their clients,
+// and formats numbers, amounts and dates the way a locale writes them.
+//
+// Catalogs map English messages to their translation, one JSON file per
+// language. Messages without a translation stay in English.
+package i18n
+
+import (
+	"billow-backend/models"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Default is the locale used when none is set, and the language messages are
+// written in
+const Default = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalogs holds the translations of each language
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	catalogs := map[string]map[string]string{}
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		data, err := localeFiles.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", file.Name(), err))
+		}
+		catalogs[strings.TrimSuffix(file.Name(), ".json")] = messages
+	}
+	return catalogs
+}
+
+// Languages lists the languages messages are translated into
+func Languages() []string {
+	languages := []string{Default}
+	for language := range catalogs {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+var tagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// Normalize cleans up a locale tag like de_at into de-AT, and reports whether
+// it is well-formed
+func Normalize(tag string) (string, bool) {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if !tagPattern.MatchString(tag) {
+		return "", false
+	}
+	parts := strings.Split(tag, "-")
+	for i := 1; i < len(parts); i++ {
+		if len(parts[i]) == 2 {
+			parts[i] = strings.ToUpper(parts[i])
+		}
+	}
+	return strings.Join(parts, "-"), true
+}
+
+// Language returns the language of a locale, e.g. de for de-AT
+func Language(locale string) string {
+	language, _, _ := strings.Cut(tableKey(locale), "-")
+	if language == "" {
+		return Default
+	}
+	return language
+}
+
+// T translates msg into the language of locale. With args, the translation
+// is a format string for them.
+func T(locale, msg string, args ...interface{}) string {
+	if translated, ok := catalogs[Language(locale)][msg]; ok && translated != "" {
+		msg = translated
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Translated reports whether locale has its own translation of msg
+func Translated(locale, msg string) bool {
+	_, ok := catalogs[Language(locale)][msg]
+	return ok
+}
+
+// numberFormat is how a locale writes numbers and amounts
+type numberFormat struct {
+	decimal, group string
+	symbolFirst    bool // €1.00 rather than 1.00 €
+	symbolSpace    bool // a space between the symbol and the number
+}
+
+const nbsp = "\u00a0"
+
+// formats by language, and by language and region where they differ
+var formats = map[string]numberFormat{
+	"en":    {".", ",", true, false},
+	"de":    {",", ".", false, true},
+	"de-ch": {".", "’", true, true},
+	"fr":    {",", nbsp, false, true},
+	"es":    {",", ".", false, true},
+	"it":    {",", ".", false, true},
+	"pt":    {",", ".", false, true},
+	"pt-br": {",", ".", true, true},
+	"nl":    {",", ".", true, true},
+	"pl":    {",", nbsp, false, true},
+	"sv":    {",", nbsp, false, true},
+	"ja":    {".", ",", true, false},
+	"zh":    {".", ",", true, false},
+}
+
+// dateLayouts are the numeric date formats of locales
+var dateLayouts = map[string]string{
+	"en":    "01/02/2006",
+	"en-gb": "02/01/2006",
+	"en-au": "02/01/2006",
+	"en-in": "02/01/2006",
+	"de":    "02.01.2006",
+	"fr":    "02/01/2006",
+	"es":    "02/01/2006",
+	"it":    "02/01/2006",
+	"pt":    "02/01/2006",
+	"nl":    "02-01-2006",
+	"pl":    "02.01.2006",
+	"sv":    "2006-01-02",
+	"ja":    "2006/01/02",
+	"zh":    "2006/01/02",
+}
+
+// tableKey returns locale lowercased with a dash, the form the tables above
+// are keyed by
+func tableKey(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+}
+
+func format(locale string) numberFormat {
+	if f, ok := formats[tableKey(locale)]; ok {
+		return f
+	}
+	if f, ok := formats[Language(locale)]; ok {
+		return f
+	}
+	return formats[Default]
+}
+
+// symbols are the currency signs printed instead of codes. They are limited
+// to ones the PDF fonts can draw.
+var symbols = map[string]string{"USD": "$", "EUR": "€", "GBP": "£", "JPY": "¥"}
+
+// minorUnits are the decimals of currencies that don't use two
+var minorUnits = map[string]int{"JPY": 0, "KRW": 0, "VND": 0, "CLP": 0, "ISK": 0}
+
+// FormatNumber writes v with the given number of decimals, e.g. 1,234.56 in
+// English and 1.234,56 in German
+func FormatNumber(locale string, v float64, decimals int) string {
+	f := format(locale)
+	scale := math.Pow(10, float64(decimals))
+	rounded := math.Round(v * scale)
+	sign := ""
+	if rounded < 0 {
+		sign = "-"
+	}
+	digits := fmt.Sprintf("%.0f", math.Abs(rounded))
+	for len(digits) <= decimals {
+		digits = "0" + digits
+	}
+	whole, fraction := digits[:len(digits)-decimals], digits[len(digits)-decimals:]
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(f.group)
+		}
+		b.WriteRune(r)
+	}
+	if decimals > 0 {
+		b.WriteString(f.decimal)
+		b.WriteString(fraction)
+	}
+	return b.String()
+}
+
+// FormatMoney writes an amount with its currency the way locale does, e.g.
+// €1,234.56 in English and 1.234,56 € in German. Currencies without a
+// symbol are written with their code.
+func FormatMoney(locale string, amount float64, currency string) string {
+	currency = strings.ToUpper(currency)
+	if currency == "" {
+		currency = "USD"
+	}
+	decimals, ok := minorUnits[currency]
+	if !ok {
+		decimals = 2
+	}
+	number := FormatNumber(locale, amount, decimals)
+	sign := ""
+	if strings.HasPrefix(number, "-") {
+		sign, number = "-", number[1:]
+	}
+
+	f := format(locale)
+	symbol, ok := symbols[currency]
+	space := ""
+	if !ok {
+		symbol, space = currency, " "
+	} else if f.symbolSpace {
+		space = " "
+	}
+	if f.symbolFirst {
+		return sign + symbol + space + number
+	}
+	return sign + number + " " + symbol
+}
+
+// FormatDate writes a YYYY-MM-DD date the way locale does. Values that aren't
+// dates are returned unchanged, and locales without a known format get ISO
+// dates.
+func FormatDate(locale, value string) string {
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return value
+	}
+	if layout, ok := dateLayouts[tableKey(locale)]; ok {
+		return t.Format(layout)
+	}
+	if layout, ok := dateLayouts[Language(locale)]; ok {
+		return t.Format(layout)
+	}
+	return t.Format("2006-01-02")
+}
+
+// UserLocale is the language the user chose in their preferences
+func UserLocale(db *gorm.DB, userID string) string {
+	var prefs models.UserPreferences
+	if err := db.Select("language").First(&prefs, "user_id = ?", userID).Error; err == nil && prefs.Language != "" {
+		return prefs.Language
+	}
+	return Default
+}
+
+// ClientLocale is the language of documents and emails for the client: their
+// own when set, else their tenant's
+func ClientLocale(db *gorm.DB, client models.Client) string {
+	if client.Language != "" {
+		return client.Language
+	}
+	return UserLocale(db, client.UserID)
+}
+
+// InvoiceLocale is the language of an invoice's documents and emails: the
+// invoice's own when set, else its client's. The client must be loaded.
+func InvoiceLocale(db *gorm.DB, invoice models.Invoice) string {
+	if invoice.Language != "" {
+		return invoice.Language
+	}
+	return ClientLocale(db, invoice.Client)
+}
@@ -0,0 +1,97 @@
+package i18n
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFormatMoney(t *testing.T) {
+	tests := []struct {
+		locale   string
+		amount   float64
+		currency string
+		want     string
+	}{
+		{"en", 1234.56, "EUR", "€1,234.56"},
+		{"de", 1234.56, "EUR", "1.234,56 €"},
+		{"de-DE", 1234.56, "EUR", "1.234,56 €"},
+		{"fr", 1234.56, "EUR", "1 234,56 €"},
+		{"de-CH", 1234.5, "CHF", "CHF 1’234.50"},
+		{"nl", 1234.5, "EUR", "€ 1.234,50"},
+		{"en", 999.999, "", "$1,000.00"},
+		{"en", -1234567.891, "USD", "-$1,234,567.89"},
+		{"de", -0.001, "EUR", "0,00 €"},
+		{"en", 1234.5, "JPY", "¥1,235"},
+		{"en", 12, "INR", "INR 12.00"},
+		{"xx", 12, "INR", "INR 12.00"},
+		{"", 0, "USD", "$0.00"},
+	}
+	for _, tt := range tests {
+		if got := FormatMoney(tt.locale, tt.amount, tt.currency); got != tt.want {
+			t.Errorf("FormatMoney(%q, %v, %q) = %q, want %q", tt.locale, tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	tests := map[string]string{"en": "12/31/2024", "en_GB": "31/12/2024", "de-AT": "31.12.2024", "fr": "31/12/2024", "xx": "2024-12-31"}
+	for locale, want := range tests {
+		if got := FormatDate(locale, "2024-12-31"); got != want {
+			t.Errorf("FormatDate(%s) = %q, want %q", locale, got, want)
+		}
+	}
+	if got := FormatDate("en", "soon"); got != "soon" {
+		t.Errorf("FormatDate kept %q", got)
+	}
+}
+
+func TestT(t *testing.T) {
+	if got := T("de-AT", "Invoice not found"); got != "Rechnung nicht gefunden" {
+		t.Errorf("got %q", got)
+	}
+	if got := T("fr", "Payment reminder: invoice %s", "INV-0001"); got != "Rappel de paiement : facture INV-0001" {
+		t.Errorf("got %q", got)
+	}
+	if got := T("es", "Invoice not found"); got != "Invoice not found" {
+		t.Errorf("untranslated messages should stay in English, got %q", got)
+	}
+}
+
+// Every translation must take the same arguments as its message
+func TestCatalogVerbs(t *testing.T) {
+	verbs := func(s string) string {
+		var found []string
+		for i := 0; i < len(s)-1; i++ {
+			if s[i] == '%' {
+				found = append(found, s[i:i+2])
+				i++
+			}
+		}
+		return strings.Join(found, "")
+	}
+	for language, messages := range catalogs {
+		for msg, translated := range messages {
+			if verbs(msg) != verbs(translated) {
+				t.Errorf("%s: %q has different verbs than %q", language, translated, msg)
+			}
+		}
+	}
+	if _, err := json.Marshal(catalogs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{"de_at": "de-AT", "EN": "en", "zh-hant-tw": "zh-hant-TW"}
+	for tag, want := range tests {
+		if got, ok := Normalize(tag); !ok || got != want {
+			t.Errorf("Normalize(%q) = %q, %v", tag, got, ok)
+		}
+	}
+	for _, bad := range []string{"", "e", "de at", "<script>"} {
+		if _, ok := Normalize(bad); ok {
+			t.Errorf("Normalize(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To          []string
+	Subject     string
+	Body        string
+	ReplyTo     string
+	FromName    string // replaces the display name of the sender, keeping its address
+	Attachments []Attachment
+}
+
+// Attachment is a file sent along with a message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTPMailer sends mail through an SMTP relay
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	// The envelope sender must be a bare address
+	sender := m.From
+	if addr, err := mail.ParseAddress(m.From); err == nil {
+		sender = addr.Address
+	}
+	from := m.From
+	if msg.FromName != "" {
+		from = (&mail.Address{Name: msg.FromName, Address: sender}).String()
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, sender, msg.To, compose(from, msg))
+}
+
+// compose writes msg as a MIME message, multipart when it has attachments
+func compose(from string, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+	if msg.ReplyTo != "" {
+		fmt.Fprintf(&b, "Reply-To: %s\r\n", msg.ReplyTo)
+	}
+	// Subjects in other languages need encoding to stay valid headers
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if len(msg.Attachments) == 0 {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		b.WriteString(msg.Body)
+		return b.Bytes()
+	}
+
+	parts := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", parts.Boundary())
+	text, _ := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+	text.Write([]byte(msg.Body))
+	for _, a := range msg.Attachments {
+		part, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+		})
+		// Lines of base64 must stay under the 76 characters MIME allows
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+	parts.Close()
+	return b.Bytes()
+}
+
+// LogMailer prints messages instead of sending them. Used when SMTP isn't configured.
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+	fmt.Printf("Email to %s: %s\n%s\n", strings.Join(msg.To, ", "), msg.Subject, msg.Body)
+	for _, a := range msg.Attachments {
+		fmt.Printf("Attached: %s (%s, %d bytes)\n", a.Filename, a.ContentType, len(a.Data))
+	}
+	return nil
+}
+
+// Default is the mailer used by the application
+var Default Mailer = FromEnv()
+
+// FromEnv returns an SMTP mailer when SMTP_HOST is set, otherwise a LogMailer
+func FromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return LogMailer{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Billow <no-reply@billow.app>"
+	}
+
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+}
+
+// SendAsync delivers msg in the background so requests aren't held up by the relay
+func SendAsync(msg Message) {
+	go func() {
+		if err := Default.Send(msg); err != nil {
+			fmt.Printf("Error sending email %q: %v\n", msg.Subject, err)
+		}
+	}()
+}
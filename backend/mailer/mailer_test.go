@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestComposeAttachments(t *testing.T) {
+	data := bytes.Repeat([]byte("%PDF-1.4 "), 40)
+	raw := compose("Billow <no-reply@billow.app>", Message{
+		To:          []string{"a@example.com", "b@example.com"},
+		Subject:     "Kontoauszug für März",
+		Body:        "Hello",
+		Attachments: []Attachment{{Filename: "statement.pdf", ContentType: "application/pdf", Data: data}},
+	})
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); subject != "Kontoauszug für März" {
+		t.Errorf("subject = %q", subject)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("content type = %q, %v", mediaType, err)
+	}
+
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	text, err := parts.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(text); string(body) != "Hello" {
+		t.Errorf("body = %q", body)
+	}
+	file, err := parts.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.FileName() != "statement.pdf" {
+		t.Errorf("filename = %q", file.FileName())
+	}
+	encoded, _ := io.ReadAll(file)
+	for _, line := range strings.Split(strings.TrimSpace(string(encoded)), "\r\n") {
+		if len(line) > 76 {
+			t.Fatalf("base64 line is %d characters long", len(line))
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	if err != nil || !bytes.Equal(decoded, data) {
+		t.Errorf("attachment doesn't round-trip: %v", err)
+	}
+}
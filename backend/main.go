@@ -1,13 +1,24 @@
 package main
 
 import (
+	"billow-backend/billing"
 	"billow-backend/config"
+	"billow-backend/middleware"
 	"billow-backend/models"
+	"billow-backend/policy"
+	"billow-backend/ratelimit"
 	"billow-backend/routes"
+	"billow-backend/usage"
 
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -25,12 +36,57 @@ func main() {
 	config.DB.AutoMigrate(&models.Subscription{})
 	config.DB.AutoMigrate(&models.UserPreferences{})
 	config.DB.AutoMigrate(&models.UsageLog{})
+	config.DB.AutoMigrate(&models.UsageCounter{})
 	config.DB.AutoMigrate(&models.AnalyticsData{})
 	config.DB.AutoMigrate(&models.Client{})
+	config.DB.AutoMigrate(&models.ClientContact{})
+	if err := config.MigrateClientAddresses(config.DB); err != nil {
+		log.Fatal("Failed to migrate client addresses:", err)
+	}
 	config.DB.AutoMigrate(&models.Invoice{})
+	config.DB.AutoMigrate(&models.AuditEvent{})
+	config.DB.AutoMigrate(&models.BillingEvent{})
+	config.DB.AutoMigrate(&models.Coupon{})
+	config.DB.AutoMigrate(&models.PromotionCode{})
+	config.DB.AutoMigrate(&models.Discount{})
+	config.DB.AutoMigrate(&models.AddOn{})
+	config.DB.AutoMigrate(&models.UserAddOn{})
+	config.DB.AutoMigrate(&models.EntitlementOverride{})
+	config.DB.AutoMigrate(&models.UsageRecord{})
+	config.DB.AutoMigrate(&models.PortalLink{})
+	config.DB.AutoMigrate(&models.PortalSession{})
+	config.DB.AutoMigrate(&models.InvoiceShare{})
+	config.DB.AutoMigrate(&models.InvoiceView{})
+	config.DB.AutoMigrate(&models.Payment{})
+	config.DB.AutoMigrate(&models.BankAccount{})
+	config.DB.AutoMigrate(&models.BusinessProfile{})
+	if err := config.MigrateBusinessEntities(config.DB); err != nil {
+		log.Fatal("Failed to migrate business entities:", err)
+	}
+	config.DB.AutoMigrate(&models.Branding{})
+	config.DB.AutoMigrate(&models.InvoiceTemplate{})
+
+	// Row-level security is defence in depth on top of the user_id filters
+	if err := config.EnableRowLevelSecurity(); err != nil {
+		log.Fatal("Failed to enable row-level security:", err)
+	}
+
+	// Create and update plans from the declarative catalog
+	syncPlanCatalog()
+
+	// Usage events are buffered and written in batches in the background
+	usage.Default = usage.NewPipeline(config.DB, 10000, 500, 2*time.Second)
+
+	// Expire trials, renew periods and apply cancellations in the background
+	workers, stopWorkers := context.WithCancel(context.Background())
+	lifecycle := billing.NewLifecycle(config.DB, 15*time.Minute)
+	if billing.DefaultProvider != nil {
+		lifecycle.Charge = billing.ProviderCharge
+	}
+	go lifecycle.Run(workers)
 
-	// Seed default plans if they don't exist
-	seedDefaultPlans()
+	// Roll metered usage into priced usage records
+	go billing.NewMetering(config.DB, time.Hour).Run(workers)
 
 	// Get port from environment variable (Heroku sets this)
 	port := os.Getenv("PORT")
@@ -44,19 +100,20 @@ func main() {
 		allowedOrigins = "http://localhost:5173,https://billow-three.vercel.app,https://billow-eecbzjx2n-kapilsarma2002s-projects.vercel.app/"
 	}
 
+	// Behind a load balancer such as Heroku's router, TRUSTED_PROXIES lists
+	// its addresses or CIDR ranges (comma separated) so clients are told apart
+	// by their forwarded address rather than the balancer's
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+
 	app := fiber.New(fiber.Config{
-		ErrorHandler: func(c *fiber.Ctx, err error) error {
-			code := fiber.StatusInternalServerError
-			if e, ok := err.(*fiber.Error); ok {
-				code = e.Code
-			}
-
-			fmt.Printf("Error: %v\n", err)
-
-			return c.Status(code).JSON(fiber.Map{
-				"error": err.Error(),
-			})
-		},
+		EnableTrustedProxyCheck: len(trustedProxies) > 0,
+		TrustedProxies:          trustedProxies,
+		ErrorHandler:            errorHandler,
 	})
 
 	// Add CORS middleware with proper configuration
@@ -67,6 +124,10 @@ func main() {
 		AllowCredentials: true,
 	}))
 
+	// Rate limit every API request by user and plan, and answer in the
+	// user's language
+	app.Use("/api", middleware.Localize(), middleware.OptionalAuthMiddleware(), middleware.RateLimitMiddleware(newRateLimitStore()))
+
 	// Setup all routes
 	fmt.Println("Setting up routes...")
 	routes.SetupAuthRoutes(app)
@@ -74,79 +135,117 @@ func main() {
 	routes.SetupClientRoutes(app)
 	routes.SetupDashboardRoutes(app)
 	routes.SetupSettingsRoutes(app)
+	routes.SetupBillingRoutes(app)
+	routes.SetupAdminRoutes(app)
+	routes.SetupPortalRoutes(app)
+
+	go func() {
+		fmt.Printf("Starting server on :%s...\n", port)
+		if err := app.Listen(":" + port); err != nil {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
+
+	// Shut down gracefully so in-flight requests finish and buffered usage
+	// events are written before the process exits
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+
+	fmt.Println("Shutting down...")
+	stopWorkers()
+	if err := app.ShutdownWithTimeout(10 * time.Second); err != nil {
+		fmt.Printf("Error shutting down server: %v\n", err)
+	}
 
-	fmt.Printf("Starting server on :%s...\n", port)
-	if err := app.Listen(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := usage.Default.Shutdown(ctx); err != nil {
+		fmt.Printf("Error flushing usage events: %v\n", err)
 	}
 }
 
-func seedDefaultPlans() {
-	// Check if plans already exist
-	var count int64
-	config.DB.Model(&models.Plan{}).Count(&count)
-
-	if count == 0 {
-		fmt.Println("Seeding default plans...")
-		plans := []models.Plan{
-			{
-				ID:                "PLN-STARTER",
-				Name:              "Starter",
-				Price:             10.0,
-				Currency:          "USD",
-				Interval:          "month",
-				InvoiceLimit:      50,
-				ClientLimit:       10,
-				MessagesPerDay:    100,
-				ImageGeneration:   false,
-				CustomVoice:       false,
-				PrioritySupport:   false,
-				AdvancedAnalytics: false,
-				APIAccess:         false,
-				WhiteLabel:        false,
-			},
-			{
-				ID:                "PLN-PRO",
-				Name:              "Pro",
-				Price:             29.0,
-				Currency:          "USD",
-				Interval:          "month",
-				InvoiceLimit:      -1, // Unlimited
-				ClientLimit:       -1, // Unlimited
-				MessagesPerDay:    1000,
-				ImageGeneration:   true,
-				CustomVoice:       true,
-				PrioritySupport:   true,
-				AdvancedAnalytics: true,
-				APIAccess:         true,
-				WhiteLabel:        false,
-			},
-			{
-				ID:                "PLN-BUSINESS",
-				Name:              "Business",
-				Price:             99.0,
-				Currency:          "USD",
-				Interval:          "month",
-				InvoiceLimit:      -1, // Unlimited
-				ClientLimit:       -1, // Unlimited
-				MessagesPerDay:    -1, // Unlimited
-				ImageGeneration:   true,
-				CustomVoice:       true,
-				PrioritySupport:   true,
-				AdvancedAnalytics: true,
-				APIAccess:         true,
-				WhiteLabel:        true,
-			},
+// errorHandler renders errors returned by handlers as JSON: policy denials as
+// structured 403s, plan limits as 402s with upgrade options and everything
+// else with its status code
+func errorHandler(c *fiber.Ctx, err error) error {
+	code := fiber.StatusInternalServerError
+	if e, ok := err.(*fiber.Error); ok {
+		code = e.Code
+	}
+
+	// Policy denials carry a structured reason for the client
+	var denied *policy.DeniedError
+	if errors.As(err, &denied) {
+		if denied.Decision.Reason == policy.ReasonNotOwner {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Not found"})
 		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error":   "Forbidden",
+			"action":  denied.Decision.Action,
+			"reason":  denied.Decision.Reason,
+			"message": denied.Decision.Message,
+			"feature": denied.Decision.Feature,
+		})
+	}
+
+	// Plan limits tell the client how to upgrade
+	var exceeded *billing.QuotaExceededError
+	if errors.As(err, &exceeded) {
+		return c.Status(fiber.StatusPaymentRequired).JSON(fiber.Map{
+			"error":           "Plan limit reached",
+			"limit":           exceeded.Quota.Name,
+			"quota":           exceeded.Quota,
+			"current_plan":    exceeded.CurrentPlan,
+			"upgrade_options": exceeded.UpgradeOptions,
+		})
+	}
+
+	fmt.Printf("Error: %v\n", err)
+
+	return c.Status(code).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// newRateLimitStore shares counters through Redis when REDIS_URL is set and
+// falls back to per-instance memory otherwise
+func newRateLimitStore() ratelimit.Store {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		return ratelimit.NewMemoryStore()
+	}
+
+	store, err := ratelimit.NewRedisStore(redisURL)
+	if err != nil {
+		fmt.Printf("Redis unavailable for rate limiting, using memory: %v\n", err)
+		return ratelimit.NewMemoryStore()
+	}
+	return store
+}
+
+// syncPlanCatalog brings the plans table in line with the catalog file at
+// PLAN_CATALOG, or the built-in catalog. New versions are created; versions
+// that differ in what subscribers pay for are reported and left alone.
+func syncPlanCatalog() {
+	defs, err := billing.LoadCatalog(os.Getenv("PLAN_CATALOG"))
+	if err != nil {
+		log.Fatal("Failed to load plan catalog:", err)
+	}
+
+	changes, err := billing.ApplyCatalog(config.DB, defs)
+	if err != nil {
+		log.Fatal("Failed to apply plan catalog:", err)
+	}
 
-		for _, plan := range plans {
-			if err := config.DB.Create(&plan).Error; err != nil {
-				fmt.Printf("Error creating plan %s: %v\n", plan.Name, err)
-			} else {
-				fmt.Printf("Created plan: %s\n", plan.Name)
-			}
+	for _, change := range changes {
+		switch change.Action {
+		case billing.CatalogCreate:
+			fmt.Printf("Created plan %s\n", change.PlanID)
+		case billing.CatalogUpdate:
+			fmt.Printf("Updated plan %s: %v\n", change.PlanID, change.Fields)
+		case billing.CatalogConflict:
+			fmt.Printf("Plan %s differs from the catalog in %v; add a new version instead of editing it\n", change.PlanID, change.Fields)
 		}
-	} else {
-		fmt.Printf("Plans already exist (%d plans found)\n", count)
 	}
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"billow-backend/billing"
+	"billow-backend/models"
+	"billow-backend/policy"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestErrorHandler(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: errorHandler})
+	app.Get("/quota", func(c *fiber.Ctx) error {
+		return fmt.Errorf("creating invoice: %w", &billing.QuotaExceededError{
+			Quota:          billing.Quota{Name: billing.InvoiceQuota, Limit: 5, Used: 5},
+			CurrentPlan:    "Starter",
+			UpgradeOptions: []models.Plan{{ID: "PLN-PRO", Name: "Pro", InvoiceLimit: -1}},
+		})
+	})
+	app.Get("/feature", func(c *fiber.Ctx) error {
+		return policy.Decision{Action: policy.BrandingManage, Reason: policy.ReasonPlanUpgradeRequired, Feature: "white_label"}.Err()
+	})
+	app.Get("/other-tenant", func(c *fiber.Ctx) error {
+		return policy.Decision{Action: policy.InvoiceRead, Reason: policy.ReasonNotOwner}.Err()
+	})
+	app.Get("/missing", func(c *fiber.Ctx) error {
+		return fiber.NewError(404, "Invoice not found")
+	})
+
+	tests := []struct {
+		path   string
+		status int
+		body   map[string]interface{}
+	}{
+		{"/quota", 402, map[string]interface{}{"error": "Plan limit reached", "limit": "invoices", "current_plan": "Starter"}},
+		{"/feature", 403, map[string]interface{}{"error": "Forbidden", "reason": "plan_upgrade_required", "feature": "white_label"}},
+		{"/other-tenant", 404, map[string]interface{}{"error": "Not found"}},
+		{"/missing", 404, map[string]interface{}{"error": "Invoice not found"}},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, resp.StatusCode, tt.status)
+		}
+		for key, want := range tt.body {
+			if body[key] != want {
+				t.Errorf("%s: %s = %v, want %v", tt.path, key, body[key], want)
+			}
+		}
+	}
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "/quota", nil))
+	var quota struct {
+		Quota          billing.Quota `json:"quota"`
+		UpgradeOptions []models.Plan `json:"upgrade_options"`
+	}
+	json.NewDecoder(resp.Body).Decode(&quota)
+	if quota.Quota.Remaining != 0 || quota.Quota.Limit != 5 || len(quota.UpgradeOptions) != 1 || quota.UpgradeOptions[0].ID != "PLN-PRO" {
+		t.Errorf("402 body = %+v", quota)
+	}
+}
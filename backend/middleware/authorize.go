@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"billow-backend/config"
+	"billow-backend/entitlements"
+	"billow-backend/models"
+	"billow-backend/policy"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetSubjectFromContext builds the policy subject for the authenticated user.
+// The subscription and entitlements are loaded once per request and cached in
+// the context.
+func GetSubjectFromContext(c *fiber.Ctx) (policy.Subject, error) {
+	if subject, ok := c.Locals("subject").(policy.Subject); ok {
+		return subject, nil
+	}
+
+	user, err := GetUserFromContext(c)
+	if err != nil {
+		return policy.Subject{}, err
+	}
+
+	subject := policy.Subject{UserID: user.ID, Role: user.Role}
+
+	var subscription models.Subscription
+	if err := config.DB.Preload("Plan").Where("user_id = ?", user.ID).First(&subscription).Error; err == nil {
+		subject.Subscription = &subscription
+	}
+
+	ents, err := entitlements.Load(config.DB, user.ID, subject.Subscription, time.Now())
+	if err != nil {
+		return policy.Subject{}, err
+	}
+	subject.Entitlements = ents
+
+	c.Locals("subject", subject)
+	return subject, nil
+}
+
+// Entitlements returns the resolved entitlements of the authenticated user
+func Entitlements(c *fiber.Ctx) (*entitlements.Entitlements, error) {
+	subject, err := GetSubjectFromContext(c)
+	if err != nil {
+		return nil, err
+	}
+	return subject.Entitlements, nil
+}
+
+// Authorize checks that the authenticated user may perform action. It is used
+// for route-level checks; handlers that load a tenant-owned record use Can.
+func Authorize(action policy.Action) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := Can(c, action, nil); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// Can asks the policy engine whether the authenticated user may perform action
+// on res and returns a *policy.DeniedError when they may not.
+func Can(c *fiber.Ctx, action policy.Action, res *policy.Resource) error {
+	subject, err := GetSubjectFromContext(c)
+	if err != nil {
+		return err
+	}
+	return policy.Default.Authorize(subject, action, res).Err()
+}
+
+// CanAccess checks action against a record loaded from the database
+func CanAccess(c *fiber.Ctx, action policy.Action, record policy.Owned) error {
+	return Can(c, action, &policy.Resource{OwnerID: record.OwnerID()})
+}
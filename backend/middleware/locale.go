@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"billow-backend/config"
+	"billow-backend/i18n"
+	"billow-backend/models"
+	"encoding/json"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Localize translates the error and message of failed API responses into the
+// language of the request. It runs the error handler itself so errors
+// returned by handlers are translated too, and must come first in the chain.
+func Localize() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				return err
+			}
+		}
+		if c.Response().StatusCode() < 400 || !strings.HasPrefix(string(c.Response().Header.ContentType()), fiber.MIMEApplicationJSON) {
+			return nil
+		}
+		locale := RequestLocale(c)
+		if i18n.Language(locale) == i18n.Default {
+			return nil
+		}
+
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(c.Response().Body(), &body); err != nil {
+			return nil
+		}
+		translated := false
+		for _, field := range []string{"error", "message"} {
+			var msg string
+			if err := json.Unmarshal(body[field], &msg); err != nil || !i18n.Translated(locale, msg) {
+				continue
+			}
+			body[field], _ = json.Marshal(i18n.T(locale, msg))
+			translated = true
+		}
+		if translated {
+			out, err := json.Marshal(body)
+			if err != nil {
+				return err
+			}
+			c.Response().SetBody(out)
+		}
+		return nil
+	}
+}
+
+// RequestLocale is the language to answer the request in: the portal client's
+// or signed-in user's setting, else the best one the request accepts
+func RequestLocale(c *fiber.Ctx) string {
+	if session, ok := c.Locals("portal_session").(models.PortalSession); ok {
+		var client models.Client
+		if err := config.DB.Select("user_id", "language").First(&client, "id = ? AND user_id = ?", session.ClientID, session.UserID).Error; err == nil {
+			return i18n.ClientLocale(config.DB, client)
+		}
+	}
+	if userID, ok := c.Locals("user_id").(string); ok {
+		return i18n.UserLocale(config.DB, userID)
+	}
+	if c.Get(fiber.HeaderAcceptLanguage) != "" {
+		if language := c.AcceptsLanguages(i18n.Languages()...); language != "" {
+			return language
+		}
+	}
+	return i18n.Default
+}
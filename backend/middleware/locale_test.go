@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLocalize(t *testing.T) {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			return c.Status(err.(*fiber.Error).Code).JSON(fiber.Map{"error": err.Error()})
+		},
+	})
+	app.Use(Localize())
+	app.Get("/status", func(c *fiber.Ctx) error {
+		return c.Status(404).JSON(fiber.Map{"error": "Invoice not found", "id": "INV-1"})
+	})
+	app.Get("/error", func(c *fiber.Ctx) error {
+		return fiber.NewError(404, "Client not found")
+	})
+
+	tests := []struct {
+		path, language, want string
+	}{
+		{"/status", "de-DE,de;q=0.9,en;q=0.8", "Rechnung nicht gefunden"},
+		{"/error", "fr", "Client introuvable"},
+		{"/status", "es", "Invoice not found"},
+		{"/status", "", "Invoice not found"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.language != "" {
+			req.Header.Set("Accept-Language", tt.language)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 404 || body["error"] != tt.want {
+			t.Errorf("%s in %q: got %d %q, want %q", tt.path, tt.language, resp.StatusCode, body["error"], tt.want)
+		}
+		if tt.path == "/status" && body["id"] != "INV-1" {
+			t.Errorf("other fields were lost: %v", body)
+		}
+	}
+}
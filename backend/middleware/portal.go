@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"billow-backend/config"
+	"billow-backend/models"
+	"billow-backend/policy"
+	"billow-backend/portal"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PortalAuth authenticates a client portal session from the X-Portal-Token
+// header. The request then acts for the session's tenant with a policy
+// subject confined to the session's client.
+func PortalAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		session, err := portal.Authenticate(config.DB, c.Get("X-Portal-Token"), time.Now())
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "Portal session required"})
+		}
+
+		c.Locals("portal_session", *session)
+		c.Locals("tenant_id", session.UserID)
+		c.Locals("subject", policy.Subject{UserID: session.UserID, Role: policy.RoleClient, ClientID: session.ClientID})
+		return c.Next()
+	}
+}
+
+// GetPortalSession returns the portal session of the request
+func GetPortalSession(c *fiber.Ctx) (models.PortalSession, error) {
+	session, ok := c.Locals("portal_session").(models.PortalSession)
+	if !ok {
+		return session, fiber.NewError(401, "Portal session required")
+	}
+	return session, nil
+}
@@ -1,174 +1,174 @@
 package middleware
 
 import (
-	"billow-backend/config"
+	"billow-backend/audit"
 	"billow-backend/models"
+	"billow-backend/policy"
+	"billow-backend/ratelimit"
+	"billow-backend/usage"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// Rate limiting based on subscription tier
-func RateLimitMiddleware() fiber.Handler {
+// rateLimitWindow is the period the per-plan limits apply to. Every API
+// request counts, so the limits are sized for an interactive session rather
+// than for individual features.
+const rateLimitWindow = time.Minute
+
+// rateLimits are requests per minute by plan ID and API area
+var rateLimits = map[string]map[string]int{
+	"PLN-STARTER": {
+		"/api/invoices":  60,
+		"/api/clients":   60,
+		"/api/dashboard": 120,
+		"default":        60,
+	},
+	"PLN-PRO": {
+		"/api/invoices":  300,
+		"/api/clients":   300,
+		"/api/dashboard": 600,
+		"default":        300,
+	},
+	"PLN-BUSINESS": {
+		"/api/invoices":  1000,
+		"/api/clients":   1000,
+		"/api/dashboard": 2000,
+		"default":        1000,
+	},
+}
+
+// anonymousRateLimit applies per client IP to requests without a user, such
+// as the client portal and shared invoices
+const anonymousRateLimit = 60
+
+// rateLimitExempt are webhooks that check the sender's signature before doing
+// any work. Providers deliver them in bursts from shared addresses.
+var rateLimitExempt = map[string]bool{
+	"/api/billing/webhook":  true,
+	"/api/payments/webhook": true,
+}
+
+// RateLimitMiddleware limits requests per user and API area according to the
+// user's plan, and per client IP for unauthenticated requests (see
+// audit.ClientIP for how proxies are handled). It must run after the user has
+// been resolved (see OptionalAuthMiddleware). If the store is unavailable
+// requests are let through rather than failing the API.
+func RateLimitMiddleware(store ratelimit.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		userID := c.Get("X-User-ID", "USR-20241215-143052-123456") // Mock user ID
+		if rateLimitExempt[c.Path()] {
+			return c.Next()
+		}
+
+		key, limit := rateLimitFor(c)
 
-		// Get user's subscription
-		var subscription models.Subscription
-		if err := config.DB.Preload("Plan").First(&subscription, "user_id = ?", userID).Error; err != nil {
-			// Default to starter limits if no subscription found
-			return applyRateLimit(c, "starter")
+		res, err := store.Hit(c.Context(), key, limit, rateLimitWindow)
+		if err != nil {
+			fmt.Printf("Rate limit store error: %v\n", err)
+			return c.Next()
+		}
+
+		c.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Set("X-RateLimit-Reset", strconv.FormatInt(res.ResetAt.Unix(), 10))
+
+		if !res.Allowed {
+			retryAfter := int(math.Ceil(res.RetryAfter.Seconds()))
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+			return c.Status(429).JSON(fiber.Map{
+				"error":       "Rate limit exceeded",
+				"limit":       res.Limit,
+				"retry_after": retryAfter,
+				"reset_time":  res.ResetAt.Unix(),
+			})
 		}
 
-		// Apply rate limits based on plan
-		planName := subscription.Plan.Name
-		return applyRateLimit(c, planName)
+		return c.Next()
 	}
 }
 
-func applyRateLimit(c *fiber.Ctx, planName string) error {
-	userID := c.Get("X-User-ID", "USR-20241215-143052-123456")
-	endpoint := c.Path()
-
-	// Define rate limits per plan
-	limits := map[string]map[string]int{
-		"starter": {
-			"/api/invoices":  50,  // 50 requests per hour
-			"/api/clients":   20,  // 20 requests per hour
-			"/api/dashboard": 100, // 100 requests per hour
-			"default":        30,  // 30 requests per hour for other endpoints
-		},
-		"pro": {
-			"/api/invoices":  200, // 200 requests per hour
-			"/api/clients":   100, // 100 requests per hour
-			"/api/dashboard": 500, // 500 requests per hour
-			"default":        150, // 150 requests per hour for other endpoints
-		},
-		"business": {
-			"/api/invoices":  1000, // 1000 requests per hour
-			"/api/clients":   500,  // 500 requests per hour
-			"/api/dashboard": 2000, // 2000 requests per hour
-			"default":        500,  // 500 requests per hour for other endpoints
-		},
+// rateLimitFor returns the counter key and limit per window for the request
+func rateLimitFor(c *fiber.Ctx) (string, int) {
+	area := apiArea(c.Path())
+
+	subject, err := GetSubjectFromContext(c)
+	if err != nil {
+		return "ip:" + audit.ClientIP(c) + ":" + area, anonymousRateLimit
 	}
 
-	// Get limit for current plan and endpoint
-	planLimits, exists := limits[planName]
+	planID := "PLN-STARTER"
+	if subject.Subscription != nil && subject.Subscription.HasAccess(time.Now()) {
+		planID = subject.Subscription.PlanID
+	}
+	planLimits, exists := rateLimits[models.BasePlanID(planID)]
 	if !exists {
-		planLimits = limits["starter"] // Default to starter limits
+		planLimits = rateLimits["PLN-STARTER"]
 	}
 
-	limit, exists := planLimits[endpoint]
+	limit, exists := planLimits[area]
 	if !exists {
 		limit = planLimits["default"]
 	}
 
-	// Check current usage in the last hour
-	oneHourAgo := time.Now().Add(-time.Hour)
-	var requestCount int64
-
-	// In production, you'd use Redis or similar for rate limiting
-	// For demo, we'll use a simple database check
-	config.DB.Model(&models.UsageLog{}).
-		Where("user_id = ? AND feature_type = ? AND timestamp >= ?",
-			userID, "api_request_"+endpoint, oneHourAgo).
-		Count(&requestCount)
-
-	if int(requestCount) >= limit {
-		return c.Status(429).JSON(fiber.Map{
-			"error":      "Rate limit exceeded",
-			"limit":      limit,
-			"reset_time": time.Now().Add(time.Hour).Unix(),
-		})
-	}
-
-	// Add rate limit headers
-	c.Set("X-RateLimit-Limit", string(rune(limit)))
-	c.Set("X-RateLimit-Remaining", string(rune(limit-int(requestCount)-1)))
-	c.Set("X-RateLimit-Reset", string(rune(time.Now().Add(time.Hour).Unix())))
+	return "user:" + subject.UserID + ":" + area, limit
+}
 
-	return c.Next()
+// apiArea reduces a path to its API area, e.g. /api/invoices/INV-1 -> /api/invoices
+func apiArea(path string) string {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(parts) < 2 {
+		return "/" + parts[0]
+	}
+	return "/" + parts[0] + "/" + parts[1]
 }
 
-// Subscription feature check middleware
+// RequireFeature rejects requests from users whose entitlements don't include
+// feature. Unknown features are denied.
 func RequireFeature(feature string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		userID := c.Get("X-User-ID", "USR-20241215-143052-123456")
-
-		// Get user's subscription
-		var subscription models.Subscription
-		if err := config.DB.Preload("Plan").First(&subscription, "user_id = ?", userID).Error; err != nil {
-			return c.Status(403).JSON(fiber.Map{
-				"error":   "Subscription required",
-				"feature": feature,
-			})
+		subject, err := GetSubjectFromContext(c)
+		if err != nil {
+			return err
 		}
-
-		// Check if plan includes the required feature
-		plan := subscription.Plan
-		hasFeature := false
-
-		switch feature {
-		case "advanced_analytics":
-			hasFeature = plan.AdvancedAnalytics
-		case "api_access":
-			hasFeature = plan.APIAccess
-		case "white_label":
-			hasFeature = plan.WhiteLabel
-		default:
-			hasFeature = true // Allow by default
+		if subject.Entitlements.Has(feature) {
+			return c.Next()
 		}
 
-		if !hasFeature {
-			return c.Status(403).JSON(fiber.Map{
-				"error":            "Feature not available in your plan",
-				"feature":          feature,
-				"current_plan":     plan.Name,
-				"upgrade_required": true,
-			})
+		d := policy.Decision{
+			Reason:  policy.ReasonPlanUpgradeRequired,
+			Message: "This feature is not available in your plan",
+			Feature: feature,
 		}
-
-		return c.Next()
+		if subject.Subscription == nil || !subject.Subscription.HasAccess(time.Now()) {
+			d.Reason = policy.ReasonSubscriptionInactive
+			d.Message = "An active subscription is required"
+		}
+		return d.Err()
 	}
 }
 
-// Usage tracking middleware
+// TrackUsage records one use of featureType for the authenticated user once
+// the handler has succeeded. Events are written asynchronously by the usage
+// pipeline, so this never adds a database round trip to the request.
 func TrackUsage(featureType string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Temporarily disabled to prevent errors
-		// TODO: Re-enable once ID generation is stable
-		return c.Next()
+		if err := c.Next(); err != nil {
+			return err
+		}
+		if c.Response().StatusCode() >= 400 {
+			return nil
+		}
 
-		/*
-			userID := c.Get("X-User-ID", "USR-20241215-143052-123456")
-
-			// Check if user exists before creating usage log
-			var user models.User
-			if err := config.DB.First(&user, "id = ?", userID).Error; err != nil {
-				// User doesn't exist, skip logging but continue with request
-				return c.Next()
-			}
-
-			// Check if we already logged this feature recently (within 1 second)
-			oneSecondAgo := time.Now().Add(-time.Second)
-			var existingLog models.UsageLog
-			if err := config.DB.Where("user_id = ? AND feature_type = ? AND timestamp >= ?",
-				userID, featureType, oneSecondAgo).First(&existingLog).Error; err != nil {
-				// No recent log exists, create new one
-				usageLog := models.UsageLog{
-					ID:          models.GenerateUsageLogID(),
-					UserID:      userID,
-					FeatureType: featureType,
-					Count:       1,
-				}
-				if createErr := config.DB.Create(&usageLog).Error; createErr != nil {
-					// Log error but don't fail the request
-					// In production, you might want to log this to a monitoring system
-				}
-			}
-			// If recent log exists, skip creating new one to avoid duplicates
+		userID, err := GetUserIDFromContext(c)
+		if err != nil {
+			return nil
+		}
 
-			return c.Next()
-		*/
+		usage.Track(usage.Event{UserID: userID, FeatureType: featureType})
+		return nil
 	}
 }
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"billow-backend/ratelimit"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRateLimitAnonymousByForwardedIP(t *testing.T) {
+	// app.Test connects from 0.0.0.0, standing in for the load balancer
+	app := fiber.New(fiber.Config{EnableTrustedProxyCheck: true, TrustedProxies: []string{"0.0.0.0"}})
+	store := ratelimit.NewMemoryStore()
+	defer store.Close()
+	app.Use(RateLimitMiddleware(store))
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(200) }
+	app.Get("/api/public/invoices/:token", ok)
+	app.Post("/api/billing/webhook", ok)
+
+	request := func(method, path, forwardedFor string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(fiber.HeaderXForwardedFor, forwardedFor)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	// The first entry is made up by the client and must not pick the bucket
+	for i := 0; i < anonymousRateLimit; i++ {
+		if code := request("GET", "/api/public/invoices/abc", "198.51.100."+strconv.Itoa(i)+", 203.0.113.7"); code != 200 {
+			t.Fatalf("request %d = %d", i+1, code)
+		}
+	}
+	if code := request("GET", "/api/public/invoices/abc", "203.0.113.7"); code != 429 {
+		t.Errorf("request over the limit = %d, want 429", code)
+	}
+	if code := request("GET", "/api/public/invoices/abc", "203.0.113.8"); code != 200 {
+		t.Errorf("another client = %d, want 200", code)
+	}
+
+	for i := 0; i <= anonymousRateLimit; i++ {
+		if code := request("POST", "/api/billing/webhook", "203.0.113.7"); code != 200 {
+			t.Fatalf("webhook %d = %d", i+1, code)
+		}
+	}
+}
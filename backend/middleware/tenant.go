@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"billow-backend/audit"
+	"billow-backend/config"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// TenantTransaction wraps the request in a database transaction bound to the
+// authenticated tenant. Inside the transaction the connection switches to the
+// restricted tenant role and sets app.tenant_id (the equivalent of SET LOCAL),
+// so Postgres row-level security hides every other tenant's rows even if a
+// query forgets its user_id filter. Must run after AuthMiddleware or
+// PortalAuth.
+func TenantTransaction() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID, err := TenantID(c)
+		if err != nil {
+			return err
+		}
+
+		tx := config.DB.Begin()
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				tx.Rollback()
+				panic(r)
+			}
+		}()
+
+		if config.RLSEnabled {
+			if err := tx.Exec("SET LOCAL ROLE " + config.TenantRole).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		// SET LOCAL does not accept bind parameters; set_config with
+		// is_local = true has the same transaction-scoped effect.
+		if err := tx.Exec("SELECT set_config('app.tenant_id', ?, true)", tenantID).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		c.Locals("db", tx)
+
+		if err := c.Next(); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		// Handlers report failures through the status code, so anything that
+		// isn't a success is rolled back
+		if c.Response().StatusCode() >= 400 {
+			return tx.Rollback().Error
+		}
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+		audit.Committed(c)
+		return committed(c)
+	}
+}
+
+// afterCommit is the context key of the steps waiting for the request's
+// transaction to commit
+const afterCommit = "after_commit"
+
+// AfterCommit runs fn once the request's transaction has committed, or right
+// away for routes that don't run in one. It is for calls to other services
+// that must not act on a change that may still roll back. An error from fn
+// becomes the response; the committed writes stay, so fn undoes them itself.
+func AfterCommit(c *fiber.Ctx, fn func() error) error {
+	if _, ok := c.Locals("db").(*gorm.DB); !ok {
+		return fn()
+	}
+	steps, _ := c.Locals(afterCommit).([]func() error)
+	c.Locals(afterCommit, append(steps, fn))
+	return nil
+}
+
+func committed(c *fiber.Ctx) error {
+	steps, _ := c.Locals(afterCommit).([]func() error)
+	c.Locals(afterCommit, nil)
+	for _, fn := range steps {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TenantID returns the tenant the request acts for: the signed-in user, or
+// the user whose client holds the portal session
+func TenantID(c *fiber.Ctx) (string, error) {
+	if tenantID, ok := c.Locals("tenant_id").(string); ok {
+		return tenantID, nil
+	}
+	return GetUserIDFromContext(c)
+}
+
+// DB returns the tenant-bound transaction for the request, or the global
+// connection for routes that don't run inside TenantTransaction
+func DB(c *fiber.Ctx) *gorm.DB {
+	if tx, ok := c.Locals("db").(*gorm.DB); ok {
+		return tx
+	}
+	return config.DB
+}
@@ -0,0 +1,111 @@
+//go:build integration
+
+package middleware
+
+import (
+	"billow-backend/config"
+	"billow-backend/models"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Run against a disposable local database:
+//
+//	TEST_DATABASE_URL="host=localhost user=postgres password=postgres dbname=billow_test sslmode=disable" \
+//	  go test -tags integration ./middleware
+func TestTenantTransactionIsolatesTenants(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	config.DB = db
+
+	if err := db.AutoMigrate(&models.User{}, &models.Client{}, &models.Invoice{}, &models.Subscription{},
+		&models.Plan{}, &models.UserPreferences{}, &models.UsageLog{}, &models.AnalyticsData{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := config.EnableRowLevelSecurity(); err != nil {
+		t.Fatalf("enable rls: %v", err)
+	}
+
+	users := []models.User{
+		{ID: "USR-RLS-A", ClerkID: "clerk_rls_a", Email: "a@rls.test"},
+		{ID: "USR-RLS-B", ClerkID: "clerk_rls_b", Email: "b@rls.test"},
+	}
+	clients := []models.Client{
+		{ID: "CLI-RLS-A", UserID: "USR-RLS-A", Name: "Tenant A client"},
+		{ID: "CLI-RLS-B", UserID: "USR-RLS-B", Name: "Tenant B client"},
+	}
+	t.Cleanup(func() {
+		db.Where("id IN ?", []string{"CLI-RLS-A", "CLI-RLS-B"}).Delete(&models.Client{})
+		db.Where("id IN ?", []string{"USR-RLS-A", "USR-RLS-B"}).Delete(&models.User{})
+	})
+	for i := range users {
+		if err := db.Create(&users[i]).Error; err != nil {
+			t.Fatalf("seed user: %v", err)
+		}
+		if err := db.Create(&clients[i]).Error; err != nil {
+			t.Fatalf("seed client: %v", err)
+		}
+	}
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", "USR-RLS-A")
+		return c.Next()
+	}, TenantTransaction())
+
+	// Deliberately forgets the user_id filter
+	app.Get("/clients", func(c *fiber.Ctx) error {
+		var found []models.Client
+		if err := DB(c).Find(&found).Error; err != nil {
+			return err
+		}
+		return c.JSON(found)
+	})
+	// Tries to write a row into another tenant
+	app.Post("/clients", func(c *fiber.Ctx) error {
+		return DB(c).Create(&models.Client{ID: "CLI-RLS-X", UserID: "USR-RLS-B", Name: "Injected"}).Error
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/clients", nil))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	var found []models.Client
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	for _, client := range found {
+		if client.UserID != "USR-RLS-A" {
+			t.Errorf("tenant A saw client %s of %s", client.ID, client.UserID)
+		}
+	}
+	if len(found) == 0 {
+		t.Error("tenant A could not see its own client")
+	}
+
+	resp, err = app.Test(httptest.NewRequest("POST", "/clients", nil))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if resp.StatusCode < 400 {
+		t.Errorf("cross-tenant insert succeeded with status %d", resp.StatusCode)
+	}
+	var injected int64
+	db.Model(&models.Client{}).Where("id = ?", "CLI-RLS-X").Count(&injected)
+	if injected != 0 {
+		t.Error("cross-tenant insert was committed")
+	}
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAuditAppendOnly is returned when code tries to modify a recorded audit event
+var ErrAuditAppendOnly = errors.New("audit events are append-only")
+
+// AuditEvent records a security-sensitive change to an account. Rows are never
+// updated or deleted and are kept after the user is removed.
+type AuditEvent struct {
+	ID         string    `json:"id" gorm:"primaryKey;type:varchar(40)"`
+	UserID     string    `json:"user_id" gorm:"type:varchar(30);not null;index"` // tenant the event belongs to
+	ActorID    string    `json:"actor_id" gorm:"type:varchar(50)"`               // user or system that made the change
+	Action     string    `json:"action" gorm:"type:varchar(50);index"`           // auth.login, subscription.changed, client.deleted, ...
+	TargetType string    `json:"target_type" gorm:"type:varchar(30)"`
+	TargetID   string    `json:"target_id" gorm:"type:varchar(50)"`
+	Risk       string    `json:"risk" gorm:"type:varchar(10);default:'low'"` // low, high
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Before     string    `json:"before,omitempty"` // JSON of the changed fields before
+	After      string    `json:"after,omitempty"`  // JSON of the changed fields after
+	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime;index"`
+}
+
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+func GenerateAuditEventID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("AUD-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
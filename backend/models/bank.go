@@ -0,0 +1,36 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// BankAccount is where a user gets paid, by IBAN or by account and routing
+// number. Payment QR codes on invoices are built from it; the address is the
+// creditor address Swiss QR-bills need. Each business can have its own
+// account; the one without a BusinessID is used for the rest.
+type BankAccount struct {
+	ID             string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID         string    `json:"user_id" gorm:"type:varchar(30);not null;uniqueIndex:idx_bank_account_business"`
+	BusinessID     string    `json:"business_id" gorm:"type:varchar(30);not null;default:'';uniqueIndex:idx_bank_account_business"`
+	AccountHolder  string    `json:"account_holder"`
+	IBAN           string    `json:"iban" gorm:"type:varchar(34)"`
+	BIC            string    `json:"bic" gorm:"type:varchar(11)"`
+	AccountNumber  string    `json:"account_number"` // for banks without IBANs, e.g. in the US
+	RoutingNumber  string    `json:"routing_number"`
+	UPIID          string    `json:"upi_id"` // virtual payment address, e.g. name@bank
+	Street         string    `json:"street"`
+	BuildingNumber string    `json:"building_number"`
+	PostalCode     string    `json:"postal_code"`
+	City           string    `json:"city"`
+	Country        string    `json:"country" gorm:"type:varchar(2)"` // ISO 3166-1 alpha-2
+	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+func GenerateBankAccountID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("BNK-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// BillingEvent records a payment provider webhook that has been applied.
+// Providers retry deliveries, so the event ID is used to apply each one once.
+type BillingEvent struct {
+	ID             string    `json:"id" gorm:"primaryKey;type:varchar(100)"` // provider event ID
+	Type           string    `json:"type" gorm:"type:varchar(60)"`
+	SubscriptionID string    `json:"subscription_id" gorm:"type:varchar(30);index"`
+	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
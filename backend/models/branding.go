@@ -0,0 +1,30 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Branding replaces Billow's look on the invoices, emails and client portal
+// of white-label accounts. It only applies while the plan includes white
+// labelling.
+type Branding struct {
+	ID           string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID       string    `json:"user_id" gorm:"type:varchar(30);not null;unique;index"`
+	PrimaryColor string    `json:"primary_color" gorm:"type:varchar(7)"` // #rrggbb
+	AccentColor  string    `json:"accent_color" gorm:"type:varchar(7)"`
+	Font         string    `json:"font" gorm:"type:varchar(20)"`
+	SenderName   string    `json:"sender_name"` // display name on emails to clients
+	ReplyTo      string    `json:"reply_to"`
+	Logo         []byte    `json:"-"` // shown in the client portal
+	LogoType     string    `json:"-" gorm:"type:varchar(20)"`
+	CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt    time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+func GenerateBrandingID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("BRD-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
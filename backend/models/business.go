@@ -0,0 +1,215 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Address is a postal address
+type Address struct {
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country" gorm:"type:varchar(2)"` // ISO 3166-1 alpha-2
+}
+
+// Lines formats the address for printing, skipping empty parts
+func (a Address) Lines() []string {
+	var lines []string
+	for _, line := range []string{
+		a.Line1,
+		a.Line2,
+		strings.TrimSpace(a.PostalCode + " " + a.City),
+		a.Region,
+		a.Country,
+	} {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// IsZero reports whether no part of the address is set
+func (a Address) IsZero() bool {
+	return len(a.Lines()) == 0
+}
+
+// Payment terms an invoice can be issued on
+type PaymentTerms string
+
+const (
+	TermsDueOnReceipt PaymentTerms = "due_on_receipt"
+	TermsNet7         PaymentTerms = "net_7"
+	TermsNet15        PaymentTerms = "net_15"
+	TermsNet30        PaymentTerms = "net_30"
+	TermsNet45        PaymentTerms = "net_45"
+	TermsNet60        PaymentTerms = "net_60"
+	TermsEndOfMonth   PaymentTerms = "eom"
+)
+
+// Valid reports whether t is one of the known terms
+func (t PaymentTerms) Valid() bool {
+	switch t {
+	case TermsDueOnReceipt, TermsNet7, TermsNet15, TermsNet30, TermsNet45, TermsNet60, TermsEndOfMonth:
+		return true
+	}
+	return false
+}
+
+// DueDate is when an invoice issued on date is due: Net terms give that many
+// days, end-of-month terms the last day of the invoice's month
+func (t PaymentTerms) DueDate(date time.Time) time.Time {
+	switch t {
+	case TermsNet7:
+		return date.AddDate(0, 0, 7)
+	case TermsNet15:
+		return date.AddDate(0, 0, 15)
+	case TermsNet30:
+		return date.AddDate(0, 0, 30)
+	case TermsNet45:
+		return date.AddDate(0, 0, 45)
+	case TermsNet60:
+		return date.AddDate(0, 0, 60)
+	case TermsEndOfMonth:
+		return time.Date(date.Year(), date.Month()+1, 0, 0, 0, 0, 0, date.Location())
+	}
+	return date
+}
+
+// TaxRate is a tax charged on invoices, e.g. VAT at 20 percent
+type TaxRate struct {
+	Label string  `json:"label"`
+	Rate  float64 `json:"rate"` // percent
+}
+
+// TaxRates are stored as a JSON list
+type TaxRates []TaxRate
+
+// Value implements driver.Valuer
+func (r TaxRates) Value() (driver.Value, error) {
+	if len(r) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(r)
+	return string(data), err
+}
+
+// Scan implements sql.Scanner
+func (r *TaxRates) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return errors.New("tax rates must be stored as text")
+	}
+	*r = nil
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, r)
+}
+
+// Included splits the taxes out of an amount that includes all of them, one
+// per rate
+func (r TaxRates) Included(amount float64) []float64 {
+	total := 0.0
+	for _, tax := range r {
+		total += tax.Rate
+	}
+	taxes := make([]float64, len(r))
+	for i, tax := range r {
+		taxes[i] = amount * tax.Rate / (100 + total)
+	}
+	return taxes
+}
+
+// Added works out each tax on a net amount
+func (r TaxRates) Added(net float64) []float64 {
+	taxes := make([]float64, len(r))
+	for i, tax := range r {
+		taxes[i] = net * tax.Rate / 100
+	}
+	return taxes
+}
+
+// BusinessProfile is a legal entity a user invoices as. A user can run several;
+// each client and invoice belongs to one of them, and the default one takes
+// anything created without a choice. Bank details live in BankAccount.
+type BusinessProfile struct {
+	ID                  string       `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID              string       `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	IsDefault           bool         `json:"is_default"`
+	LegalName           string       `json:"legal_name"`
+	TradingName         string       `json:"trading_name"`
+	Email               string       `json:"email"`
+	Phone               string       `json:"phone"`
+	Website             string       `json:"website"`
+	Address             Address      `json:"address" gorm:"embedded;embeddedPrefix:address_"`
+	RegistrationNumber  string       `json:"registration_number"`
+	TaxNumber           string       `json:"tax_number"` // VAT, GST or EIN
+	DefaultPaymentTerms PaymentTerms `json:"default_payment_terms" gorm:"type:varchar(20)"`
+	FooterNotes         string       `json:"footer_notes"`
+	Logo                []byte       `json:"-"`
+	LogoType            string       `json:"-" gorm:"type:varchar(20)"`
+	CreatedAt           time.Time    `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt           time.Time    `json:"updated_at" gorm:"autoUpdateTime"`
+
+	// New invoices default to the base currency and are numbered
+	// InvoicePrefix + NextInvoiceNumber
+	BaseCurrency      string `json:"base_currency" gorm:"type:varchar(3)"`
+	InvoicePrefix     string `json:"invoice_prefix" gorm:"type:varchar(20)"`
+	NextInvoiceNumber int    `json:"next_invoice_number" gorm:"default:1"`
+
+	// Tax settings
+	TaxLabel     string  `json:"tax_label" gorm:"type:varchar(20)"` // e.g. VAT or GST
+	TaxRate      float64 `json:"tax_rate"`                          // percent
+	TaxInclusive bool    `json:"tax_inclusive"`                     // invoice amounts are entered with tax; otherwise it is added
+}
+
+// DefaultTaxRates is the tax of invoices that neither set one nor have a
+// client default, or nil when the business charges none
+func (p BusinessProfile) DefaultTaxRates() TaxRates {
+	if p.TaxRate <= 0 {
+		return nil
+	}
+	label := p.TaxLabel
+	if label == "" {
+		label = "Tax"
+	}
+	return TaxRates{{Label: label, Rate: p.TaxRate}}
+}
+
+// DisplayName is the name invoices are issued under
+func (p BusinessProfile) DisplayName() string {
+	if p.TradingName != "" {
+		return p.TradingName
+	}
+	return p.LegalName
+}
+
+// InvoiceNumber formats the nth invoice number of the sequence
+func (p BusinessProfile) InvoiceNumber(n int) string {
+	prefix := p.InvoicePrefix
+	if prefix == "" {
+		prefix = "INV-"
+	}
+	return fmt.Sprintf("%s%04d", prefix, n)
+}
+
+func GenerateBusinessProfileID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("BIZ-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPaymentTermsDueDate(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		terms PaymentTerms
+		from  string
+		due   string
+	}{
+		{TermsDueOnReceipt, "2024-01-31", "2024-01-31"},
+		{"", "2024-01-31", "2024-01-31"},
+		{TermsEndOfMonth, "2024-01-31", "2024-01-31"},
+		{TermsEndOfMonth, "2024-02-01", "2024-02-29"},
+		{TermsEndOfMonth, "2024-12-15", "2024-12-31"},
+		{TermsNet7, "2024-12-28", "2025-01-04"},
+		{TermsNet30, "2024-01-15", "2024-02-14"},
+		{TermsNet30, "2024-01-31", "2024-03-01"},
+		{TermsNet30, "2023-01-31", "2023-03-02"},
+		{TermsNet60, "2024-11-30", "2025-01-29"},
+	}
+	for _, tt := range tests {
+		if got := tt.terms.DueDate(date(tt.from)).Format("2006-01-02"); got != tt.due {
+			t.Errorf("%q from %s = %s, want %s", tt.terms, tt.from, got, tt.due)
+		}
+	}
+}
+
+func TestDefaultTaxRates(t *testing.T) {
+	tests := []struct {
+		business BusinessProfile
+		want     TaxRates
+	}{
+		{BusinessProfile{}, nil},
+		{BusinessProfile{TaxLabel: "VAT"}, nil},
+		{BusinessProfile{TaxLabel: "VAT", TaxRate: 20}, TaxRates{{Label: "VAT", Rate: 20}}},
+		{BusinessProfile{TaxRate: 7.5}, TaxRates{{Label: "Tax", Rate: 7.5}}},
+	}
+	for _, tt := range tests {
+		if got := tt.business.DefaultTaxRates(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v: got %v, want %v", tt.business, got, tt.want)
+		}
+	}
+}
@@ -1,33 +1,96 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
 type Client struct {
-	ID             string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
-	UserID         string    `json:"user_id" gorm:"type:varchar(30);not null;index"`
-	Name           string    `json:"name"`
-	Email          string    `json:"email"`
-	Phone          string    `json:"phone"`
-	Company        string    `json:"company"`
-	Address        string    `json:"address"`
-	TotalInvoiced  float64   `json:"total_invoiced"`
-	TotalPaid      float64   `json:"total_paid"`
-	InvoiceCount   int       `json:"invoice_count"`
-	AverageInvoice float64   `json:"average_invoice"`
-	PaymentDelay   int       `json:"payment_delay"` // in days
-	Avatar         string    `json:"avatar"`
-	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt      time.Time `json:"updated_at" gorm:"autoUpdateTime"`
-	
+	ID              string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID          string    `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	BusinessID      string    `json:"business_id" gorm:"type:varchar(30);index"`
+	Name            string    `json:"name"`
+	Email           string    `json:"email"`
+	Phone           string    `json:"phone"`
+	Company         string    `json:"company"`
+	BillingAddress  Address   `json:"billing_address" gorm:"embedded;embeddedPrefix:billing_address_"`
+	ShippingAddress Address   `json:"shipping_address" gorm:"embedded;embeddedPrefix:shipping_address_"`
+	TotalInvoiced   float64   `json:"total_invoiced"`
+	TotalPaid       float64   `json:"total_paid"`
+	InvoiceCount    int       `json:"invoice_count"`
+	AverageInvoice  float64   `json:"average_invoice"`
+	PaymentDelay    int       `json:"payment_delay"` // in days
+	Avatar          string    `json:"avatar"`
+	TemplateID      string    `json:"template_id" gorm:"type:varchar(30)"` // invoice template for the client's invoices
+	Language        string    `json:"language" gorm:"type:varchar(20)"`    // documents and emails to the client, instead of the user's language
+	CreatedAt       time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt       time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+
+	// Defaults for new invoices to the client
+	DefaultCurrency string       `json:"default_currency" gorm:"type:varchar(3)"`
+	PaymentTerms    PaymentTerms `json:"payment_terms" gorm:"type:varchar(20)"`
+	DefaultTaxRates TaxRates     `json:"default_tax_rates" gorm:"type:text"`
+	DefaultNotes    string       `json:"default_notes" gorm:"type:text"`
+
 	// Relationships
-	User     User      `json:"user,omitempty" gorm:"foreignKey:UserID;references:ID"`
-	Invoices []Invoice `json:"invoices,omitempty" gorm:"foreignKey:ClientID"`
+	User     User            `json:"user,omitempty" gorm:"foreignKey:UserID;references:ID"`
+	Invoices []Invoice       `json:"invoices,omitempty" gorm:"foreignKey:ClientID"`
+	Contacts []ClientContact `json:"contacts,omitempty" gorm:"foreignKey:ClientID"`
+}
+
+// OwnerID returns the tenant the client belongs to
+func (c Client) OwnerID() string {
+	return c.UserID
+}
+
+// BillingEmails are the addresses invoices go to: those of the billing
+// contacts, or the client's own email when none is set. Contacts must be loaded.
+func (c Client) BillingEmails() []string {
+	var emails []string
+	seen := map[string]bool{}
+	for _, contact := range c.Contacts {
+		email := strings.TrimSpace(contact.Email)
+		if contact.IsBillingContact && email != "" && !seen[strings.ToLower(email)] {
+			seen[strings.ToLower(email)] = true
+			emails = append(emails, email)
+		}
+	}
+	if len(emails) == 0 && c.Email != "" {
+		emails = append(emails, c.Email)
+	}
+	return emails
+}
+
+// ClientContact is a person at a client. Billing contacts receive the
+// client's invoices.
+type ClientContact struct {
+	ID               string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID           string    `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	ClientID         string    `json:"client_id" gorm:"type:varchar(30);not null;index"`
+	Name             string    `json:"name"`
+	Role             string    `json:"role"` // e.g. Accounts payable
+	Email            string    `json:"email"`
+	Phone            string    `json:"phone"`
+	IsBillingContact bool      `json:"is_billing_contact"`
+	CreatedAt        time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt        time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// OwnerID returns the tenant the contact belongs to
+func (c ClientContact) OwnerID() string {
+	return c.UserID
+}
+
+func GenerateClientContactID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("CON-%s-%d", time.Now().Format("20060102-150405"), idCounter)
 }
 
 // GenerateClientID creates a unique client ID using current timestamp
 func GenerateClientID() string {
 	now := time.Now()
 	return "CLI-" + now.Format("20060102-150405") + "-" + string(rune(now.Nanosecond()/1000000))
-}
\ No newline at end of file
+}
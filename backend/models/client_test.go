@@ -0,0 +1,32 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBillingEmails(t *testing.T) {
+	tests := []struct {
+		name   string
+		client Client
+		want   []string
+	}{
+		{"client email without contacts", Client{Email: "ap@acme.test"}, []string{"ap@acme.test"}},
+		{"no email at all", Client{}, nil},
+		{"billing contacts replace the client email", Client{Email: "ap@acme.test", Contacts: []ClientContact{
+			{Email: "jane@acme.test", IsBillingContact: true},
+			{Email: "sales@acme.test"},
+			{Email: " bob@acme.test ", IsBillingContact: true},
+		}}, []string{"jane@acme.test", "bob@acme.test"}},
+		{"duplicates differing in case", Client{Contacts: []ClientContact{
+			{Email: "Jane@Acme.test", IsBillingContact: true},
+			{Email: "jane@acme.test", IsBillingContact: true},
+		}}, []string{"Jane@Acme.test"}},
+		{"only other contacts", Client{Email: "ap@acme.test", Contacts: []ClientContact{{Email: "sales@acme.test"}}}, []string{"ap@acme.test"}},
+	}
+	for _, tt := range tests {
+		if got := tt.client.BillingEmails(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
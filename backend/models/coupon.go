@@ -0,0 +1,105 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// How long a coupon's discount lasts once redeemed
+const (
+	CouponOnce      = "once"      // the current billing period
+	CouponRepeating = "repeating" // DurationInMonths from redemption
+	CouponForever   = "forever"
+)
+
+// Coupon is a discount that promotion codes hand out
+type Coupon struct {
+	ID               string     `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	Name             string     `json:"name"`
+	PercentOff       float64    `json:"percent_off"` // 0-100, or 0 when AmountOff is used
+	AmountOff        float64    `json:"amount_off"`  // per billing period, in Currency
+	Currency         string     `json:"currency" gorm:"default:'USD'"`
+	Duration         string     `json:"duration" gorm:"type:varchar(20);not null"` // once, repeating, forever
+	DurationInMonths int        `json:"duration_in_months"`
+	MaxRedemptions   int        `json:"max_redemptions"` // 0 for unlimited
+	TimesRedeemed    int        `json:"times_redeemed"`
+	RedeemBy         *time.Time `json:"redeem_by,omitempty"`
+	PlanIDs          string     `json:"plan_ids"`                   // comma-separated; empty applies to every plan
+	ProviderCouponID string     `json:"-" gorm:"type:varchar(100)"` // coupon applied on the payment provider
+	CreatedAt        time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt        time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// AppliesTo reports whether the coupon can discount planID. Restricting a
+// coupon to a plan covers its yearly variant too.
+func (c Coupon) AppliesTo(planID string) bool {
+	if strings.TrimSpace(c.PlanIDs) == "" {
+		return true
+	}
+	for _, id := range strings.Split(c.PlanIDs, ",") {
+		id = strings.TrimSpace(id)
+		if id == planID || id == BasePlanID(planID) {
+			return true
+		}
+	}
+	return false
+}
+
+// PromotionCode is a customer-facing code for a coupon, e.g. PARTNER20. A
+// coupon can have several codes with their own limits, one per partner.
+type PromotionCode struct {
+	ID             string     `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	Code           string     `json:"code" gorm:"uniqueIndex;type:varchar(50);not null"` // stored upper case
+	CouponID       string     `json:"coupon_id" gorm:"type:varchar(30);not null;index"`
+	Active         bool       `json:"active" gorm:"default:true"`
+	MaxRedemptions int        `json:"max_redemptions"` // 0 for unlimited
+	TimesRedeemed  int        `json:"times_redeemed"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+
+	// Relationships
+	Coupon Coupon `json:"coupon" gorm:"foreignKey:CouponID;references:ID"`
+}
+
+// Discount is a coupon redeemed against a subscription
+type Discount struct {
+	ID              string     `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID          string     `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	SubscriptionID  string     `json:"subscription_id" gorm:"type:varchar(30);not null;index"`
+	CouponID        string     `json:"coupon_id" gorm:"type:varchar(30);not null"`
+	PromotionCodeID string     `json:"promotion_code_id" gorm:"type:varchar(30)"`
+	StartsAt        time.Time  `json:"starts_at"`
+	EndsAt          *time.Time `json:"ends_at,omitempty"` // nil for forever
+	CreatedAt       time.Time  `json:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	Coupon Coupon `json:"coupon" gorm:"foreignKey:CouponID;references:ID"`
+}
+
+// ActiveAt reports whether the discount applies at t
+func (d Discount) ActiveAt(t time.Time) bool {
+	return !t.Before(d.StartsAt) && (d.EndsAt == nil || t.Before(*d.EndsAt))
+}
+
+func GenerateCouponID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("CPN-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
+
+func GeneratePromotionCodeID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("PRC-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
+
+func GenerateDiscountID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("DSC-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AddOn is something a subscriber can buy on top of their plan, e.g. extra
+// clients or white-label branding
+type AddOn struct {
+	ID             string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	Name           string    `json:"name"`
+	Price          float64   `json:"price"`
+	Currency       string    `json:"currency" gorm:"default:'USD'"`
+	Features       string    `json:"features"`         // comma-separated plan features it grants
+	InvoiceLimit   int       `json:"invoice_limit"`    // added to the plan limit per unit, -1 for unlimited
+	ClientLimit    int       `json:"client_limit"`     // added to the plan limit per unit, -1 for unlimited
+	MessagesPerDay int       `json:"messages_per_day"` // added to the plan limit per unit, -1 for unlimited
+	Active         bool      `json:"active" gorm:"default:true"`
+	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// FeatureList returns the features the add-on grants
+func (a AddOn) FeatureList() []string {
+	features := []string{}
+	for _, feature := range strings.Split(a.Features, ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// SetFeatures stores features, rejecting names that aren't plan features
+func (a *AddOn) SetFeatures(features []string) error {
+	for _, feature := range features {
+		if (&Plan{}).featureFlag(feature) == nil {
+			return fmt.Errorf("unknown plan feature %q", feature)
+		}
+	}
+	a.Features = strings.Join(features, ",")
+	return nil
+}
+
+// UserAddOn is an add-on a tenant has bought
+type UserAddOn struct {
+	ID        string     `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID    string     `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	AddOnID   string     `json:"add_on_id" gorm:"type:varchar(30);not null"`
+	Quantity  int        `json:"quantity" gorm:"default:1"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil until cancelled
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+
+	// Relationships
+	AddOn AddOn `json:"add_on" gorm:"foreignKey:AddOnID;references:ID"`
+}
+
+// ActiveAt reports whether the add-on applies at t
+func (u UserAddOn) ActiveAt(t time.Time) bool {
+	return u.AddOn.Active && (u.ExpiresAt == nil || t.Before(*u.ExpiresAt))
+}
+
+// EntitlementOverride grants or revokes a feature, or replaces a quota limit,
+// for one tenant regardless of their plan. Staff use them for trials of
+// single features and support concessions.
+type EntitlementOverride struct {
+	ID        string     `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID    string     `json:"user_id" gorm:"type:varchar(30);not null;uniqueIndex:idx_override_user_key"`
+	Key       string     `json:"key" gorm:"type:varchar(50);not null;uniqueIndex:idx_override_user_key"` // feature or quota name
+	Enabled   *bool      `json:"enabled,omitempty"`                                                      // for features
+	Limit     *int       `json:"limit,omitempty"`                                                        // for quotas, -1 for unlimited
+	Reason    string     `json:"reason"`
+	CreatedBy string     `json:"created_by" gorm:"type:varchar(30)"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// ActiveAt reports whether the override applies at t
+func (o EntitlementOverride) ActiveAt(t time.Time) bool {
+	return o.ExpiresAt == nil || t.Before(*o.ExpiresAt)
+}
+
+func GenerateAddOnID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("ADD-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
+
+func GenerateUserAddOnID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("UAD-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
+
+func GenerateEntitlementOverrideID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("ENT-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
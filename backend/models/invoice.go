@@ -8,6 +8,8 @@ import (
 type Invoice struct {
 	ID           string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
 	UserID       string    `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	BusinessID   string    `json:"business_id" gorm:"type:varchar(30);index"`
+	Number       string    `json:"number" gorm:"type:varchar(40)"` // from the business's sequence
 	ClientID     string    `json:"client_id" gorm:"type:varchar(30);not null;index;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT"`
 	Client       Client    `json:"client" gorm:"foreignKey:ClientID;references:ID"`
 	ClientName   string    `json:"client_name"` // For backward compatibility and display
@@ -16,13 +18,88 @@ type Invoice struct {
 	CurrencyType string    `json:"currency_type"`
 	Status       string    `json:"status"` // paid/unpaid/overdue/processing
 	DueDate      string    `json:"due_date"`
+	TemplateID   string    `json:"template_id" gorm:"type:varchar(30)"` // overrides the client's template
 	CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt    time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 
+	// Set from the client's defaults when left out
+	PaymentTerms PaymentTerms `json:"payment_terms" gorm:"type:varchar(20)"`
+	TaxRates     TaxRates     `json:"tax_rates" gorm:"type:text"` // taxes included in the amount
+	TaxInclusive *bool        `json:"tax_inclusive"`              // false when the tax was added to a net amount
+	Notes        string       `json:"notes" gorm:"type:text"`
+	Language     string       `json:"language" gorm:"type:varchar(20)"` // of the document
+
+	// Recorded when the invoice is opened through a share link
+	ViewedAt     *time.Time `json:"viewed_at"` // first view
+	LastViewedAt *time.Time `json:"last_viewed_at"`
+	ViewCount    int        `json:"view_count"`
+
 	// Relationships
 	User User `json:"user,omitempty" gorm:"foreignKey:UserID;references:ID"`
 }
 
+// OwnerID returns the tenant the invoice belongs to
+func (i Invoice) OwnerID() string {
+	return i.UserID
+}
+
+// TaxesIncluded reports whether the amount was entered with its taxes, rather
+// than net with the taxes added on top. Either way Amount is the total due.
+func (i Invoice) TaxesIncluded() bool {
+	return i.TaxInclusive == nil || *i.TaxInclusive
+}
+
+// DisplayNumber is the number printed on the invoice: its number in the
+// business's sequence, or its ID for invoices from before numbering
+func (i Invoice) DisplayNumber() string {
+	if i.Number != "" {
+		return i.Number
+	}
+	return i.ID
+}
+
+// InvoiceShare is a public link to an invoice. Only a hash of its token is
+// stored, so a link can't be recovered from the database, only revoked.
+type InvoiceShare struct {
+	ID        string     `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID    string     `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	InvoiceID string     `json:"invoice_id" gorm:"type:varchar(30);not null;index"`
+	TokenHash string     `json:"-" gorm:"type:varchar(64);uniqueIndex;not null"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil never expires
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// ActiveAt reports whether the link opens the invoice at t
+func (s InvoiceShare) ActiveAt(t time.Time) bool {
+	return s.RevokedAt == nil && (s.ExpiresAt == nil || t.Before(*s.ExpiresAt))
+}
+
+// InvoiceView is one opening of a shared invoice
+type InvoiceView struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID    string    `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	InvoiceID string    `json:"invoice_id" gorm:"type:varchar(30);not null;index"`
+	ShareID   string    `json:"share_id" gorm:"type:varchar(30);not null"`
+	IP        string    `json:"ip" gorm:"type:varchar(45)"`
+	UserAgent string    `json:"user_agent" gorm:"type:varchar(500)"`
+	ViewedAt  time.Time `json:"viewed_at"`
+}
+
+func GenerateInvoiceShareID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("SHR-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
+
+func GenerateInvoiceViewID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("VEW-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
+
 // GenerateInvoiceID creates a unique invoice ID using current date, time, and nanoseconds
 // Format: INV-YYYYMMDD-HHMMSS-NNNNNN (e.g., INV-20241215-143052-123456)
 func GenerateInvoiceID() string {
@@ -35,11 +112,3 @@ func GenerateInvoiceID() string {
 		now.Format("150405"),   // HHMMSS
 		nanoseconds)            // Microseconds (6 digits)
 }
-
-// GenerateInvoiceNumber creates a sequential invoice number for display
-func GenerateInvoiceNumber(userID string) string {
-	// Count existing invoices for this user to generate next number
-	var count int64
-	// This would be called from the route handler where we have access to the DB
-	return fmt.Sprintf("INV-%04d", count+1)
-}
\ No newline at end of file
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Meter aggregations
+const (
+	MeterPerPeriod = "period" // usage over the billing period counts against the allowance
+	MeterPerDay    = "day"    // each UTC day's usage counts against the allowance
+)
+
+// Meter prices usage of a feature beyond what the plan includes
+type Meter struct {
+	FeatureType string  `json:"feature_type"` // usage event, e.g. message_sent
+	Quota       string  `json:"quota"`        // entitlement quota setting the allowance, e.g. messages_per_day
+	Aggregation string  `json:"aggregation"`  // period or day
+	UnitPrice   float64 `json:"unit_price"`   // per unit of overage, in the plan currency
+}
+
+// MeterList returns the plan's meters. Plans without metered billing have none.
+func (p Plan) MeterList() []Meter {
+	var meters []Meter
+	if p.Meters != "" {
+		json.Unmarshal([]byte(p.Meters), &meters)
+	}
+	return meters
+}
+
+// MeterFor returns the meter billing overage on quota
+func (p Plan) MeterFor(quota string) (Meter, bool) {
+	for _, meter := range p.MeterList() {
+		if meter.Quota == quota {
+			return meter, true
+		}
+	}
+	return Meter{}, false
+}
+
+// SetMeters stores the plan's meters
+func (p *Plan) SetMeters(meters []Meter) error {
+	if len(meters) == 0 {
+		p.Meters = ""
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, meter := range meters {
+		switch {
+		case meter.FeatureType == "":
+			return errors.New("meter feature_type is required")
+		case meter.Aggregation != MeterPerPeriod && meter.Aggregation != MeterPerDay:
+			return fmt.Errorf("meter %s: aggregation must be period or day", meter.FeatureType)
+		case meter.UnitPrice < 0:
+			return fmt.Errorf("meter %s: unit_price can't be negative", meter.FeatureType)
+		case seen[meter.FeatureType]:
+			return fmt.Errorf("meter %s is defined twice", meter.FeatureType)
+		}
+		seen[meter.FeatureType] = true
+	}
+	data, err := json.Marshal(meters)
+	if err != nil {
+		return err
+	}
+	p.Meters = string(data)
+	return nil
+}
+
+// Usage record kinds
+const (
+	UsageRecordUsage      = "usage"      // a meter's usage over one billing period
+	UsageRecordAdjustment = "adjustment" // usage that arrived after its period closed
+)
+
+// UsageRecord is metered usage rolled up from UsageLog and priced against the
+// plan's meter. Records of the open period are recomputed until the period
+// closes; usage arriving later is billed by an adjustment in the next open
+// period rather than by changing a closed record.
+type UsageRecord struct {
+	ID             string     `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID         string     `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	SubscriptionID string     `json:"subscription_id" gorm:"type:varchar(30);not null"`
+	PlanID         string     `json:"plan_id" gorm:"type:varchar(30)"`
+	FeatureType    string     `json:"feature_type" gorm:"type:varchar(50);not null"`
+	Kind           string     `json:"kind" gorm:"type:varchar(20);not null"`
+	Aggregation    string     `json:"aggregation" gorm:"type:varchar(20)"`
+	PeriodStart    time.Time  `json:"period_start" gorm:"index"`
+	PeriodEnd      time.Time  `json:"period_end"`
+	AdjustsPeriod  *time.Time `json:"adjusts_period,omitempty"` // start of the closed period late usage belongs to
+	Quantity       int64      `json:"quantity"`
+	Included       int64      `json:"included"` // -1 for unlimited; per day for daily meters
+	Overage        int64      `json:"overage"`
+	UnitPrice      float64    `json:"unit_price"`
+	Amount         float64    `json:"amount"`
+	Currency       string     `json:"currency" gorm:"default:'USD'"`
+	ClosedAt       *time.Time `json:"closed_at,omitempty"`
+	LateQuantity   int64      `json:"late_quantity"` // usage billed by adjustments after closing
+	LateOverage    int64      `json:"late_overage"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+func GenerateUsageRecordID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("MTR-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Payment is money received against an invoice
+type Payment struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID    string    `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	InvoiceID string    `json:"invoice_id" gorm:"type:varchar(30);not null;index"`
+	Amount    float64   `json:"amount"`
+	Currency  string    `json:"currency" gorm:"type:varchar(3)"`
+	Gateway   string    `json:"gateway" gorm:"type:varchar(20);uniqueIndex:idx_payment_reference"`
+	Reference string    `json:"reference" gorm:"type:varchar(100);uniqueIndex:idx_payment_reference"` // the gateway's payment ID
+	PaidAt    time.Time `json:"paid_at"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+func GeneratePaymentID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("PAY-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// PortalLink is a magic link emailed to a client. It signs them in to the
+// client portal once.
+type PortalLink struct {
+	ID        string     `json:"id" gorm:"primaryKey;type:varchar(64)"` // random, carried in the signed token
+	UserID    string     `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	ClientID  string     `json:"client_id" gorm:"type:varchar(30);not null"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// PortalSession is a client signed in to the portal. It only ever grants
+// access to the one client it was issued for.
+type PortalSession struct {
+	ID        string     `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	TokenHash string     `json:"-" gorm:"type:varchar(64);uniqueIndex;not null"` // SHA-256 of the bearer token
+	UserID    string     `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	ClientID  string     `json:"client_id" gorm:"type:varchar(30);not null"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// ActiveAt reports whether the session can be used at t
+func (s PortalSession) ActiveAt(t time.Time) bool {
+	return s.RevokedAt == nil && t.Before(s.ExpiresAt)
+}
+
+func GeneratePortalSessionID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("PSS-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// InvoiceTemplate is a user's own layout for invoice pages, written in Go's
+// html/template language and rendered by the render package. Invoices use
+// their own template, else their client's, else the user's default one.
+type InvoiceTemplate struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID    string    `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	Name      string    `json:"name"`
+	Body      string    `json:"body" gorm:"type:text"`
+	IsDefault bool      `json:"is_default"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+func GenerateInvoiceTemplateID() string {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	idCounter++
+	return fmt.Sprintf("TPL-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+}
@@ -1,7 +1,11 @@
 package models
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 )
@@ -18,6 +22,7 @@ type User struct {
 	Email        string    `json:"email" gorm:"unique;not null"`
 	DisplayName  string    `json:"display_name"`
 	ProfileImage string    `json:"profile_image"`
+	Role         string    `json:"role" gorm:"type:varchar(20);default:'owner'"` // owner, admin
 	CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt    time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 
@@ -29,24 +34,62 @@ type User struct {
 	Invoices     []Invoice        `json:"invoices,omitempty" gorm:"foreignKey:UserID"`
 }
 
+// Subscription statuses
+const (
+	SubscriptionTrialing = "trialing"
+	SubscriptionActive   = "active"
+	SubscriptionPastDue  = "past_due"
+	SubscriptionCanceled = "canceled"
+	SubscriptionExpired  = "expired" // trial ended without converting to a paid plan
+)
+
+// PastDueGracePeriod is how long a past-due subscription keeps access before it is canceled
+const PastDueGracePeriod = 7 * 24 * time.Hour
+
 type Subscription struct {
-	ID               string     `json:"id" gorm:"primaryKey;type:varchar(30)"`
-	UserID           string     `json:"user_id" gorm:"type:varchar(30);not null;index"`
-	PlanID           string     `json:"plan_id" gorm:"type:varchar(30);not null"`
-	Status           string     `json:"status"` // active, canceled, past_due, trialing
-	CurrentPeriodEnd time.Time  `json:"current_period_end"`
-	TrialEnd         *time.Time `json:"trial_end,omitempty"`
-	CanceledAt       *time.Time `json:"canceled_at,omitempty"`
-	CreatedAt        time.Time  `json:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt        time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+	ID                string     `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	UserID            string     `json:"user_id" gorm:"type:varchar(30);not null;index"`
+	PlanID            string     `json:"plan_id" gorm:"type:varchar(30);not null"`
+	Status            string     `json:"status"` // active, canceled, past_due, trialing, expired
+	CurrentPeriodEnd  time.Time  `json:"current_period_end"`
+	TrialEnd          *time.Time `json:"trial_end,omitempty"`
+	CancelAtPeriodEnd bool       `json:"cancel_at_period_end"`
+	CanceledAt        *time.Time `json:"canceled_at,omitempty"`
+	ScheduledPlanID   string     `json:"scheduled_plan_id,omitempty" gorm:"type:varchar(30)"` // applied when the current period ends
+
+	// Set when the subscription is billed by the payment provider
+	ProviderCustomerID     string `json:"-" gorm:"type:varchar(100);index"`
+	ProviderSubscriptionID string `json:"-" gorm:"type:varchar(100);index"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 
 	// Relationships
 	User User `json:"user" gorm:"foreignKey:UserID;references:ID"`
 	Plan Plan `json:"plan" gorm:"foreignKey:PlanID;references:ID"`
 }
 
+// HasAccess reports whether the subscription currently grants access to its
+// plan. It doesn't rely on the lifecycle worker having run: a trial is over as
+// soon as TrialEnd passes, and past-due subscriptions keep access only during
+// the grace period.
+func (s Subscription) HasAccess(now time.Time) bool {
+	switch s.Status {
+	case SubscriptionActive:
+		return true
+	case SubscriptionTrialing:
+		return s.TrialEnd == nil || now.Before(*s.TrialEnd)
+	case SubscriptionPastDue:
+		return now.Before(s.CurrentPeriodEnd.Add(PastDueGracePeriod))
+	}
+	return false
+}
+
 type Plan struct {
 	ID                string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	Code              string    `json:"code" gorm:"type:varchar(30);index"` // the plan all versions belong to, e.g. PLN-PRO
+	Version           int       `json:"version" gorm:"default:1"`
+	Retired           bool      `json:"retired"` // no longer offered; existing subscribers keep it
 	Name              string    `json:"name"`
 	Price             float64   `json:"price"`
 	Currency          string    `json:"currency" gorm:"default:'USD'"`
@@ -60,10 +103,95 @@ type Plan struct {
 	AdvancedAnalytics bool      `json:"advanced_analytics"`
 	APIAccess         bool      `json:"api_access"`
 	WhiteLabel        bool      `json:"white_label"`
+	ProviderPriceID   string    `json:"-" gorm:"type:varchar(100)"` // price billed by the payment provider
+	Meters            string    `json:"-" gorm:"type:text"`         // JSON list of Meter, see MeterList
 	CreatedAt         time.Time `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt         time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 }
 
+// PlanFeatures are the feature flags a plan can include
+var PlanFeatures = []string{
+	"image_generation",
+	"custom_voice",
+	"priority_support",
+	"advanced_analytics",
+	"api_access",
+	"white_label",
+}
+
+// featureFlag returns the field behind a plan feature, or nil for unknown names
+func (p *Plan) featureFlag(feature string) *bool {
+	switch feature {
+	case "image_generation":
+		return &p.ImageGeneration
+	case "custom_voice":
+		return &p.CustomVoice
+	case "priority_support":
+		return &p.PrioritySupport
+	case "advanced_analytics":
+		return &p.AdvancedAnalytics
+	case "api_access":
+		return &p.APIAccess
+	case "white_label":
+		return &p.WhiteLabel
+	}
+	return nil
+}
+
+// Features lists the features the plan includes
+func (p Plan) Features() []string {
+	features := []string{}
+	for _, feature := range PlanFeatures {
+		if *p.featureFlag(feature) {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// HasFeature reports whether the plan includes feature. Unknown features are
+// never included.
+func (p Plan) HasFeature(feature string) bool {
+	flag := p.featureFlag(feature)
+	return flag != nil && *flag
+}
+
+// SetFeatures turns on exactly the named features
+func (p *Plan) SetFeatures(features []string) error {
+	for _, feature := range PlanFeatures {
+		*p.featureFlag(feature) = false
+	}
+	for _, feature := range features {
+		flag := p.featureFlag(feature)
+		if flag == nil {
+			return fmt.Errorf("unknown plan feature %q", feature)
+		}
+		*flag = true
+	}
+	return nil
+}
+
+// AnnualPlanSuffix marks the yearly variant of a plan ID, e.g. PLN-PRO-ANNUAL
+const AnnualPlanSuffix = "-ANNUAL"
+
+// planVersionSuffix matches the version part of a plan ID, e.g. PLN-PRO-V2
+var planVersionSuffix = regexp.MustCompile(`-V[0-9]+$`)
+
+// PlanVersionID is the ID of a version of a plan. The first version keeps
+// the plan code as its ID so subscriptions from before versioning still match.
+func PlanVersionID(code string, version int) string {
+	if version <= 1 {
+		return code
+	}
+	return fmt.Sprintf("%s-V%d", code, version)
+}
+
+// BasePlanID returns the plan a variant belongs to, so limits and access
+// keyed by plan apply to every version and to monthly and yearly billing alike
+func BasePlanID(planID string) string {
+	return strings.TrimSuffix(planVersionSuffix.ReplaceAllString(planID, ""), AnnualPlanSuffix)
+}
+
 type UserPreferences struct {
 	ID                 string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
 	UserID             string    `json:"user_id" gorm:"type:varchar(30);not null;unique;index"`
@@ -84,7 +212,7 @@ type UserPreferences struct {
 }
 
 type UsageLog struct {
-	ID          string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
+	ID          string    `json:"id" gorm:"primaryKey;type:varchar(40)"`
 	UserID      string    `json:"user_id" gorm:"type:varchar(30);not null;index"`
 	FeatureType string    `json:"feature_type"` // invoice_created, client_created, message_sent, image_generated
 	Count       int       `json:"count" gorm:"default:1"`
@@ -95,6 +223,16 @@ type UsageLog struct {
 	User User `json:"user" gorm:"foreignKey:UserID;references:ID"`
 }
 
+// UsageCounter is the per-day total of a feature's usage, maintained by the
+// usage pipeline so metrics don't have to scan UsageLog
+type UsageCounter struct {
+	UserID      string    `json:"user_id" gorm:"primaryKey;type:varchar(30)"`
+	Day         time.Time `json:"day" gorm:"primaryKey;type:date"`
+	FeatureType string    `json:"feature_type" gorm:"primaryKey;type:varchar(50)"`
+	Count       int       `json:"count"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
 type AnalyticsData struct {
 	ID               string    `json:"id" gorm:"primaryKey;type:varchar(30)"`
 	UserID           string    `json:"user_id" gorm:"type:varchar(30);not null;index"`
@@ -138,11 +276,14 @@ func GeneratePreferencesID() string {
 	return fmt.Sprintf("PRF-%s-%d", time.Now().Format("20060102-150405"), idCounter)
 }
 
+// GenerateUsageLogID uses a random suffix rather than the process counter so
+// IDs stay unique across restarts and concurrent instances
 func GenerateUsageLogID() string {
-	idMutex.Lock()
-	defer idMutex.Unlock()
-	idCounter++
-	return fmt.Sprintf("ULG-%s-%d", time.Now().Format("20060102-150405"), idCounter)
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("ULG-%s-%s", time.Now().Format("20060102-150405"), hex.EncodeToString(suffix))
 }
 
 func GenerateAnalyticsID() string {
@@ -150,4 +291,4 @@ func GenerateAnalyticsID() string {
 	defer idMutex.Unlock()
 	idCounter++
 	return fmt.Sprintf("ANL-%s-%d", time.Now().Format("20060102-150405"), idCounter)
-}
\ No newline at end of file
+}
@@ -0,0 +1,267 @@
+// Package payqr builds the payment QR codes clients scan with their banking
+// app: EPC SEPA credit transfers, UPI and Swiss QR-bills
+package payqr
+
+import (
+	"billow-backend/models"
+	"billow-backend/qr"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Supported schemes
+const (
+	SchemeEPC   = "epc"
+	SchemeUPI   = "upi"
+	SchemeSwiss = "swiss"
+)
+
+var (
+	ErrUnknownScheme  = errors.New("unknown payment QR scheme, use epc, upi or swiss")
+	ErrNoScheme       = errors.New("no payment QR scheme for this currency")
+	ErrMissingDetails = errors.New("bank details are incomplete")
+)
+
+// Payment is what the code asks the payer to transfer
+type Payment struct {
+	InvoiceID string
+	Amount    float64
+	Currency  string
+}
+
+// SchemeFor is the default scheme for invoices in currency
+func SchemeFor(currency string) string {
+	switch strings.ToUpper(currency) {
+	case "EUR":
+		return SchemeEPC
+	case "INR":
+		return SchemeUPI
+	case "CHF":
+		return SchemeSwiss
+	}
+	return ""
+}
+
+// Payload returns the text encoded in the QR code of scheme
+func Payload(scheme string, account models.BankAccount, p Payment) (string, error) {
+	switch scheme {
+	case SchemeEPC:
+		return EPC(account, p)
+	case SchemeUPI:
+		return UPI(account, p)
+	case SchemeSwiss:
+		return Swiss(account, p)
+	}
+	return "", ErrUnknownScheme
+}
+
+// Code encodes the payload of scheme with the error correction and size
+// limits its standard sets
+func Code(scheme string, account models.BankAccount, p Payment) (*qr.Code, error) {
+	payload, err := Payload(scheme, account, p)
+	if err != nil {
+		return nil, err
+	}
+	switch scheme {
+	case SchemeEPC:
+		return qr.EncodeMax([]byte(payload), qr.M, 13)
+	case SchemeSwiss:
+		code, err := qr.EncodeMax([]byte(payload), qr.M, 25)
+		if code != nil {
+			code.Cross = true
+		}
+		return code, err
+	default:
+		return qr.Encode([]byte(payload), qr.M)
+	}
+}
+
+// EPC builds an EPC069-12 (version 002) SEPA credit transfer payload, the
+// "GiroCode" understood by European banking apps
+func EPC(account models.BankAccount, p Payment) (string, error) {
+	if !strings.EqualFold(p.Currency, "EUR") {
+		return "", fmt.Errorf("SEPA QR codes are only for EUR invoices")
+	}
+	iban := NormalizeIBAN(account.IBAN)
+	if !ValidIBAN(iban) {
+		return "", fmt.Errorf("%w: a valid IBAN is required", ErrMissingDetails)
+	}
+	name := strings.TrimSpace(account.AccountHolder)
+	if name == "" {
+		return "", fmt.Errorf("%w: the account holder is required", ErrMissingDetails)
+	}
+	if err := checkAmount(p.Amount); err != nil {
+		return "", err
+	}
+
+	lines := []string{
+		"BCD",
+		"002",
+		"1", // UTF-8
+		"SCT",
+		strings.ToUpper(strings.TrimSpace(account.BIC)),
+		truncate(name, 70),
+		iban,
+		fmt.Sprintf("EUR%.2f", p.Amount),
+		"", // purpose
+		"", // structured reference; the invoice goes in the text instead
+		truncate("Invoice "+p.InvoiceID, 140),
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// UPI builds a upi://pay deep link
+func UPI(account models.BankAccount, p Payment) (string, error) {
+	if !strings.EqualFold(p.Currency, "INR") {
+		return "", fmt.Errorf("UPI QR codes are only for INR invoices")
+	}
+	vpa := strings.TrimSpace(account.UPIID)
+	if !strings.Contains(vpa, "@") {
+		return "", fmt.Errorf("%w: a UPI ID is required", ErrMissingDetails)
+	}
+	if err := checkAmount(p.Amount); err != nil {
+		return "", err
+	}
+
+	params := [][2]string{
+		{"pa", vpa},
+		{"pn", strings.TrimSpace(account.AccountHolder)},
+		{"am", fmt.Sprintf("%.2f", p.Amount)},
+		{"cu", "INR"},
+		{"tn", "Invoice " + p.InvoiceID},
+		{"tr", p.InvoiceID},
+	}
+	var query []string
+	for _, param := range params {
+		if param[1] == "" {
+			continue
+		}
+		// Some UPI apps read '+' literally, so spaces are always %20
+		query = append(query, param[0]+"="+strings.ReplaceAll(url.QueryEscape(param[1]), "+", "%20"))
+	}
+	return "upi://pay?" + strings.Join(query, "&"), nil
+}
+
+// Swiss builds the payload of a Swiss QR-bill (version 2.0). Payments to a
+// QR-IBAN carry a QR reference derived from the invoice; others carry the
+// invoice in the message.
+func Swiss(account models.BankAccount, p Payment) (string, error) {
+	currency := strings.ToUpper(p.Currency)
+	if currency != "CHF" && currency != "EUR" {
+		return "", fmt.Errorf("Swiss QR-bills are only for CHF and EUR invoices")
+	}
+	iban := NormalizeIBAN(account.IBAN)
+	if !ValidIBAN(iban) || (iban[:2] != "CH" && iban[:2] != "LI") {
+		return "", fmt.Errorf("%w: a Swiss or Liechtenstein IBAN is required", ErrMissingDetails)
+	}
+	name := strings.TrimSpace(account.AccountHolder)
+	if name == "" || account.PostalCode == "" || account.City == "" || len(account.Country) != 2 {
+		return "", fmt.Errorf("%w: the account holder and their postal code, city and country are required", ErrMissingDetails)
+	}
+	if err := checkAmount(p.Amount); err != nil {
+		return "", err
+	}
+
+	referenceType, reference, message := "NON", "", truncate("Invoice "+p.InvoiceID, 140)
+	if IsQRIBAN(iban) {
+		referenceType, reference = "QRR", QRReference(p.InvoiceID)
+	}
+
+	lines := []string{
+		"SPC",
+		"0200",
+		"1", // UTF-8
+		iban,
+		// Creditor, as a structured address
+		"S",
+		truncate(name, 70),
+		truncate(strings.TrimSpace(account.Street), 70),
+		truncate(strings.TrimSpace(account.BuildingNumber), 16),
+		truncate(strings.TrimSpace(account.PostalCode), 16),
+		truncate(strings.TrimSpace(account.City), 35),
+		strings.ToUpper(account.Country),
+		// Ultimate creditor, reserved for future use
+		"", "", "", "", "", "", "",
+		fmt.Sprintf("%.2f", p.Amount),
+		currency,
+		// Ultimate debtor, left for the payer to fill in
+		"", "", "", "", "", "", "",
+		referenceType,
+		reference,
+		message,
+		"EPD",
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// NormalizeIBAN removes spaces and upper-cases an IBAN
+func NormalizeIBAN(iban string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(iban), ""))
+}
+
+// ValidIBAN checks the length and the ISO 13616 mod-97 check digits
+func ValidIBAN(iban string) bool {
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	var digits strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			fmt.Fprintf(&digits, "%d", r-'A'+10)
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// IsQRIBAN reports whether a Swiss IBAN is a QR-IBAN, whose institution ID
+// is in the range 30000-31999
+func IsQRIBAN(iban string) bool {
+	return len(iban) == 21 && iban[4:6] >= "30" && iban[4:6] <= "31"
+}
+
+// QRReference derives the 27 digit QR reference of an invoice from the
+// digits of its ID, ending in a modulo 10 recursive check digit
+func QRReference(invoiceID string) string {
+	var digits []byte
+	for i := 0; i < len(invoiceID); i++ {
+		if invoiceID[i] >= '0' && invoiceID[i] <= '9' {
+			digits = append(digits, invoiceID[i])
+		}
+	}
+	if len(digits) > 26 {
+		digits = digits[len(digits)-26:]
+	}
+	reference := strings.Repeat("0", 26-len(digits)) + string(digits)
+
+	table := [10]int{0, 9, 4, 6, 8, 2, 7, 1, 3, 5}
+	carry := 0
+	for i := 0; i < len(reference); i++ {
+		carry = table[(carry+int(reference[i]-'0'))%10]
+	}
+	return reference + fmt.Sprint((10-carry)%10)
+}
+
+func checkAmount(amount float64) error {
+	if amount < 0.01 || amount > 999999999.99 {
+		return fmt.Errorf("amount must be between 0.01 and 999999999.99")
+	}
+	return nil
+}
+
+// truncate cuts s to at most n characters
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
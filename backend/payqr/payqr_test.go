@@ -0,0 +1,122 @@
+package payqr
+
+import (
+	"billow-backend/models"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidIBAN(t *testing.T) {
+	tests := map[string]bool{
+		"DE89370400440532013000": true,
+		"CH9300762011623852957":  true,
+		"CH4431999123000889012":  true,
+		"DE89370400440532013001": false,
+		"DE8937040044":           false,
+		"DE89-3704-0044-0532-01": false,
+	}
+	for iban, valid := range tests {
+		if got := ValidIBAN(iban); got != valid {
+			t.Errorf("ValidIBAN(%s) = %v, want %v", iban, got, valid)
+		}
+	}
+	if got := NormalizeIBAN(" de89 3704 0044 0532 0130 00 "); got != "DE89370400440532013000" {
+		t.Errorf("NormalizeIBAN = %q", got)
+	}
+}
+
+func TestQRReference(t *testing.T) {
+	// Example reference from the Swiss implementation guidelines
+	if got := QRReference("21000000000313947143000901"); got != "210000000003139471430009017" {
+		t.Errorf("QRReference = %s", got)
+	}
+	if got := QRReference("INV-20241215-143052-123456"); len(got) != 27 || !strings.HasPrefix(got, "00000020241215143052123456") {
+		t.Errorf("QRReference = %s", got)
+	}
+}
+
+func TestEPC(t *testing.T) {
+	account := models.BankAccount{AccountHolder: "Acme GmbH", IBAN: "DE89 3704 0044 0532 0130 00", BIC: "cobadeffxxx"}
+	payload, err := EPC(account, Payment{InvoiceID: "INV-1", Amount: 1234.5, Currency: "EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "BCD\n002\n1\nSCT\nCOBADEFFXXX\nAcme GmbH\nDE89370400440532013000\nEUR1234.50\n\n\nInvoice INV-1"
+	if payload != want {
+		t.Errorf("payload = %q, want %q", payload, want)
+	}
+
+	if _, err := EPC(account, Payment{Amount: 10, Currency: "USD"}); err == nil {
+		t.Error("USD invoices must be rejected")
+	}
+	account.IBAN = ""
+	if _, err := EPC(account, Payment{Amount: 10, Currency: "EUR"}); !errors.Is(err, ErrMissingDetails) {
+		t.Errorf("err = %v, want ErrMissingDetails", err)
+	}
+}
+
+func TestUPI(t *testing.T) {
+	account := models.BankAccount{AccountHolder: "Ravi & Sons", UPIID: "ravi@okbank"}
+	payload, err := UPI(account, Payment{InvoiceID: "INV-1", Amount: 500, Currency: "INR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "upi://pay?pa=ravi%40okbank&pn=Ravi%20%26%20Sons&am=500.00&cu=INR&tn=Invoice%20INV-1&tr=INV-1"
+	if payload != want {
+		t.Errorf("payload = %q, want %q", payload, want)
+	}
+}
+
+func TestSwiss(t *testing.T) {
+	account := models.BankAccount{
+		AccountHolder: "Robert Schneider AG", IBAN: "CH4431999123000889012",
+		Street: "Rue du Lac", BuildingNumber: "1268", PostalCode: "2501", City: "Biel", Country: "ch",
+	}
+	payload, err := Swiss(account, Payment{InvoiceID: "INV-20241215-143052-123456", Amount: 1949.75, Currency: "CHF"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(payload, "\n")
+	if len(lines) != 31 || lines[0] != "SPC" || lines[30] != "EPD" {
+		t.Fatalf("got %d lines: %q", len(lines), payload)
+	}
+	checks := map[int]string{3: "CH4431999123000889012", 4: "S", 5: "Robert Schneider AG", 10: "CH", 18: "1949.75", 19: "CHF", 27: "QRR", 28: QRReference("INV-20241215-143052-123456")}
+	for i, want := range checks {
+		if lines[i] != want {
+			t.Errorf("line %d = %q, want %q", i+1, lines[i], want)
+		}
+	}
+
+	// A regular IBAN carries no reference
+	account.IBAN = "CH9300762011623852957"
+	payload, _ = Swiss(account, Payment{InvoiceID: "INV-1", Amount: 10, Currency: "EUR"})
+	if lines := strings.Split(payload, "\n"); lines[27] != "NON" || lines[28] != "" || lines[29] != "Invoice INV-1" {
+		t.Errorf("unexpected reference lines %q", lines[27:30])
+	}
+
+	account.IBAN = "DE89370400440532013000"
+	if _, err := Swiss(account, Payment{Amount: 10, Currency: "CHF"}); !errors.Is(err, ErrMissingDetails) {
+		t.Errorf("err = %v, want ErrMissingDetails", err)
+	}
+}
+
+func TestCode(t *testing.T) {
+	account := models.BankAccount{
+		AccountHolder: "Robert Schneider AG", IBAN: "CH9300762011623852957",
+		PostalCode: "2501", City: "Biel", Country: "CH",
+	}
+	code, err := Code(SchemeSwiss, account, Payment{InvoiceID: "INV-1", Amount: 10, Currency: "CHF"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !code.Cross || code.Version > 25 {
+		t.Errorf("swiss code: cross %v version %d", code.Cross, code.Version)
+	}
+	if _, err := Code("paypal", account, Payment{}); err != ErrUnknownScheme {
+		t.Errorf("err = %v, want ErrUnknownScheme", err)
+	}
+	if SchemeFor("eur") != SchemeEPC || SchemeFor("INR") != SchemeUPI || SchemeFor("CHF") != SchemeSwiss || SchemeFor("USD") != "" {
+		t.Error("unexpected default schemes")
+	}
+}
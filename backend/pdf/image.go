@@ -0,0 +1,87 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Image is a picture that can be drawn on pages
+type Image struct {
+	Width, Height int
+
+	data       []byte
+	filter     string
+	colorSpace string
+}
+
+// LoadImage reads a PNG or JPEG. RGB and grayscale JPEGs are embedded as they
+// are; anything else is flattened onto white and compressed.
+func LoadImage(data []byte) (*Image, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("pdf: reading image: %w", err)
+	}
+	if format == "jpeg" {
+		switch config.ColorModel {
+		case color.GrayModel:
+			return &Image{Width: config.Width, Height: config.Height, data: data, filter: "DCTDecode", colorSpace: "DeviceGray"}, nil
+		case color.YCbCrModel:
+			return &Image{Width: config.Width, Height: config.Height, data: data, filter: "DCTDecode", colorSpace: "DeviceRGB"}, nil
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("pdf: decoding image: %w", err)
+	}
+	bounds := img.Bounds()
+	raw := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// Colors are premultiplied, so adding the missing alpha as white
+			// composites onto a white page
+			r, g, b, a := img.At(x, y).RGBA()
+			raw = append(raw, byte((r+0xffff-a)>>8), byte((g+0xffff-a)>>8), byte((b+0xffff-a)>>8))
+		}
+	}
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(raw)
+	w.Close()
+	return &Image{Width: bounds.Dx(), Height: bounds.Dy(), data: compressed.Bytes(), filter: "FlateDecode", colorSpace: "DeviceRGB"}, nil
+}
+
+// Image draws img with its top-left corner at (x, y), scaled to w by h
+func (d *Document) Image(img *Image, x, y, w, h float64) {
+	index := -1
+	for i, existing := range d.images {
+		if existing == img {
+			index = i
+		}
+	}
+	if index < 0 {
+		d.images = append(d.images, img)
+		index = len(d.images) - 1
+	}
+	fmt.Fprintf(d.page(), "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", w, h, x, PageHeight-y-h, index+1)
+}
+
+// FitImage returns the size img is drawn at to fit in a w by h box without
+// distorting it
+func FitImage(img *Image, w, h float64) (float64, float64) {
+	scale := w / float64(img.Width)
+	if s := h / float64(img.Height); s < scale {
+		scale = s
+	}
+	return float64(img.Width) * scale, float64(img.Height) * scale
+}
+
+func (img *Image) object() string {
+	return fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8 /Filter /%s /Length %d >>\nstream\n%s\nendstream",
+		img.Width, img.Height, img.colorSpace, img.filter, len(img.data), img.data)
+}
@@ -0,0 +1,247 @@
+package pdf
+
+import (
+	"billow-backend/i18n"
+	"billow-backend/models"
+	"billow-backend/qr"
+	"math"
+	"strings"
+)
+
+// InvoiceOptions are the optional parts of an invoice document
+type InvoiceOptions struct {
+	Business       *models.BusinessProfile // issuer identity, instead of the bare user
+	Logo           *Image
+	Bank           *models.BankAccount // printed as transfer details
+	PaymentQR      *qr.Code            // printed under the total for paying by scan
+	PaymentQRLabel string
+	Locale         string // language and number and date format; English by default
+
+	// White-label accounts restyle the document and drop the Billow footer
+	Family     Family
+	Accent     *Color // headings and rules
+	WhiteLabel bool
+}
+
+// Invoice renders inv, whose Client must be loaded, as issued by issuer
+func Invoice(inv models.Invoice, issuer models.User, opts InvoiceOptions) []byte {
+	d := New()
+	d.Family = opts.Family
+	locale := opts.Locale
+	t := func(msg string, args ...interface{}) string { return i18n.T(locale, msg, args...) }
+	const left, right = 56.0, PageWidth - 56
+
+	// heading draws s, and rule a line, in the accent color
+	heading := func(x, y, size float64, s string) {
+		if opts.Accent != nil {
+			d.SetColor(*opts.Accent)
+			defer d.SetColor(Color{})
+		}
+		d.Text(x, y, size, Bold, s)
+	}
+	rule := func(y float64) {
+		if opts.Accent != nil {
+			d.SetColor(*opts.Accent)
+			defer d.SetColor(Color{})
+		}
+		d.Line(left, y, right, y, 0.5)
+	}
+
+	heading(left, 80, 24, strings.ToUpper(t("Invoice")))
+	if opts.Logo != nil {
+		w, h := FitImage(opts.Logo, 160, 48)
+		d.Image(opts.Logo, right-w, 36, w, h)
+		d.TextRight(right, 100, 10, Regular, inv.DisplayNumber())
+	} else {
+		d.TextRight(right, 80, 10, Regular, inv.DisplayNumber())
+	}
+
+	y := 130.0
+	heading(left, y, 9, strings.ToUpper(t("From")))
+	fy := block(d, left, y+16, issuerLines(issuer, opts.Business, t))
+
+	heading(320, y, 9, strings.ToUpper(t("Bill to")))
+	billTo := append([]string{inv.Client.Name, inv.Client.Company}, inv.Client.BillingAddress.Lines()...)
+	ly := block(d, 320, y+16, append(billTo, inv.Client.Email))
+
+	y = math.Max(240, math.Max(fy, ly)+16)
+	rule(y)
+	details := [][2]string{
+		{t("Invoice date"), i18n.FormatDate(locale, inv.InvoiceDate)},
+		{t("Due date"), i18n.FormatDate(locale, inv.DueDate)},
+		{t("Status"), t(inv.Status)},
+	}
+	for i, row := range details {
+		d.Text(left, y+24+float64(i)*16, 10, Regular, row[0])
+		d.TextRight(right, y+24+float64(i)*16, 10, Regular, row[1])
+	}
+
+	rate := func(tax models.TaxRate) string {
+		decimals := 0
+		if tax.Rate != math.Trunc(tax.Rate) {
+			decimals = 2
+		}
+		return i18n.FormatNumber(locale, tax.Rate, decimals)
+	}
+	taxes := inv.TaxRates.Included(inv.Amount)
+
+	y += 96
+	rule(y)
+	// Taxes added to a net amount are listed above the total, included
+	// taxes below it
+	if !inv.TaxesIncluded() && len(taxes) > 0 {
+		net := inv.Amount
+		for _, tax := range taxes {
+			net -= tax
+		}
+		d.Text(left, y+28, 10, Regular, t("Subtotal"))
+		d.TextRight(right, y+28, 10, Regular, i18n.FormatMoney(locale, net, inv.CurrencyType))
+		for i, tax := range taxes {
+			y += 16
+			d.Text(left, y+28, 10, Regular, t("%s %s%%", inv.TaxRates[i].Label, rate(inv.TaxRates[i])))
+			d.TextRight(right, y+28, 10, Regular, i18n.FormatMoney(locale, tax, inv.CurrencyType))
+		}
+		y += 24
+		taxes = nil
+	}
+	d.Text(left, y+28, 14, Bold, t("Amount due"))
+	d.TextRight(right, y+28, 14, Bold, i18n.FormatMoney(locale, inv.Amount, inv.CurrencyType))
+	for i, tax := range taxes {
+		y += 16
+		d.Text(left, y+28, 9, Regular, t("incl. %s %s%%", inv.TaxRates[i].Label, rate(inv.TaxRates[i])))
+		d.TextRight(right, y+28, 9, Regular, i18n.FormatMoney(locale, tax, inv.CurrencyType))
+	}
+
+	if opts.PaymentQR != nil {
+		// 46mm square, the size Swiss QR-bills prescribe and plenty for the rest
+		y += 64
+		heading(left, y, 9, strings.ToUpper(t("Scan to pay")))
+		if opts.PaymentQRLabel != "" {
+			d.Text(left, y+14, 9, Regular, opts.PaymentQRLabel)
+		}
+		d.QRCode(opts.PaymentQR, left, y+28, 130.4)
+	}
+
+	if bank := opts.Bank; bank != nil {
+		transfer := []string{bank.AccountHolder}
+		if bank.IBAN != "" {
+			transfer = append(transfer, "IBAN: "+groupIBAN(bank.IBAN))
+		}
+		if bank.BIC != "" {
+			transfer = append(transfer, "BIC: "+bank.BIC)
+		}
+		if bank.AccountNumber != "" {
+			transfer = append(transfer, t("Account: %s", bank.AccountNumber))
+		}
+		if bank.RoutingNumber != "" {
+			transfer = append(transfer, t("Routing: %s", bank.RoutingNumber))
+		}
+		if len(transfer) > 1 {
+			if opts.PaymentQR == nil {
+				y += 64
+			}
+			heading(320, y, 9, strings.ToUpper(t("Pay by bank transfer")))
+			block(d, 320, y+16, append(transfer, t("Reference: %s", inv.DisplayNumber())))
+		}
+	}
+
+	// The invoice's own notes go above the business's standing ones
+	var paragraphs []string
+	if inv.Notes != "" {
+		paragraphs = append(paragraphs, strings.Split(inv.Notes, "\n")...)
+	}
+	if opts.Business != nil && opts.Business.FooterNotes != "" {
+		paragraphs = append(paragraphs, strings.Split(opts.Business.FooterNotes, "\n")...)
+	}
+	if len(paragraphs) > 0 {
+		var notes []string
+		for _, paragraph := range paragraphs {
+			notes = append(notes, wrap(d, paragraph, right-left, 8, Regular)...)
+		}
+		ny := PageHeight - 60 - float64(len(notes)-1)*11
+		rule(ny - 16)
+		for i, line := range notes {
+			d.Text(left, ny+float64(i)*11, 8, Regular, line)
+		}
+	}
+
+	if !opts.WhiteLabel {
+		d.TextRight(right, PageHeight-28, 7, Regular, t("Created with Billow"))
+	}
+
+	return d.Bytes()
+}
+
+// issuerLines are the name, address and contact details documents are issued
+// under: the business's when there is one, else the user's
+func issuerLines(issuer models.User, b *models.BusinessProfile, t func(string, ...interface{}) string) []string {
+	from := []string{issuerName(issuer), issuer.Email}
+	if b != nil {
+		from = []string{b.DisplayName()}
+		if b.TradingName != "" && b.LegalName != "" && b.LegalName != b.TradingName {
+			from = append(from, b.LegalName)
+		}
+		from = append(from, b.Address.Lines()...)
+		email := b.Email
+		if email == "" {
+			email = issuer.Email
+		}
+		from = append(from, email, b.Phone)
+		if b.TaxNumber != "" {
+			from = append(from, t("Tax ID: %s", b.TaxNumber))
+		}
+		if b.RegistrationNumber != "" {
+			from = append(from, t("Reg. no.: %s", b.RegistrationNumber))
+		}
+	}
+	return from
+}
+
+// block draws the non-empty lines one under another and returns the baseline
+// of the last one
+func block(d *Document, x, y float64, lines []string) float64 {
+	last := y
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		d.Text(x, y, 10, Regular, line)
+		last = y
+		y += 14
+	}
+	return last
+}
+
+// wrap breaks s into lines no wider than width
+func wrap(d *Document, s string, width, size float64, font Font) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && d.Width(line+" "+word, size, font) > width {
+			lines = append(lines, line)
+			line = word
+		} else if line != "" {
+			line += " " + word
+		} else {
+			line = word
+		}
+	}
+	return append(lines, line)
+}
+
+// groupIBAN prints an IBAN in blocks of four, the way it is read out
+func groupIBAN(iban string) string {
+	var groups []string
+	for len(iban) > 4 {
+		groups = append(groups, iban[:4])
+		iban = iban[4:]
+	}
+	return strings.Join(append(groups, iban), " ")
+}
+
+func issuerName(user models.User) string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	return user.Email
+}
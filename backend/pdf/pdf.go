@@ -0,0 +1,234 @@
+// Package pdf writes simple PDF documents: text in the standard PDF fonts,
+// lines and images on A4 pages. It covers what invoices and statements need
+// without pulling in a layout engine.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Font selects the regular or bold weight of the document's family
+type Font string
+
+const (
+	Regular Font = "F1"
+	Bold    Font = "F2"
+)
+
+// Family is one of the standard PDF typefaces, which need no embedding
+type Family int
+
+const (
+	Helvetica Family = iota
+	Times
+	Courier
+)
+
+var baseFonts = map[Family][2]string{
+	Helvetica: {"Helvetica", "Helvetica-Bold"},
+	Times:     {"Times-Roman", "Times-Bold"},
+	Courier:   {"Courier", "Courier-Bold"},
+}
+
+// Color is an RGB fill and stroke color
+type Color struct {
+	R, G, B uint8
+}
+
+// ParseColor reads a #rrggbb hex color
+func ParseColor(s string) (Color, bool) {
+	var c Color
+	if len(s) != 7 || s[0] != '#' {
+		return c, false
+	}
+	if _, err := fmt.Sscanf(s[1:], "%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return c, false
+	}
+	return c, true
+}
+
+// Document is a PDF being built page by page. Coordinates are in points from
+// the top-left corner of the page.
+type Document struct {
+	Family Family // typeface of the whole document, Helvetica by default
+
+	pages  []*bytes.Buffer
+	images []*Image
+}
+
+// New returns a document with one empty page
+func New() *Document {
+	d := &Document{}
+	d.AddPage()
+	return d
+}
+
+// AddPage starts a new page; later drawing goes onto it
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *Document) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// Text draws s with its baseline at (x, y)
+func (d *Document) Text(x, y, size float64, font Font, s string) {
+	fmt.Fprintf(d.page(), "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, PageHeight-y, escape(s))
+}
+
+// TextRight draws s so that it ends at x
+func (d *Document) TextRight(x, y, size float64, font Font, s string) {
+	d.Text(x-d.Width(s, size, font), y, size, font, s)
+}
+
+// SetColor changes the color of everything drawn after it on the page
+func (d *Document) SetColor(c Color) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	fmt.Fprintf(d.page(), "%.3f %.3f %.3f rg %.3f %.3f %.3f RG\n", r, g, b, r, g, b)
+}
+
+// Line draws a straight line
+func (d *Document) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(d.page(), "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// Rect fills a rectangle, black when dark and white otherwise
+func (d *Document) Rect(x, y, w, h float64, dark bool) {
+	gray := 1
+	if dark {
+		gray = 0
+	}
+	fmt.Fprintf(d.page(), "%d g %.2f %.2f %.2f %.2f re f 0 g\n", gray, x, PageHeight-y-h, w, h)
+}
+
+// Bytes serializes the document
+func (d *Document) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-4 are fixed; each page then takes a page and a content
+	// object, and the images follow
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	xobjects := ""
+	if len(d.images) > 0 {
+		refs := make([]string, len(d.images))
+		for i := range d.images {
+			refs[i] = fmt.Sprintf("/Im%d %d 0 R", i+1, 5+2*len(d.pages)+i)
+		}
+		xobjects = " /XObject << " + strings.Join(refs, " ") + " >>"
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	fonts := baseFonts[d.Family]
+	object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", fonts[0]))
+	object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", fonts[1]))
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >>%s >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, xobjects, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+	for _, img := range d.images {
+		object(img.object())
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// winAnsi are the codes of characters WinAnsi places outside Latin-1
+var winAnsi = map[rune]byte{'€': 0x80, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97}
+
+// escape encodes s as a WinAnsi PDF string literal body. Characters the
+// encoding lacks are replaced with '?'.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case winAnsi[r] != 0:
+			fmt.Fprintf(&b, `\%03o`, winAnsi[r])
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, `\%03o`, r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// metrics are character widths in thousandths of the font size
+type metrics struct {
+	digit, punctuation, dash, upper, narrow, lower int
+}
+
+var familyMetrics = map[Family]metrics{
+	Helvetica: {digit: 556, punctuation: 278, dash: 333, upper: 667, narrow: 222, lower: 556},
+	Times:     {digit: 500, punctuation: 250, dash: 333, upper: 667, narrow: 278, lower: 444},
+	Courier:   {digit: 600, punctuation: 600, dash: 600, upper: 600, narrow: 600, lower: 600},
+}
+
+// Width estimates the width of s in points in Helvetica. Digits and
+// punctuation use the exact metrics so right-aligned amounts line up; letters
+// use averages.
+func Width(s string, size float64, font Font) float64 {
+	return measure(familyMetrics[Helvetica], s, size, font)
+}
+
+// Width estimates the width of s in points in the document's family
+func (d *Document) Width(s string, size float64, font Font) float64 {
+	return measure(familyMetrics[d.Family], s, size, font)
+}
+
+func measure(m metrics, s string, size float64, font Font) float64 {
+	units, letters := 0, 0
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			units += m.digit
+		case r == ' ' || r == '.' || r == ',' || r == ':' || r == '/':
+			units += m.punctuation
+		case r == '-':
+			units += m.dash
+		case r >= 'A' && r <= 'Z':
+			letters += m.upper
+		case r == 'i' || r == 'j' || r == 'l':
+			letters += m.narrow
+		default:
+			letters += m.lower
+		}
+	}
+	// Courier is monospaced in both weights
+	if font == Bold && m.lower != m.digit {
+		letters = letters * 11 / 10
+	}
+	return float64(units+letters) * size / 1000
+}
@@ -0,0 +1,195 @@
+package pdf
+
+import (
+	"billow-backend/models"
+	"billow-backend/qr"
+	"billow-backend/statement"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestDocumentCrossReferences(t *testing.T) {
+	d := New()
+	d.Text(50, 50, 12, Regular, "Page (one) \\ café €")
+	d.AddPage()
+	d.Text(50, 50, 12, Bold, "Page two")
+	out := d.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatal("missing PDF header or trailer")
+	}
+	if !bytes.Contains(out, []byte(`(Page \(one\) \\ caf\351 \200) Tj`)) {
+		t.Error("text was not escaped to WinAnsi")
+	}
+
+	// Every xref entry must point at the start of its object
+	entries := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(out, -1)
+	if len(entries) != 8 {
+		t.Fatalf("got %d objects, want 8", len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if want := strconv.Itoa(i+1) + " 0 obj"; !bytes.HasPrefix(out[offset:], []byte(want)) {
+			t.Errorf("xref entry %d doesn't point at %q", i+1, want)
+		}
+	}
+}
+
+func TestInvoice(t *testing.T) {
+	client := models.Client{Name: "Acme", BillingAddress: models.Address{Line1: "1 Market Street", PostalCode: "94105", City: "San Francisco", Country: "US"}}
+	inv := models.Invoice{ID: "INV-1", Amount: 1234.5, CurrencyType: "EUR", Client: client, TaxRates: models.TaxRates{{Label: "VAT", Rate: 20}}, Notes: "Thanks!"}
+	out := Invoice(inv, models.User{DisplayName: "Jane Doe"}, InvoiceOptions{})
+	for _, want := range []string{"(INV-1)", "(Acme)", "(Jane Doe)", `(\2001,234.50)`, "(1 Market Street)", "(94105 San Francisco)", "(incl. VAT 20%)", `(\200205.75)`, "(Thanks!)"} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("invoice is missing %s", want)
+		}
+	}
+}
+
+func TestInvoiceTaxAdded(t *testing.T) {
+	exclusive := false
+	inv := models.Invoice{ID: "INV-1", Amount: 1200, CurrencyType: "EUR", TaxRates: models.TaxRates{{Label: "VAT", Rate: 20}}, TaxInclusive: &exclusive}
+	out := Invoice(inv, models.User{}, InvoiceOptions{})
+	for _, want := range []string{"(Subtotal)", `(\2001,000.00)`, "(VAT 20%)", `(\200200.00)`, `(\2001,200.00)`} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("invoice is missing %s", want)
+		}
+	}
+	if bytes.Contains(out, []byte("incl.")) {
+		t.Error("added tax is shown as included")
+	}
+}
+
+func TestInvoiceLocale(t *testing.T) {
+	inv := models.Invoice{ID: "INV-1", Amount: 1234.5, CurrencyType: "EUR", InvoiceDate: "2024-03-01", DueDate: "2024-03-31", Status: "unpaid"}
+	out := Invoice(inv, models.User{}, InvoiceOptions{Locale: "de"})
+	for _, want := range []string{"(RECHNUNG)", "(Rechnungsdatum)", "(01.03.2024)", "(offen)", `(F\344lliger Betrag)`, `(1.234,50 \200)`} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("invoice is missing %s", want)
+		}
+	}
+}
+
+func TestStatement(t *testing.T) {
+	a := statement.Account{
+		From:    "2024-03-01",
+		To:      "2024-03-31",
+		Opening: []statement.Balance{{Currency: "EUR", Outstanding: 100}},
+		Entries: []statement.Entry{
+			{Date: "2024-03-05", Kind: statement.EntryInvoice, Number: "A-0002", Currency: "EUR", Amount: 250, Balance: 350},
+			{Date: "2024-03-20", Kind: statement.EntryPayment, Number: "A-0001", Currency: "EUR", Paid: 100, Balance: 250},
+		},
+		Closing: []statement.Balance{{Currency: "EUR", Outstanding: 250, Overdue: 250}},
+	}
+	out := Statement(a, models.Client{Name: "Acme"}, models.User{DisplayName: "Jane Doe"}, InvoiceOptions{Locale: "de"})
+	for _, want := range []string{"(KONTOAUSZUG)", `(01.03.2024 \226 31.03.2024)`, "(Anfangssaldo)", "(Rechnung A-0002)", "(Zahlung zu A-0001)", `(250,00 \200)`, `(\334berf\344llig)`} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("statement is missing %s", want)
+		}
+	}
+
+	// Long statements continue on further pages
+	for i := 0; i < 40; i++ {
+		a.Entries = append(a.Entries, a.Entries[0])
+	}
+	if out := Statement(a, models.Client{}, models.User{}, InvoiceOptions{}); !bytes.Contains(out, []byte("/Count 2")) {
+		t.Error("expected the statement to run over two pages")
+	}
+}
+
+func TestInvoicePaymentQR(t *testing.T) {
+	code, err := qr.Encode([]byte("BCD"), qr.M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := models.Invoice{ID: "INV-1", Amount: 10, CurrencyType: "EUR"}
+	out := Invoice(inv, models.User{}, InvoiceOptions{PaymentQR: code, PaymentQRLabel: "SEPA transfer"})
+	if !bytes.Contains(out, []byte("(SCAN TO PAY)")) || !bytes.Contains(out, []byte("(SEPA transfer)")) {
+		t.Error("payment QR label is missing")
+	}
+	if !bytes.Contains(out, []byte(" re f 0 g")) {
+		t.Error("payment QR modules are missing")
+	}
+}
+
+func TestInvoiceBusinessProfile(t *testing.T) {
+	inv := models.Invoice{ID: "INV-1", Number: "DC-0007", Amount: 10, CurrencyType: "EUR"}
+	business := &models.BusinessProfile{
+		LegalName: "Doe Consulting GmbH", TradingName: "Doe Consulting", TaxNumber: "DE123456789",
+		Address:     models.Address{Line1: "Hauptstr. 1", PostalCode: "10115", City: "Berlin", Country: "DE"},
+		FooterNotes: "Thank you for your business",
+	}
+	bank := &models.BankAccount{AccountHolder: "Doe Consulting GmbH", IBAN: "DE89370400440532013000"}
+	out := Invoice(inv, models.User{DisplayName: "Jane Doe"}, InvoiceOptions{Business: business, Bank: bank})
+	for _, want := range []string{"(Doe Consulting)", "(Doe Consulting GmbH)", "(10115 Berlin)", "(Tax ID: DE123456789)",
+		"(DC-0007)", "(IBAN: DE89 3704 0044 0532 0130 00)", "(Reference: DC-0007)", "(Thank you for your business)"} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("invoice is missing %s", want)
+		}
+	}
+	if bytes.Contains(out, []byte("(Jane Doe)")) {
+		t.Error("the business profile should replace the user's name")
+	}
+}
+
+func TestInvoiceWhiteLabel(t *testing.T) {
+	inv := models.Invoice{ID: "INV-1", Amount: 10, CurrencyType: "EUR"}
+	if out := Invoice(inv, models.User{}, InvoiceOptions{}); !bytes.Contains(out, []byte("(Created with Billow)")) {
+		t.Error("invoice is missing the Billow footer")
+	}
+
+	accent, ok := ParseColor("#1A73E8")
+	if !ok || accent != (Color{0x1a, 0x73, 0xe8}) {
+		t.Fatalf("ParseColor = %v, %v", accent, ok)
+	}
+	out := Invoice(inv, models.User{}, InvoiceOptions{WhiteLabel: true, Family: Times, Accent: &accent})
+	if bytes.Contains(out, []byte("Billow")) {
+		t.Error("white-labelled invoice mentions Billow")
+	}
+	if !bytes.Contains(out, []byte("/BaseFont /Times-Roman")) || !bytes.Contains(out, []byte("/BaseFont /Times-Bold")) {
+		t.Error("invoice doesn't use the brand font")
+	}
+	if !bytes.Contains(out, []byte("0.102 0.451 0.910 rg")) {
+		t.Error("invoice doesn't use the brand color")
+	}
+
+	for _, bad := range []string{"", "1a73e8", "#1a73e", "#gggggg"} {
+		if _, ok := ParseColor(bad); ok {
+			t.Errorf("ParseColor(%q) should fail", bad)
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	logo, err := LoadImage(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := FitImage(logo, 100, 100); w != 100 || h != 50 {
+		t.Errorf("FitImage = %vx%v, want 100x50", w, h)
+	}
+
+	d := New()
+	d.Image(logo, 10, 10, 40, 20)
+	d.Image(logo, 60, 10, 40, 20)
+	out := d.Bytes()
+	if !bytes.Contains(out, []byte("/XObject << /Im1 7 0 R >>")) || bytes.Contains(out, []byte("/Im2")) {
+		t.Error("image should be stored once and referenced from the page")
+	}
+	if !bytes.Contains(out, []byte("7 0 obj\n<< /Type /XObject /Subtype /Image /Width 4 /Height 2")) {
+		t.Error("missing image object")
+	}
+
+	if _, err := LoadImage([]byte("not an image")); err == nil {
+		t.Error("expected an error for invalid data")
+	}
+}
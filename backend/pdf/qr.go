@@ -0,0 +1,25 @@
+package pdf
+
+import "billow-backend/qr"
+
+// QRCode draws code with its top-left corner at (x, y), size points across
+// without the quiet zone. Callers leave the quiet zone blank around it.
+func (d *Document) QRCode(code *qr.Code, x, y, size float64) {
+	module := size / float64(code.Size)
+	for row := 0; row < code.Size; row++ {
+		for col := 0; col < code.Size; col++ {
+			if !code.Modules[row][col] {
+				continue
+			}
+			run := 1
+			for col+run < code.Size && code.Modules[row][col+run] {
+				run++
+			}
+			d.Rect(x+float64(col)*module, y+float64(row)*module, float64(run)*module, module, true)
+			col += run - 1
+		}
+	}
+	for _, r := range code.Overlay() {
+		d.Rect(x+r.X*module, y+r.Y*module, r.W*module, r.H*module, r.Dark)
+	}
+}
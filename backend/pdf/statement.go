@@ -0,0 +1,126 @@
+package pdf
+
+import (
+	"billow-backend/i18n"
+	"billow-backend/models"
+	"billow-backend/statement"
+	"math"
+	"strings"
+)
+
+// Statement renders the statement of account a of client as issued by
+// issuer. Of the options, the bank details and payment QR code are not used.
+func Statement(a statement.Account, client models.Client, issuer models.User, opts InvoiceOptions) []byte {
+	d := New()
+	d.Family = opts.Family
+	locale := opts.Locale
+	t := func(msg string, args ...interface{}) string { return i18n.T(locale, msg, args...) }
+	date := func(value string) string { return i18n.FormatDate(locale, value) }
+	const left, right = 56.0, PageWidth - 56
+
+	heading := func(x, y, size float64, s string) {
+		if opts.Accent != nil {
+			d.SetColor(*opts.Accent)
+			defer d.SetColor(Color{})
+		}
+		d.Text(x, y, size, Bold, s)
+	}
+	rule := func(y float64) {
+		if opts.Accent != nil {
+			d.SetColor(*opts.Accent)
+			defer d.SetColor(Color{})
+		}
+		d.Line(left, y, right, y, 0.5)
+	}
+
+	period := t("All activity")
+	switch {
+	case a.From != "" && a.To != "":
+		period = date(a.From) + " – " + date(a.To)
+	case a.From != "":
+		period = t("From %s", date(a.From))
+	case a.To != "":
+		period = t("Until %s", date(a.To))
+	}
+
+	heading(left, 80, 24, strings.ToUpper(t("Statement")))
+	if opts.Logo != nil {
+		w, h := FitImage(opts.Logo, 160, 48)
+		d.Image(opts.Logo, right-w, 36, w, h)
+		d.TextRight(right, 100, 10, Regular, period)
+	} else {
+		d.TextRight(right, 80, 10, Regular, period)
+	}
+
+	y := 130.0
+	heading(left, y, 9, strings.ToUpper(t("From")))
+	fy := block(d, left, y+16, issuerLines(issuer, opts.Business, t))
+	heading(320, y, 9, strings.ToUpper(t("Bill to")))
+	ly := block(d, 320, y+16, append([]string{client.Name, client.Company}, client.BillingAddress.Lines()...))
+	y = math.Max(240, math.Max(fy, ly)+16)
+
+	// Columns: date, description, charges, payments and balance
+	columns := [...]float64{left, left + 72, right - 180, right - 90, right}
+	header := func(y float64) {
+		rule(y)
+		d.Text(columns[0], y+16, 9, Bold, t("Date"))
+		d.Text(columns[1], y+16, 9, Bold, t("Description"))
+		d.TextRight(columns[2], y+16, 9, Bold, t("Charges"))
+		d.TextRight(columns[3], y+16, 9, Bold, t("Payments"))
+		d.TextRight(columns[4], y+16, 9, Bold, t("Balance"))
+		rule(y + 24)
+	}
+	// next moves down a row, continuing on a new page when this one is full
+	next := func(y float64) float64 {
+		if y+16 > PageHeight-72 {
+			d.AddPage()
+			header(56)
+			return 56 + 40
+		}
+		return y + 16
+	}
+
+	y += 8
+	for _, b := range a.Opening {
+		d.Text(left, y, 10, Regular, t("Opening balance"))
+		d.TextRight(right, y, 10, Regular, i18n.FormatMoney(locale, b.Outstanding, b.Currency))
+		y += 16
+	}
+
+	header(y)
+	y += 40
+	if len(a.Entries) == 0 {
+		d.Text(columns[1], y, 9, Regular, t("No invoices or payments in this period"))
+		y += 16
+	}
+	for _, e := range a.Entries {
+		d.Text(columns[0], y, 9, Regular, date(e.Date))
+		if e.Kind == statement.EntryInvoice {
+			d.Text(columns[1], y, 9, Regular, t("Invoice %s", e.Number))
+			d.TextRight(columns[2], y, 9, Regular, i18n.FormatMoney(locale, e.Amount, e.Currency))
+		} else {
+			d.Text(columns[1], y, 9, Regular, t("Payment for %s", e.Number))
+			d.TextRight(columns[3], y, 9, Regular, i18n.FormatMoney(locale, e.Paid, e.Currency))
+		}
+		d.TextRight(columns[4], y, 9, Regular, i18n.FormatMoney(locale, e.Balance, e.Currency))
+		y = next(y)
+	}
+
+	rule(y - 8)
+	for _, b := range a.Closing {
+		y = next(y + 8)
+		d.Text(left, y, 12, Bold, t("Closing balance"))
+		d.TextRight(right, y, 12, Bold, i18n.FormatMoney(locale, b.Outstanding, b.Currency))
+		if b.Overdue > 0 {
+			y = next(y)
+			d.Text(left, y, 10, Regular, t("Overdue"))
+			d.TextRight(right, y, 10, Regular, i18n.FormatMoney(locale, b.Overdue, b.Currency))
+		}
+	}
+
+	if !opts.WhiteLabel {
+		d.TextRight(right, PageHeight-28, 7, Regular, t("Created with Billow"))
+	}
+
+	return d.Bytes()
+}
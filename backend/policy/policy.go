@@ -0,0 +1,250 @@
+package policy
+
+import (
+	"billow-backend/entitlements"
+	"billow-backend/models"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Action identifies an operation a subject wants to perform
+type Action string
+
+const (
+	ClientCreate    Action = "client:create"
+	ClientRead      Action = "client:read"
+	ClientUpdate    Action = "client:update"
+	ClientDelete    Action = "client:delete"
+	ClientStatement Action = "client:statement" // email the client their statement of account
+
+	InvoiceCreate  Action = "invoice:create"
+	InvoiceRead    Action = "invoice:read"
+	InvoiceUpdate  Action = "invoice:update"
+	InvoiceDelete  Action = "invoice:delete"
+	InvoiceShare   Action = "invoice:share"
+	InvoiceCollect Action = "invoice:collect" // request online payment
+	InvoiceRemind  Action = "invoice:remind"  // email the client a payment reminder
+
+	DashboardRead     Action = "dashboard:read"
+	AnalyticsRead     Action = "analytics:read"
+	AnalyticsAdvanced Action = "analytics:advanced"
+
+	ProfileRead       Action = "profile:read"
+	ProfileUpdate     Action = "profile:update"
+	PreferencesRead   Action = "preferences:read"
+	PreferencesUpdate Action = "preferences:update"
+	AuditLogRead      Action = "audit_log:read"
+	BrandingManage    Action = "branding:manage" // white-label look of invoices, emails and the portal
+	TemplatesManage   Action = "templates:manage"
+
+	SubscriptionRead   Action = "subscription:read"
+	SubscriptionChange Action = "subscription:change"
+	PlansRead          Action = "plans:read"
+	PlansManage        Action = "plans:manage"
+	CouponsManage      Action = "coupons:manage"
+	EntitlementsManage Action = "entitlements:manage"
+
+	PortalRead   Action = "portal:read"   // a client viewing their own invoices and balances
+	PortalUpdate Action = "portal:update" // a client changing their own billing details
+)
+
+// Roles a user can hold
+const (
+	RoleOwner  = "owner"  // account holder, full access to their own tenant
+	RoleAdmin  = "admin"  // Billow staff, may additionally manage platform data
+	RoleClient = "client" // a tenant's client signed in to the client portal
+)
+
+// Reason explains why a decision was denied
+type Reason string
+
+const (
+	ReasonUnauthenticated      Reason = "unauthenticated"
+	ReasonUnknownAction        Reason = "unknown_action"
+	ReasonRoleForbidden        Reason = "role_forbidden"
+	ReasonSubscriptionInactive Reason = "subscription_inactive"
+	ReasonPlanUpgradeRequired  Reason = "plan_upgrade_required"
+	ReasonNotOwner             Reason = "not_owner"
+)
+
+// Subject is the authenticated party performing an action
+type Subject struct {
+	UserID       string
+	Role         string
+	Subscription *models.Subscription // nil when the user has no subscription
+	ClientID     string               // set for portal sessions, which only reach this client
+
+	// Entitlements resolved for the request. When nil only the subscription's
+	// plan is considered.
+	Entitlements *entitlements.Entitlements
+}
+
+// Resource is the tenant-owned record an action targets
+type Resource struct {
+	OwnerID  string
+	ClientID string // the tenant's client the record belongs to, if any
+}
+
+// Owned is implemented by models that belong to a single tenant
+type Owned interface {
+	OwnerID() string
+}
+
+// Decision is the outcome of an authorization check
+type Decision struct {
+	Allowed bool   `json:"-"`
+	Action  Action `json:"action"`
+	Reason  Reason `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	Feature string `json:"feature,omitempty"`
+}
+
+// Err converts a denied decision into an error the fiber error handler
+// renders as a structured 403, or nil when the decision allows the action.
+func (d Decision) Err() error {
+	if d.Allowed {
+		return nil
+	}
+	return &DeniedError{Decision: d}
+}
+
+// DeniedError is returned by handlers when the policy engine denies an action
+type DeniedError struct {
+	Decision Decision
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("%s denied: %s", e.Decision.Action, e.Decision.Reason)
+}
+
+// Engine evaluates role permissions, plan entitlements and resource ownership
+type Engine struct {
+	roles    map[string]map[Action]bool
+	features map[Action]string
+}
+
+// tenantActions are the actions every account holder may perform on their own data
+var tenantActions = []Action{
+	ClientCreate, ClientRead, ClientUpdate, ClientDelete, ClientStatement,
+	InvoiceCreate, InvoiceRead, InvoiceUpdate, InvoiceDelete, InvoiceShare, InvoiceCollect, InvoiceRemind,
+	DashboardRead, AnalyticsRead, AnalyticsAdvanced,
+	ProfileRead, ProfileUpdate, PreferencesRead, PreferencesUpdate, AuditLogRead, BrandingManage, TemplatesManage,
+	SubscriptionRead, SubscriptionChange, PlansRead,
+}
+
+// NewEngine returns an engine with Billow's default rules
+func NewEngine() *Engine {
+	e := &Engine{
+		roles: map[string]map[Action]bool{
+			RoleOwner:  {},
+			RoleAdmin:  {PlansManage: true, CouponsManage: true, EntitlementsManage: true},
+			RoleClient: {PortalRead: true, PortalUpdate: true},
+		},
+		features: map[Action]string{
+			AnalyticsAdvanced: "advanced_analytics",
+			BrandingManage:    "white_label",
+		},
+	}
+	for _, role := range []string{RoleOwner, RoleAdmin} {
+		for _, action := range tenantActions {
+			e.roles[role][action] = true
+		}
+	}
+	return e
+}
+
+// Default is the engine used by the HTTP handlers
+var Default = NewEngine()
+
+// Actions returns every action known to the engine
+func (e *Engine) Actions() []Action {
+	seen := map[Action]bool{}
+	var actions []Action
+	for _, perms := range e.roles {
+		for action := range perms {
+			if !seen[action] {
+				seen[action] = true
+				actions = append(actions, action)
+			}
+		}
+	}
+	return actions
+}
+
+// Authorize decides whether sub may perform action on res. res may be nil for
+// actions that do not target a tenant-owned record.
+func (e *Engine) Authorize(sub Subject, action Action, res *Resource) Decision {
+	d := Decision{Action: action}
+
+	if sub.UserID == "" {
+		d.Reason = ReasonUnauthenticated
+		d.Message = "Authentication required"
+		return d
+	}
+
+	known := false
+	for _, perms := range e.roles {
+		if perms[action] {
+			known = true
+			break
+		}
+	}
+	if !known {
+		d.Reason = ReasonUnknownAction
+		d.Message = "Unknown action"
+		return d
+	}
+
+	role := sub.Role
+	if role == "" {
+		role = RoleOwner
+	}
+	if !e.roles[role][action] {
+		d.Reason = ReasonRoleForbidden
+		d.Message = "Your role does not permit this action"
+		return d
+	}
+
+	if feature, ok := e.features[action]; ok {
+		d.Feature = feature
+		ents := sub.Entitlements
+		if ents == nil {
+			ents = entitlements.Resolve(sub.Subscription, nil, nil, time.Now())
+		}
+		if !ents.Has(feature) {
+			if sub.Subscription == nil || !sub.Subscription.HasAccess(time.Now()) {
+				d.Reason = ReasonSubscriptionInactive
+				d.Message = "An active subscription is required"
+				return d
+			}
+			d.Reason = ReasonPlanUpgradeRequired
+			d.Message = "This feature is not available in your plan"
+			return d
+		}
+	}
+
+	if res != nil && res.OwnerID != sub.UserID {
+		d.Reason = ReasonNotOwner
+		d.Message = "Resource belongs to another account"
+		return d
+	}
+
+	// Portal sessions are confined to their client within the tenant
+	if role == RoleClient && (sub.ClientID == "" || (res != nil && res.ClientID != sub.ClientID)) {
+		d.Reason = ReasonNotOwner
+		d.Message = "Resource belongs to another client"
+		return d
+	}
+
+	d.Allowed = true
+	return d
+}
+
+// OwnedBy scopes a query to rows belonging to the subject's tenant
+func OwnedBy(sub Subject) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ?", sub.UserID)
+	}
+}
@@ -0,0 +1,91 @@
+package policy
+
+import (
+	"billow-backend/entitlements"
+	"billow-backend/models"
+	"testing"
+)
+
+func subscribed(planID string, status string, plan models.Plan) *models.Subscription {
+	plan.ID = planID
+	return &models.Subscription{PlanID: planID, Status: status, Plan: plan}
+}
+
+func TestAuthorize(t *testing.T) {
+	starter := subscribed("PLN-STARTER", "active", models.Plan{})
+	pro := subscribed("PLN-PRO", "active", models.Plan{AdvancedAnalytics: true})
+	canceledPro := subscribed("PLN-PRO", "canceled", models.Plan{AdvancedAnalytics: true})
+
+	alice := Subject{UserID: "USR-A", Role: RoleOwner, Subscription: starter}
+	bob := &Resource{OwnerID: "USR-B"}
+	own := &Resource{OwnerID: "USR-A"}
+	portal := Subject{UserID: "USR-A", Role: RoleClient, ClientID: "CLT-1"}
+
+	tests := []struct {
+		name    string
+		subject Subject
+		action  Action
+		res     *Resource
+		allowed bool
+		reason  Reason
+	}{
+		{"owner reads own client", alice, ClientRead, own, true, ""},
+		{"owner updates own invoice", alice, InvoiceUpdate, own, true, ""},
+		{"owner shares own invoice", alice, InvoiceShare, own, true, ""},
+		{"owner cannot share another tenant's invoice", alice, InvoiceShare, bob, false, ReasonNotOwner},
+		{"empty role defaults to owner", Subject{UserID: "USR-A"}, InvoiceRead, own, true, ""},
+		{"anonymous is rejected", Subject{}, ClientRead, own, false, ReasonUnauthenticated},
+		{"unknown action is rejected", alice, Action("client:export"), own, false, ReasonUnknownAction},
+		{"unknown role is rejected", Subject{UserID: "USR-A", Role: "guest"}, ClientRead, own, false, ReasonRoleForbidden},
+		{"owner cannot manage plans", alice, PlansManage, nil, false, ReasonRoleForbidden},
+		{"admin can manage plans", Subject{UserID: "USR-A", Role: RoleAdmin}, PlansManage, nil, true, ""},
+		{"owner cannot manage coupons", alice, CouponsManage, nil, false, ReasonRoleForbidden},
+		{"owner cannot manage entitlements", alice, EntitlementsManage, nil, false, ReasonRoleForbidden},
+		{"starter lacks advanced analytics", alice, AnalyticsAdvanced, nil, false, ReasonPlanUpgradeRequired},
+		{"pro has advanced analytics", Subject{UserID: "USR-A", Subscription: pro}, AnalyticsAdvanced, nil, true, ""},
+		{"canceled plan loses features", Subject{UserID: "USR-A", Subscription: canceledPro}, AnalyticsAdvanced, nil, false, ReasonSubscriptionInactive},
+		{"no subscription loses features", Subject{UserID: "USR-A"}, AnalyticsAdvanced, nil, false, ReasonSubscriptionInactive},
+		{"starter cannot white-label", alice, BrandingManage, nil, false, ReasonPlanUpgradeRequired},
+		{"white-label plan manages branding", Subject{UserID: "USR-A", Subscription: subscribed("PLN-BIZ", "active", models.Plan{WhiteLabel: true})}, BrandingManage, nil, true, ""},
+		{"override grants a feature without a subscription", Subject{UserID: "USR-A", Entitlements: &entitlements.Entitlements{
+			Features: map[string]entitlements.Feature{"advanced_analytics": {Enabled: true, Source: entitlements.SourceOverride}},
+		}}, AnalyticsAdvanced, nil, true, ""},
+		{"portal client reads their invoice", portal, PortalRead, &Resource{OwnerID: "USR-A", ClientID: "CLT-1"}, true, ""},
+		{"portal client cannot reach a sibling client", portal, PortalRead, &Resource{OwnerID: "USR-A", ClientID: "CLT-2"}, false, ReasonNotOwner},
+		{"portal client cannot reach another tenant", portal, PortalUpdate, &Resource{OwnerID: "USR-B", ClientID: "CLT-1"}, false, ReasonNotOwner},
+		{"portal session without a client is rejected", Subject{UserID: "USR-A", Role: RoleClient}, PortalRead, nil, false, ReasonNotOwner},
+		{"portal client cannot use the tenant API", portal, InvoiceRead, own, false, ReasonRoleForbidden},
+		{"owner has no portal session", alice, PortalRead, nil, false, ReasonRoleForbidden},
+		{"admin cannot read another tenant", Subject{UserID: "USR-A", Role: RoleAdmin}, ClientRead, bob, false, ReasonNotOwner},
+	}
+
+	engine := NewEngine()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := engine.Authorize(tt.subject, tt.action, tt.res)
+			if d.Allowed != tt.allowed || d.Reason != tt.reason {
+				t.Fatalf("got allowed=%v reason=%q, want allowed=%v reason=%q", d.Allowed, d.Reason, tt.allowed, tt.reason)
+			}
+			if (d.Err() == nil) != tt.allowed {
+				t.Fatalf("Err() = %v, want nil only when allowed", d.Err())
+			}
+		})
+	}
+}
+
+// Every action known to the engine must refuse a record owned by another
+// tenant, whatever the subject's role or plan.
+func TestAuthorizeNeverCrossesTenants(t *testing.T) {
+	engine := NewEngine()
+	business := subscribed("PLN-BUSINESS", "active", models.Plan{AdvancedAnalytics: true, APIAccess: true, WhiteLabel: true})
+
+	for _, role := range []string{RoleOwner, RoleAdmin, RoleClient} {
+		sub := Subject{UserID: "USR-A", Role: role, Subscription: business, ClientID: "CLT-1"}
+		for _, action := range engine.Actions() {
+			d := engine.Authorize(sub, action, &Resource{OwnerID: "USR-B"})
+			if d.Allowed {
+				t.Errorf("%s was allowed %s on another tenant's record", role, action)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Package portal signs clients in to the client portal with magic links and
+// keeps their sessions
+package portal
+
+import (
+	"billow-backend/models"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// How long links and sessions last
+const (
+	LinkTTL    = 15 * time.Minute
+	SessionTTL = 12 * time.Hour
+)
+
+var (
+	ErrInvalidLink    = errors.New("invalid or expired sign-in link")
+	ErrInvalidSession = errors.New("invalid or expired portal session")
+)
+
+// Signer signs and verifies magic link tokens
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner returns a signer using secret
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// DefaultSigner signs with PORTAL_SECRET. Without it a random secret is used,
+// so links stop working when the process restarts.
+var DefaultSigner = signerFromEnv()
+
+func signerFromEnv() *Signer {
+	if secret := os.Getenv("PORTAL_SECRET"); secret != "" {
+		return NewSigner([]byte(secret))
+	}
+	fmt.Println("PORTAL_SECRET not set, portal links won't survive a restart")
+	return NewSigner(randomBytes(32))
+}
+
+// Sign returns the token for a link: the link ID and expiry, then an
+// HMAC-SHA256 of both
+func (s *Signer) Sign(linkID string, expires time.Time) string {
+	payload := linkID + "." + strconv.FormatInt(expires.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+// Verify checks a token's signature and expiry and returns the link ID
+func (s *Signer) Verify(token string, now time.Time) (string, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidLink
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidLink
+	}
+	given, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(given, s.mac(string(payload))) {
+		return "", ErrInvalidLink
+	}
+
+	linkID, expiry, ok := strings.Cut(string(payload), ".")
+	if !ok {
+		return "", ErrInvalidLink
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || !now.Before(time.Unix(unix, 0)) {
+		return "", ErrInvalidLink
+	}
+	return linkID, nil
+}
+
+func (s *Signer) mac(payload string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// CreateLink stores a single-use link for client and returns its token
+func CreateLink(db *gorm.DB, signer *Signer, client models.Client, now time.Time) (string, error) {
+	link := models.PortalLink{
+		ID:        hex.EncodeToString(randomBytes(16)),
+		UserID:    client.UserID,
+		ClientID:  client.ID,
+		ExpiresAt: now.Add(LinkTTL),
+	}
+	if err := db.Create(&link).Error; err != nil {
+		return "", err
+	}
+	return signer.Sign(link.ID, link.ExpiresAt), nil
+}
+
+// Redeem uses up a link and starts a session for its client. It returns the
+// session and the bearer token, which is only stored hashed.
+func Redeem(db *gorm.DB, signer *Signer, token string, now time.Time) (*models.PortalSession, string, error) {
+	linkID, err := signer.Verify(token, now)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var session models.PortalSession
+	var bearer string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var link models.PortalLink
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&link, "id = ?", linkID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidLink
+		}
+		if err != nil {
+			return err
+		}
+		if link.UsedAt != nil || !now.Before(link.ExpiresAt) {
+			return ErrInvalidLink
+		}
+
+		link.UsedAt = &now
+		if err := tx.Save(&link).Error; err != nil {
+			return err
+		}
+
+		bearer = NewToken()
+		session = models.PortalSession{
+			ID:        models.GeneratePortalSessionID(),
+			TokenHash: HashToken(bearer),
+			UserID:    link.UserID,
+			ClientID:  link.ClientID,
+			ExpiresAt: now.Add(SessionTTL),
+		}
+		return tx.Create(&session).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &session, bearer, nil
+}
+
+// Authenticate returns the active session for a bearer token
+func Authenticate(db *gorm.DB, bearer string, now time.Time) (*models.PortalSession, error) {
+	if bearer == "" {
+		return nil, ErrInvalidSession
+	}
+	var session models.PortalSession
+	if err := db.First(&session, "token_hash = ?", HashToken(bearer)).Error; err != nil {
+		return nil, ErrInvalidSession
+	}
+	if !session.ActiveAt(now) {
+		return nil, ErrInvalidSession
+	}
+	return &session, nil
+}
+
+// NewToken returns an unguessable bearer token
+func NewToken() string {
+	return base64.RawURLEncoding.EncodeToString(randomBytes(32))
+}
+
+// HashToken is how bearer tokens are stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
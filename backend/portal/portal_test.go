@@ -0,0 +1,40 @@
+package portal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedLinks(t *testing.T) {
+	now := time.Date(2024, 4, 16, 12, 0, 0, 0, time.UTC)
+	signer := NewSigner([]byte("secret"))
+	token := signer.Sign("abc123", now.Add(LinkTTL))
+
+	if id, err := signer.Verify(token, now); err != nil || id != "abc123" {
+		t.Fatalf("Verify = %q, %v", id, err)
+	}
+
+	encoded, signature, _ := strings.Cut(token, ".")
+	forged := NewSigner([]byte("secret")).Sign("other", now.Add(LinkTTL))
+	otherPayload, _, _ := strings.Cut(forged, ".")
+
+	tests := map[string]struct {
+		signer *Signer
+		token  string
+		at     time.Time
+	}{
+		"expired":           {signer, token, now.Add(LinkTTL)},
+		"other secret":      {NewSigner([]byte("other")), token, now},
+		"swapped payload":   {signer, otherPayload + "." + signature, now},
+		"missing signature": {signer, encoded, now},
+		"garbage":           {signer, "not a token", now},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := tt.signer.Verify(tt.token, tt.at); err != ErrInvalidLink {
+				t.Errorf("Verify error = %v, want ErrInvalidLink", err)
+			}
+		})
+	}
+}
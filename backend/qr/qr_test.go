@@ -0,0 +1,125 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// golden was produced by an independent encoder (rsc.io/qr) for the same
+// data, level and mask
+var golden = []string{
+	"#######..#.##.#...#######",
+	"#.....#.#.#.##....#.....#",
+	"#.###.#..##.#..#..#.###.#",
+	"#.###.#...#..#....#.###.#",
+	"#.###.#.#..#.#..#.#.###.#",
+	"#.....#..#.##.##..#.....#",
+	"#######.#.#.#.#.#.#######",
+	"............#............",
+	"#.#.#.#...##....#...#..#.",
+	"#.####....####..#.##.####",
+	"#.##..#.##.#..#.##.######",
+	"###..#..########..####.#.",
+	".##.#.#####...#.####.#..#",
+	".#.###.....#..###.#..##.#",
+	"#..#..##.##..#....###..##",
+	".#...#..#.##...#.#.#.#...",
+	"#...###.#...#...#####..##",
+	"........#..###.##...#.#.#",
+	"#######..####.###.#.##.##",
+	"#.....#..#..###.#...##.#.",
+	"#.###.#.##.#..#.#####...#",
+	"#.###.#...##..#....##.#..",
+	"#.###.#.#....#.#...###..#",
+	"#.....#...##....##.#...#.",
+	"#######.#...#...###.##.##",
+}
+
+func TestEncodeMatchesReference(t *testing.T) {
+	code, err := Encode([]byte("upi://pay?pa=a@b"), M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code.Version != 2 || code.Size != 25 {
+		t.Fatalf("version %d size %d, want version 2 size 25", code.Version, code.Size)
+	}
+	for y, row := range golden {
+		var got strings.Builder
+		for x := 0; x < code.Size; x++ {
+			if code.Modules[y][x] {
+				got.WriteByte('#')
+			} else {
+				got.WriteByte('.')
+			}
+		}
+		if got.String() != row {
+			t.Errorf("row %2d = %s\n       want %s", y, got.String(), row)
+		}
+	}
+}
+
+func TestEncodeVersions(t *testing.T) {
+	tests := []struct {
+		length  int
+		level   Level
+		version int
+	}{
+		{14, M, 1},
+		{15, M, 2},
+		{331, M, 13}, // the largest EPC payload
+		{2953, L, 40},
+	}
+	for _, tt := range tests {
+		code, err := Encode(bytes.Repeat([]byte("a"), tt.length), tt.level)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", tt.length, err)
+		}
+		if code.Version != tt.version {
+			t.Errorf("%d bytes at level %d: version %d, want %d", tt.length, tt.level, code.Version, tt.version)
+		}
+		if code.Size != 4*tt.version+17 {
+			t.Errorf("version %d size %d", tt.version, code.Size)
+		}
+	}
+
+	if _, err := EncodeMax(bytes.Repeat([]byte("a"), 332), M, 13); err != ErrTooLong {
+		t.Errorf("err = %v, want ErrTooLong", err)
+	}
+	if _, err := Encode(bytes.Repeat([]byte("a"), 2954), L); err != ErrTooLong {
+		t.Errorf("err = %v, want ErrTooLong", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	code, err := Encode([]byte("SPC"), M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code.Cross = true
+
+	img, err := png.Decode(bytes.NewReader(code.PNG(4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	side := (code.Size + 2*QuietZone) * 4
+	if img.Bounds().Dx() != side || img.Bounds().Dy() != side {
+		t.Errorf("png is %v, want %dx%d", img.Bounds(), side, side)
+	}
+	// Top-left corner of the finder pattern is dark, the quiet zone light
+	if r, _, _, _ := img.At(QuietZone*4, QuietZone*4).RGBA(); r != 0 {
+		t.Error("finder pattern is not dark")
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Error("quiet zone is not light")
+	}
+
+	svg := code.SVG(4)
+	if !strings.HasPrefix(svg, "<svg ") || !strings.HasSuffix(svg, "</svg>") {
+		t.Errorf("not an svg document: %.40s", svg)
+	}
+	if strings.Count(svg, "<rect") != 1+len(code.Overlay()) {
+		t.Error("svg is missing the background or the cross")
+	}
+}
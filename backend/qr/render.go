@@ -0,0 +1,111 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// QuietZone is the light margin, in modules, scanners need around a code
+const QuietZone = 4
+
+// Rect is an area drawn over the modules, in modules from the top-left
+// corner of the code
+type Rect struct {
+	X, Y, W, H float64
+	Dark       bool
+}
+
+// Overlay is what is drawn on top of the modules: the Swiss cross for codes
+// with Cross set, sized as the QR-bill standard asks (7mm on a 46mm code)
+func (c *Code) Overlay() []Rect {
+	if !c.Cross {
+		return nil
+	}
+	size := float64(c.Size)
+	outer := size * 7 / 46
+	inner := outer * 6 / 7
+	arm, width := inner*20/32, inner*6/32
+	centre := size / 2
+	return []Rect{
+		{centre - outer/2, centre - outer/2, outer, outer, false},
+		{centre - inner/2, centre - inner/2, inner, inner, true},
+		{centre - arm/2, centre - width/2, arm, width, false},
+		{centre - width/2, centre - arm/2, width, arm, false},
+	}
+}
+
+// PNG renders the code with scale pixels per module and a quiet zone
+func (c *Code) PNG(scale int) []byte {
+	if scale < 1 {
+		scale = 1
+	}
+	side := (c.Size + 2*QuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			if c.Dark(x/scale-QuietZone, y/scale-QuietZone) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	for _, r := range c.Overlay() {
+		x0, y0 := int((r.X+QuietZone)*float64(scale)), int((r.Y+QuietZone)*float64(scale))
+		x1, y1 := int((r.X+r.W+QuietZone)*float64(scale)), int((r.Y+r.H+QuietZone)*float64(scale))
+		var index uint8
+		if r.Dark {
+			index = 1
+		}
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				img.SetColorIndex(x, y, index)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	png.Encode(&out, img)
+	return out.Bytes()
+}
+
+// SVG renders the code with a quiet zone. The image is scale user units per
+// module and scales cleanly to any size.
+func (c *Code) SVG(scale int) string {
+	if scale < 1 {
+		scale = 1
+	}
+	side := c.Size + 2*QuietZone
+
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Modules[y][x] {
+				continue
+			}
+			run := 1
+			for x+run < c.Size && c.Modules[y][x+run] {
+				run++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", x+QuietZone, y+QuietZone, run, run)
+			x += run - 1
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		side*scale, side*scale, side, side)
+	fmt.Fprintf(&out, `<rect width="%d" height="%d" fill="#fff"/>`, side, side)
+	fmt.Fprintf(&out, `<path d="%s" fill="#000"/>`, path.String())
+	for _, r := range c.Overlay() {
+		fill := "#fff"
+		if r.Dark {
+			fill = "#000"
+		}
+		fmt.Fprintf(&out, `<rect x="%.3f" y="%.3f" width="%.3f" height="%.3f" fill="%s"/>`, r.X+QuietZone, r.Y+QuietZone, r.W, r.H, fill)
+	}
+	out.WriteString("</svg>")
+	return out.String()
+}
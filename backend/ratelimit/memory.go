@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type counter struct {
+	windowStart time.Time
+	previous    int64
+	current     int64
+	window      time.Duration
+}
+
+// MemoryStore keeps counters in process memory. Limits are per instance, so
+// use RedisStore when running more than one backend.
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]*counter
+	now      func() time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMemoryStore returns an empty in-memory store and starts a janitor that
+// drops counters which have been idle for two windows. Close stops it.
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		counters: map[string]*counter{},
+		now:      time.Now,
+		stop:     make(chan struct{}),
+	}
+	go s.cleanup(time.Minute)
+	return s
+}
+
+// Close stops the janitor. The store keeps counting, but idle counters are no
+// longer dropped.
+func (s *MemoryStore) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *MemoryStore) Hit(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	start := now.Truncate(window)
+
+	c, ok := s.counters[key]
+	if !ok {
+		c = &counter{windowStart: start, window: window}
+		s.counters[key] = c
+	}
+
+	switch {
+	case c.windowStart.Equal(start):
+	case c.windowStart.Add(window).Equal(start):
+		c.previous, c.current = c.current, 0
+		c.windowStart = start
+	default:
+		c.previous, c.current = 0, 0
+		c.windowStart = start
+	}
+
+	c.current++
+	return evaluate(now, start, window, c.previous, c.current, limit), nil
+}
+
+func (s *MemoryStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.sweep()
+		}
+	}
+}
+
+// sweep drops the counters that have been idle for two windows
+func (s *MemoryStore) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for key, c := range s.counters {
+		if now.Sub(c.windowStart) > 2*c.window {
+			delete(s.counters, key)
+		}
+	}
+}
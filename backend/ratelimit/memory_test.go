@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreSlidingWindow(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	s := &MemoryStore{counters: map[string]*counter{}, now: func() time.Time { return now }}
+	hit := func() Result {
+		res, err := s.Hit(context.Background(), "user:USR-1:/api/invoices", 10, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for i := 1; i <= 10; i++ {
+		if res := hit(); !res.Allowed || res.Remaining != 10-i {
+			t.Fatalf("hit %d = %+v", i, res)
+		}
+	}
+	res := hit()
+	if res.Allowed || res.RetryAfter != time.Minute || !res.ResetAt.Equal(start.Add(time.Minute)) {
+		t.Fatalf("hit over the limit = %+v", res)
+	}
+
+	// Halfway through the next window the 11 earlier hits weigh 5.5
+	now = start.Add(90 * time.Second)
+	for i := 1; i <= 4; i++ {
+		if res := hit(); !res.Allowed {
+			t.Fatalf("hit %d in the next window = %+v", i, res)
+		}
+	}
+	res = hit()
+	if res.Allowed {
+		t.Fatalf("5th hit in the next window allowed: %+v", res)
+	}
+	// Room for one more once the earlier hits weigh 5: 6/11 into the window
+	if want := start.Add(time.Minute + 6*time.Minute/11).Sub(now); res.RetryAfter != want {
+		t.Errorf("retry after %v, want %v", res.RetryAfter, want)
+	}
+
+	// Windows further back no longer count
+	now = start.Add(3 * time.Minute)
+	if res := hit(); !res.Allowed || res.Remaining != 9 {
+		t.Errorf("hit after idle windows = %+v", res)
+	}
+
+	// Keys are counted separately
+	other, _ := s.Hit(context.Background(), "user:USR-2:/api/invoices", 10, time.Minute)
+	if other.Remaining != 9 {
+		t.Errorf("other key = %+v", other)
+	}
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	s := &MemoryStore{counters: map[string]*counter{}, now: func() time.Time { return now }}
+	s.Hit(context.Background(), "minute", 10, time.Minute)
+	s.Hit(context.Background(), "hour", 10, time.Hour)
+
+	now = start.Add(3 * time.Minute)
+	s.sweep()
+	if _, ok := s.counters["minute"]; ok {
+		t.Error("idle counter kept")
+	}
+	if _, ok := s.counters["hour"]; !ok {
+		t.Error("counter of a running window dropped")
+	}
+}
+
+func TestMemoryStoreClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		s := NewMemoryStore()
+		s.Close()
+		s.Close()
+	}
+	// The janitors exit once they see the store closed
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines left running after Close, %d before", n, before)
+	}
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Result describes the state of a key after a request was counted
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAt    time.Time     // end of the current window
+	RetryAfter time.Duration // how long to wait before retrying, zero when allowed
+}
+
+// Store counts requests per key using a sliding window. Implementations must be
+// safe for concurrent use.
+type Store interface {
+	// Hit records one request for key and reports whether it fits in limit
+	// requests per window.
+	Hit(ctx context.Context, key string, limit int, window time.Duration) (Result, error)
+}
+
+// evaluate applies the sliding window counter approximation: the previous
+// window's count is weighted by how much of it still overlaps the sliding
+// window ending now.
+func evaluate(now time.Time, windowStart time.Time, window time.Duration, previous, current int64, limit int) Result {
+	elapsed := float64(now.Sub(windowStart)) / float64(window)
+	estimated := float64(previous)*(1-elapsed) + float64(current)
+
+	res := Result{
+		Limit:   limit,
+		ResetAt: windowStart.Add(window),
+	}
+
+	if estimated <= float64(limit) {
+		res.Allowed = true
+		res.Remaining = limit - int(math.Ceil(estimated))
+		if res.Remaining < 0 {
+			res.Remaining = 0
+		}
+		return res
+	}
+
+	// Work out when the weighted previous window has decayed enough for one
+	// more request; if the current window alone is full, wait for the next one.
+	retryAt := res.ResetAt
+	if previous > 0 && current < int64(limit) {
+		needed := 1 - float64(int64(limit)-current)/float64(previous)
+		retryAt = windowStart.Add(time.Duration(needed * float64(window)))
+	}
+	res.RetryAfter = retryAt.Sub(now)
+	if res.RetryAfter < time.Second {
+		res.RetryAfter = time.Second
+	}
+	return res
+}
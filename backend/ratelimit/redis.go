@@ -0,0 +1,256 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisStore keeps counters in Redis, or any server speaking the Redis
+// protocol, so limits are shared between backend instances. It talks RESP
+// directly and only needs MULTI/EXEC, INCR, PEXPIRE and GET.
+type RedisStore struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	pool     chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// RedisError is an error reply sent by the server
+type RedisError string
+
+func (e RedisError) Error() string { return "redis: " + string(e) }
+
+// NewRedisStore connects to the server described by rawURL, for example
+// redis://:password@localhost:6379/0
+func NewRedisStore(rawURL string) (*RedisStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported redis url scheme %q", u.Scheme)
+	}
+
+	s := &RedisStore{
+		addr:    u.Host,
+		timeout: time.Second,
+		pool:    make(chan *redisConn, 16),
+	}
+	if !strings.Contains(s.addr, ":") {
+		s.addr += ":6379"
+	}
+	if u.User != nil {
+		s.password, _ = u.User.Password()
+	}
+	if path := strings.TrimPrefix(u.Path, "/"); path != "" {
+		if s.db, err = strconv.Atoi(path); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", path)
+		}
+	}
+
+	// Fail fast on a bad address or password
+	conn, err := s.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	s.release(conn)
+	return s, nil
+}
+
+func (s *RedisStore) Hit(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	now := time.Now()
+	start := now.Truncate(window)
+	current := fmt.Sprintf("ratelimit:%s:%d", key, start.UnixMilli())
+	previous := fmt.Sprintf("ratelimit:%s:%d", key, start.Add(-window).UnixMilli())
+
+	reply, err := s.do(ctx,
+		[]string{"MULTI"},
+		[]string{"INCR", current},
+		[]string{"PEXPIRE", current, strconv.FormatInt((2 * window).Milliseconds(), 10)},
+		[]string{"GET", previous},
+		[]string{"EXEC"},
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	results, ok := reply.([]interface{})
+	if !ok || len(results) != 3 {
+		return Result{}, errors.New("redis: unexpected EXEC reply")
+	}
+	currentCount, ok := results[0].(int64)
+	if !ok {
+		return Result{}, errors.New("redis: unexpected INCR reply")
+	}
+	var previousCount int64
+	if value, ok := results[2].(string); ok {
+		previousCount, _ = strconv.ParseInt(value, 10, 64)
+	}
+
+	return evaluate(now, start, window, previousCount, currentCount, limit), nil
+}
+
+// do pipelines commands on one connection and returns the last reply
+func (s *RedisStore) do(ctx context.Context, commands ...[]string) (interface{}, error) {
+	conn, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.pipeline(commands)
+	if err != nil {
+		var redisErr RedisError
+		if !errors.As(err, &redisErr) {
+			// The connection may be in an unknown state
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	s.release(conn)
+	return reply, err
+}
+
+func (s *RedisStore) acquire(ctx context.Context) (*redisConn, error) {
+	var conn *redisConn
+	select {
+	case conn = <-s.pool:
+	default:
+		var err error
+		if conn, err = s.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(s.timeout)
+	}
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+func (s *RedisStore) release(conn *redisConn) {
+	select {
+	case s.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+func (s *RedisStore) dial(ctx context.Context) (*redisConn, error) {
+	dialer := net.Dialer{Timeout: s.timeout}
+	nc, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	var setup [][]string
+	if s.password != "" {
+		setup = append(setup, []string{"AUTH", s.password})
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	if len(setup) > 0 {
+		if _, err := conn.pipeline(setup); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// pipeline writes every command, then reads every reply. The first error
+// reply is returned after all replies have been consumed.
+func (conn *redisConn) pipeline(commands [][]string) (interface{}, error) {
+	var b strings.Builder
+	for _, args := range commands {
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := io.WriteString(conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	var last interface{}
+	var firstErr error
+	for range commands {
+		reply, err := conn.readReply()
+		var redisErr RedisError
+		if err != nil && !errors.As(err, &redisErr) {
+			return nil, err
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		last = reply
+	}
+	return last, firstErr
+}
+
+// readReply decodes one RESP value: simple strings and bulk strings become
+// string, integers int64, arrays []interface{} and nil replies nil.
+func (conn *redisConn) readReply() (interface{}, error) {
+	line, err := conn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(conn.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			value, err := conn.readReply()
+			var redisErr RedisError
+			if err != nil && !errors.As(err, &redisErr) {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+}
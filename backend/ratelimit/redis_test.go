@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis speaks just enough RESP for RedisStore: AUTH, SELECT, MULTI/EXEC,
+// INCR, PEXPIRE and GET
+type fakeRedis struct {
+	addr     string
+	password string
+
+	mu     sync.Mutex
+	db     string
+	values map[string]int64
+	ttls   map[string]string
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	f := &fakeRedis{addr: l.Addr().String(), password: password, values: map[string]int64{}, ttls: map[string]string{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(nc net.Conn) {
+	defer nc.Close()
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	authed := f.password == ""
+	var queued [][]string
+	multi := false
+
+	for {
+		value, err := conn.readReply()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range value.([]interface{}) {
+			args = append(args, arg.(string))
+		}
+
+		var reply string
+		switch command := strings.ToUpper(args[0]); {
+		case command == "AUTH":
+			if args[1] != f.password {
+				reply = "-WRONGPASS invalid password\r\n"
+				break
+			}
+			authed, reply = true, "+OK\r\n"
+		case !authed:
+			reply = "-NOAUTH Authentication required\r\n"
+		case command == "SELECT":
+			f.mu.Lock()
+			f.db = args[1]
+			f.mu.Unlock()
+			reply = "+OK\r\n"
+		case command == "MULTI":
+			multi, queued, reply = true, nil, "+OK\r\n"
+		case command == "EXEC":
+			reply = fmt.Sprintf("*%d\r\n", len(queued))
+			for _, args := range queued {
+				reply += f.exec(args)
+			}
+			multi, queued = false, nil
+		case multi:
+			queued, reply = append(queued, args), "+QUEUED\r\n"
+		default:
+			reply = f.exec(args)
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "INCR":
+		f.values[args[1]]++
+		return fmt.Sprintf(":%d\r\n", f.values[args[1]])
+	case "PEXPIRE":
+		f.ttls[args[1]] = args[2]
+		return ":1\r\n"
+	case "GET":
+		value, ok := f.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		s := strconv.FormatInt(value, 10)
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func TestRedisStore(t *testing.T) {
+	f := startFakeRedis(t, "secret")
+
+	if _, err := NewRedisStore("redis://:wrong@" + f.addr); err == nil {
+		t.Error("connected with a wrong password")
+	}
+
+	s, err := NewRedisStore("redis://:secret@" + f.addr + "/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		res, err := s.Hit(ctx, "user:USR-1:/api/invoices", 3, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed || res.Remaining != 3-i || res.Limit != 3 {
+			t.Fatalf("hit %d = %+v", i, res)
+		}
+	}
+	res, err := s.Hit(ctx, "user:USR-1:/api/invoices", 3, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.RetryAfter <= 0 {
+		t.Errorf("hit over the limit = %+v", res)
+	}
+
+	start := time.Now().Truncate(time.Hour)
+	key := fmt.Sprintf("ratelimit:user:USR-1:/api/invoices:%d", start.UnixMilli())
+	f.mu.Lock()
+	count, ttl, db := f.values[key], f.ttls[key], f.db
+	f.mu.Unlock()
+	if count != 4 || ttl != "7200000" || db != "2" {
+		t.Errorf("server has %s = %d expiring in %s ms in database %s", key, count, ttl, db)
+	}
+
+	// A full previous window weighs on the current one
+	f.mu.Lock()
+	f.values[fmt.Sprintf("ratelimit:user:USR-2:/api/invoices:%d", start.Add(-time.Hour).UnixMilli())] = 1000000
+	f.mu.Unlock()
+	if res, err := s.Hit(ctx, "user:USR-2:/api/invoices", 3, time.Hour); err != nil || res.Allowed {
+		t.Errorf("hit after a full previous window = %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,485 @@
+// Package render renders user-defined invoice templates. Templates are written
+// in Go's html/template language but confined to a sandbox: only whitelisted
+// functions can be called, templates can't define or invoke other templates,
+// variables can't be reassigned, range only walks the data, and execution is
+// cut off when it takes too long, loops too often, writes too much or builds
+// too much in memory.
+package render
+
+import (
+	"billow-backend/i18n"
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"text/template/parse"
+	"time"
+)
+
+// Limits on templates and their output
+const (
+	MaxSourceSize = 64 << 10
+	MaxOutputSize = 1 << 20
+	Timeout       = time.Second
+)
+
+// maxAllocated caps the strings functions may build in one execution,
+// maxWidth the width and precision of printf verbs and maxPasses how often
+// range bodies may run in one execution
+const (
+	maxAllocated = 4 * MaxOutputSize
+	maxWidth     = 1000
+	maxPasses    = 100000
+)
+
+var (
+	ErrTooLarge = errors.New("render: output exceeds the size limit")
+	ErrTimeout  = errors.New("render: template took too long to render")
+)
+
+// Party is a business or client as templates see it
+type Party struct {
+	Name      string   `json:"name"`
+	Company   string   `json:"company"`
+	Email     string   `json:"email"`
+	Phone     string   `json:"phone"`
+	TaxNumber string   `json:"tax_number"`
+	Address   []string `json:"address"`
+}
+
+// Invoice is the invoice as templates see it
+type Invoice struct {
+	Number   string  `json:"number"`
+	Date     string  `json:"date"`     // YYYY-MM-DD
+	DueDate  string  `json:"due_date"` // YYYY-MM-DD
+	Status   string  `json:"status"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Notes    string  `json:"notes"`
+}
+
+// Data is what a template is executed with
+type Data struct {
+	Locale  string  `json:"locale"` // e.g. en, en-GB or de
+	Invoice Invoice `json:"invoice"`
+	From    Party   `json:"from"`
+	To      Party   `json:"to"`
+	PDFURL  string  `json:"pdf_url"` // set on shared invoice pages
+}
+
+// Sample is example data for previewing templates
+func Sample() Data {
+	return Data{
+		Locale: "en",
+		Invoice: Invoice{
+			Number:   "INV-0042",
+			Date:     "2024-03-01",
+			DueDate:  "2024-03-31",
+			Status:   "unpaid",
+			Amount:   1234.5,
+			Currency: "EUR",
+			Notes:    "Thank you for your business.",
+		},
+		From: Party{
+			Name:      "Doe Consulting",
+			Email:     "billing@doe-consulting.example",
+			TaxNumber: "DE123456789",
+			Address:   []string{"Hauptstr. 1", "10115 Berlin", "DE"},
+		},
+		To: Party{
+			Name:    "Jane Smith",
+			Company: "Acme Corp",
+			Email:   "accounts@acme.example",
+			Address: []string{"1 Market Street", "San Francisco", "US"},
+		},
+	}
+}
+
+// builtins are the html/template builtins templates may use as they are. call
+// is left out so data can't smuggle in functions; the builtins that build
+// strings are replaced in funcs.
+var builtins = map[string]bool{
+	"and": true, "or": true, "not": true, "len": true, "index": true, "slice": true,
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+}
+
+// funcs are the functions added for templates. Text and formatting follow
+// the locale of the data, so they are bound again on every execution.
+// Functions that build strings charge what they may build to b first, so
+// declaring one variable from the next can't double a string until memory
+// runs out.
+func funcs(locale string, b *budget) template.FuncMap {
+	return template.FuncMap{
+		"t": func(msg string) string {
+			return i18n.T(locale, msg)
+		},
+		"money": func(amount float64, currency string) string {
+			return i18n.FormatMoney(locale, amount, currency)
+		},
+		"number": func(v float64, decimals int) string {
+			return i18n.FormatNumber(locale, v, decimals)
+		},
+		"date": func(value string, locales ...string) string {
+			if len(locales) > 0 {
+				return i18n.FormatDate(locales[0], value)
+			}
+			return i18n.FormatDate(locale, value)
+		},
+		"upper": func(s string) (string, error) {
+			return spend(b, 2*len(s), func() string { return strings.ToUpper(s) })
+		},
+		"lower": func(s string) (string, error) {
+			return spend(b, 2*len(s), func() string { return strings.ToLower(s) })
+		},
+		"join": func(parts []string, sep string) (string, error) {
+			size := len(sep) * len(parts)
+			for _, part := range parts {
+				size += len(part)
+			}
+			return spend(b, size, func() string { return strings.Join(parts, sep) })
+		},
+		"printf": func(format string, args ...interface{}) (string, error) {
+			size, err := printfSize(format, args)
+			if err != nil {
+				return "", err
+			}
+			return spend(b, size, func() string { return fmt.Sprintf(format, args...) })
+		},
+		"print": func(args ...interface{}) (string, error) {
+			return spend(b, printSize(args), func() string { return fmt.Sprint(args...) })
+		},
+		"println": func(args ...interface{}) (string, error) {
+			return spend(b, printSize(args), func() string { return fmt.Sprintln(args...) })
+		},
+		// Escaping turns a byte into at most 6
+		"html": func(args ...interface{}) (string, error) {
+			return spend(b, 6*printSize(args), func() string { return template.HTMLEscaper(args...) })
+		},
+		"js": func(args ...interface{}) (string, error) {
+			return spend(b, 6*printSize(args), func() string { return template.JSEscaper(args...) })
+		},
+		"urlquery": func(args ...interface{}) (string, error) {
+			return spend(b, 6*printSize(args), func() string { return template.URLQueryEscaper(args...) })
+		},
+	}
+}
+
+// budget is what one execution may still build and run, and until when
+type budget struct {
+	left      int
+	passes    int
+	deadline  time.Time
+	abandoned atomic.Bool
+}
+
+// expired reports whether the execution ran out of time
+func (b *budget) expired() bool {
+	return b.abandoned.Load() || time.Now().After(b.deadline)
+}
+
+// spend charges size to b and builds the string if it is still within the
+// budget and the time limit. A render that timed out stops here.
+func spend(b *budget, size int, build func() string) (string, error) {
+	if b.expired() {
+		return "", ErrTimeout
+	}
+	if b.left -= size; b.left < 0 {
+		return "", ErrTooLarge
+	}
+	return build(), nil
+}
+
+// passFunc is added to the pipeline of every range by Parse, so each time a
+// range starts its passes are charged to the budget. Nested empty ranges never
+// write or call a function, so nothing else would stop them. It isn't one of
+// funcs, so templates can't call it themselves.
+const passFunc = "_passes"
+
+// countPasses charges the passes of a range over items to b and hands items
+// on to the range
+func countPasses(b *budget) func(interface{}) (interface{}, error) {
+	return func(items interface{}) (interface{}, error) {
+		if b.expired() {
+			return nil, ErrTimeout
+		}
+		switch v := reflect.ValueOf(items); v.Kind() {
+		case reflect.Array, reflect.Slice, reflect.Map, reflect.String:
+			b.passes += v.Len()
+		}
+		if b.passes > maxPasses {
+			return nil, ErrTimeout
+		}
+		return items, nil
+	}
+}
+
+// printSize is how long fmt.Sprint makes args, plus room for separators.
+// Strings are what functions build; anything else comes from the data.
+func printSize(args []interface{}) int {
+	size := len(args) + 1
+	for _, arg := range args {
+		if s, ok := arg.(string); ok {
+			size += len(s)
+		} else {
+			size += len(fmt.Sprint(arg))
+		}
+	}
+	return size
+}
+
+// printfSize is at most how long fmt.Sprintf makes its result. Verbs take
+// their arguments in order, so no argument is printed twice; quoting or hex
+// makes one at most six times as long, and widths are capped.
+func printfSize(format string, args []interface{}) (int, error) {
+	size := len(format) + 6*printSize(args) + 32*len(args)
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		// Flags, width and precision up to the verb
+		n := 0
+		for i++; i < len(format) && strings.IndexByte("+-# .0123456789*[", format[i]) >= 0; i++ {
+			switch c := format[i]; {
+			case c == '*' || c == '[':
+				return 0, errors.New("printf: widths and argument indexes must be written in the format")
+			case c >= '0' && c <= '9':
+				if n = n*10 + int(c-'0'); n > maxWidth {
+					return 0, fmt.Errorf("printf: widths and precisions can be at most %d", maxWidth)
+				}
+			default:
+				size, n = size+n, 0
+			}
+		}
+		// Verbs without an argument print an error in their place
+		size += n + 16
+	}
+	return size, nil
+}
+
+// Template is a parsed template that passed the sandbox checks
+type Template struct {
+	tmpl *template.Template
+}
+
+// Parse parses src and checks it only uses what the sandbox allows
+func Parse(src string) (*Template, error) {
+	if len(src) > MaxSourceSize {
+		return nil, fmt.Errorf("template is larger than %d KB", MaxSourceSize>>10)
+	}
+	tmpl, err := template.New("invoice").Funcs(funcs("", nil)).Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(tmpl.Templates()) > 1 {
+		return nil, errors.New("templates can't define other templates")
+	}
+	if err := check(tmpl.Tree.Root); err != nil {
+		return nil, err
+	}
+	chargeRanges(tmpl.Tree, tmpl.Tree.Root)
+	return &Template{tmpl: tmpl}, nil
+}
+
+// check walks the parse tree and rejects anything outside the sandbox
+func check(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := check(child); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return check(n.Pipe)
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		// Ranging over a number or a function result could loop for as long
+		// as it likes, so only fields of the data can be walked
+		if !rangesOverData(n.Pipe) {
+			return fmt.Errorf("line %d: range can only walk fields of the data", n.Line)
+		}
+		return checkBranch(&n.BranchNode)
+	case *parse.TemplateNode:
+		return fmt.Errorf("line %d: templates can't include other templates", n.Line)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		// Reassigning a variable in a range could grow it on every pass
+		if n.IsAssign {
+			return fmt.Errorf("line %d: variables can't be reassigned", n.Line)
+		}
+		for _, cmd := range n.Cmds {
+			if err := check(cmd); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if err := check(arg); err != nil {
+				return err
+			}
+		}
+	case *parse.ChainNode:
+		return check(n.Node)
+	case *parse.IdentifierNode:
+		if _, ok := funcs("", nil)[n.Ident]; !ok && !builtins[n.Ident] {
+			return fmt.Errorf("function %q is not allowed", n.Ident)
+		}
+	}
+	return nil
+}
+
+func checkBranch(n *parse.BranchNode) error {
+	for _, child := range []parse.Node{n.Pipe, n.List, n.ElseList} {
+		if err := check(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chargeRanges turns every {{range .Field}} under node into
+// {{range _passes .Field}}. check has made sure each ranges over a field.
+func chargeRanges(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			chargeRanges(tree, child)
+		}
+	case *parse.IfNode:
+		chargeBranch(tree, &n.BranchNode)
+	case *parse.WithNode:
+		chargeBranch(tree, &n.BranchNode)
+	case *parse.RangeNode:
+		cmd := n.Pipe.Cmds[0]
+		pass := parse.NewIdentifier(passFunc).SetTree(tree).SetPos(cmd.Pos)
+		cmd.Args = append([]parse.Node{pass}, cmd.Args...)
+		chargeBranch(tree, &n.BranchNode)
+	}
+}
+
+func chargeBranch(tree *parse.Tree, n *parse.BranchNode) {
+	chargeRanges(tree, n.List)
+	chargeRanges(tree, n.ElseList)
+}
+
+// rangesOverData reports whether pipe is a plain field of the data, like
+// .To.Address or $.From.Address
+func rangesOverData(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		return true
+	case *parse.VariableNode:
+		return len(arg.Ident) > 1
+	}
+	return false
+}
+
+// Execute renders the template with data within the output and time limits
+func (t *Template) Execute(data Data) ([]byte, error) {
+	// Clones are bound to the data's locale; the parsed template is never
+	// executed itself, so it can be shared
+	tmpl, err := t.tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	b := &budget{left: maxAllocated, deadline: time.Now().Add(Timeout)}
+	tmpl.Funcs(funcs(data.Locale, b)).Funcs(template.FuncMap{passFunc: countPasses(b)})
+
+	w := &limitedWriter{budget: b}
+	done := make(chan error, 1)
+	go func() { done <- tmpl.Execute(w, data) }()
+
+	timer := time.NewTimer(Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+		return w.buf.Bytes(), nil
+	case <-timer.C:
+		// The render stops at its next write or function call
+		b.abandoned.Store(true)
+		return nil, ErrTimeout
+	}
+}
+
+// Render parses and executes src with data
+func Render(src string, data Data) ([]byte, error) {
+	t, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return t.Execute(data)
+}
+
+// limitedWriter fails writes past the output limit or the deadline
+type limitedWriter struct {
+	buf    bytes.Buffer
+	budget *budget
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.budget.expired() {
+		return 0, ErrTimeout
+	}
+	if w.buf.Len()+len(p) > MaxOutputSize {
+		return 0, ErrTooLarge
+	}
+	return w.buf.Write(p)
+}
+
+// DefaultSource is the template invoices use when none is chosen, and a
+// starting point for writing one
+const DefaultSource = `<!DOCTYPE html>
+<html lang="{{.Locale}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{t "Invoice"}} {{.Invoice.Number}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #1f2937; max-width: 720px; margin: 40px auto; padding: 0 20px; }
+h1 { font-size: 28px; margin-bottom: 4px; }
+.muted { color: #6b7280; }
+.parties { display: flex; justify-content: space-between; margin: 32px 0; }
+table { width: 100%; border-collapse: collapse; }
+td { padding: 8px 0; border-top: 1px solid #e5e7eb; }
+td:last-child { text-align: right; }
+.total td { font-size: 20px; font-weight: bold; }
+.notes { white-space: pre-line; margin-top: 32px; }
+</style>
+</head>
+<body>
+<h1>{{t "Invoice"}}</h1>
+<div class="muted">{{.Invoice.Number}}</div>
+<div class="parties">
+<div><div class="muted">{{t "From"}}</div><div>{{.From.Name}}</div>{{range .From.Address}}<div>{{.}}</div>{{end}}{{with .From.Email}}<div>{{.}}</div>{{end}}{{with .From.TaxNumber}}<div>{{printf (t "Tax ID: %s") .}}</div>{{end}}</div>
+<div><div class="muted">{{t "Bill to"}}</div><div>{{.To.Name}}</div>{{with .To.Company}}<div>{{.}}</div>{{end}}{{range .To.Address}}<div>{{.}}</div>{{end}}</div>
+</div>
+<table>
+<tr><td>{{t "Invoice date"}}</td><td>{{date .Invoice.Date}}</td></tr>
+<tr><td>{{t "Due date"}}</td><td>{{date .Invoice.DueDate}}</td></tr>
+<tr class="total"><td>{{t "Amount due"}}</td><td>{{money .Invoice.Amount .Invoice.Currency}}</td></tr>
+</table>
+{{with .Invoice.Notes}}<p class="notes muted">{{.}}</p>{{end}}
+{{with .PDFURL}}<p><a href="{{.}}">{{t "Download PDF"}}</a></p>{{end}}
+</body>
+</html>
+`
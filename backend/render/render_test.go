@@ -0,0 +1,134 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	data := Sample()
+	data.To.Name = "<script>alert(1)</script>"
+	out, err := Render(DefaultSource, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"INV-0042", "03/01/2024", "€1,234.50", "10115 Berlin", "&lt;script&gt;"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output is missing %s", want)
+		}
+	}
+
+	out, err = Render(`{{date .Invoice.Date}} {{date .Invoice.Date "en-GB"}} {{upper .To.Company}}`, Data{Locale: "de", Invoice: Invoice{Date: "2024-03-01"}, To: Party{Company: "Acme"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), "01.03.2024 01/03/2024 ACME"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	out, err = Render(DefaultSource, Data{Locale: "fr", Invoice: Invoice{Date: "2024-03-01", Amount: 1234.5, Currency: "EUR"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Facture", "Date de facturation", "01/03/2024", "1\u00a0234,50 €"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("French output is missing %s", want)
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	rejected := map[string]string{
+		"define":       `{{define "x"}}x{{end}}`,
+		"template":     `{{template "invoice"}}`,
+		"block":        `{{block "x" .}}x{{end}}`,
+		"call":         `{{call .Invoice.Number}}`,
+		"unknown func": `{{exec "ls"}}`,
+		"range number": `{{range 1000000000}}{{end}}`,
+		"range func":   `{{range len .To.Address}}{{end}}`,
+		"range var":    `{{$n := 5}}{{range $n}}{{end}}`,
+		"reassign":     `{{$x := ""}}{{range .To.Address}}{{$x = printf "%s%s" $x .}}{{end}}`,
+		"too large":    strings.Repeat("x", MaxSourceSize+1),
+	}
+	for name, src := range rejected {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("%s: expected the template to be rejected", name)
+		}
+	}
+
+	for _, src := range []string{`{{range .To.Address}}{{.}}{{end}}`, `{{range $i, $line := $.From.Address}}{{$i}}{{$line}}{{end}}`} {
+		if _, err := Parse(src); err != nil {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+}
+
+func TestOutputLimit(t *testing.T) {
+	data := Sample()
+	data.To.Address = make([]string, 2000)
+	src := `{{range .To.Address}}{{range $.To.Address}}{{printf "%1000s" ""}}{{end}}{{end}}`
+	if _, err := Render(src, data); !errors.Is(err, ErrTooLarge) && !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want the output or time limit", err)
+	}
+}
+
+// Nested ranges with empty bodies never write or call a function; their
+// passes must still run out long before the deadline, and the render stop
+func TestRangeLimit(t *testing.T) {
+	data := Sample()
+	data.To.Address = make([]string, 1000)
+	src := `{{range .To.Address}}{{range $.To.Address}}{{range $.To.Address}}{{end}}{{end}}{{end}}`
+
+	start := time.Now()
+	if _, err := Render(src, data); !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want the time limit", err)
+	}
+	if elapsed := time.Since(start); elapsed >= Timeout {
+		t.Errorf("stopped after %v, by the timer rather than the pass limit", elapsed)
+	}
+
+	out, err := Render(`{{range $i, $line := .From.Address}}{{$i}}:{{$line}};{{end}}`, Data{From: Party{Address: []string{"a", "b"}}})
+	if err != nil || string(out) != "0:a;1:b;" {
+		t.Errorf("got %q, %v", out, err)
+	}
+}
+
+// Strings built from strings must stay within the budget long before they
+// can use up memory, not just fail once they are written
+func TestMemoryLimit(t *testing.T) {
+	doubling := func(format string) string {
+		src := `{{$v0 := printf "%01000d" 1}}`
+		for i := 1; i <= 30; i++ {
+			src += fmt.Sprintf(`{{$v%d := `+format+`}}`, i, i-1, i-1)
+		}
+		return src + `{{$v30}}`
+	}
+	reassigned := `{{$x := printf "%0100000d" 1}}` + strings.Repeat(`{{$x = printf "%s%s" $x $x}}`, 13) + `{{len $x}}`
+
+	tests := map[string]string{
+		"reassignment":      reassigned,
+		"wide verb":         `{{$x := printf "%0100000d" 1}}{{len $x}}`,
+		"star width":        `{{printf "%0*d" 100000 1}}`,
+		"repeated argument": `{{printf "%[1]s%[1]s%[1]s%[1]s" .Invoice.Notes}}`,
+		"printf doubling":   doubling(`printf "%%s%%s" $v%d $v%d`),
+		"print doubling":    doubling(`print $v%d $v%d`),
+		"escaping":          `{{$a := printf "%01000s" "<"}}` + strings.Repeat(`{{$a := html $a $a}}`, 30) + `{{len $a}}`,
+	}
+	for name, src := range tests {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		_, err := Render(src, Sample())
+		runtime.ReadMemStats(&after)
+
+		if err == nil {
+			t.Errorf("%s: rendered without hitting a limit", name)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 8*maxAllocated {
+			t.Errorf("%s: allocated %d MB", name, allocated>>20)
+		}
+	}
+}
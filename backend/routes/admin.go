@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"billow-backend/middleware"
+	"billow-backend/policy"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupAdminRoutes registers the endpoints Billow staff use to manage
+// platform data
+func SetupAdminRoutes(app *fiber.App) {
+	admin := app.Group("/api/admin", middleware.AuthMiddleware(), middleware.TenantTransaction())
+
+	// Plan catalog
+	admin.Get("/plans", middleware.Authorize(policy.PlansManage), getPlanCatalog)
+	admin.Get("/plans/catalog-diff", middleware.Authorize(policy.PlansManage), getPlanCatalogDiff)
+	admin.Post("/plans", middleware.Authorize(policy.PlansManage), createPlanVersion)
+	admin.Put("/plans/:id", middleware.Authorize(policy.PlansManage), updatePlan)
+
+	// Coupons behind partner promotions
+	admin.Get("/coupons", middleware.Authorize(policy.CouponsManage), getCoupons)
+	admin.Post("/coupons", middleware.Authorize(policy.CouponsManage), createCoupon)
+	admin.Post("/coupons/:id/promotion-codes", middleware.Authorize(policy.CouponsManage), createPromotionCode)
+	admin.Put("/promotion-codes/:id", middleware.Authorize(policy.CouponsManage), updatePromotionCode)
+
+	// Add-ons and per-user entitlement overrides
+	admin.Get("/add-ons", middleware.Authorize(policy.EntitlementsManage), getAddOns)
+	admin.Post("/add-ons", middleware.Authorize(policy.EntitlementsManage), createAddOn)
+	admin.Get("/users/:id/entitlements", middleware.Authorize(policy.EntitlementsManage), getUserEntitlements)
+	admin.Post("/users/:id/add-ons", middleware.Authorize(policy.EntitlementsManage), grantAddOn)
+	admin.Put("/users/:id/overrides/:key", middleware.Authorize(policy.EntitlementsManage), setEntitlementOverride)
+	admin.Delete("/users/:id/overrides/:key", middleware.Authorize(policy.EntitlementsManage), deleteEntitlementOverride)
+}
@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"billow-backend/policy"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// getAuditLog lists the account's audit events, newest first.
+// Filters: action, target_type, target_id, risk, from, to (YYYY-MM-DD).
+// Pagination: page (from 1) and limit (max 200).
+func getAuditLog(c *fiber.Ctx) error {
+	subject, err := middleware.GetSubjectFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	query := db.Model(&models.AuditEvent{}).Scopes(policy.OwnedBy(subject))
+
+	for _, field := range []string{"action", "target_type", "target_id", "risk"} {
+		if value := c.Query(field); value != "" {
+			query = query.Where(field+" = ?", value)
+		}
+	}
+
+	if from := c.Query("from"); from != "" {
+		fromDate, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid from date, expected YYYY-MM-DD"})
+		}
+		query = query.Where("created_at >= ?", fromDate)
+	}
+	if to := c.Query("to"); to != "" {
+		toDate, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid to date, expected YYYY-MM-DD"})
+		}
+		query = query.Where("created_at < ?", toDate.AddDate(0, 0, 1))
+	}
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "50"))
+	if err != nil || limit < 1 {
+		limit = 50
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch audit log"})
+	}
+
+	events := []models.AuditEvent{}
+	if err := query.Session(&gorm.Session{}).Order("created_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&events).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch audit log"})
+	}
+
+	return c.JSON(fiber.Map{
+		"events": events,
+		"page":   page,
+		"limit":  limit,
+		"total":  total,
+	})
+}
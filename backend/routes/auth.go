@@ -1,12 +1,14 @@
 package routes
 
 import (
+	"billow-backend/audit"
 	"billow-backend/config"
 	"billow-backend/models"
 	"fmt"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 func SetupAuthRoutes(app *fiber.App) {
@@ -57,6 +59,15 @@ func syncUser(c *fiber.Ctx) error {
 
 		fmt.Printf("User created successfully: %s\n", user.ID)
 
+		audit.Record(c, config.DB, audit.Entry{
+			UserID:     user.ID,
+			ActorID:    user.ID,
+			Action:     audit.Signup,
+			TargetType: "user",
+			TargetID:   user.ID,
+			After:      user,
+		})
+
 		// Create default subscription (Starter plan with trial)
 		subscription := models.Subscription{
 			ID:               models.GenerateSubscriptionID(),
@@ -114,6 +125,14 @@ func syncUser(c *fiber.Ctx) error {
 
 		fmt.Printf("User updated successfully: %s\n", existingUser.ID)
 
+		audit.Record(c, config.DB, audit.Entry{
+			UserID:     existingUser.ID,
+			ActorID:    existingUser.ID,
+			Action:     audit.Login,
+			TargetType: "user",
+			TargetID:   existingUser.ID,
+		})
+
 		return c.JSON(fiber.Map{
 			"message": "User updated successfully",
 			"is_new":  false,
@@ -127,7 +146,6 @@ func handleClerkWebhook(c *fiber.Ctx) error {
 		Type string `json:"type"`
 		Data struct {
 			ID           string `json:"id"`
-			EmailAddress string `json:"email_addresses"`
 			FirstName    string `json:"first_name"`
 			LastName     string `json:"last_name"`
 			ImageURL     string `json:"image_url"`
@@ -202,6 +220,7 @@ func handleClerkWebhook(c *fiber.Ctx) error {
 			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
 		}
 
+		before := user
 		user.Email = email
 		user.DisplayName = webhookData.Data.FirstName + " " + webhookData.Data.LastName
 		user.ProfileImage = webhookData.Data.ImageURL
@@ -213,12 +232,42 @@ func handleClerkWebhook(c *fiber.Ctx) error {
 
 		fmt.Printf("Webhook user updated: %s\n", user.ID)
 
+		audit.Record(c, config.DB, audit.Entry{
+			UserID:     user.ID,
+			ActorID:    "clerk",
+			Action:     audit.ProfileUpdated,
+			TargetType: "user",
+			TargetID:   user.ID,
+			Before:     before,
+			After:      user,
+		})
+
 	case "user.deleted":
 		// Handle user deletion
-		if err := config.DB.Where("clerk_id = ?", webhookData.Data.ID).Delete(&models.User{}).Error; err != nil {
+		var user models.User
+		if err := config.DB.Where("clerk_id = ?", webhookData.Data.ID).First(&user).Error; err != nil {
+			fmt.Printf("Webhook user not found: %s\n", webhookData.Data.ID)
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+
+		// Recorded before the user is gone so the alert can still reach them,
+		// and sent only once the deletion is committed
+		err := config.DB.Transaction(func(tx *gorm.DB) error {
+			audit.Record(c, tx, audit.Entry{
+				UserID:     user.ID,
+				ActorID:    "clerk",
+				Action:     audit.AccountDeleted,
+				TargetType: "user",
+				TargetID:   user.ID,
+				Before:     user,
+			})
+			return tx.Delete(&user).Error
+		})
+		if err != nil {
 			fmt.Printf("Webhook error deleting user: %v\n", err)
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to delete user"})
 		}
+		audit.Committed(c)
 
 		fmt.Printf("Webhook user deleted: %s\n", webhookData.Data.ID)
 	}
@@ -0,0 +1,40 @@
+package routes
+
+import (
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"billow-backend/policy"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// findOwned loads the record with the given ID into dest and asks the policy
+// engine whether the authenticated user may perform action on it. Records that
+// belong to another tenant are reported exactly like missing ones so IDs
+// cannot be probed across accounts.
+func findOwned(c *fiber.Ctx, action policy.Action, dest policy.Owned, id string) error {
+	if err := middleware.DB(c).Where("id = ?", id).First(dest).Error; err != nil {
+		return notFound(dest)
+	}
+
+	if err := middleware.CanAccess(c, action, dest); err != nil {
+		var denied *policy.DeniedError
+		if errors.As(err, &denied) && denied.Decision.Reason == policy.ReasonNotOwner {
+			return notFound(dest)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func notFound(record policy.Owned) error {
+	switch record.(type) {
+	case *models.Client:
+		return fiber.NewError(404, "Client not found")
+	case *models.Invoice:
+		return fiber.NewError(404, "Invoice not found")
+	}
+	return fiber.ErrNotFound
+}
@@ -0,0 +1,179 @@
+package routes
+
+import (
+	"billow-backend/billing"
+	"billow-backend/config"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func SetupBillingRoutes(app *fiber.App) {
+	// Called by the payment provider, authenticated by the webhook signature
+	app.Post("/api/billing/webhook", handleBillingWebhook)
+	// Called by the payment gateway when an invoice is paid online
+	app.Post("/api/payments/webhook", handlePaymentWebhook)
+}
+
+// appURL is where the provider's hosted pages send the user back to
+func appURL(path string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:5173"
+	}
+	return strings.TrimRight(base, "/") + path
+}
+
+// apiURL builds a link to this server, for pages it serves itself
+func apiURL(path string) string {
+	base := os.Getenv("API_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return strings.TrimRight(base, "/") + path
+}
+
+// createCheckoutSession starts a hosted checkout for a paid plan
+func createCheckoutSession(c *fiber.Ctx) error {
+	if billing.DefaultProvider == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Payments are not configured"})
+	}
+
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	var checkoutData struct {
+		PlanID string `json:"plan_id"`
+	}
+	if err := c.BodyParser(&checkoutData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+
+	var plan models.Plan
+	if err := db.First(&plan, "id = ?", checkoutData.PlanID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Plan not found"})
+	}
+	if err := requireOffered(db, plan, ""); err != nil {
+		return err
+	}
+
+	session, err := startCheckout(c, user, &plan)
+	if err != nil {
+		return err
+	}
+	return c.JSON(session)
+}
+
+// requireOffered rejects retired and superseded plan versions. Subscribers
+// grandfathered on one may still pick it again.
+func requireOffered(db *gorm.DB, plan models.Plan, currentPlanID string) error {
+	if plan.ID == currentPlanID {
+		return nil
+	}
+	offered, err := billing.IsOffered(db, plan)
+	if err != nil {
+		return err
+	}
+	if !offered {
+		return fiber.NewError(400, "Plan is no longer available")
+	}
+	return nil
+}
+
+// startCheckout creates a checkout session for plan, reusing the provider
+// customer from an earlier subscription. Errors are *fiber.Error.
+func startCheckout(c *fiber.Ctx, user *models.User, plan *models.Plan) (*billing.Session, error) {
+	if plan.ProviderPriceID == "" {
+		return nil, fiber.NewError(400, "Plan can't be purchased online")
+	}
+
+	var subscription models.Subscription
+	middleware.DB(c).Where("user_id = ?", user.ID).Limit(1).Find(&subscription)
+
+	// Carry a redeemed discount over to the provider subscription
+	var couponID string
+	if discount, _ := billing.ActiveDiscount(middleware.DB(c), subscription.ID, time.Now()); discount != nil && discount.Coupon.AppliesTo(plan.ID) {
+		couponID = discount.Coupon.ProviderCouponID
+	}
+
+	session, err := billing.DefaultProvider.CreateCheckoutSession(c.UserContext(), billing.CheckoutParams{
+		UserID:     user.ID,
+		PlanID:     plan.ID,
+		PriceID:    plan.ProviderPriceID,
+		CustomerID: subscription.ProviderCustomerID,
+		CouponID:   couponID,
+		Email:      user.Email,
+		SuccessURL: appURL("/settings?checkout=success"),
+		CancelURL:  appURL("/settings?checkout=canceled"),
+	})
+	if err != nil {
+		fmt.Printf("Checkout session failed for %s: %v\n", user.ID, err)
+		return nil, fiber.NewError(502, "Failed to start checkout")
+	}
+	return session, nil
+}
+
+// createPortalSession links to the provider page for payment methods and
+// billing history
+func createPortalSession(c *fiber.Ctx) error {
+	if billing.DefaultProvider == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Payments are not configured"})
+	}
+
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	var subscription models.Subscription
+	if err := middleware.DB(c).First(&subscription, "user_id = ?", userID).Error; err != nil || subscription.ProviderCustomerID == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "No billing account for this subscription"})
+	}
+
+	session, err := billing.DefaultProvider.CreatePortalSession(c.UserContext(), subscription.ProviderCustomerID, appURL("/settings"))
+	if err != nil {
+		fmt.Printf("Portal session failed for %s: %v\n", userID, err)
+		return c.Status(502).JSON(fiber.Map{"error": "Failed to open billing portal"})
+	}
+	return c.JSON(session)
+}
+
+// handleBillingWebhook applies payment provider events to the local
+// subscription. Events that can't be matched are acknowledged so the provider
+// stops retrying them.
+func handleBillingWebhook(c *fiber.Ctx) error {
+	if billing.DefaultProvider == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Payments are not configured"})
+	}
+
+	event, err := billing.DefaultProvider.ParseWebhook(c.Body(), c.Get("Stripe-Signature"))
+	if err != nil {
+		if errors.Is(err, billing.ErrInvalidSignature) {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid signature"})
+		}
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid webhook data"})
+	}
+
+	fmt.Printf("Received billing webhook: %s (%s)\n", event.Type, event.ID)
+
+	if err := billing.ApplyEvent(config.DB, event); err != nil {
+		if errors.Is(err, billing.ErrUnknownSubscription) {
+			fmt.Printf("Billing webhook %s matched no subscription\n", event.ID)
+			return c.JSON(fiber.Map{"received": true})
+		}
+		fmt.Printf("Billing webhook %s failed: %v\n", event.ID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to apply webhook"})
+	}
+
+	return c.JSON(fiber.Map{"received": true})
+}
@@ -0,0 +1,258 @@
+package routes
+
+import (
+	"billow-backend/audit"
+	"billow-backend/entitlements"
+	"billow-backend/i18n"
+	"billow-backend/mailer"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"billow-backend/pdf"
+	"html/template"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// brandFont is a typeface brands can pick: a CSS stack for the portal and
+// shared pages, and the closest standard PDF family for documents
+type brandFont struct {
+	CSS    template.CSS
+	Family pdf.Family
+}
+
+var brandFonts = map[string]brandFont{
+	"helvetica": {`Helvetica, Arial, sans-serif`, pdf.Helvetica},
+	"arial":     {`Arial, Helvetica, sans-serif`, pdf.Helvetica},
+	"georgia":   {`Georgia, "Times New Roman", serif`, pdf.Times},
+	"times":     {`"Times New Roman", Times, serif`, pdf.Times},
+	"courier":   {`"Courier New", Courier, monospace`, pdf.Courier},
+}
+
+// activeBranding returns the user's branding while their plan includes white
+// labelling, and nil otherwise
+func activeBranding(db *gorm.DB, userID string) *models.Branding {
+	var branding models.Branding
+	if err := db.First(&branding, "user_id = ?", userID).Error; err != nil {
+		return nil
+	}
+	var subscription *models.Subscription
+	var found models.Subscription
+	if err := db.Preload("Plan").First(&found, "user_id = ?", userID).Error; err == nil {
+		subscription = &found
+	}
+	ents, err := entitlements.Load(db, userID, subscription, time.Now())
+	if err != nil || !ents.Has("white_label") {
+		return nil
+	}
+	return &branding
+}
+
+// brandingView is the branding clients see. Without white labelling only the
+// Billow mark is shown.
+func brandingView(branding *models.Branding) fiber.Map {
+	if branding == nil {
+		return fiber.Map{"show_billow_branding": true}
+	}
+	return fiber.Map{
+		"show_billow_branding": false,
+		"primary_color":        branding.PrimaryColor,
+		"accent_color":         branding.AccentColor,
+		"font":                 branding.Font,
+		"font_css":             brandFonts[branding.Font].CSS,
+		"has_logo":             len(branding.Logo) > 0,
+	}
+}
+
+// brandedMessage addresses an email to clients as the brand and adds the
+// Billow footer, in the client's language, when there is none
+func brandedMessage(msg mailer.Message, branding *models.Branding, locale string) mailer.Message {
+	if branding == nil {
+		msg.Body += "\n--\n" + i18n.T(locale, "Sent with Billow, invoicing for small businesses") + "\n"
+		return msg
+	}
+	msg.FromName = branding.SenderName
+	if branding.ReplyTo != "" {
+		msg.ReplyTo = branding.ReplyTo
+	}
+	return msg
+}
+
+func getBranding(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	var branding models.Branding
+	if err := middleware.DB(c).First(&branding, "user_id = ?", userID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Branding not found"})
+	}
+	return c.JSON(fiber.Map{"branding": branding, "has_logo": len(branding.Logo) > 0})
+}
+
+// updateBranding saves the colors, font and email sender of the brand
+func updateBranding(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	var updateData models.Branding
+	if err := c.BodyParser(&updateData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+	primary := strings.ToLower(strings.TrimSpace(updateData.PrimaryColor))
+	accent := strings.ToLower(strings.TrimSpace(updateData.AccentColor))
+	for _, color := range []string{primary, accent} {
+		if _, ok := pdf.ParseColor(color); color != "" && !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "Colors must be hex codes like #1a73e8"})
+		}
+	}
+	font := strings.ToLower(strings.TrimSpace(updateData.Font))
+	if _, ok := brandFonts[font]; font != "" && !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown font"})
+	}
+	senderName := strings.TrimSpace(updateData.SenderName)
+	if len(senderName) > 100 || strings.ContainsAny(senderName, "\r\n") {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid sender name"})
+	}
+	replyTo := strings.TrimSpace(updateData.ReplyTo)
+	if replyTo != "" {
+		addr, err := mail.ParseAddress(replyTo)
+		if err != nil || addr.Address != replyTo {
+			return c.Status(400).JSON(fiber.Map{"error": "Reply-to must be an email address"})
+		}
+	}
+
+	var branding models.Branding
+	var before interface{}
+	if err := db.First(&branding, "user_id = ?", userID).Error; err == nil {
+		before = branding
+	} else {
+		branding = models.Branding{ID: models.GenerateBrandingID(), UserID: userID}
+	}
+	branding.PrimaryColor = primary
+	branding.AccentColor = accent
+	branding.Font = font
+	branding.SenderName = senderName
+	branding.ReplyTo = replyTo
+	if err := db.Save(&branding).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save branding"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ProfileUpdated,
+		TargetType: "branding",
+		TargetID:   branding.ID,
+		Before:     before,
+		After:      branding,
+	})
+
+	return c.JSON(fiber.Map{"branding": branding, "has_logo": len(branding.Logo) > 0})
+}
+
+// deleteBranding goes back to the Billow look
+func deleteBranding(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	var branding models.Branding
+	if err := db.First(&branding, "user_id = ?", userID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Branding not found"})
+	}
+	if err := db.Delete(&branding).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete branding"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ProfileUpdated,
+		TargetType: "branding",
+		TargetID:   branding.ID,
+		Before:     branding,
+	})
+
+	return c.JSON(fiber.Map{"message": "Branding deleted successfully"})
+}
+
+func getBrandingLogo(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	var branding models.Branding
+	if err := middleware.DB(c).Select("logo", "logo_type").First(&branding, "user_id = ?", userID).Error; err != nil || len(branding.Logo) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Logo not found"})
+	}
+	c.Set(fiber.HeaderContentType, branding.LogoType)
+	return c.Send(branding.Logo)
+}
+
+// uploadBrandingLogo stores the logo shown in the client portal, and on
+// invoices of businesses without a logo of their own
+func uploadBrandingLogo(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	data, contentType, err := readLogo(c)
+	if err != nil {
+		return err
+	}
+
+	var branding models.Branding
+	if err := db.Omit("logo").First(&branding, "user_id = ?", userID).Error; err != nil {
+		branding = models.Branding{ID: models.GenerateBrandingID(), UserID: userID}
+		if err := db.Create(&branding).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to save logo"})
+		}
+	}
+	if err := db.Model(&branding).Updates(map[string]interface{}{"logo": data, "logo_type": contentType}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save logo"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ProfileUpdated,
+		TargetType: "branding",
+		TargetID:   branding.ID,
+		Before:     fiber.Map{"logo_type": branding.LogoType},
+		After:      fiber.Map{"logo_type": contentType, "logo_size": len(data)},
+	})
+
+	return c.JSON(fiber.Map{"message": "Logo updated successfully"})
+}
+
+func deleteBrandingLogo(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	var branding models.Branding
+	if err := db.Omit("logo").First(&branding, "user_id = ?", userID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Branding not found"})
+	}
+	if err := db.Model(&branding).Updates(map[string]interface{}{"logo": nil, "logo_type": ""}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete logo"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ProfileUpdated,
+		TargetType: "branding",
+		TargetID:   branding.ID,
+		Before:     fiber.Map{"logo_type": branding.LogoType},
+	})
+
+	return c.JSON(fiber.Map{"message": "Logo deleted successfully"})
+}
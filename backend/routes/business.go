@@ -0,0 +1,412 @@
+package routes
+
+import (
+	"billow-backend/audit"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"billow-backend/pdf"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// maxLogoSize keeps logos small enough to embed in every invoice PDF
+const maxLogoSize = 512 << 10
+
+type businessProfileResponse struct {
+	models.BusinessProfile
+	HasLogo     bool                `json:"has_logo"`
+	BankAccount *models.BankAccount `json:"bank_account"`
+}
+
+// loadBusiness loads one of the user's businesses, or the default one when
+// businessID is empty
+func loadBusiness(db *gorm.DB, userID, businessID string) (models.BusinessProfile, error) {
+	var business models.BusinessProfile
+	query := db.Where("user_id = ?", userID)
+	if businessID != "" {
+		query = query.Where("id = ?", businessID)
+	} else {
+		query = query.Where("is_default = ?", true)
+	}
+	err := query.First(&business).Error
+	return business, err
+}
+
+// businessName is the name invoices of the business are issued under
+func businessName(db *gorm.DB, user models.User, businessID string) string {
+	if business, err := loadBusiness(db.Select("legal_name", "trading_name"), user.ID, businessID); err == nil && business.DisplayName() != "" {
+		return business.DisplayName()
+	}
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	return user.Email
+}
+
+// businessScope narrows queries to the business in ?business_id=; without
+// one they stay consolidated across all of the user's businesses
+func businessScope(c *fiber.Ctx) (func(*gorm.DB) *gorm.DB, *models.BusinessProfile, error) {
+	businessID := c.Query("business_id")
+	if businessID == "" {
+		return func(db *gorm.DB) *gorm.DB { return db }, nil, nil
+	}
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	business, err := loadBusiness(middleware.DB(c).Omit("logo"), userID, businessID)
+	if err != nil {
+		return nil, nil, fiber.NewError(404, "Business not found")
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("business_id = ?", business.ID)
+	}, &business, nil
+}
+
+// assignBusiness puts a new client or invoice into the requested business,
+// or the default one when none was requested
+func assignBusiness(db *gorm.DB, userID, requested string) (string, error) {
+	business, err := loadBusiness(db.Select("id"), userID, requested)
+	if err == nil {
+		return business.ID, nil
+	}
+	if requested != "" {
+		return "", fiber.NewError(400, "Invalid business selected")
+	}
+	// Users who never set up a business keep working without one
+	return "", nil
+}
+
+func getBusinessProfiles(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	var businesses []models.BusinessProfile
+	if err := db.Where("user_id = ?", userID).Order("is_default DESC, created_at ASC").Find(&businesses).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch businesses"})
+	}
+	response := make([]businessProfileResponse, len(businesses))
+	for i, business := range businesses {
+		response[i] = businessProfileResponse{BusinessProfile: business, HasLogo: len(business.Logo) > 0}
+	}
+	return c.JSON(response)
+}
+
+// getBusinessProfile returns the business in :id, or the default one
+func getBusinessProfile(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	business, err := loadBusiness(db, userID, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Business profile not found"})
+	}
+	response := businessProfileResponse{BusinessProfile: business, HasLogo: len(business.Logo) > 0}
+	if account, err := businessBankAccount(db, userID, business.ID); err == nil {
+		response.BankAccount = &account
+	}
+	return c.JSON(response)
+}
+
+func createBusinessProfile(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	business := models.BusinessProfile{ID: models.GenerateBusinessProfileID(), UserID: userID, NextInvoiceNumber: 1}
+	if _, err := loadBusiness(db.Select("id"), userID, ""); err != nil {
+		business.IsDefault = true
+	}
+	return saveBusinessProfile(c, db, business, nil, 201)
+}
+
+// updateBusinessProfile replaces the business in :id. Without an ID it
+// updates the default business, creating it on first use.
+func updateBusinessProfile(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	id := c.Params("id")
+	business, err := loadBusiness(db, userID, id)
+	if err != nil {
+		if id != "" {
+			return c.Status(404).JSON(fiber.Map{"error": "Business profile not found"})
+		}
+		business = models.BusinessProfile{ID: models.GenerateBusinessProfileID(), UserID: userID, IsDefault: true, NextInvoiceNumber: 1}
+		return saveBusinessProfile(c, db, business, nil, 200)
+	}
+	return saveBusinessProfile(c, db, business, business, 200)
+}
+
+// normalizeAddress trims the parts of an address and checks its country code
+func normalizeAddress(address *models.Address) error {
+	address.Line1 = strings.TrimSpace(address.Line1)
+	address.Line2 = strings.TrimSpace(address.Line2)
+	address.City = strings.TrimSpace(address.City)
+	address.Region = strings.TrimSpace(address.Region)
+	address.PostalCode = strings.TrimSpace(address.PostalCode)
+	address.Country = strings.ToUpper(strings.TrimSpace(address.Country))
+	if address.Country != "" && len(address.Country) != 2 {
+		return fiber.NewError(400, "Country must be an ISO 3166 two-letter code")
+	}
+	return nil
+}
+
+// saveBusinessProfile applies the request body to business and stores it.
+// Bank details can be sent along as bank_account.
+func saveBusinessProfile(c *fiber.Ctx, db *gorm.DB, business models.BusinessProfile, before interface{}, status int) error {
+	var updateData struct {
+		models.BusinessProfile
+		BankAccount *models.BankAccount `json:"bank_account"`
+	}
+	if err := c.BodyParser(&updateData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+	address := updateData.Address
+	currency := strings.ToUpper(strings.TrimSpace(updateData.BaseCurrency))
+	if strings.TrimSpace(updateData.LegalName) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Legal name is required"})
+	}
+	if err := normalizeAddress(&address); err != nil {
+		return err
+	}
+	if currency != "" && len(currency) != 3 {
+		return c.Status(400).JSON(fiber.Map{"error": "Base currency must be an ISO 4217 code"})
+	}
+	if updateData.DefaultPaymentTerms != "" && !updateData.DefaultPaymentTerms.Valid() {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown payment terms"})
+	}
+	if len(updateData.InvoicePrefix) > 20 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invoice prefix must be at most 20 characters"})
+	}
+	// Going back would hand out numbers that were already used
+	if updateData.NextInvoiceNumber != 0 && updateData.NextInvoiceNumber < business.NextInvoiceNumber {
+		return c.Status(400).JSON(fiber.Map{"error": "Invoice numbers can only move forward"})
+	}
+	if updateData.TaxRate < 0 || updateData.TaxRate > 100 {
+		return c.Status(400).JSON(fiber.Map{"error": "Tax rate must be between 0 and 100"})
+	}
+
+	business.LegalName = strings.TrimSpace(updateData.LegalName)
+	business.TradingName = strings.TrimSpace(updateData.TradingName)
+	business.Email = strings.TrimSpace(updateData.Email)
+	business.Phone = strings.TrimSpace(updateData.Phone)
+	business.Website = strings.TrimSpace(updateData.Website)
+	business.Address = address
+	business.RegistrationNumber = strings.TrimSpace(updateData.RegistrationNumber)
+	business.TaxNumber = strings.TrimSpace(updateData.TaxNumber)
+	business.DefaultPaymentTerms = updateData.DefaultPaymentTerms
+	business.FooterNotes = strings.TrimSpace(updateData.FooterNotes)
+	business.BaseCurrency = currency
+	business.InvoicePrefix = strings.TrimSpace(updateData.InvoicePrefix)
+	if updateData.NextInvoiceNumber != 0 {
+		business.NextInvoiceNumber = updateData.NextInvoiceNumber
+	}
+	business.TaxLabel = strings.TrimSpace(updateData.TaxLabel)
+	business.TaxRate = updateData.TaxRate
+	business.TaxInclusive = updateData.TaxInclusive
+
+	// Only one business is the default; it can be moved but not unset
+	if updateData.IsDefault && !business.IsDefault {
+		if err := db.Model(&models.BusinessProfile{}).Where("user_id = ? AND id <> ?", business.UserID, business.ID).
+			Update("is_default", false).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to save business profile"})
+		}
+		business.IsDefault = true
+	}
+	if err := db.Save(&business).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save business profile"})
+	}
+
+	// Clients and invoices from before the first business belong to it
+	if before == nil && business.IsDefault {
+		for _, model := range []interface{}{&models.Client{}, &models.Invoice{}} {
+			if err := db.Model(model).Where("user_id = ? AND COALESCE(business_id, '') = ''", business.UserID).
+				Update("business_id", business.ID).Error; err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to save business profile"})
+			}
+		}
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ProfileUpdated,
+		TargetType: "business_profile",
+		TargetID:   business.ID,
+		Before:     before,
+		After:      business,
+	})
+
+	response := businessProfileResponse{BusinessProfile: business, HasLogo: len(business.Logo) > 0}
+	if updateData.BankAccount != nil {
+		account, err := saveBankAccount(c, db, business.UserID, business.ID, *updateData.BankAccount)
+		if err != nil {
+			return err
+		}
+		response.BankAccount = &account
+	}
+	return c.Status(status).JSON(response)
+}
+
+// deleteBusinessProfile removes a business. One that still has clients or
+// invoices can't be deleted while the user has others to move them to.
+func deleteBusinessProfile(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	business, err := loadBusiness(db, userID, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Business profile not found"})
+	}
+
+	var others []models.BusinessProfile
+	db.Omit("logo").Where("user_id = ? AND id <> ?", userID, business.ID).Order("created_at ASC").Find(&others)
+	var clientCount, invoiceCount int64
+	db.Model(&models.Client{}).Where("business_id = ?", business.ID).Count(&clientCount)
+	db.Model(&models.Invoice{}).Where("business_id = ?", business.ID).Count(&invoiceCount)
+	if len(others) > 0 && clientCount+invoiceCount > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "Move this business's clients and invoices to another business first"})
+	}
+
+	if len(others) == 0 {
+		for _, model := range []interface{}{&models.Client{}, &models.Invoice{}} {
+			if err := db.Model(model).Where("business_id = ?", business.ID).Update("business_id", "").Error; err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to delete business profile"})
+			}
+		}
+	} else if business.IsDefault {
+		if err := db.Model(&others[0]).Update("is_default", true).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to delete business profile"})
+		}
+	}
+	if err := db.Where("user_id = ? AND business_id = ?", userID, business.ID).Delete(&models.BankAccount{}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete business profile"})
+	}
+	if err := db.Delete(&business).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete business profile"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ProfileUpdated,
+		TargetType: "business_profile",
+		TargetID:   business.ID,
+		Before:     business,
+	})
+
+	return c.JSON(fiber.Map{"message": "Business profile deleted successfully"})
+}
+
+func getBusinessLogo(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	business, err := loadBusiness(middleware.DB(c).Select("logo", "logo_type"), userID, c.Params("id"))
+	if err != nil || len(business.Logo) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Logo not found"})
+	}
+	c.Set(fiber.HeaderContentType, business.LogoType)
+	return c.Send(business.Logo)
+}
+
+// readLogo reads the PNG or JPEG sent as the "logo" form file
+func readLogo(c *fiber.Ctx) ([]byte, string, error) {
+	file, err := c.FormFile("logo")
+	if err != nil {
+		return nil, "", fiber.NewError(400, "Logo file is required")
+	}
+	if file.Size > maxLogoSize {
+		return nil, "", fiber.NewError(400, "Logo must be at most 512 KB")
+	}
+	f, err := file.Open()
+	if err != nil {
+		return nil, "", fiber.NewError(400, "Logo file is required")
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxLogoSize+1))
+	if err != nil || len(data) > maxLogoSize {
+		return nil, "", fiber.NewError(400, "Logo must be at most 512 KB")
+	}
+	contentType := http.DetectContentType(data)
+	if contentType != "image/png" && contentType != "image/jpeg" {
+		return nil, "", fiber.NewError(400, "Logo must be a PNG or JPEG image")
+	}
+	if _, err := pdf.LoadImage(data); err != nil {
+		return nil, "", fiber.NewError(400, "Logo could not be read")
+	}
+	return data, contentType, nil
+}
+
+// uploadBusinessLogo stores the logo printed on the business's invoices
+func uploadBusinessLogo(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	business, err := loadBusiness(db.Omit("logo"), userID, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Create your business profile first"})
+	}
+	data, contentType, err := readLogo(c)
+	if err != nil {
+		return err
+	}
+	if err := db.Model(&business).Updates(map[string]interface{}{"logo": data, "logo_type": contentType}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save logo"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ProfileUpdated,
+		TargetType: "business_profile",
+		TargetID:   business.ID,
+		Before:     fiber.Map{"logo_type": business.LogoType},
+		After:      fiber.Map{"logo_type": contentType, "logo_size": len(data)},
+	})
+
+	return c.JSON(fiber.Map{"message": "Logo updated successfully"})
+}
+
+func deleteBusinessLogo(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	business, err := loadBusiness(db.Omit("logo"), userID, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Business profile not found"})
+	}
+	if err := db.Model(&business).Updates(map[string]interface{}{"logo": nil, "logo_type": ""}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete logo"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ProfileUpdated,
+		TargetType: "business_profile",
+		TargetID:   business.ID,
+		Before:     fiber.Map{"logo_type": business.LogoType},
+	})
+
+	return c.JSON(fiber.Map{"message": "Logo deleted successfully"})
+}
@@ -0,0 +1,84 @@
+//go:build integration
+
+package routes
+
+import (
+	"billow-backend/models"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Each business numbers its own invoices, applies its own tax settings and
+// scopes listings to itself
+func TestInvoicesPerBusiness(t *testing.T) {
+	db := openTestDB(t)
+	seed(t, db,
+		&models.Plan{ID: "PLN-BE", Code: "PLN-BE", Name: "Business entities", Interval: "month", InvoiceLimit: -1, ClientLimit: -1},
+		&models.User{ID: "USR-BE", ClerkID: "clerk_be", Email: "owner@business.test"},
+		&models.User{ID: "USR-BE-OTHER", ClerkID: "clerk_be_other", Email: "other@business.test"},
+		&models.Subscription{ID: "SUB-BE", UserID: "USR-BE", PlanID: "PLN-BE", Status: "active", CurrentPeriodEnd: time.Now().AddDate(0, 1, 0)},
+		&models.BusinessProfile{ID: "BIZ-BE-A", UserID: "USR-BE", IsDefault: true, LegalName: "A Ltd", InvoicePrefix: "A-", NextInvoiceNumber: 1},
+		&models.BusinessProfile{ID: "BIZ-BE-B", UserID: "USR-BE", LegalName: "B GmbH", InvoicePrefix: "B-", NextInvoiceNumber: 7, TaxLabel: "VAT", TaxRate: 20},
+		&models.BusinessProfile{ID: "BIZ-BE-OTHER", UserID: "USR-BE-OTHER", IsDefault: true, LegalName: "Other Inc"},
+		&models.Client{ID: "CLI-BE-A", UserID: "USR-BE", BusinessID: "BIZ-BE-A", Name: "Client of A"},
+		&models.Client{ID: "CLI-BE-B", UserID: "USR-BE", BusinessID: "BIZ-BE-B", Name: "Client of B"},
+	)
+	t.Cleanup(func() { db.Where("user_id = ?", "USR-BE").Delete(&models.Invoice{}) })
+
+	app := setupApp()
+	request := func(method, path, body string, out interface{}) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", "USR-BE")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+	create := func(clientID string) models.Invoice {
+		t.Helper()
+		var invoice models.Invoice
+		body := `{"client_id":"` + clientID + `","invoice_date":"2026-01-01","due_date":"2026-01-31","amount":100}`
+		if status := request("POST", "/api/invoices", body, &invoice); status != 200 {
+			t.Fatalf("create invoice for %s = %d", clientID, status)
+		}
+		return invoice
+	}
+
+	a1, a2, b := create("CLI-BE-A"), create("CLI-BE-A"), create("CLI-BE-B")
+	if a1.Number != "A-0001" || a2.Number != "A-0002" || b.Number != "B-0007" {
+		t.Errorf("numbers = %s, %s, %s; want A-0001, A-0002, B-0007", a1.Number, a2.Number, b.Number)
+	}
+	var business models.BusinessProfile
+	db.First(&business, "id = ?", "BIZ-BE-B")
+	if business.NextInvoiceNumber != 8 {
+		t.Errorf("B's next number = %d, want 8", business.NextInvoiceNumber)
+	}
+
+	// B charges VAT on top of net amounts; A has no tax settings
+	if len(b.TaxRates) != 1 || b.TaxRates[0].Label != "VAT" || b.TaxRates[0].Rate != 20 || b.TaxesIncluded() || b.Amount != 120 {
+		t.Errorf("B's invoice: amount %v, taxes %+v, included %v; want 120 with VAT 20%% added", b.Amount, b.TaxRates, b.TaxesIncluded())
+	}
+	if len(a1.TaxRates) != 0 || !a1.TaxesIncluded() || a1.Amount != 100 {
+		t.Errorf("A's invoice: amount %v, taxes %+v, included %v", a1.Amount, a1.TaxRates, a1.TaxesIncluded())
+	}
+
+	var invoices []models.Invoice
+	if status := request("GET", "/api/invoices?business_id=BIZ-BE-B", "", &invoices); status != 200 || len(invoices) != 1 || invoices[0].ID != b.ID {
+		t.Errorf("B's invoices = %d, %d invoices", status, len(invoices))
+	}
+	if status := request("GET", "/api/invoices", "", &invoices); status != 200 || len(invoices) != 3 {
+		t.Errorf("all invoices = %d, %d invoices", status, len(invoices))
+	}
+	if status := request("GET", "/api/invoices?business_id=BIZ-BE-OTHER", "", nil); status != 404 {
+		t.Errorf("another user's business = %d, want 404", status)
+	}
+}
@@ -1,24 +1,36 @@
 package routes
 
 import (
-	"billow-backend/config"
+	"billow-backend/audit"
+	"billow-backend/billing"
+	"billow-backend/i18n"
 	"billow-backend/middleware"
 	"billow-backend/models"
+	"billow-backend/policy"
+	"billow-backend/usage"
 	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 func SetupClientRoutes(app *fiber.App) {
 	// Apply auth middleware to all client routes
-	clients := app.Group("/api/clients", middleware.AuthMiddleware())
-	
-	clients.Post("/", createClient)
-	clients.Get("/", getClients)
-	clients.Get("/:id", getClient)
-	clients.Put("/:id", updateClient)
-	clients.Delete("/:id", deleteClient)
-	clients.Get("/:id/revenue-data", getClientRevenueData)
+	clients := app.Group("/api/clients", middleware.AuthMiddleware(), middleware.TenantTransaction())
+
+	clients.Post("/", middleware.Authorize(policy.ClientCreate), middleware.RequireSubscription(), middleware.TrackUsage(usage.ClientCreated), createClient)
+	clients.Get("/", middleware.Authorize(policy.ClientRead), getClients)
+	clients.Get("/:id", middleware.Authorize(policy.ClientRead), getClient)
+	clients.Put("/:id", middleware.Authorize(policy.ClientUpdate), updateClient)
+	clients.Delete("/:id", middleware.Authorize(policy.ClientDelete), deleteClient)
+	clients.Get("/:id/revenue-data", middleware.Authorize(policy.ClientRead), getClientRevenueData)
+	clients.Get("/:id/statement", middleware.Authorize(policy.ClientRead), getClientStatement)
+	clients.Post("/:id/statement/send", middleware.Authorize(policy.ClientStatement), middleware.TrackUsage(usage.MessageSent), sendClientStatement)
+	clients.Get("/:id/contacts", middleware.Authorize(policy.ClientRead), getClientContacts)
+	clients.Post("/:id/contacts", middleware.Authorize(policy.ClientUpdate), createClientContact)
+	clients.Put("/:id/contacts/:contactId", middleware.Authorize(policy.ClientUpdate), updateClientContact)
+	clients.Delete("/:id/contacts/:contactId", middleware.Authorize(policy.ClientUpdate), deleteClientContact)
 }
 
 func createClient(c *fiber.Ctx) error {
@@ -26,22 +38,52 @@ func createClient(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
+	db := middleware.DB(c)
 
 	client := new(models.Client)
 	if err := c.BodyParser(client); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
 
+	if err := reserveQuota(c, db, billing.ClientQuota); err != nil {
+		return err
+	}
+
 	// Generate unique client ID and set user ID
 	client.ID = models.GenerateClientID()
 	client.UserID = userID
+	if client.BusinessID, err = assignBusiness(db, userID, client.BusinessID); err != nil {
+		return err
+	}
+	if err := checkTemplateID(db, userID, client.TemplateID); err != nil {
+		return err
+	}
+	if err := normalizeLanguage(&client.Language); err != nil {
+		return err
+	}
+	if err := normalizeClientAddresses(client); err != nil {
+		return err
+	}
+	if err := normalizeClientDefaults(client); err != nil {
+		return err
+	}
+	// Contacts sent along are created with the client
+	for i := range client.Contacts {
+		contact := &client.Contacts[i]
+		if err := normalizeContact(contact); err != nil {
+			return err
+		}
+		contact.ID = models.GenerateClientContactID()
+		contact.UserID = userID
+		contact.ClientID = client.ID
+	}
 
 	// Calculate average invoice if invoice count > 0
 	if client.InvoiceCount > 0 {
 		client.AverageInvoice = client.TotalInvoiced / float64(client.InvoiceCount)
 	}
 
-	if err := config.DB.Create(&client).Error; err != nil {
+	if err := db.Create(&client).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to create client"})
 	}
 
@@ -49,49 +91,50 @@ func createClient(c *fiber.Ctx) error {
 }
 
 func getClients(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
+	subject, err := middleware.GetSubjectFromContext(c)
 	if err != nil {
 		return err
 	}
+	db := middleware.DB(c)
 
 	var clients []models.Client
-	
+
 	// Get query parameters for search and filtering
 	search := c.Query("search", "")
-	
-	query := config.DB.Where("user_id = ?", userID).Order("created_at DESC")
-	
+	scope, _, err := businessScope(c)
+	if err != nil {
+		return err
+	}
+
+	query := db.Scopes(policy.OwnedBy(subject), scope).Order("created_at DESC")
+
 	if search != "" {
 		query = query.Where("name ILIKE ? OR email ILIKE ?", "%"+search+"%", "%"+search+"%")
 	}
-	
+
 	if err := query.Find(&clients).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch clients"})
 	}
 
 	// Update client statistics based on their invoices
 	for i := range clients {
-		updateClientStatistics(&clients[i])
+		updateClientStatistics(db, &clients[i])
 	}
 
 	return c.JSON(clients)
 }
 
 func getClient(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
-	if err != nil {
-		return err
-	}
-
 	id := c.Params("id")
 	var client models.Client
 
-	if err := config.DB.Where("id = ? AND user_id = ?", id, userID).First(&client).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Client not found"})
+	if err := findOwned(c, policy.ClientRead, &client, id); err != nil {
+		return err
 	}
 
 	// Update statistics
-	updateClientStatistics(&client)
+	updateClientStatistics(middleware.DB(c), &client)
+	loadContacts(middleware.DB(c), &client)
 
 	return c.JSON(client)
 }
@@ -101,65 +144,108 @@ func updateClient(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
+	db := middleware.DB(c)
 
 	id := c.Params("id")
 	var client models.Client
 
-	if err := config.DB.Where("id = ? AND user_id = ?", id, userID).First(&client).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Client not found"})
+	if err := findOwned(c, policy.ClientUpdate, &client, id); err != nil {
+		return err
 	}
 
+	businessID := client.BusinessID
 	if err := c.BodyParser(&client); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Ensure user ID doesn't change
+	// Ensure the body can't move the record to another ID or tenant
+	client.ID = id
 	client.UserID = userID
 
+	// Invoices stay with the business that issued them, so only clients
+	// without any can move
+	if client.BusinessID == "" {
+		client.BusinessID = businessID
+	} else if client.BusinessID != businessID {
+		if _, err := assignBusiness(db, userID, client.BusinessID); err != nil {
+			return err
+		}
+		var invoiceCount int64
+		db.Model(&models.Invoice{}).Where("client_id = ?", client.ID).Count(&invoiceCount)
+		if invoiceCount > 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Cannot move a client with existing invoices to another business"})
+		}
+	}
+
+	if err := checkTemplateID(db, userID, client.TemplateID); err != nil {
+		return err
+	}
+	if err := normalizeLanguage(&client.Language); err != nil {
+		return err
+	}
+	if err := normalizeClientAddresses(&client); err != nil {
+		return err
+	}
+	if err := normalizeClientDefaults(&client); err != nil {
+		return err
+	}
+
 	// Recalculate average invoice
 	if client.InvoiceCount > 0 {
 		client.AverageInvoice = client.TotalInvoiced / float64(client.InvoiceCount)
 	}
 
-	if err := config.DB.Save(&client).Error; err != nil {
+	// Contacts are changed through their own endpoints
+	if err := db.Omit("Contacts").Save(&client).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update client"})
 	}
+	loadContacts(db, &client)
 
 	return c.JSON(client)
 }
 
 func deleteClient(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
-	if err != nil {
+	db := middleware.DB(c)
+	id := c.Params("id")
+	var client models.Client
+
+	if err := findOwned(c, policy.ClientDelete, &client, id); err != nil {
 		return err
 	}
 
-	id := c.Params("id")
-	
 	// Check if client has invoices
 	var invoiceCount int64
-	config.DB.Model(&models.Invoice{}).Where("client_id = ? AND user_id = ?", id, userID).Count(&invoiceCount)
-	
+	db.Model(&models.Invoice{}).Where("client_id = ?", client.ID).Count(&invoiceCount)
+
 	if invoiceCount > 0 {
 		return c.Status(400).JSON(fiber.Map{"error": "Cannot delete client with existing invoices"})
 	}
-	
-	if err := config.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Client{}).Error; err != nil {
+
+	if err := db.Delete(&client).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete client"})
 	}
 
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ClientDeleted,
+		TargetType: "client",
+		TargetID:   client.ID,
+		Before:     client,
+	})
+
 	return c.JSON(fiber.Map{"message": "Client deleted successfully"})
 }
 
 func getClientRevenueData(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
-	if err != nil {
+	db := middleware.DB(c)
+	id := c.Params("id")
+	var client models.Client
+
+	if err := findOwned(c, policy.ClientRead, &client, id); err != nil {
 		return err
 	}
 
-	id := c.Params("id")
 	months := c.Query("months", "7") // Default to 7 months
-	
+
 	monthsInt, err := strconv.Atoi(months)
 	if err != nil {
 		monthsInt = 7
@@ -167,7 +253,7 @@ func getClientRevenueData(c *fiber.Ctx) error {
 
 	// Get actual revenue data from paid invoices
 	var invoices []models.Invoice
-	if err := config.DB.Where("client_id = ? AND user_id = ? AND status = 'paid'", id, userID).
+	if err := db.Where("client_id = ? AND status = 'paid'", client.ID).
 		Order("invoice_date DESC").
 		Limit(monthsInt).
 		Find(&invoices).Error; err != nil {
@@ -195,9 +281,9 @@ func getClientRevenueData(c *fiber.Ctx) error {
 }
 
 // Helper function to update client statistics based on their invoices
-func updateClientStatistics(client *models.Client) {
+func updateClientStatistics(db *gorm.DB, client *models.Client) {
 	var invoices []models.Invoice
-	config.DB.Where("client_id = ? AND user_id = ?", client.ID, client.UserID).Find(&invoices)
+	db.Where("client_id = ?", client.ID).Find(&invoices)
 
 	totalInvoiced := 0.0
 	totalPaid := 0.0
@@ -219,10 +305,59 @@ func updateClientStatistics(client *models.Client) {
 	}
 
 	// Update in database
-	config.DB.Model(client).Updates(map[string]interface{}{
+	db.Model(client).Updates(map[string]interface{}{
 		"total_invoiced":  totalInvoiced,
 		"total_paid":      totalPaid,
 		"invoice_count":   invoiceCount,
 		"average_invoice": client.AverageInvoice,
 	})
-}
\ No newline at end of file
+}
+
+// normalizeClientAddresses trims and checks the client's billing and shipping
+// addresses
+func normalizeClientAddresses(client *models.Client) error {
+	if err := normalizeAddress(&client.BillingAddress); err != nil {
+		return err
+	}
+	return normalizeAddress(&client.ShippingAddress)
+}
+
+// normalizeClientDefaults checks the defaults the client's invoices start from
+func normalizeClientDefaults(client *models.Client) error {
+	client.DefaultCurrency = strings.ToUpper(strings.TrimSpace(client.DefaultCurrency))
+	if client.DefaultCurrency != "" && len(client.DefaultCurrency) != 3 {
+		return fiber.NewError(400, "Currency must be an ISO 4217 code")
+	}
+	if client.PaymentTerms != "" && !client.PaymentTerms.Valid() {
+		return fiber.NewError(400, "Unknown payment terms")
+	}
+	client.DefaultNotes = strings.TrimSpace(client.DefaultNotes)
+	return normalizeTaxRates(client.DefaultTaxRates)
+}
+
+// normalizeTaxRates trims the labels of the rates and checks them
+func normalizeTaxRates(rates models.TaxRates) error {
+	for i := range rates {
+		rates[i].Label = strings.TrimSpace(rates[i].Label)
+		if rates[i].Label == "" {
+			return fiber.NewError(400, "Tax label is required")
+		}
+		if rates[i].Rate < 0 || rates[i].Rate > 100 {
+			return fiber.NewError(400, "Tax rate must be between 0 and 100")
+		}
+	}
+	return nil
+}
+
+// normalizeLanguage cleans up a language setting; empty leaves it unset
+func normalizeLanguage(language *string) error {
+	if *language == "" {
+		return nil
+	}
+	normalized, ok := i18n.Normalize(*language)
+	if !ok {
+		return fiber.NewError(400, "Invalid language")
+	}
+	*language = normalized
+	return nil
+}
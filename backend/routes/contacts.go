@@ -0,0 +1,129 @@
+package routes
+
+import (
+	"billow-backend/audit"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"billow-backend/policy"
+	"net/mail"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// normalizeContact trims the contact's details and checks them
+func normalizeContact(contact *models.ClientContact) error {
+	contact.Name = strings.TrimSpace(contact.Name)
+	contact.Role = strings.TrimSpace(contact.Role)
+	contact.Email = strings.TrimSpace(contact.Email)
+	contact.Phone = strings.TrimSpace(contact.Phone)
+	if contact.Name == "" && contact.Email == "" {
+		return fiber.NewError(400, "Contact name or email is required")
+	}
+	if contact.Email != "" {
+		if addr, err := mail.ParseAddress(contact.Email); err != nil || addr.Address != contact.Email {
+			return fiber.NewError(400, "Invalid contact email")
+		}
+	}
+	if contact.IsBillingContact && contact.Email == "" {
+		return fiber.NewError(400, "Billing contacts need an email address")
+	}
+	return nil
+}
+
+// loadContacts fills in the client's contacts, billing contacts first
+func loadContacts(db *gorm.DB, client *models.Client) error {
+	return db.Where("client_id = ?", client.ID).
+		Order("is_billing_contact DESC, created_at").
+		Find(&client.Contacts).Error
+}
+
+// findContact loads a contact of the client in the URL
+func findContact(c *fiber.Ctx, action policy.Action) (models.Client, models.ClientContact, error) {
+	var client models.Client
+	var contact models.ClientContact
+	if err := findOwned(c, action, &client, c.Params("id")); err != nil {
+		return client, contact, err
+	}
+	if err := middleware.DB(c).Where("id = ? AND client_id = ?", c.Params("contactId"), client.ID).First(&contact).Error; err != nil {
+		return client, contact, fiber.NewError(404, "Contact not found")
+	}
+	return client, contact, nil
+}
+
+func getClientContacts(c *fiber.Ctx) error {
+	var client models.Client
+	if err := findOwned(c, policy.ClientRead, &client, c.Params("id")); err != nil {
+		return err
+	}
+	if err := loadContacts(middleware.DB(c), &client); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch contacts"})
+	}
+	return c.JSON(client.Contacts)
+}
+
+func createClientContact(c *fiber.Ctx) error {
+	var client models.Client
+	if err := findOwned(c, policy.ClientUpdate, &client, c.Params("id")); err != nil {
+		return err
+	}
+
+	var contact models.ClientContact
+	if err := c.BodyParser(&contact); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+	if err := normalizeContact(&contact); err != nil {
+		return err
+	}
+	contact.ID = models.GenerateClientContactID()
+	contact.UserID = client.UserID
+	contact.ClientID = client.ID
+
+	if err := middleware.DB(c).Create(&contact).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create contact"})
+	}
+	return c.Status(201).JSON(contact)
+}
+
+func updateClientContact(c *fiber.Ctx) error {
+	_, contact, err := findContact(c, policy.ClientUpdate)
+	if err != nil {
+		return err
+	}
+
+	id, userID, clientID := contact.ID, contact.UserID, contact.ClientID
+	if err := c.BodyParser(&contact); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+	contact.ID, contact.UserID, contact.ClientID = id, userID, clientID
+	if err := normalizeContact(&contact); err != nil {
+		return err
+	}
+
+	if err := middleware.DB(c).Save(&contact).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update contact"})
+	}
+	return c.JSON(contact)
+}
+
+func deleteClientContact(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+	client, contact, err := findContact(c, policy.ClientUpdate)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Delete(&contact).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete contact"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ClientUpdated,
+		TargetType: "client",
+		TargetID:   client.ID,
+		Before:     contact,
+	})
+
+	return c.JSON(fiber.Map{"message": "Contact deleted successfully"})
+}
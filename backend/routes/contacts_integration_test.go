@@ -0,0 +1,102 @@
+//go:build integration
+
+package routes
+
+import (
+	"billow-backend/mailer"
+	"billow-backend/models"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type captureMailer chan mailer.Message
+
+func (m captureMailer) Send(msg mailer.Message) error {
+	m <- msg
+	return nil
+}
+
+// captureMail collects the emails sent during the test
+func captureMail(t *testing.T) captureMailer {
+	sent := make(captureMailer, 10)
+	defaultMailer := mailer.Default
+	mailer.Default = sent
+	t.Cleanup(func() { mailer.Default = defaultMailer })
+	return sent
+}
+
+func TestClientContacts(t *testing.T) {
+	db := openTestDB(t)
+	seed(t, db,
+		&models.User{ID: "USR-CC", ClerkID: "clerk_cc", Email: "owner@contacts.test"},
+		&models.Client{ID: "CLI-CC", UserID: "USR-CC", Name: "Contacts client", Email: "front-desk@client.test"},
+	)
+	t.Cleanup(func() { db.Where("client_id = ?", "CLI-CC").Delete(&models.ClientContact{}) })
+	t.Cleanup(func() { db.Where("client_id = ?", "CLI-CC").Delete(&models.PortalLink{}) })
+	sent := captureMail(t)
+
+	app := setupApp()
+	request := func(method, path, body string, out interface{}) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", "USR-CC")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+
+	var billing, sales models.ClientContact
+	if status := request("POST", "/api/clients/CLI-CC/contacts", `{"name":"Jane","email":"Jane@Client.test","is_billing_contact":true}`, &billing); status != 201 {
+		t.Fatalf("create billing contact = %d", status)
+	}
+	if status := request("POST", "/api/clients/CLI-CC/contacts", `{"name":"Sam","email":"sam@client.test"}`, &sales); status != 201 {
+		t.Fatalf("create contact = %d", status)
+	}
+	if status := request("POST", "/api/clients/CLI-CC/contacts", `{"name":"Bob","is_billing_contact":true}`, nil); status != 400 {
+		t.Errorf("billing contact without email = %d, want 400", status)
+	}
+	if status := request("PUT", "/api/clients/CLI-CC/contacts/"+sales.ID, `{"id":"CON-OTHER","client_id":"CLI-OTHER","name":"Sam","role":"Sales","email":"sam@client.test"}`, &sales); status != 200 || sales.ClientID != "CLI-CC" || sales.Role != "Sales" {
+		t.Errorf("update contact = %d, %+v", status, sales)
+	}
+
+	var contacts []models.ClientContact
+	if status := request("GET", "/api/clients/CLI-CC/contacts", "", &contacts); status != 200 || len(contacts) != 2 || contacts[0].ID != billing.ID {
+		t.Errorf("contacts = %d, %+v; want the billing contact first", status, contacts)
+	}
+
+	// Billing contacts can sign in to the portal; other contacts can't
+	for _, email := range []string{"jane@client.test", "sam@client.test"} {
+		if status := request("POST", "/api/portal/login", `{"email":"`+email+`"}`, nil); status != 202 {
+			t.Errorf("portal login for %s = %d", email, status)
+		}
+	}
+	select {
+	case msg := <-sent:
+		if len(msg.To) != 1 || msg.To[0] != "jane@client.test" || !strings.Contains(msg.Body, "/portal?token=") {
+			t.Errorf("sign-in email to %v: %s", msg.To, msg.Body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no sign-in link sent to the billing contact")
+	}
+	select {
+	case msg := <-sent:
+		t.Errorf("sign-in link sent to %v, who is not a billing contact", msg.To)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if status := request("DELETE", "/api/clients/CLI-CC/contacts/"+billing.ID, "", nil); status != 200 {
+		t.Errorf("delete contact = %d", status)
+	}
+	if status := request("DELETE", "/api/clients/CLI-CC/contacts/"+billing.ID, "", nil); status != 404 {
+		t.Errorf("delete deleted contact = %d, want 404", status)
+	}
+}
@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"billow-backend/models"
+	"testing"
+)
+
+func TestNormalizeContact(t *testing.T) {
+	tests := []struct {
+		name    string
+		contact models.ClientContact
+		valid   bool
+	}{
+		{"name only", models.ClientContact{Name: " Jane "}, true},
+		{"billing contact", models.ClientContact{Email: " jane@acme.test ", IsBillingContact: true}, true},
+		{"nothing to reach", models.ClientContact{Role: "Accounts payable"}, false},
+		{"display name in the email", models.ClientContact{Email: "Jane <jane@acme.test>"}, false},
+		{"invalid email", models.ClientContact{Email: "jane@"}, false},
+		{"billing contact without email", models.ClientContact{Name: "Jane", IsBillingContact: true}, false},
+	}
+	for _, tt := range tests {
+		contact := tt.contact
+		err := normalizeContact(&contact)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: err = %v, want valid %v", tt.name, err, tt.valid)
+		}
+		if err == nil && (contact.Name != "" && contact.Name != "Jane" || contact.Email != "" && contact.Email != "jane@acme.test") {
+			t.Errorf("%s: not trimmed: %+v", tt.name, contact)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package routes
+
+import (
+	"billow-backend/audit"
+	"billow-backend/billing"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// discountResponse describes a discount and what it makes the plan cost
+func discountResponse(discount *models.Discount, plan models.Plan) fiber.Map {
+	return fiber.Map{
+		"id":               discount.ID,
+		"coupon":           discount.Coupon,
+		"starts_at":        discount.StartsAt,
+		"ends_at":          discount.EndsAt,
+		"price":            plan.Price,
+		"discounted_price": billing.DiscountedPrice(plan, discount.Coupon),
+		"currency":         plan.Currency,
+	}
+}
+
+// redeemPromotionCode applies a promotion code to the user's subscription
+func redeemPromotionCode(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	var redeemData struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&redeemData); err != nil || strings.TrimSpace(redeemData.Code) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Promotion code is required"})
+	}
+
+	var subscription models.Subscription
+	if err := db.Preload("Plan").First(&subscription, "user_id = ?", userID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Subscription not found"})
+	}
+	if !subscription.HasAccess(time.Now()) {
+		return c.Status(400).JSON(fiber.Map{"error": "Subscription is not active", "status": subscription.Status})
+	}
+
+	discount, err := billing.Redeem(db, subscription, redeemData.Code, time.Now())
+	switch {
+	case errors.Is(err, billing.ErrCodeNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Invalid promotion code"})
+	case errors.Is(err, billing.ErrCodeExpired), errors.Is(err, billing.ErrCodeExhausted),
+		errors.Is(err, billing.ErrCodeNotApplicable), errors.Is(err, billing.ErrAlreadyDiscounted):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to redeem promotion code"})
+	}
+
+	// Subscriptions billed by the provider get the discount there too
+	if subscription.ProviderSubscriptionID != "" && billing.DefaultProvider != nil {
+		if discount.Coupon.ProviderCouponID == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "promotion code does not apply to this subscription"})
+		}
+		if err := billing.DefaultProvider.ApplyCoupon(c.UserContext(), subscription.ProviderSubscriptionID, discount.Coupon.ProviderCouponID); err != nil {
+			fmt.Printf("Applying coupon failed for %s: %v\n", subscription.ID, err)
+			return c.Status(502).JSON(fiber.Map{"error": "Failed to apply discount with the payment provider"})
+		}
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.SubscriptionChanged,
+		TargetType: "discount",
+		TargetID:   discount.ID,
+		After:      discount,
+	})
+
+	return c.JSON(fiber.Map{
+		"message":  "Promotion code applied",
+		"discount": discountResponse(discount, subscription.Plan),
+	})
+}
+
+func getCoupons(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+
+	var coupons []models.Coupon
+	if err := db.Order("created_at DESC").Find(&coupons).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch coupons"})
+	}
+
+	var codes []models.PromotionCode
+	if err := db.Order("created_at DESC").Find(&codes).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch promotion codes"})
+	}
+
+	return c.JSON(fiber.Map{"coupons": coupons, "promotion_codes": codes})
+}
+
+func createCoupon(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+
+	var couponData struct {
+		models.Coupon
+		ProviderCouponID string `json:"provider_coupon_id"`
+	}
+	if err := c.BodyParser(&couponData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+	coupon := couponData.Coupon
+	coupon.ProviderCouponID = couponData.ProviderCouponID
+
+	switch {
+	case (coupon.PercentOff > 0) == (coupon.AmountOff > 0):
+		return c.Status(400).JSON(fiber.Map{"error": "Set either percent_off or amount_off"})
+	case coupon.PercentOff < 0 || coupon.PercentOff > 100 || coupon.AmountOff < 0:
+		return c.Status(400).JSON(fiber.Map{"error": "Discount out of range"})
+	case coupon.Duration == models.CouponRepeating && coupon.DurationInMonths <= 0:
+		return c.Status(400).JSON(fiber.Map{"error": "Repeating coupons need duration_in_months"})
+	case coupon.Duration != models.CouponOnce && coupon.Duration != models.CouponRepeating && coupon.Duration != models.CouponForever:
+		return c.Status(400).JSON(fiber.Map{"error": "Duration must be once, repeating or forever"})
+	}
+
+	coupon.ID = models.GenerateCouponID()
+	coupon.TimesRedeemed = 0
+	if coupon.Currency == "" {
+		coupon.Currency = "USD"
+	}
+
+	if err := db.Create(&coupon).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create coupon"})
+	}
+
+	return c.Status(201).JSON(coupon)
+}
+
+func createPromotionCode(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+
+	var coupon models.Coupon
+	if err := db.First(&coupon, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Coupon not found"})
+	}
+
+	var code models.PromotionCode
+	if err := c.BodyParser(&code); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+	code.Code = strings.ToUpper(strings.TrimSpace(code.Code))
+	if code.Code == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Code is required"})
+	}
+
+	code.ID = models.GeneratePromotionCodeID()
+	code.CouponID = coupon.ID
+	code.Active = true
+	code.TimesRedeemed = 0
+
+	var existing int64
+	db.Model(&models.PromotionCode{}).Where("code = ?", code.Code).Count(&existing)
+	if existing > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "Code already exists"})
+	}
+
+	if err := db.Omit("Coupon").Create(&code).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create promotion code"})
+	}
+
+	code.Coupon = coupon
+	return c.Status(201).JSON(code)
+}
+
+// updatePromotionCode changes a code's limits or deactivates it
+func updatePromotionCode(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+
+	var code models.PromotionCode
+	if err := db.First(&code, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Promotion code not found"})
+	}
+
+	var updateData struct {
+		Active         *bool      `json:"active"`
+		MaxRedemptions *int       `json:"max_redemptions"`
+		ExpiresAt      *time.Time `json:"expires_at"`
+	}
+	if err := c.BodyParser(&updateData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+
+	if updateData.Active != nil {
+		code.Active = *updateData.Active
+	}
+	if updateData.MaxRedemptions != nil {
+		code.MaxRedemptions = *updateData.MaxRedemptions
+	}
+	if updateData.ExpiresAt != nil {
+		code.ExpiresAt = updateData.ExpiresAt
+	}
+
+	if err := db.Omit("Coupon").Save(&code).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update promotion code"})
+	}
+
+	return c.JSON(code)
+}
@@ -1,24 +1,25 @@
 package routes
 
 import (
-	"billow-backend/config"
 	"billow-backend/middleware"
 	"billow-backend/models"
+	"billow-backend/policy"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 func SetupDashboardRoutes(app *fiber.App) {
 	// Apply auth middleware to all dashboard routes
-	dashboard := app.Group("/api/dashboard", middleware.AuthMiddleware())
+	dashboard := app.Group("/api/dashboard", middleware.AuthMiddleware(), middleware.TenantTransaction())
 
-	dashboard.Get("/kpi", getDashboardKPI)
-	dashboard.Get("/revenue-chart", getRevenueChart)
-	dashboard.Get("/top-clients", getTopClients)
-	dashboard.Get("/recent-invoices", getRecentInvoices)
-	dashboard.Get("/reports-summary", getReportsSummary)
+	dashboard.Get("/kpi", middleware.Authorize(policy.DashboardRead), getDashboardKPI)
+	dashboard.Get("/revenue-chart", middleware.Authorize(policy.DashboardRead), getRevenueChart)
+	dashboard.Get("/top-clients", middleware.Authorize(policy.DashboardRead), getTopClients)
+	dashboard.Get("/recent-invoices", middleware.Authorize(policy.DashboardRead), getRecentInvoices)
+	dashboard.Get("/reports-summary", middleware.Authorize(policy.DashboardRead), getReportsSummary)
 }
 
 // Currency conversion rates to USD (updated regularly in production)
@@ -46,28 +47,54 @@ func convertToUSD(amount float64, currency string) float64 {
 	return amount * rate
 }
 
-// KPI Data structure - all amounts in USD
+// report is what a dashboard covers: one business from ?business_id=, in its
+// base currency, or all of them consolidated in USD
+type report struct {
+	scope    func(*gorm.DB) *gorm.DB
+	currency string
+}
+
+func reportFor(c *fiber.Ctx) (report, error) {
+	scope, business, err := businessScope(c)
+	if err != nil {
+		return report{}, err
+	}
+	r := report{scope: scope, currency: "USD"}
+	if business != nil {
+		if _, known := currencyRates[business.BaseCurrency]; known {
+			r.currency = business.BaseCurrency
+		}
+	}
+	return r, nil
+}
+
+// convert turns an amount into the report currency
+func (r report) convert(amount float64, currency string) float64 {
+	return convertToUSD(amount, currency) / currencyRates[r.currency]
+}
+
+// KPI Data structure - all amounts in the report currency
 type KPIData struct {
 	TotalInvoiced   float64 `json:"total_invoiced"`
 	TotalPaid       float64 `json:"total_paid"`
 	Outstanding     float64 `json:"outstanding"`
 	ClientCount     int64   `json:"client_count"`
-	PrimaryCurrency string  `json:"primary_currency"` // Always "USD"
+	PrimaryCurrency string  `json:"primary_currency"` // USD, or the business's base currency
 }
 
-// Revenue Chart Data - all amounts in USD
+// Revenue Chart Data - all amounts in the report currency
 type RevenueChartData struct {
 	Month   string  `json:"month"`
 	Revenue float64 `json:"revenue"`
 }
 
-// Top Client Data - all amounts in USD
+// Top Client Data - all amounts in the report currency
 type TopClientData struct {
 	Name    string  `json:"name"`
 	Revenue float64 `json:"revenue"`
 }
 
-// Reports Summary Data - all amounts in USD
+// Reports Summary Data - all amounts in the report currency
 type ReportsSummaryData struct {
 	TotalRevenue     float64 `json:"total_revenue"`
 	CollectionRate   float64 `json:"collection_rate"`
@@ -76,47 +103,57 @@ type ReportsSummaryData struct {
 	TopRevenueMonth  string  `json:"top_revenue_month"`
 	ClientCount      int64   `json:"client_count"`
 	AveragePerClient float64 `json:"average_per_client"`
-	PrimaryCurrency  string  `json:"primary_currency"` // Always "USD"
+	PrimaryCurrency  string  `json:"primary_currency"` // USD, or the business's base currency
 }
 
 func getDashboardKPI(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
+	subject, err := middleware.GetSubjectFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+	r, err := reportFor(c)
 	if err != nil {
 		return err
 	}
 
 	var kpi KPIData
 
-	// Get all invoices for the user and convert to USD
+	// Get all invoices for the user and convert to the report currency
 	var invoices []models.Invoice
-	config.DB.Where("user_id = ?", userID).Find(&invoices)
+	db.Scopes(policy.OwnedBy(subject), r.scope).Find(&invoices)
 
-	totalInvoicedUSD := 0.0
-	totalPaidUSD := 0.0
+	totalInvoiced := 0.0
+	totalPaid := 0.0
 
 	for _, invoice := range invoices {
-		// Convert each invoice amount to USD
-		amountUSD := convertToUSD(invoice.Amount, invoice.CurrencyType)
-		totalInvoicedUSD += amountUSD
+		// Convert each invoice amount to the report currency
+		amount := r.convert(invoice.Amount, invoice.CurrencyType)
+		totalInvoiced += amount
 
 		if invoice.Status == "paid" {
-			totalPaidUSD += amountUSD
+			totalPaid += amount
 		}
 	}
 
-	kpi.TotalInvoiced = totalInvoicedUSD
-	kpi.TotalPaid = totalPaidUSD
-	kpi.Outstanding = totalInvoicedUSD - totalPaidUSD
-	kpi.PrimaryCurrency = "USD" // Always USD
+	kpi.TotalInvoiced = totalInvoiced
+	kpi.TotalPaid = totalPaid
+	kpi.Outstanding = totalInvoiced - totalPaid
+	kpi.PrimaryCurrency = r.currency
 
 	// Get client count for the user
-	config.DB.Model(&models.Client{}).Where("user_id = ?", userID).Count(&kpi.ClientCount)
+	db.Model(&models.Client{}).Scopes(policy.OwnedBy(subject), r.scope).Count(&kpi.ClientCount)
 
 	return c.JSON(kpi)
 }
 
 func getRevenueChart(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
+	subject, err := middleware.GetSubjectFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+	r, err := reportFor(c)
 	if err != nil {
 		return err
 	}
@@ -125,14 +162,14 @@ func getRevenueChart(c *fiber.Ctx) error {
 
 	// Get all paid invoices for the user
 	var invoices []models.Invoice
-	if err := config.DB.Where("user_id = ? AND status = ?", userID, "paid").
+	if err := db.Scopes(policy.OwnedBy(subject), r.scope).Where("status = ?", "paid").
 		Order("invoice_date DESC").
 		Find(&invoices).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch revenue data"})
 	}
 
-	// Create a map to aggregate revenue by month in USD
-	monthlyRevenueUSD := make(map[string]float64)
+	// Create a map to aggregate revenue by month in the report currency
+	monthlyRevenue := make(map[string]float64)
 
 	// Get last 12 months
 	now := time.Now()
@@ -142,7 +179,7 @@ func getRevenueChart(c *fiber.Ctx) error {
 		monthName := month.Format("Jan")
 
 		// Initialize with 0
-		monthlyRevenueUSD[monthKey] = 0
+		monthlyRevenue[monthKey] = 0
 
 		// Add to result with proper month name
 		revenueData = append(revenueData, RevenueChartData{
@@ -151,32 +188,37 @@ func getRevenueChart(c *fiber.Ctx) error {
 		})
 	}
 
-	// Aggregate actual revenue data (convert all to USD)
+	// Aggregate actual revenue data (convert all to the report currency)
 	for _, invoice := range invoices {
 		if invoice.InvoiceDate != "" {
 			if invoiceTime, err := time.Parse("2006-01-02", invoice.InvoiceDate); err == nil {
 				monthKey := invoiceTime.Format("2006-01")
-				if _, exists := monthlyRevenueUSD[monthKey]; exists {
-					amountUSD := convertToUSD(invoice.Amount, invoice.CurrencyType)
-					monthlyRevenueUSD[monthKey] += amountUSD
+				if _, exists := monthlyRevenue[monthKey]; exists {
+					amount := r.convert(invoice.Amount, invoice.CurrencyType)
+					monthlyRevenue[monthKey] += amount
 				}
 			}
 		}
 	}
 
-	// Update the revenue data with USD amounts
+	// Update the revenue data with the converted amounts
 	now = time.Now()
 	for i := range revenueData {
 		month := now.AddDate(0, -(11 - i), 0)
 		monthKey := month.Format("2006-01")
-		revenueData[i].Revenue = monthlyRevenueUSD[monthKey]
+		revenueData[i].Revenue = monthlyRevenue[monthKey]
 	}
 
 	return c.JSON(revenueData)
 }
 
 func getTopClients(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
+	subject, err := middleware.GetSubjectFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+	r, err := reportFor(c)
 	if err != nil {
 		return err
 	}
@@ -185,25 +227,25 @@ func getTopClients(c *fiber.Ctx) error {
 
 	// Get all clients for the user
 	var clients []models.Client
-	if err := config.DB.Where("user_id = ?", userID).Find(&clients).Error; err != nil {
+	if err := db.Scopes(policy.OwnedBy(subject), r.scope).Find(&clients).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch clients"})
 	}
 
-	// Calculate revenue for each client in USD
+	// Calculate revenue for each client in the report currency
 	for _, client := range clients {
 		var clientInvoices []models.Invoice
-		config.DB.Where("client_id = ? AND user_id = ? AND status = ?", client.ID, userID, "paid").Find(&clientInvoices)
+		db.Scopes(r.scope).Where("client_id = ? AND status = ?", client.ID, "paid").Find(&clientInvoices)
 
-		totalRevenueUSD := 0.0
+		totalRevenue := 0.0
 		for _, invoice := range clientInvoices {
-			amountUSD := convertToUSD(invoice.Amount, invoice.CurrencyType)
-			totalRevenueUSD += amountUSD
+			amount := r.convert(invoice.Amount, invoice.CurrencyType)
+			totalRevenue += amount
 		}
 
 		// Include all clients, even those with 0 revenue
 		topClients = append(topClients, TopClientData{
 			Name:    client.Name,
-			Revenue: totalRevenueUSD,
+			Revenue: totalRevenue,
 		})
 	}
 
@@ -225,7 +267,12 @@ func getTopClients(c *fiber.Ctx) error {
 }
 
 func getRecentInvoices(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
+	subject, err := middleware.GetSubjectFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+	r, err := reportFor(c)
 	if err != nil {
 		return err
 	}
@@ -239,7 +286,7 @@ func getRecentInvoices(c *fiber.Ctx) error {
 		limit = 5
 	}
 
-	if err := config.DB.Where("user_id = ?", userID).Preload("Client").
+	if err := db.Scopes(policy.OwnedBy(subject), r.scope).Preload("Client").
 		Order("created_at DESC").
 		Limit(limit).
 		Find(&invoices).Error; err != nil {
@@ -257,67 +304,72 @@ func getRecentInvoices(c *fiber.Ctx) error {
 }
 
 func getReportsSummary(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
+	subject, err := middleware.GetSubjectFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+	r, err := reportFor(c)
 	if err != nil {
 		return err
 	}
 
 	var summary ReportsSummaryData
 
-	// Get all invoices for the user and convert to USD
+	// Get all invoices for the user and convert to the report currency
 	var invoices []models.Invoice
-	config.DB.Where("user_id = ?", userID).Find(&invoices)
+	db.Scopes(policy.OwnedBy(subject), r.scope).Find(&invoices)
 
-	summary.PrimaryCurrency = "USD" // Always USD
+	summary.PrimaryCurrency = r.currency
 
-	// Calculate totals in USD
-	totalRevenueUSD := 0.0
-	totalPaidUSD := 0.0
+	// Calculate totals in the report currency
+	totalRevenue := 0.0
+	totalPaid := 0.0
 
 	for _, invoice := range invoices {
-		amountUSD := convertToUSD(invoice.Amount, invoice.CurrencyType)
-		totalRevenueUSD += amountUSD
+		amount := r.convert(invoice.Amount, invoice.CurrencyType)
+		totalRevenue += amount
 
 		if invoice.Status == "paid" {
-			totalPaidUSD += amountUSD
+			totalPaid += amount
 		}
 	}
 
-	summary.TotalRevenue = totalRevenueUSD
+	summary.TotalRevenue = totalRevenue
 
 	// Calculate collection rate
-	if totalRevenueUSD > 0 {
-		summary.CollectionRate = (totalPaidUSD / totalRevenueUSD) * 100
+	if totalRevenue > 0 {
+		summary.CollectionRate = (totalPaid / totalRevenue) * 100
 	}
 
 	// Get client count for the user
-	config.DB.Model(&models.Client{}).Where("user_id = ?", userID).Count(&summary.ClientCount)
+	db.Model(&models.Client{}).Scopes(policy.OwnedBy(subject), r.scope).Count(&summary.ClientCount)
 
 	// Calculate average per client
 	if summary.ClientCount > 0 {
 		summary.AveragePerClient = summary.TotalRevenue / float64(summary.ClientCount)
 	}
 
-	// Get top client (in USD) for the user
+	// Get top client for the user
 	var clients []models.Client
-	if err := config.DB.Where("user_id = ?", userID).Find(&clients).Error; err == nil {
+	if err := db.Scopes(policy.OwnedBy(subject), r.scope).Find(&clients).Error; err == nil {
 		var topClient TopClientData
-		maxRevenueUSD := 0.0
+		maxRevenue := 0.0
 
 		for _, client := range clients {
 			var clientInvoices []models.Invoice
-			config.DB.Where("client_id = ? AND user_id = ? AND status = ?", client.ID, userID, "paid").Find(&clientInvoices)
+			db.Scopes(r.scope).Where("client_id = ? AND status = ?", client.ID, "paid").Find(&clientInvoices)
 
-			totalRevenueUSD := 0.0
+			totalRevenue := 0.0
 			for _, invoice := range clientInvoices {
-				amountUSD := convertToUSD(invoice.Amount, invoice.CurrencyType)
-				totalRevenueUSD += amountUSD
+				amount := r.convert(invoice.Amount, invoice.CurrencyType)
+				totalRevenue += amount
 			}
 
-			if totalRevenueUSD > maxRevenueUSD {
-				maxRevenueUSD = totalRevenueUSD
+			if totalRevenue > maxRevenue {
+				maxRevenue = totalRevenue
 				topClient.Name = client.Name
-				topClient.Revenue = totalRevenueUSD
+				topClient.Revenue = totalRevenue
 			}
 		}
 
@@ -325,27 +377,27 @@ func getReportsSummary(c *fiber.Ctx) error {
 		summary.TopClientRevenue = topClient.Revenue
 	}
 
-	// Get top revenue month (in USD) for the user
+	// Get top revenue month for the user
 	var paidInvoices []models.Invoice
-	if err := config.DB.Where("user_id = ? AND status = ?", userID, "paid").Find(&paidInvoices).Error; err == nil {
-		monthlyRevenueUSD := make(map[string]float64)
+	if err := db.Scopes(policy.OwnedBy(subject), r.scope).Where("status = ?", "paid").Find(&paidInvoices).Error; err == nil {
+		monthlyRevenue := make(map[string]float64)
 
 		for _, invoice := range paidInvoices {
 			if invoice.InvoiceDate != "" {
 				if invoiceTime, err := time.Parse("2006-01-02", invoice.InvoiceDate); err == nil {
 					monthKey := invoiceTime.Format("2006-01")
-					amountUSD := convertToUSD(invoice.Amount, invoice.CurrencyType)
-					monthlyRevenueUSD[monthKey] += amountUSD
+					amount := r.convert(invoice.Amount, invoice.CurrencyType)
+					monthlyRevenue[monthKey] += amount
 				}
 			}
 		}
 
 		// Find the month with highest revenue
-		maxRevenueUSD := 0.0
+		maxRevenue := 0.0
 		topMonth := ""
-		for month, revenue := range monthlyRevenueUSD {
-			if revenue > maxRevenueUSD {
-				maxRevenueUSD = revenue
+		for month, revenue := range monthlyRevenue {
+			if revenue > maxRevenue {
+				maxRevenue = revenue
 				topMonth = month
 			}
 		}
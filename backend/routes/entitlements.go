@@ -0,0 +1,249 @@
+package routes
+
+import (
+	"billow-backend/audit"
+	"billow-backend/config"
+	"billow-backend/entitlements"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func getAddOns(c *fiber.Ctx) error {
+	var addOns []models.AddOn
+	if err := middleware.DB(c).Order("created_at DESC").Find(&addOns).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch add-ons"})
+	}
+	return c.JSON(addOns)
+}
+
+func createAddOn(c *fiber.Ctx) error {
+	var addOnData struct {
+		models.AddOn
+		Features []string `json:"features"`
+	}
+	if err := c.BodyParser(&addOnData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+	addOn := addOnData.AddOn
+	if strings.TrimSpace(addOn.Name) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Name is required"})
+	}
+	if addOn.Price < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Price can't be negative"})
+	}
+	if err := addOn.SetFeatures(addOnData.Features); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	addOn.ID = models.GenerateAddOnID()
+	addOn.Active = true
+	if addOn.Currency == "" {
+		addOn.Currency = "USD"
+	}
+
+	if err := middleware.DB(c).Create(&addOn).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create add-on"})
+	}
+	return c.Status(201).JSON(addOn)
+}
+
+// targetUser loads the user an admin request is about. Their records belong
+// to another tenant, so admin writes go through the owner connection.
+func targetUser(c *fiber.Ctx) (models.User, error) {
+	var user models.User
+	if err := config.DB.First(&user, "id = ?", c.Params("id")).Error; err != nil {
+		return user, fiber.NewError(404, "User not found")
+	}
+	return user, nil
+}
+
+// getUserEntitlements shows what a user is entitled to and why
+func getUserEntitlements(c *fiber.Ctx) error {
+	user, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+
+	var subscription *models.Subscription
+	var sub models.Subscription
+	if err := config.DB.Preload("Plan").Where("user_id = ?", user.ID).First(&sub).Error; err == nil {
+		subscription = &sub
+	}
+
+	ents, err := entitlements.Load(config.DB, user.ID, subscription, time.Now())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to resolve entitlements"})
+	}
+
+	var addOns []models.UserAddOn
+	var overrides []models.EntitlementOverride
+	if err := config.DB.Preload("AddOn").Where("user_id = ?", user.ID).Find(&addOns).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch add-ons"})
+	}
+	if err := config.DB.Where("user_id = ?", user.ID).Find(&overrides).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch overrides"})
+	}
+
+	return c.JSON(fiber.Map{
+		"entitlements": ents,
+		"add_ons":      addOns,
+		"overrides":    overrides,
+	})
+}
+
+// grantAddOn gives a user an add-on, e.g. after it was bought from sales
+func grantAddOn(c *fiber.Ctx) error {
+	user, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+
+	var grantData struct {
+		AddOnID   string     `json:"add_on_id"`
+		Quantity  int        `json:"quantity"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.BodyParser(&grantData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+	if grantData.Quantity == 0 {
+		grantData.Quantity = 1
+	}
+	if grantData.Quantity < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Quantity must be positive"})
+	}
+
+	var addOn models.AddOn
+	if err := config.DB.First(&addOn, "id = ? AND active = ?", grantData.AddOnID, true).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Add-on not found"})
+	}
+
+	owned := models.UserAddOn{
+		ID:        models.GenerateUserAddOnID(),
+		UserID:    user.ID,
+		AddOnID:   addOn.ID,
+		Quantity:  grantData.Quantity,
+		ExpiresAt: grantData.ExpiresAt,
+	}
+	if err := config.DB.Omit("AddOn").Create(&owned).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to grant add-on"})
+	}
+	owned.AddOn = addOn
+
+	audit.Record(c, config.DB, audit.Entry{
+		UserID:     user.ID,
+		Action:     audit.EntitlementChanged,
+		TargetType: "add_on",
+		TargetID:   owned.ID,
+		After:      owned,
+	})
+
+	return c.Status(201).JSON(owned)
+}
+
+// setEntitlementOverride grants or revokes a feature, or replaces a quota
+// limit, for one user
+func setEntitlementOverride(c *fiber.Ctx) error {
+	user, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+	adminID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	key := c.Params("key")
+	if !entitlements.IsKey(key) {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown feature or quota"})
+	}
+
+	var overrideData struct {
+		Enabled   *bool      `json:"enabled"`
+		Limit     *int       `json:"limit"`
+		Reason    string     `json:"reason"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.BodyParser(&overrideData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+	if entitlements.IsQuota(key) {
+		if overrideData.Limit == nil || *overrideData.Limit < -1 {
+			return c.Status(400).JSON(fiber.Map{"error": "Quotas need a limit of -1 or more"})
+		}
+		overrideData.Enabled = nil
+	} else {
+		if overrideData.Enabled == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Features need enabled"})
+		}
+		overrideData.Limit = nil
+	}
+	if strings.TrimSpace(overrideData.Reason) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Reason is required"})
+	}
+
+	var before *models.EntitlementOverride
+	override := models.EntitlementOverride{ID: models.GenerateEntitlementOverrideID(), UserID: user.ID, Key: key}
+	err = config.DB.Where("user_id = ? AND key = ?", user.ID, key).First(&override).Error
+	switch {
+	case err == nil:
+		existing := override
+		before = &existing
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch override"})
+	}
+
+	override.Enabled = overrideData.Enabled
+	override.Limit = overrideData.Limit
+	override.Reason = overrideData.Reason
+	override.ExpiresAt = overrideData.ExpiresAt
+	override.CreatedBy = adminID
+	if err := config.DB.Save(&override).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save override"})
+	}
+
+	entry := audit.Entry{
+		UserID:     user.ID,
+		Action:     audit.EntitlementChanged,
+		TargetType: "entitlement_override",
+		TargetID:   override.ID,
+		After:      override,
+	}
+	if before != nil {
+		entry.Before = before
+	}
+	audit.Record(c, config.DB, entry)
+
+	return c.JSON(override)
+}
+
+func deleteEntitlementOverride(c *fiber.Ctx) error {
+	user, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+
+	var override models.EntitlementOverride
+	if err := config.DB.Where("user_id = ? AND key = ?", user.ID, c.Params("key")).First(&override).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Override not found"})
+	}
+	if err := config.DB.Delete(&override).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete override"})
+	}
+
+	audit.Record(c, config.DB, audit.Entry{
+		UserID:     user.ID,
+		Action:     audit.EntitlementChanged,
+		TargetType: "entitlement_override",
+		TargetID:   override.ID,
+		Before:     override,
+	})
+
+	return c.JSON(fiber.Map{"message": "Override removed"})
+}
@@ -1,31 +1,49 @@
 package routes
 
 import (
-	"billow-backend/config"
+	"billow-backend/audit"
+	"billow-backend/billing"
 	"billow-backend/middleware"
 	"billow-backend/models"
+	"billow-backend/policy"
+	"billow-backend/usage"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func Setup(app *fiber.App) {
 	// Apply auth middleware to all invoice routes
-	invoices := app.Group("/api/invoices", middleware.AuthMiddleware())
-	
-	invoices.Post("/", createInvoice)
-	invoices.Get("/", getInvoices)
-	invoices.Get("/:id", getInvoice)
-	invoices.Put("/:id", updateInvoice)
-	invoices.Delete("/:id", deleteInvoice)
+	invoices := app.Group("/api/invoices", middleware.AuthMiddleware(), middleware.TenantTransaction())
+
+	invoices.Post("/", middleware.Authorize(policy.InvoiceCreate), middleware.RequireSubscription(), middleware.TrackUsage(usage.InvoiceCreated), createInvoice)
+	invoices.Get("/", middleware.Authorize(policy.InvoiceRead), getInvoices)
+	invoices.Get("/:id", middleware.Authorize(policy.InvoiceRead), getInvoice)
+	invoices.Put("/:id", middleware.Authorize(policy.InvoiceUpdate), updateInvoice)
+	invoices.Delete("/:id", middleware.Authorize(policy.InvoiceDelete), deleteInvoice)
+	invoices.Post("/:id/share", middleware.Authorize(policy.InvoiceShare), shareInvoice)
+	invoices.Get("/:id/shares", middleware.Authorize(policy.InvoiceShare), getInvoiceShares)
+	invoices.Delete("/:id/shares/:shareId", middleware.Authorize(policy.InvoiceShare), revokeInvoiceShare)
+	invoices.Post("/:id/payment-link", middleware.Authorize(policy.InvoiceCollect), createPaymentLink)
+	invoices.Post("/:id/remind", middleware.Authorize(policy.InvoiceRemind), middleware.TrackUsage(usage.MessageSent), sendInvoiceReminder)
+	invoices.Get("/:id/qr", middleware.Authorize(policy.InvoiceRead), getInvoiceQR)
+	invoices.Get("/:id/html", middleware.Authorize(policy.InvoiceRead), getInvoiceHTML)
+
+	// Shared invoices open without login
+	app.Get("/api/public/invoices/:token", viewSharedInvoice)
 }
 
 func createInvoice(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
+	subject, err := middleware.GetSubjectFromContext(c)
 	if err != nil {
 		return err
 	}
+	userID := subject.UserID
+	db := middleware.DB(c)
 
 	invoice := new(models.Invoice)
 	if err := c.BodyParser(invoice); err != nil {
@@ -35,11 +53,12 @@ func createInvoice(c *fiber.Ctx) error {
 	// Generate unique invoice ID and set user ID
 	invoice.ID = models.GenerateInvoiceID()
 	invoice.UserID = userID
+	invoice.ViewedAt, invoice.LastViewedAt, invoice.ViewCount = nil, nil, 0
 
 	// Validate that the client belongs to the user
 	if invoice.ClientID != "" {
 		var client models.Client
-		if err := config.DB.Where("id = ? AND user_id = ?", invoice.ClientID, userID).First(&client).Error; err != nil {
+		if err := findOwned(c, policy.ClientRead, &client, invoice.ClientID); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid client selected"})
 		}
 		// Set client name for backward compatibility
@@ -48,19 +67,9 @@ func createInvoice(c *fiber.Ctx) error {
 
 	// Handle legacy client_name field (for backward compatibility)
 	if invoice.ClientName != "" && invoice.ClientID == "" {
-		var client models.Client
-		// Try to find existing client by name
-		if err := config.DB.Where("name = ? AND user_id = ?", invoice.ClientName, userID).First(&client).Error; err != nil {
-			// Client doesn't exist, create a new one
-			client = models.Client{
-				ID:     models.GenerateClientID(),
-				UserID: userID,
-				Name:   invoice.ClientName,
-				Email:  "", // Will need to be updated later
-			}
-			if err := config.DB.Create(&client).Error; err != nil {
-				return c.Status(500).JSON(fiber.Map{"error": "Failed to create client"})
-			}
+		client, err := legacyClient(c, db, subject, invoice.ClientName, invoice.BusinessID)
+		if err != nil {
+			return err
 		}
 		invoice.ClientID = client.ID
 	}
@@ -70,6 +79,23 @@ func createInvoice(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Client is required"})
 	}
 
+	// The invoice is issued by the client's business
+	var client models.Client
+	db.First(&client, "id = ?", invoice.ClientID)
+	if invoice.BusinessID == "" {
+		invoice.BusinessID = client.BusinessID
+	}
+	if client.BusinessID != "" && invoice.BusinessID != client.BusinessID {
+		return c.Status(400).JSON(fiber.Map{"error": "The client belongs to another business"})
+	}
+	if invoice.BusinessID, err = assignBusiness(db, userID, invoice.BusinessID); err != nil {
+		return err
+	}
+
+	if err := checkTemplateID(db, userID, invoice.TemplateID); err != nil {
+		return err
+	}
+
 	if invoice.Amount <= 0 {
 		return c.Status(400).JSON(fiber.Map{"error": "Amount must be greater than 0"})
 	}
@@ -78,13 +104,23 @@ func createInvoice(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invoice date is required"})
 	}
 
-	if invoice.DueDate == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "Due date is required"})
+	if err := normalizeInvoiceTerms(invoice); err != nil {
+		return err
+	}
+	business, _ := loadBusiness(db.Select("base_currency", "default_payment_terms", "tax_label", "tax_rate", "tax_inclusive"), userID, invoice.BusinessID)
+	if err := applyClientDefaults(invoice, client, business); err != nil {
+		return err
+	}
+	// A net amount gets its taxes added so the invoice holds the total due
+	if !invoice.TaxesIncluded() {
+		for _, tax := range invoice.TaxRates.Added(invoice.Amount) {
+			invoice.Amount += tax
+		}
+		invoice.Amount = billing.FromMinorUnits(billing.MinorUnits(invoice.Amount, invoice.CurrencyType), invoice.CurrencyType)
 	}
 
-	// Set default currency if not provided
-	if invoice.CurrencyType == "" {
-		invoice.CurrencyType = "USD"
+	if invoice.DueDate == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Due date is required"})
 	}
 
 	// Set default status if not provided
@@ -92,14 +128,25 @@ func createInvoice(c *fiber.Ctx) error {
 		invoice.Status = "unpaid"
 	}
 
-	if err := config.DB.Create(&invoice).Error; err != nil {
+	if err := reserveQuota(c, db, billing.InvoiceQuota); err != nil {
+		return err
+	}
+
+	invoice.Number = ""
+	if invoice.BusinessID != "" {
+		if err := assignInvoiceNumber(db, invoice); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to number invoice"})
+		}
+	}
+
+	if err := db.Create(&invoice).Error; err != nil {
 		fmt.Printf("Error creating invoice: %v\n", err)
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to create invoice"})
 	}
 
 	// Load the client relationship for response
-	config.DB.Preload("Client").First(&invoice, "id = ?", invoice.ID)
-	
+	db.Preload("Client").First(&invoice, "id = ?", invoice.ID)
+
 	// Set client name for backward compatibility
 	if invoice.Client.Name != "" {
 		invoice.ClientName = invoice.Client.Name
@@ -108,24 +155,103 @@ func createInvoice(c *fiber.Ctx) error {
 	return c.JSON(invoice)
 }
 
+// assignInvoiceNumber gives invoice the next number in its business's
+// sequence. The business row stays locked until the transaction ends, so
+// concurrent invoices can't get the same number.
+func assignInvoiceNumber(db *gorm.DB, invoice *models.Invoice) error {
+	var business models.BusinessProfile
+	if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "invoice_prefix", "next_invoice_number").
+		First(&business, "id = ? AND user_id = ?", invoice.BusinessID, invoice.UserID).Error; err != nil {
+		return err
+	}
+	if business.NextInvoiceNumber < 1 {
+		business.NextInvoiceNumber = 1
+	}
+	invoice.Number = business.InvoiceNumber(business.NextInvoiceNumber)
+	return db.Model(&business).Update("next_invoice_number", business.NextInvoiceNumber+1).Error
+}
+
+// normalizeInvoiceTerms checks the terms, taxes and language of an invoice
+func normalizeInvoiceTerms(invoice *models.Invoice) error {
+	if invoice.PaymentTerms != "" && !invoice.PaymentTerms.Valid() {
+		return fiber.NewError(400, "Unknown payment terms")
+	}
+	if err := normalizeTaxRates(invoice.TaxRates); err != nil {
+		return err
+	}
+	return normalizeLanguage(&invoice.Language)
+}
+
+// applyClientDefaults fills in what the invoice leaves out from the client's
+// defaults, then the business's, and works out the due date from the terms
+func applyClientDefaults(invoice *models.Invoice, client models.Client, business models.BusinessProfile) error {
+	if invoice.CurrencyType == "" {
+		invoice.CurrencyType = client.DefaultCurrency
+	}
+	if invoice.CurrencyType == "" {
+		invoice.CurrencyType = business.BaseCurrency
+	}
+	if invoice.CurrencyType == "" {
+		invoice.CurrencyType = "USD"
+	}
+	if invoice.PaymentTerms == "" {
+		invoice.PaymentTerms = client.PaymentTerms
+	}
+	if invoice.PaymentTerms == "" {
+		invoice.PaymentTerms = business.DefaultPaymentTerms
+	}
+	// An empty list means no taxes; only a missing one takes the default
+	if invoice.TaxRates == nil {
+		invoice.TaxRates = client.DefaultTaxRates
+	}
+	if invoice.TaxRates == nil {
+		invoice.TaxRates = business.DefaultTaxRates()
+	}
+	// Without tax settings on the business, amounts stay as entered
+	if invoice.TaxInclusive == nil {
+		inclusive := business.TaxInclusive || business.DefaultTaxRates() == nil
+		invoice.TaxInclusive = &inclusive
+	}
+	if invoice.Notes == "" {
+		invoice.Notes = client.DefaultNotes
+	}
+	if invoice.Language == "" {
+		invoice.Language = client.Language
+	}
+
+	if invoice.DueDate == "" && invoice.PaymentTerms != "" {
+		date, err := time.Parse("2006-01-02", invoice.InvoiceDate)
+		if err != nil {
+			return fiber.NewError(400, "Dates must be YYYY-MM-DD")
+		}
+		invoice.DueDate = invoice.PaymentTerms.DueDate(date).Format("2006-01-02")
+	}
+	return nil
+}
+
 func getInvoices(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
+	subject, err := middleware.GetSubjectFromContext(c)
 	if err != nil {
 		return err
 	}
+	db := middleware.DB(c)
 
 	var invoices []models.Invoice
-	
+
 	// Get limit from query parameter for pagination
 	limitStr := c.Query("limit", "")
-	query := config.DB.Where("user_id = ?", userID).Preload("Client").Order("created_at DESC")
-	
+	scope, _, err := businessScope(c)
+	if err != nil {
+		return err
+	}
+	query := db.Scopes(policy.OwnedBy(subject), scope).Preload("Client").Order("created_at DESC")
+
 	if limitStr != "" {
 		if limit, err := strconv.Atoi(limitStr); err == nil && limit > 0 {
 			query = query.Limit(limit)
 		}
 	}
-	
+
 	if err := query.Find(&invoices).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch invoices"})
 	}
@@ -141,18 +267,17 @@ func getInvoices(c *fiber.Ctx) error {
 }
 
 func getInvoice(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
-	if err != nil {
-		return err
-	}
-
+	db := middleware.DB(c)
 	id := c.Params("id")
 	var invoice models.Invoice
 
-	if err := config.DB.Where("id = ? AND user_id = ?", id, userID).Preload("Client").First(&invoice).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Invoice not found"})
+	if err := findOwned(c, policy.InvoiceRead, &invoice, id); err != nil {
+		return err
 	}
 
+	// Load the client relationship
+	db.Preload("Client").First(&invoice, "id = ?", invoice.ID)
+
 	// Set client name for backward compatibility
 	if invoice.Client.Name != "" {
 		invoice.ClientName = invoice.Client.Name
@@ -162,54 +287,70 @@ func getInvoice(c *fiber.Ctx) error {
 }
 
 func updateInvoice(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
+	subject, err := middleware.GetSubjectFromContext(c)
 	if err != nil {
 		return err
 	}
+	userID := subject.UserID
+	db := middleware.DB(c)
 
 	id := c.Params("id")
 	var invoice models.Invoice
 
-	if err := config.DB.Where("id = ? AND user_id = ?", id, userID).First(&invoice).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Invoice not found"})
+	if err := findOwned(c, policy.InvoiceUpdate, &invoice, id); err != nil {
+		return err
 	}
 
+	viewedAt, lastViewedAt, viewCount := invoice.ViewedAt, invoice.LastViewedAt, invoice.ViewCount
+	businessID, number := invoice.BusinessID, invoice.Number
 	if err := c.BodyParser(&invoice); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Ensure user ID doesn't change
+	// Ensure the body can't move the record to another ID, tenant or
+	// business, renumber it or fake views
+	invoice.ID = id
 	invoice.UserID = userID
+	invoice.BusinessID, invoice.Number = businessID, number
+	invoice.ViewedAt, invoice.LastViewedAt, invoice.ViewCount = viewedAt, lastViewedAt, viewCount
 
-	// Validate that the client belongs to the user
+	// Validate that the client belongs to the user and the invoice's business
 	if invoice.ClientID != "" {
 		var client models.Client
-		if err := config.DB.Where("id = ? AND user_id = ?", invoice.ClientID, userID).First(&client).Error; err != nil {
+		if err := findOwned(c, policy.ClientRead, &client, invoice.ClientID); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid client selected"})
 		}
+		if client.BusinessID != "" && client.BusinessID != invoice.BusinessID {
+			return c.Status(400).JSON(fiber.Map{"error": "The client belongs to another business"})
+		}
+	}
+
+	if err := checkTemplateID(db, userID, invoice.TemplateID); err != nil {
+		return err
+	}
+	if err := normalizeInvoiceTerms(&invoice); err != nil {
+		return err
 	}
 
 	// Handle client updates (legacy support)
 	if invoice.ClientName != "" && invoice.ClientID == "" {
-		var client models.Client
-		if err := config.DB.Where("name = ? AND user_id = ?", invoice.ClientName, userID).First(&client).Error; err != nil {
-			client = models.Client{
-				ID:     models.GenerateClientID(),
-				UserID: userID,
-				Name:   invoice.ClientName,
-			}
-			config.DB.Create(&client)
+		client, err := legacyClient(c, db, subject, invoice.ClientName, invoice.BusinessID)
+		if err != nil {
+			return err
+		}
+		if client.BusinessID != "" && client.BusinessID != invoice.BusinessID {
+			return c.Status(400).JSON(fiber.Map{"error": "The client belongs to another business"})
 		}
 		invoice.ClientID = client.ID
 	}
 
-	if err := config.DB.Save(&invoice).Error; err != nil {
+	if err := db.Save(&invoice).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update invoice"})
 	}
 
 	// Load the client relationship
-	config.DB.Preload("Client").First(&invoice, "id = ?", invoice.ID)
-	
+	db.Preload("Client").First(&invoice, "id = ?", invoice.ID)
+
 	// Set client name for backward compatibility
 	if invoice.Client.Name != "" {
 		invoice.ClientName = invoice.Client.Name
@@ -218,17 +359,55 @@ func updateInvoice(c *fiber.Ctx) error {
 	return c.JSON(invoice)
 }
 
+// legacyClient finds the user's client called name for requests that still
+// send client_name instead of client_id. A missing one is created under the
+// same permission, plan limit and business rules as POST /api/clients.
+func legacyClient(c *fiber.Ctx, db *gorm.DB, subject policy.Subject, name, businessID string) (models.Client, error) {
+	var client models.Client
+	if err := db.Scopes(policy.OwnedBy(subject)).Where("name = ?", name).First(&client).Error; err == nil {
+		return client, nil
+	}
+
+	if err := middleware.Can(c, policy.ClientCreate, nil); err != nil {
+		return client, err
+	}
+	if err := reserveQuota(c, db, billing.ClientQuota); err != nil {
+		return client, err
+	}
+	client = models.Client{
+		ID:     models.GenerateClientID(),
+		UserID: subject.UserID,
+		Name:   name,
+	}
+	var err error
+	if client.BusinessID, err = assignBusiness(db, subject.UserID, businessID); err != nil {
+		return client, err
+	}
+	if err := db.Create(&client).Error; err != nil {
+		return client, fiber.NewError(500, "Failed to create client")
+	}
+	return client, nil
+}
+
 func deleteInvoice(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
-	if err != nil {
+	db := middleware.DB(c)
+	id := c.Params("id")
+	var invoice models.Invoice
+
+	if err := findOwned(c, policy.InvoiceDelete, &invoice, id); err != nil {
 		return err
 	}
 
-	id := c.Params("id")
-	
-	if err := config.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Invoice{}).Error; err != nil {
+	if err := db.Delete(&invoice).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete invoice"})
 	}
 
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.InvoiceDeleted,
+		TargetType: "invoice",
+		TargetID:   invoice.ID,
+		Before:     invoice,
+	})
+
 	return c.JSON(fiber.Map{"message": "Invoice deleted successfully"})
-}
\ No newline at end of file
+}
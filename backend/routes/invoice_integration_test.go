@@ -0,0 +1,44 @@
+//go:build integration
+
+package routes
+
+import (
+	"billow-backend/models"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Naming a new client when updating an invoice is held to the same client
+// limit as creating one
+func TestUpdateInvoiceLegacyClientNameChecksLimit(t *testing.T) {
+	db := openTestDB(t)
+	limit := 1
+	seed(t, db,
+		&models.User{ID: "USR-LEG", ClerkID: "clerk_leg", Email: "leg@legacy.test"},
+		&models.EntitlementOverride{ID: "OVR-LEG", UserID: "USR-LEG", Key: "clients", Limit: &limit},
+		&models.Client{ID: "CLI-LEG", UserID: "USR-LEG", Name: "Existing"},
+		&models.Invoice{ID: "INV-LEG", UserID: "USR-LEG", ClientID: "CLI-LEG", InvoiceDate: "2026-01-01", Amount: 100, Status: "unpaid"},
+	)
+
+	req := httptest.NewRequest("PUT", "/api/invoices/INV-LEG", strings.NewReader(`{"client_name":"Brand new","amount":100,"status":"unpaid"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", "USR-LEG")
+	resp, err := setupApp().Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == 200 {
+		t.Error("update over the client limit succeeded")
+	}
+
+	var clients int64
+	db.Model(&models.Client{}).Where("user_id = ?", "USR-LEG").Count(&clients)
+	if clients != 1 {
+		t.Errorf("%d clients, want 1", clients)
+	}
+	var invoice models.Invoice
+	if err := db.First(&invoice, "id = ?", "INV-LEG").Error; err != nil || invoice.ClientID != "CLI-LEG" {
+		t.Errorf("invoice %+v, %v", invoice, err)
+	}
+}
@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"billow-backend/models"
+	"reflect"
+	"testing"
+)
+
+func TestApplyClientDefaults(t *testing.T) {
+	vat := models.TaxRates{{Label: "VAT", Rate: 20}}
+	gst := models.TaxRates{{Label: "GST", Rate: 10}}
+	euro := models.Client{DefaultCurrency: "EUR", PaymentTerms: models.TermsNet30, DefaultTaxRates: gst}
+	pounds := models.BusinessProfile{BaseCurrency: "GBP", DefaultPaymentTerms: models.TermsEndOfMonth, TaxLabel: "VAT", TaxRate: 20}
+	inclusive := models.BusinessProfile{TaxLabel: "VAT", TaxRate: 20, TaxInclusive: true}
+
+	tests := []struct {
+		name      string
+		invoice   models.Invoice
+		client    models.Client
+		business  models.BusinessProfile
+		currency  string
+		terms     models.PaymentTerms
+		due       string
+		taxes     models.TaxRates
+		inclusive bool
+	}{
+		{"nothing set", models.Invoice{DueDate: "2024-02-01"}, models.Client{}, models.BusinessProfile{},
+			"USD", "", "2024-02-01", nil, true},
+		{"client first", models.Invoice{InvoiceDate: "2024-01-31"}, euro, pounds,
+			"EUR", models.TermsNet30, "2024-03-01", gst, false},
+		{"then business", models.Invoice{InvoiceDate: "2024-01-31"}, models.Client{}, pounds,
+			"GBP", models.TermsEndOfMonth, "2024-01-31", vat, false},
+		{"invoice wins", models.Invoice{InvoiceDate: "2024-01-10", CurrencyType: "CHF", PaymentTerms: models.TermsNet7, TaxRates: vat}, euro, pounds,
+			"CHF", models.TermsNet7, "2024-01-17", vat, false},
+		{"explicit due date", models.Invoice{InvoiceDate: "2024-01-10", DueDate: "2024-01-12"}, euro, pounds,
+			"EUR", models.TermsNet30, "2024-01-12", gst, false},
+		{"empty tax list means no taxes", models.Invoice{InvoiceDate: "2024-01-10", TaxRates: models.TaxRates{}}, euro, pounds,
+			"EUR", models.TermsNet30, "2024-02-09", models.TaxRates{}, false},
+		{"tax-inclusive business", models.Invoice{DueDate: "2024-02-01"}, models.Client{}, inclusive,
+			"USD", "", "2024-02-01", vat, true},
+	}
+	for _, tt := range tests {
+		invoice := tt.invoice
+		if err := applyClientDefaults(&invoice, tt.client, tt.business); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if invoice.CurrencyType != tt.currency || invoice.PaymentTerms != tt.terms || invoice.DueDate != tt.due {
+			t.Errorf("%s: got %s, %q, due %s; want %s, %q, due %s", tt.name,
+				invoice.CurrencyType, invoice.PaymentTerms, invoice.DueDate, tt.currency, tt.terms, tt.due)
+		}
+		if !reflect.DeepEqual(invoice.TaxRates, tt.taxes) || invoice.TaxesIncluded() != tt.inclusive {
+			t.Errorf("%s: taxes %#v included %v, want %#v included %v", tt.name,
+				invoice.TaxRates, invoice.TaxesIncluded(), tt.taxes, tt.inclusive)
+		}
+	}
+
+	invoice := models.Invoice{InvoiceDate: "31/01/2024", PaymentTerms: models.TermsNet30}
+	if err := applyClientDefaults(&invoice, models.Client{}, models.BusinessProfile{}); err == nil {
+		t.Error("a due date was worked out from an invalid invoice date")
+	}
+}
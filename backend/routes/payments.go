@@ -0,0 +1,97 @@
+package routes
+
+import (
+	"billow-backend/billing"
+	"billow-backend/config"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"billow-backend/policy"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// createPaymentLink returns a hosted page where the client pays what is
+// still outstanding on the invoice
+func createPaymentLink(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+	var invoice models.Invoice
+	if err := findOwned(c, policy.InvoiceCollect, &invoice, c.Params("id")); err != nil {
+		return err
+	}
+	if billing.DefaultGateway == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Online payments are not configured"})
+	}
+	db.Preload("Client").First(&invoice, "id = ?", invoice.ID)
+
+	if invoice.Status == "paid" {
+		return c.Status(400).JSON(fiber.Map{"error": "Invoice is already paid"})
+	}
+	outstanding, err := billing.Outstanding(db, invoice)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create payment link"})
+	}
+	if billing.MinorUnits(outstanding, invoice.CurrencyType) <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Nothing is left to pay on this invoice"})
+	}
+
+	currency := invoice.CurrencyType
+	if currency == "" {
+		currency = "USD"
+	}
+	session, err := billing.DefaultGateway.CreatePaymentCheckout(c.UserContext(), billing.PaymentParams{
+		InvoiceID:  invoice.ID,
+		UserID:     invoice.UserID,
+		Amount:     outstanding,
+		Currency:   currency,
+		Email:      invoice.Client.Email,
+		SuccessURL: appURL("/portal?payment=success&invoice=" + invoice.ID),
+		CancelURL:  appURL("/portal?payment=canceled&invoice=" + invoice.ID),
+	})
+	if err != nil {
+		fmt.Printf("Payment checkout failed for %s: %v\n", invoice.ID, err)
+		return c.Status(502).JSON(fiber.Map{"error": "Failed to create payment link"})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"id":       session.ID,
+		"url":      session.URL,
+		"amount":   outstanding,
+		"currency": currency,
+	})
+}
+
+// handlePaymentWebhook records invoice payments reported by the gateway.
+// Payments for invoices that no longer exist are acknowledged so the gateway
+// stops retrying them.
+func handlePaymentWebhook(c *fiber.Ctx) error {
+	gateway := billing.DefaultGateway
+	if gateway == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Online payments are not configured"})
+	}
+
+	event, err := gateway.ParsePaymentWebhook(c.Body(), c.Get(gateway.SignatureHeader()))
+	if err != nil {
+		if errors.Is(err, billing.ErrInvalidSignature) {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid signature"})
+		}
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid webhook data"})
+	}
+	if event.Type == "" {
+		return c.JSON(fiber.Map{"received": true})
+	}
+
+	fmt.Printf("Received payment webhook: %s for %s (%s)\n", event.Type, event.InvoiceID, event.ID)
+
+	if err := billing.ApplyPayment(config.DB, event); err != nil {
+		if errors.Is(err, billing.ErrUnknownInvoice) {
+			fmt.Printf("Payment webhook %s matched no invoice\n", event.ID)
+			return c.JSON(fiber.Map{"received": true})
+		}
+		fmt.Printf("Payment webhook %s failed: %v\n", event.ID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to apply webhook"})
+	}
+
+	return c.JSON(fiber.Map{"received": true})
+}
@@ -0,0 +1,148 @@
+package routes
+
+import (
+	"billow-backend/audit"
+	"billow-backend/billing"
+	"billow-backend/config"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// getPlanCatalog lists every plan version with how many subscribers it has
+func getPlanCatalog(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+
+	var plans []models.Plan
+	if err := db.Order("code ASC, version ASC").Find(&plans).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch plans"})
+	}
+
+	var offered []string
+	if err := db.Model(&models.Plan{}).Scopes(billing.Offered).Pluck("id", &offered).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch plans"})
+	}
+	isOffered := map[string]bool{}
+	for _, id := range offered {
+		isOffered[id] = true
+	}
+
+	// Subscriber counts span every tenant, so they bypass the tenant transaction
+	var counts []struct {
+		PlanID string
+		Count  int64
+	}
+	if err := config.DB.Model(&models.Subscription{}).Select("plan_id, COUNT(*) AS count").Group("plan_id").Scan(&counts).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to count subscribers"})
+	}
+	subscribers := map[string]int64{}
+	for _, count := range counts {
+		subscribers[count.PlanID] = count.Count
+	}
+
+	catalog := make([]fiber.Map, len(plans))
+	for i, plan := range plans {
+		catalog[i] = fiber.Map{
+			"plan":              plan,
+			"features":          plan.Features(),
+			"provider_price_id": plan.ProviderPriceID,
+			"offered":           isOffered[plan.ID],
+			"subscribers":       subscribers[plan.ID],
+		}
+	}
+
+	return c.JSON(fiber.Map{"plans": catalog})
+}
+
+// getPlanCatalogDiff shows what applying the catalog file would change
+func getPlanCatalogDiff(c *fiber.Ctx) error {
+	defs, err := billing.LoadCatalog(os.Getenv("PLAN_CATALOG"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load plan catalog", "details": err.Error()})
+	}
+
+	changes, err := billing.DiffCatalog(middleware.DB(c), defs)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to compare plan catalog"})
+	}
+
+	return c.JSON(fiber.Map{"changes": changes})
+}
+
+// createPlanVersion adds a plan, or the next version of an existing one.
+// Subscribers on earlier versions are grandfathered; new subscribers get the
+// new version.
+func createPlanVersion(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+
+	var def billing.PlanDefinition
+	if err := c.BodyParser(&def); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+
+	// Serialise version numbering per plan
+	if err := db.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "plan:"+def.Code).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create plan"})
+	}
+
+	plan, err := billing.CreatePlanVersion(db, def)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.PlanChanged,
+		TargetType: "plan",
+		TargetID:   plan.ID,
+		After:      plan,
+	})
+
+	return c.Status(201).JSON(plan)
+}
+
+// updatePlan changes the parts of a plan version that don't affect what its
+// subscribers pay for
+func updatePlan(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+
+	var plan models.Plan
+	if err := db.First(&plan, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Plan not found"})
+	}
+
+	var updateData struct {
+		Name            *string `json:"name"`
+		ProviderPriceID *string `json:"provider_price_id"`
+		Retired         *bool   `json:"retired"`
+	}
+	if err := c.BodyParser(&updateData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+
+	before := plan
+	if updateData.Name != nil && *updateData.Name != "" {
+		plan.Name = *updateData.Name
+	}
+	if updateData.ProviderPriceID != nil {
+		plan.ProviderPriceID = *updateData.ProviderPriceID
+	}
+	if updateData.Retired != nil {
+		plan.Retired = *updateData.Retired
+	}
+
+	if err := db.Save(&plan).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update plan"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.PlanChanged,
+		TargetType: "plan",
+		TargetID:   plan.ID,
+		Before:     before,
+		After:      plan,
+	})
+
+	return c.JSON(plan)
+}
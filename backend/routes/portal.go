@@ -0,0 +1,282 @@
+package routes
+
+import (
+	"billow-backend/audit"
+	"billow-backend/config"
+	"billow-backend/i18n"
+	"billow-backend/mailer"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"billow-backend/policy"
+	"billow-backend/portal"
+	"billow-backend/statement"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupPortalRoutes registers the client portal. Clients sign in with a magic
+// link sent to their email and only ever see their own invoices.
+func SetupPortalRoutes(app *fiber.App) {
+	app.Post("/api/portal/login", requestPortalLink)
+	app.Post("/api/portal/session", startPortalSession)
+
+	account := app.Group("/api/portal/account", middleware.PortalAuth(), middleware.TenantTransaction())
+	account.Get("/", middleware.Authorize(policy.PortalRead), getPortalAccount)
+	account.Get("/logo", middleware.Authorize(policy.PortalRead), getPortalLogo)
+	account.Get("/invoices", middleware.Authorize(policy.PortalRead), getPortalInvoices)
+	account.Get("/invoices/:id/pdf", middleware.Authorize(policy.PortalRead), getPortalInvoicePDF)
+	account.Get("/balance", middleware.Authorize(policy.PortalRead), getPortalBalance)
+	account.Get("/statement", middleware.Authorize(policy.PortalRead), getPortalStatement)
+	account.Put("/billing-address", middleware.Authorize(policy.PortalUpdate), updatePortalBillingAddress)
+	account.Post("/logout", middleware.Authorize(policy.PortalRead), endPortalSession)
+}
+
+// requestPortalLink emails a sign-in link to every client with the address,
+// as its own email or that of one of its billing contacts. The response is
+// the same whether or not any client matched, so it can't be used to find out
+// who invoices whom.
+func requestPortalLink(c *fiber.Ctx) error {
+	var loginData struct {
+		Email string `json:"email"`
+	}
+	if err := c.BodyParser(&loginData); err != nil || !strings.Contains(loginData.Email, "@") {
+		return c.Status(400).JSON(fiber.Map{"error": "A valid email is required"})
+	}
+	email := strings.ToLower(strings.TrimSpace(loginData.Email))
+
+	// No tenant is known yet, so the lookup runs on the owner connection
+	var clients []models.Client
+	err := config.DB.Preload("User").
+		Where("LOWER(email) = ? OR id IN (SELECT client_id FROM client_contacts WHERE is_billing_contact AND LOWER(email) = ?)", email, email).
+		Find(&clients).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to send sign-in link"})
+	}
+
+	for _, client := range clients {
+		token, err := portal.CreateLink(config.DB, portal.DefaultSigner, client, time.Now())
+		if err != nil {
+			fmt.Printf("Creating portal link for %s failed: %v\n", client.ID, err)
+			continue
+		}
+		issuer := businessName(config.DB, client.User, client.BusinessID)
+		locale := i18n.ClientLocale(config.DB, client)
+		mailer.SendAsync(brandedMessage(mailer.Message{
+			To:      []string{email},
+			Subject: i18n.T(locale, "Sign in to view your invoices from %s", issuer),
+			Body: fmt.Sprintf("%s\n\n%s\n\n%s %s\n",
+				i18n.T(locale, "Use this link to see your invoices from %s:", issuer), appURL("/portal?token="+token),
+				i18n.T(locale, "The link works once and expires in %d minutes.", int(portal.LinkTTL.Minutes())),
+				i18n.T(locale, "If you didn't ask for it, you can ignore this email.")),
+		}, activeBranding(config.DB, client.UserID), locale))
+	}
+
+	return c.Status(202).JSON(fiber.Map{"message": "If that email belongs to a client, a sign-in link is on its way"})
+}
+
+// startPortalSession exchanges a magic link token for a session token, sent
+// back in the X-Portal-Token header of later requests
+func startPortalSession(c *fiber.Ctx) error {
+	var sessionData struct {
+		Token string `json:"token"`
+	}
+	if err := c.BodyParser(&sessionData); err != nil || sessionData.Token == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Token is required"})
+	}
+
+	session, bearer, err := portal.Redeem(config.DB, portal.DefaultSigner, sessionData.Token, time.Now())
+	if errors.Is(err, portal.ErrInvalidLink) {
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start session"})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"session_token": bearer,
+		"expires_at":    session.ExpiresAt,
+	})
+}
+
+// portalClient loads the client of the portal session
+func portalClient(c *fiber.Ctx) (models.Client, error) {
+	var client models.Client
+	session, err := middleware.GetPortalSession(c)
+	if err != nil {
+		return client, err
+	}
+	if err := middleware.DB(c).Preload("User").First(&client, "id = ? AND user_id = ?", session.ClientID, session.UserID).Error; err != nil {
+		return client, fiber.NewError(404, "Client not found")
+	}
+	return client, middleware.Can(c, policy.PortalRead, &policy.Resource{OwnerID: client.UserID, ClientID: client.ID})
+}
+
+// portalInvoices loads the invoices of the portal session's client
+func portalInvoices(c *fiber.Ctx, client models.Client) ([]models.Invoice, error) {
+	var invoices []models.Invoice
+	err := middleware.DB(c).Where("user_id = ? AND client_id = ?", client.UserID, client.ID).
+		Order("invoice_date DESC").Find(&invoices).Error
+	return invoices, err
+}
+
+func getPortalAccount(c *fiber.Ctx) error {
+	client, err := portalClient(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"client": fiber.Map{
+			"id":               client.ID,
+			"name":             client.Name,
+			"email":            client.Email,
+			"company":          client.Company,
+			"billing_address":  client.BillingAddress,
+			"shipping_address": client.ShippingAddress,
+		},
+		"issuer": fiber.Map{
+			"name":  businessName(middleware.DB(c), client.User, client.BusinessID),
+			"email": client.User.Email,
+		},
+		"branding": brandingView(activeBranding(middleware.DB(c), client.UserID)),
+	})
+}
+
+// getPortalLogo serves the issuer's brand logo for the portal header
+func getPortalLogo(c *fiber.Ctx) error {
+	client, err := portalClient(c)
+	if err != nil {
+		return err
+	}
+	branding := activeBranding(middleware.DB(c), client.UserID)
+	if branding == nil || len(branding.Logo) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Logo not found"})
+	}
+	c.Set(fiber.HeaderContentType, branding.LogoType)
+	return c.Send(branding.Logo)
+}
+
+func getPortalInvoices(c *fiber.Ctx) error {
+	client, err := portalClient(c)
+	if err != nil {
+		return err
+	}
+	invoices, err := portalInvoices(c, client)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch invoices"})
+	}
+	for i := range invoices {
+		invoices[i].ClientName = client.Name
+	}
+	return c.JSON(invoices)
+}
+
+func getPortalInvoicePDF(c *fiber.Ctx) error {
+	client, err := portalClient(c)
+	if err != nil {
+		return err
+	}
+
+	var invoice models.Invoice
+	if err := middleware.DB(c).First(&invoice, "id = ? AND user_id = ? AND client_id = ?", c.Params("id"), client.UserID, client.ID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+	if err := middleware.Can(c, policy.PortalRead, &policy.Resource{OwnerID: invoice.UserID, ClientID: invoice.ClientID}); err != nil {
+		return err
+	}
+	invoice.Client = client
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.pdf"`, invoice.ID))
+	return c.Send(invoicePDF(middleware.DB(c), invoice, client.User))
+}
+
+func getPortalBalance(c *fiber.Ctx) error {
+	client, err := portalClient(c)
+	if err != nil {
+		return err
+	}
+	invoices, err := portalInvoices(c, client)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch invoices"})
+	}
+	return c.JSON(fiber.Map{"balances": statement.Balances(invoices, time.Now())})
+}
+
+// getPortalStatement returns the client's statement of account for ?from=
+// and ?to= (YYYY-MM-DD, both optional), as the business sees it
+func getPortalStatement(c *fiber.Ctx) error {
+	client, err := portalClient(c)
+	if err != nil {
+		return err
+	}
+	account, err := clientAccount(c, middleware.DB(c), client)
+	if err != nil {
+		return err
+	}
+	return c.JSON(account)
+}
+
+// updatePortalBillingAddress lets the client correct the address their
+// invoices are billed to
+func updatePortalBillingAddress(c *fiber.Ctx) error {
+	client, err := portalClient(c)
+	if err != nil {
+		return err
+	}
+	if err := middleware.Can(c, policy.PortalUpdate, &policy.Resource{OwnerID: client.UserID, ClientID: client.ID}); err != nil {
+		return err
+	}
+
+	var address models.Address
+	if err := c.BodyParser(&address); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+	if err := normalizeAddress(&address); err != nil {
+		return err
+	}
+	if address.IsZero() {
+		return c.Status(400).JSON(fiber.Map{"error": "Address is required"})
+	}
+
+	db := middleware.DB(c)
+	before := client
+	client.BillingAddress = address
+	if err := db.Model(&client).Updates(map[string]interface{}{
+		"billing_address_line1":       address.Line1,
+		"billing_address_line2":       address.Line2,
+		"billing_address_city":        address.City,
+		"billing_address_region":      address.Region,
+		"billing_address_postal_code": address.PostalCode,
+		"billing_address_country":     address.Country,
+	}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update address"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		UserID:     client.UserID,
+		ActorID:    "client:" + client.ID,
+		Action:     audit.ClientUpdated,
+		TargetType: "client",
+		TargetID:   client.ID,
+		Before:     before,
+		After:      client,
+	})
+
+	return c.JSON(fiber.Map{"message": "Billing address updated", "billing_address": client.BillingAddress})
+}
+
+func endPortalSession(c *fiber.Ctx) error {
+	session, err := middleware.GetPortalSession(c)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := middleware.DB(c).Model(&models.PortalSession{}).Where("id = ?", session.ID).Update("revoked_at", now).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to sign out"})
+	}
+	return c.JSON(fiber.Map{"message": "Signed out"})
+}
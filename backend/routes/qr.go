@@ -0,0 +1,258 @@
+package routes
+
+import (
+	"billow-backend/audit"
+	"billow-backend/billing"
+	"billow-backend/i18n"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"billow-backend/payqr"
+	"billow-backend/pdf"
+	"billow-backend/policy"
+	"billow-backend/qr"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var schemeLabels = map[string]string{
+	payqr.SchemeEPC:   "SEPA credit transfer",
+	payqr.SchemeUPI:   "UPI",
+	payqr.SchemeSwiss: "Swiss QR-bill",
+}
+
+// getInvoiceQR renders the payment QR code of an invoice as ?format=png
+// (default) or svg. ?scheme= picks epc, upi or swiss; by default it follows
+// the invoice currency.
+func getInvoiceQR(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+	var invoice models.Invoice
+	if err := findOwned(c, policy.InvoiceRead, &invoice, c.Params("id")); err != nil {
+		return err
+	}
+
+	scheme := c.Query("scheme", payqr.SchemeFor(invoice.CurrencyType))
+	if scheme == "" {
+		return c.Status(400).JSON(fiber.Map{"error": payqr.ErrNoScheme.Error()})
+	}
+	format := c.Query("format", "png")
+	if format != "png" && format != "svg" {
+		return c.Status(400).JSON(fiber.Map{"error": "format must be png or svg"})
+	}
+
+	code, err := invoicePaymentQR(db, invoice, scheme)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(400).JSON(fiber.Map{"error": "Add your bank details in settings first"})
+		}
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	if format == "svg" {
+		c.Set(fiber.HeaderContentType, "image/svg+xml")
+		return c.SendString(code.SVG(8))
+	}
+	c.Set(fiber.HeaderContentType, "image/png")
+	return c.Send(code.PNG(8))
+}
+
+// invoicePaymentQR builds the QR code paying what is still outstanding on
+// invoice with scheme
+func invoicePaymentQR(db *gorm.DB, invoice models.Invoice, scheme string) (*qr.Code, error) {
+	if invoice.Status == "paid" {
+		return nil, errors.New("invoice is already paid")
+	}
+	account, err := payeeAccount(db, invoice.UserID, invoice.BusinessID)
+	if err != nil {
+		return nil, err
+	}
+	outstanding, err := billing.Outstanding(db, invoice)
+	if err != nil {
+		return nil, err
+	}
+	return payqr.Code(scheme, account, payqr.Payment{
+		InvoiceID: invoice.ID,
+		Amount:    outstanding,
+		Currency:  invoice.CurrencyType,
+	})
+}
+
+// businessBankAccount loads the bank details of a business, falling back to
+// the user's general ones
+func businessBankAccount(db *gorm.DB, userID, businessID string) (models.BankAccount, error) {
+	var account models.BankAccount
+	err := db.Where("user_id = ? AND business_id IN ?", userID, []string{businessID, ""}).
+		Order("business_id DESC").First(&account).Error
+	return account, err
+}
+
+// payeeAccount loads the bank details invoices of a business are paid to,
+// filling the account holder and address from the business profile when they
+// were left out
+func payeeAccount(db *gorm.DB, userID, businessID string) (models.BankAccount, error) {
+	account, err := businessBankAccount(db, userID, businessID)
+	if err != nil {
+		return account, err
+	}
+	business, err := loadBusiness(db.Omit("logo"), userID, businessID)
+	if err != nil {
+		return account, nil
+	}
+	if account.AccountHolder == "" {
+		account.AccountHolder = business.LegalName
+	}
+	if account.City == "" && account.PostalCode == "" {
+		account.Street = business.Address.Line1
+		account.BuildingNumber = ""
+		account.PostalCode = business.Address.PostalCode
+		account.City = business.Address.City
+		account.Country = business.Address.Country
+	}
+	return account, nil
+}
+
+// invoicePDF renders an invoice in its language under the issuer's
+// business profile and branding, with bank details and a payment QR code when
+// its currency has a scheme and the issuer's bank details allow one
+func invoicePDF(db *gorm.DB, invoice models.Invoice, issuer models.User) []byte {
+	opts := documentOptions(db, invoice.UserID, invoice.BusinessID, i18n.InvoiceLocale(db, invoice))
+	if account, err := payeeAccount(db, invoice.UserID, invoice.BusinessID); err == nil {
+		opts.Bank = &account
+	}
+	if scheme := payqr.SchemeFor(invoice.CurrencyType); scheme != "" {
+		if code, err := invoicePaymentQR(db, invoice, scheme); err == nil {
+			opts.PaymentQR, opts.PaymentQRLabel = code, i18n.T(opts.Locale, schemeLabels[scheme])
+		}
+	}
+	return pdf.Invoice(invoice, issuer, opts)
+}
+
+// documentOptions styles documents of the business in locale after its
+// profile and the user's branding
+func documentOptions(db *gorm.DB, userID, businessID, locale string) pdf.InvoiceOptions {
+	opts := pdf.InvoiceOptions{Locale: locale}
+	if business, err := loadBusiness(db, userID, businessID); err == nil {
+		opts.Business = &business
+		if len(business.Logo) > 0 {
+			if logo, err := pdf.LoadImage(business.Logo); err == nil {
+				opts.Logo = logo
+			}
+		}
+	}
+	if branding := activeBranding(db, userID); branding != nil {
+		opts.WhiteLabel = true
+		opts.Family = brandFonts[branding.Font].Family
+		if accent, ok := pdf.ParseColor(branding.PrimaryColor); ok {
+			opts.Accent = &accent
+		}
+		if opts.Logo == nil && len(branding.Logo) > 0 {
+			if logo, err := pdf.LoadImage(branding.Logo); err == nil {
+				opts.Logo = logo
+			}
+		}
+	}
+	return opts
+}
+
+// getBankAccount returns the bank details of the business in :id, or the
+// user's general ones
+func getBankAccount(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	businessID := c.Params("id")
+	if businessID != "" {
+		if _, err := loadBusiness(db.Select("id"), userID, businessID); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Business profile not found"})
+		}
+	}
+	var account models.BankAccount
+	if err := db.First(&account, "user_id = ? AND business_id = ?", userID, businessID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Bank details not found"})
+	}
+	return c.JSON(account)
+}
+
+// updateBankAccount saves the bank details payment QR codes are built from
+func updateBankAccount(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	db := middleware.DB(c)
+
+	businessID := c.Params("id")
+	if businessID != "" {
+		if _, err := loadBusiness(db.Select("id"), userID, businessID); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Business profile not found"})
+		}
+	}
+	var updateData models.BankAccount
+	if err := c.BodyParser(&updateData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+	account, err := saveBankAccount(c, db, userID, businessID, updateData)
+	if err != nil {
+		return err
+	}
+	return c.JSON(account)
+}
+
+// saveBankAccount validates and stores the bank details of a business, or
+// the user's general ones when businessID is empty
+func saveBankAccount(c *fiber.Ctx, db *gorm.DB, userID, businessID string, updateData models.BankAccount) (models.BankAccount, error) {
+	var account models.BankAccount
+	updateData.IBAN = payqr.NormalizeIBAN(updateData.IBAN)
+	updateData.BIC = strings.ToUpper(strings.TrimSpace(updateData.BIC))
+	updateData.Country = strings.ToUpper(strings.TrimSpace(updateData.Country))
+	if updateData.IBAN != "" && !payqr.ValidIBAN(updateData.IBAN) {
+		return account, fiber.NewError(400, "Invalid IBAN")
+	}
+	if updateData.BIC != "" && len(updateData.BIC) != 8 && len(updateData.BIC) != 11 {
+		return account, fiber.NewError(400, "BIC must be 8 or 11 characters")
+	}
+	if updateData.Country != "" && len(updateData.Country) != 2 {
+		return account, fiber.NewError(400, "Country must be an ISO 3166 two-letter code")
+	}
+	if updateData.UPIID != "" && !strings.Contains(updateData.UPIID, "@") {
+		return account, fiber.NewError(400, fmt.Sprintf("Invalid UPI ID %q", updateData.UPIID))
+	}
+
+	var before interface{}
+	if err := db.First(&account, "user_id = ? AND business_id = ?", userID, businessID).Error; err == nil {
+		before = account
+	} else {
+		account = models.BankAccount{ID: models.GenerateBankAccountID(), UserID: userID, BusinessID: businessID}
+	}
+	account.AccountHolder = strings.TrimSpace(updateData.AccountHolder)
+	account.IBAN = updateData.IBAN
+	account.BIC = updateData.BIC
+	account.AccountNumber = strings.TrimSpace(updateData.AccountNumber)
+	account.RoutingNumber = strings.TrimSpace(updateData.RoutingNumber)
+	account.UPIID = strings.TrimSpace(updateData.UPIID)
+	account.Street = strings.TrimSpace(updateData.Street)
+	account.BuildingNumber = strings.TrimSpace(updateData.BuildingNumber)
+	account.PostalCode = strings.TrimSpace(updateData.PostalCode)
+	account.City = strings.TrimSpace(updateData.City)
+	account.Country = updateData.Country
+	if err := db.Save(&account).Error; err != nil {
+		return account, fiber.NewError(500, "Failed to save bank details")
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ProfileUpdated,
+		TargetType: "bank_account",
+		TargetID:   account.ID,
+		Before:     before,
+		After:      account,
+	})
+	return account, nil
+}
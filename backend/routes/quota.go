@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"billow-backend/billing"
+	"billow-backend/middleware"
+	"billow-backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// reserveQuota checks the user's entitled limit before creating a record, see
+// billing.Reserve. Users without a subscription only get limits from overrides.
+func reserveQuota(c *fiber.Ctx, db *gorm.DB, name string) error {
+	subject, err := middleware.GetSubjectFromContext(c)
+	if err != nil {
+		return err
+	}
+	sub := models.Subscription{UserID: subject.UserID}
+	if subject.Subscription != nil {
+		sub = *subject.Subscription
+	}
+	return billing.Reserve(db, sub, name, subject.Entitlements.Limit(name))
+}
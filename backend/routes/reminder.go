@@ -0,0 +1,69 @@
+package routes
+
+import (
+	"billow-backend/billing"
+	"billow-backend/i18n"
+	"billow-backend/mailer"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"billow-backend/policy"
+	"billow-backend/statement"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sendInvoiceReminder emails the client's billing contacts a reminder to pay
+// what is still outstanding on the invoice, in the invoice's language
+func sendInvoiceReminder(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+	var invoice models.Invoice
+	if err := findOwned(c, policy.InvoiceRemind, &invoice, c.Params("id")); err != nil {
+		return err
+	}
+	db.Preload("Client.Contacts").Preload("User").First(&invoice, "id = ?", invoice.ID)
+
+	if statement.IsPaid(invoice) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invoice is already paid"})
+	}
+	if len(invoice.Client.BillingEmails()) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "The client has no email address"})
+	}
+	outstanding, err := billing.Outstanding(db, invoice)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to send reminder"})
+	}
+	if billing.MinorUnits(outstanding, invoice.CurrencyType) <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Nothing is left to pay on this invoice"})
+	}
+
+	locale := i18n.InvoiceLocale(db, invoice)
+	issuer := businessName(db, invoice.User, invoice.BusinessID)
+	msg := reminderMessage(invoice, outstanding, issuer, locale, time.Now())
+	mailer.SendAsync(brandedMessage(msg, activeBranding(db, invoice.UserID), locale))
+
+	return c.Status(202).JSON(fiber.Map{"message": "Reminder sent", "to": msg.To})
+}
+
+// reminderMessage writes the payment reminder for an invoice with amount
+// still to pay
+func reminderMessage(invoice models.Invoice, amount float64, issuer, locale string, now time.Time) mailer.Message {
+	t := func(msg string, args ...interface{}) string { return i18n.T(locale, msg, args...) }
+	number := invoice.DisplayNumber()
+	money := i18n.FormatMoney(locale, amount, invoice.CurrencyType)
+	due := i18n.FormatDate(locale, invoice.DueDate)
+
+	notice := t("This is a friendly reminder that invoice %s for %s is due on %s.", number, money, due)
+	if statement.IsOverdue(invoice, now) {
+		notice = t("This is a friendly reminder that invoice %s for %s was due on %s.", number, money, due)
+	}
+	return mailer.Message{
+		To:      invoice.Client.BillingEmails(),
+		Subject: t("Payment reminder: invoice %s", number),
+		Body: fmt.Sprintf("%s\n\n%s\n\n%s\n\n%s\n%s\n",
+			t("Hello %s,", invoice.Client.Name), notice,
+			t("If you have already paid, please disregard this email."),
+			t("Kind regards,"), issuer),
+	}
+}
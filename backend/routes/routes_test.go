@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"billow-backend/middleware"
+	"billow-backend/policy"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// publicRoutes are reachable without going through the policy engine
+var publicRoutes = map[string]bool{
+	"POST /api/auth/sync-user":        true,
+	"POST /api/auth/webhook":          true,
+	"POST /api/billing/webhook":       true,
+	"POST /api/payments/webhook":      true,
+	"POST /api/portal/login":          true,
+	"POST /api/portal/session":        true,
+	"GET /api/public/invoices/:token": true,
+}
+
+// tenantRoutes are the routes that address a tenant's record by ID, each with a
+// request for tenant A's records. Another tenant must get a 404 from every one
+// of them; tenant_integration_test.go sends them.
+var tenantRoutes = map[string]struct{ path, body string }{
+	"GET /api/clients/:id":                          {"/api/clients/CLI-XT-A", ""},
+	"PUT /api/clients/:id":                          {"/api/clients/CLI-XT-A", `{"name":"Taken over"}`},
+	"DELETE /api/clients/:id":                       {"/api/clients/CLI-XT-A", ""},
+	"GET /api/clients/:id/revenue-data":             {"/api/clients/CLI-XT-A/revenue-data", ""},
+	"GET /api/clients/:id/statement":                {"/api/clients/CLI-XT-A/statement", ""},
+	"POST /api/clients/:id/statement/send":          {"/api/clients/CLI-XT-A/statement/send", ""},
+	"GET /api/clients/:id/contacts":                 {"/api/clients/CLI-XT-A/contacts", ""},
+	"POST /api/clients/:id/contacts":                {"/api/clients/CLI-XT-A/contacts", `{"name":"Mallory","email":"m@xt.test","is_billing_contact":true}`},
+	"PUT /api/clients/:id/contacts/:contactId":      {"/api/clients/CLI-XT-A/contacts/CON-XT-A", `{"name":"Mallory","email":"m@xt.test"}`},
+	"DELETE /api/clients/:id/contacts/:contactId":   {"/api/clients/CLI-XT-A/contacts/CON-XT-A", ""},
+	"GET /api/invoices/:id":                         {"/api/invoices/INV-XT-A", ""},
+	"PUT /api/invoices/:id":                         {"/api/invoices/INV-XT-A", `{"amount":1,"status":"paid"}`},
+	"DELETE /api/invoices/:id":                      {"/api/invoices/INV-XT-A", ""},
+	"POST /api/invoices/:id/share":                  {"/api/invoices/INV-XT-A/share", `{}`},
+	"GET /api/invoices/:id/shares":                  {"/api/invoices/INV-XT-A/shares", ""},
+	"DELETE /api/invoices/:id/shares/:shareId":      {"/api/invoices/INV-XT-A/shares/SHR-XT-A", ""},
+	"POST /api/invoices/:id/payment-link":           {"/api/invoices/INV-XT-A/payment-link", ""},
+	"POST /api/invoices/:id/remind":                 {"/api/invoices/INV-XT-A/remind", ""},
+	"GET /api/invoices/:id/qr":                      {"/api/invoices/INV-XT-A/qr", ""},
+	"GET /api/invoices/:id/html":                    {"/api/invoices/INV-XT-A/html", ""},
+	"GET /api/settings/businesses/:id":              {"/api/settings/businesses/BIZ-XT-A", ""},
+	"PUT /api/settings/businesses/:id":              {"/api/settings/businesses/BIZ-XT-A", `{"legal_name":"Taken over"}`},
+	"DELETE /api/settings/businesses/:id":           {"/api/settings/businesses/BIZ-XT-A", ""},
+	"GET /api/settings/businesses/:id/logo":         {"/api/settings/businesses/BIZ-XT-A/logo", ""},
+	"PUT /api/settings/businesses/:id/logo":         {"/api/settings/businesses/BIZ-XT-A/logo", ""},
+	"DELETE /api/settings/businesses/:id/logo":      {"/api/settings/businesses/BIZ-XT-A/logo", ""},
+	"GET /api/settings/businesses/:id/bank-account": {"/api/settings/businesses/BIZ-XT-A/bank-account", ""},
+	"PUT /api/settings/businesses/:id/bank-account": {"/api/settings/businesses/BIZ-XT-A/bank-account", `{"iban":"DE89370400440532013000"}`},
+	"GET /api/settings/templates/:id":               {"/api/settings/templates/TPL-XT-A", ""},
+	"PUT /api/settings/templates/:id":               {"/api/settings/templates/TPL-XT-A", `{"name":"Taken over","body":"<p></p>"}`},
+	"DELETE /api/settings/templates/:id":            {"/api/settings/templates/TPL-XT-A", ""},
+}
+
+func setupApp() *fiber.App {
+	app := fiber.New()
+	SetupAuthRoutes(app)
+	Setup(app)
+	SetupClientRoutes(app)
+	SetupDashboardRoutes(app)
+	SetupSettingsRoutes(app)
+	SetupBillingRoutes(app)
+	SetupAdminRoutes(app)
+	SetupPortalRoutes(app)
+	return app
+}
+
+// Every API route must pass through middleware.Authorize so a handler can't be
+// reached without a policy decision.
+func TestEveryRouteIsAuthorized(t *testing.T) {
+	authorize := reflect.ValueOf(middleware.Authorize(policy.ClientRead)).Pointer()
+
+	for _, route := range setupApp().GetRoutes(true) {
+		if route.Method == fiber.MethodHead || !strings.HasPrefix(route.Path, "/api/") {
+			continue
+		}
+		key := route.Method + " " + route.Path
+		if publicRoutes[key] {
+			continue
+		}
+
+		guarded := false
+		for _, h := range route.Handlers {
+			if reflect.ValueOf(h).Pointer() == authorize {
+				guarded = true
+				break
+			}
+		}
+		if !guarded {
+			t.Errorf("%s is not guarded by middleware.Authorize", key)
+		}
+	}
+}
+
+// Every route addressing a tenant's record by ID must be in tenantRoutes, so
+// the cross-tenant test can't miss one
+func TestEveryTenantRouteIsCheckedAcrossTenants(t *testing.T) {
+	prefixes := []string{"/api/clients/:id", "/api/invoices/:id", "/api/settings/businesses/:id", "/api/settings/templates/:id"}
+	for _, route := range setupApp().GetRoutes(true) {
+		if route.Method == fiber.MethodHead {
+			continue
+		}
+		for _, prefix := range prefixes {
+			key := route.Method + " " + route.Path
+			if strings.HasPrefix(route.Path, prefix) {
+				if _, ok := tenantRoutes[key]; !ok {
+					t.Errorf("%s is missing from tenantRoutes", key)
+				}
+			}
+		}
+	}
+}
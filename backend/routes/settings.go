@@ -1,39 +1,93 @@
 package routes
 
 import (
+	"billow-backend/audit"
+	"billow-backend/billing"
 	"billow-backend/config"
+	"billow-backend/entitlements"
 	"billow-backend/middleware"
 	"billow-backend/models"
+	"billow-backend/policy"
+	"billow-backend/usage"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func SetupSettingsRoutes(app *fiber.App) {
 	// Apply auth middleware to all settings routes
-	settings := app.Group("/api/settings", middleware.AuthMiddleware())
-	subscription := app.Group("/api/subscription", middleware.AuthMiddleware())
-	analytics := app.Group("/api/analytics", middleware.AuthMiddleware())
+	settings := app.Group("/api/settings", middleware.AuthMiddleware(), middleware.TenantTransaction())
+	subscription := app.Group("/api/subscription", middleware.AuthMiddleware(), middleware.TenantTransaction())
+	analytics := app.Group("/api/analytics", middleware.AuthMiddleware(), middleware.TenantTransaction())
 
 	// Profile settings
-	settings.Post("/profile", updateProfile)
-	settings.Get("/profile", getProfile)
+	settings.Post("/profile", middleware.Authorize(policy.ProfileUpdate), updateProfile)
+	settings.Get("/profile", middleware.Authorize(policy.ProfileRead), getProfile)
+	settings.Get("/bank-account", middleware.Authorize(policy.ProfileRead), getBankAccount)
+	settings.Put("/bank-account", middleware.Authorize(policy.ProfileUpdate), updateBankAccount)
+
+	// Business entities; /business is the default one
+	settings.Get("/business", middleware.Authorize(policy.ProfileRead), getBusinessProfile)
+	settings.Put("/business", middleware.Authorize(policy.ProfileUpdate), updateBusinessProfile)
+	settings.Delete("/business", middleware.Authorize(policy.ProfileUpdate), deleteBusinessProfile)
+	settings.Get("/business/logo", middleware.Authorize(policy.ProfileRead), getBusinessLogo)
+	settings.Put("/business/logo", middleware.Authorize(policy.ProfileUpdate), uploadBusinessLogo)
+	settings.Delete("/business/logo", middleware.Authorize(policy.ProfileUpdate), deleteBusinessLogo)
+	settings.Get("/businesses", middleware.Authorize(policy.ProfileRead), getBusinessProfiles)
+	settings.Post("/businesses", middleware.Authorize(policy.ProfileUpdate), createBusinessProfile)
+	settings.Get("/businesses/:id", middleware.Authorize(policy.ProfileRead), getBusinessProfile)
+	settings.Put("/businesses/:id", middleware.Authorize(policy.ProfileUpdate), updateBusinessProfile)
+	settings.Delete("/businesses/:id", middleware.Authorize(policy.ProfileUpdate), deleteBusinessProfile)
+	settings.Get("/businesses/:id/logo", middleware.Authorize(policy.ProfileRead), getBusinessLogo)
+	settings.Put("/businesses/:id/logo", middleware.Authorize(policy.ProfileUpdate), uploadBusinessLogo)
+	settings.Delete("/businesses/:id/logo", middleware.Authorize(policy.ProfileUpdate), deleteBusinessLogo)
+	settings.Get("/businesses/:id/bank-account", middleware.Authorize(policy.ProfileRead), getBankAccount)
+	settings.Put("/businesses/:id/bank-account", middleware.Authorize(policy.ProfileUpdate), updateBankAccount)
+
+	// White-label branding
+	settings.Get("/branding", middleware.Authorize(policy.BrandingManage), getBranding)
+	settings.Put("/branding", middleware.Authorize(policy.BrandingManage), updateBranding)
+	settings.Delete("/branding", middleware.Authorize(policy.BrandingManage), deleteBranding)
+	settings.Get("/branding/logo", middleware.Authorize(policy.BrandingManage), getBrandingLogo)
+	settings.Put("/branding/logo", middleware.Authorize(policy.BrandingManage), uploadBrandingLogo)
+	settings.Delete("/branding/logo", middleware.Authorize(policy.BrandingManage), deleteBrandingLogo)
+
+	// Invoice templates
+	settings.Get("/templates", middleware.Authorize(policy.TemplatesManage), getInvoiceTemplates)
+	settings.Post("/templates", middleware.Authorize(policy.TemplatesManage), createInvoiceTemplate)
+	settings.Post("/templates/preview", middleware.Authorize(policy.TemplatesManage), previewInvoiceTemplate)
+	settings.Get("/templates/:id", middleware.Authorize(policy.TemplatesManage), getInvoiceTemplate)
+	settings.Put("/templates/:id", middleware.Authorize(policy.TemplatesManage), updateInvoiceTemplate)
+	settings.Delete("/templates/:id", middleware.Authorize(policy.TemplatesManage), deleteInvoiceTemplate)
 
 	// Subscription management
-	subscription.Get("/status", getSubscriptionStatus)
-	subscription.Post("/change", changeSubscription)
-	subscription.Get("/usage", getUsageMetrics)
-	subscription.Get("/plans", getAvailablePlans)
+	subscription.Get("/status", middleware.Authorize(policy.SubscriptionRead), getSubscriptionStatus)
+	subscription.Post("/change", middleware.Authorize(policy.SubscriptionChange), changeSubscription)
+	subscription.Post("/preview-change", middleware.Authorize(policy.SubscriptionRead), previewSubscriptionChange)
+	subscription.Post("/redeem", middleware.Authorize(policy.SubscriptionChange), redeemPromotionCode)
+	subscription.Get("/usage", middleware.Authorize(policy.SubscriptionRead), getUsageMetrics)
+	subscription.Get("/usage/statement", middleware.Authorize(policy.SubscriptionRead), getUsageStatement)
+	subscription.Get("/entitlements", middleware.Authorize(policy.SubscriptionRead), getEntitlements)
+	subscription.Get("/plans", middleware.Authorize(policy.PlansRead), getAvailablePlans)
+	subscription.Post("/cancel", middleware.Authorize(policy.SubscriptionChange), cancelSubscription)
+	subscription.Post("/resume", middleware.Authorize(policy.SubscriptionChange), resumeSubscription)
+	subscription.Post("/checkout", middleware.Authorize(policy.SubscriptionChange), createCheckoutSession)
+	subscription.Post("/portal", middleware.Authorize(policy.SubscriptionChange), createPortalSession)
 
 	// Preferences
-	settings.Post("/preferences", updatePreferences)
-	settings.Get("/preferences", getPreferences)
+	settings.Post("/preferences", middleware.Authorize(policy.PreferencesUpdate), updatePreferences)
+	settings.Get("/preferences", middleware.Authorize(policy.PreferencesRead), getPreferences)
+
+	// Security
+	settings.Get("/audit-log", middleware.Authorize(policy.AuditLogRead), getAuditLog)
 
 	// Analytics
-	analytics.Get("/usage", getUsageAnalytics)
-	analytics.Get("/dashboard", getAnalyticsDashboard)
+	analytics.Get("/usage", middleware.Authorize(policy.AnalyticsRead), getUsageAnalytics)
+	analytics.Get("/dashboard", middleware.Authorize(policy.AnalyticsRead), getAnalyticsDashboard)
 }
 
 // Profile Management
@@ -42,6 +96,7 @@ func updateProfile(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
+	db := middleware.DB(c)
 
 	var updateData struct {
 		DisplayName  string `json:"display_name"`
@@ -63,10 +118,12 @@ func updateProfile(c *fiber.Ctx) error {
 
 	// Update user profile
 	var user models.User
-	if err := config.DB.First(&user, "id = ?", userID).Error; err != nil {
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
 	}
 
+	before := user
+
 	// Update existing user
 	if updateData.DisplayName != "" {
 		user.DisplayName = updateData.DisplayName
@@ -78,10 +135,18 @@ func updateProfile(c *fiber.Ctx) error {
 		user.ProfileImage = updateData.ProfileImage
 	}
 
-	if err := config.DB.Save(&user).Error; err != nil {
+	if err := db.Save(&user).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update profile"})
 	}
 
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ProfileUpdated,
+		TargetType: "user",
+		TargetID:   user.ID,
+		Before:     before,
+		After:      user,
+	})
+
 	return c.JSON(fiber.Map{
 		"message": "Profile updated successfully",
 		"user":    user,
@@ -103,55 +168,126 @@ func getSubscriptionStatus(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
+	db := middleware.DB(c)
 
 	var subscription models.Subscription
-	if err := config.DB.Preload("Plan").First(&subscription, "user_id = ?", userID).Error; err != nil {
+	if err := db.Preload("Plan").First(&subscription, "user_id = ?", userID).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Subscription not found"})
 	}
 
-	return c.JSON(fiber.Map{"subscription": subscription})
+	response := fiber.Map{"subscription": subscription}
+	discount, err := billing.ActiveDiscount(db, subscription.ID, time.Now())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch discount"})
+	}
+	if discount != nil {
+		response["discount"] = discountResponse(discount, subscription.Plan)
+	}
+
+	return c.JSON(response)
 }
 
-func getUsageMetrics(c *fiber.Ctx) error {
-	userID, err := middleware.GetUserIDFromContext(c)
+// getEntitlements returns the features and limits the user has, resolved from
+// their plan, add-ons and overrides
+func getEntitlements(c *fiber.Ctx) error {
+	ents, err := middleware.Entitlements(c)
 	if err != nil {
 		return err
 	}
+	return c.JSON(ents)
+}
 
-	// Get current month usage
-	startOfMonth := time.Now().AddDate(0, 0, -time.Now().Day()+1)
+// getUsageStatement returns the metered usage statement of the billing period
+// containing ?date= (YYYY-MM-DD), or of the current period. The current
+// period's usage is aggregated first so the statement is up to date.
+func getUsageStatement(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
 
-	// Count invoices created this month
-	var invoiceCount int64
-	config.DB.Model(&models.Invoice{}).Where("user_id = ? AND created_at >= ?", userID, startOfMonth).Count(&invoiceCount)
+	var subscription models.Subscription
+	if err := db.Preload("Plan").First(&subscription, "user_id = ?", userID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Subscription not found"})
+	}
 
-	// Count clients created this month
-	var clientCount int64
-	config.DB.Model(&models.Client{}).Where("user_id = ? AND created_at >= ?", userID, startOfMonth).Count(&clientCount)
+	now := time.Now()
+	at := now
+	if date := c.Query("date"); date != "" {
+		if at, err = time.Parse("2006-01-02", date); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "date must be YYYY-MM-DD"})
+		}
+		if at.After(now) {
+			return c.Status(400).JSON(fiber.Map{"error": "date can't be in the future"})
+		}
+	}
 
-	// Get usage logs for other features
-	var usageLogs []models.UsageLog
-	config.DB.Where("user_id = ? AND timestamp >= ?", userID, startOfMonth).Find(&usageLogs)
+	ents, err := middleware.Entitlements(c)
+	if err != nil {
+		return err
+	}
+	if err := billing.AggregateUsage(db, subscription, ents, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to aggregate usage"})
+	}
 
-	messagesCount := 0
-	imagesGenerated := 0
+	start, end := billing.PeriodContaining(subscription, at, now)
+	statement, err := billing.BuildStatement(db, subscription, start, end)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build statement"})
+	}
+	return c.JSON(statement)
+}
 
-	for _, log := range usageLogs {
-		switch log.FeatureType {
-		case "message_sent":
-			messagesCount += log.Count
-		case "image_generated":
-			imagesGenerated += log.Count
-		}
+func getUsageMetrics(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
 	}
+	db := middleware.DB(c)
 
 	// Get user's subscription to get limits
 	var subscription models.Subscription
-	if err := config.DB.Preload("Plan").First(&subscription, "user_id = ?", userID).Error; err != nil {
+	if err := db.Preload("Plan").First(&subscription, "user_id = ?", userID).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Subscription not found"})
 	}
 
+	// Usage is counted over the current billing period
+	periodStart, periodEnd := billing.CurrentPeriod(subscription, time.Now())
+
+	// Count invoices created this period
+	var invoiceCount int64
+	db.Model(&models.Invoice{}).Where("user_id = ? AND created_at >= ?", userID, periodStart).Count(&invoiceCount)
+
+	// Count clients created this period
+	var clientCount int64
+	db.Model(&models.Client{}).Where("user_id = ? AND created_at >= ?", userID, periodStart).Count(&clientCount)
+
+	// Get daily usage counters for other features
+	totals, err := usage.Totals(db, userID, periodStart)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch usage"})
+	}
+	messagesCount := totals[usage.MessageSent]
+	imagesGenerated := totals[usage.ImageGenerated]
+
+	ents, err := middleware.Entitlements(c)
+	if err != nil {
+		return err
+	}
+
+	// Remaining quota for the limits enforced on create
+	quotas := map[string]billing.Quota{}
+	for _, name := range []string{billing.InvoiceQuota, billing.ClientQuota} {
+		quota, err := billing.LoadQuota(db, subscription, name, ents.Limit(name))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch usage"})
+		}
+		quotas[name] = quota
+	}
+
 	return c.JSON(fiber.Map{
+		"quotas": quotas,
 		"current_usage": map[string]interface{}{
 			"invoices_created": invoiceCount,
 			"clients_created":  clientCount,
@@ -159,26 +295,36 @@ func getUsageMetrics(c *fiber.Ctx) error {
 			"images_generated": imagesGenerated,
 		},
 		"limits": map[string]interface{}{
-			"invoice_limit":      subscription.Plan.InvoiceLimit,
-			"client_limit":       subscription.Plan.ClientLimit,
-			"messages_per_day":   subscription.Plan.MessagesPerDay,
-			"image_generation":   subscription.Plan.ImageGeneration,
-			"custom_voice":       subscription.Plan.CustomVoice,
-			"priority_support":   subscription.Plan.PrioritySupport,
-			"advanced_analytics": subscription.Plan.AdvancedAnalytics,
-			"api_access":         subscription.Plan.APIAccess,
-			"white_label":        subscription.Plan.WhiteLabel,
+			"invoice_limit":      ents.Limit(entitlements.InvoiceQuota),
+			"client_limit":       ents.Limit(entitlements.ClientQuota),
+			"messages_per_day":   ents.Limit(entitlements.MessageQuota),
+			"image_generation":   ents.Has("image_generation"),
+			"custom_voice":       ents.Has("custom_voice"),
+			"priority_support":   ents.Has("priority_support"),
+			"advanced_analytics": ents.Has("advanced_analytics"),
+			"api_access":         ents.Has("api_access"),
+			"white_label":        ents.Has("white_label"),
 		},
 		"period": map[string]interface{}{
-			"start": startOfMonth,
-			"end":   subscription.CurrentPeriodEnd,
+			"start": periodStart,
+			"end":   periodEnd,
 		},
 	})
 }
 
 func getAvailablePlans(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+	query := db.Scopes(billing.Offered).Order("interval ASC, price ASC")
+	if interval := c.Query("interval"); interval != "" {
+		query = query.Where("interval = ?", interval)
+	}
+
 	var plans []models.Plan
-	if err := config.DB.Find(&plans).Error; err != nil {
+	if err := query.Find(&plans).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch plans"})
 	}
 
@@ -186,6 +332,13 @@ func getAvailablePlans(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "No plans available"})
 	}
 
+	// Prices reflect the user's discount where it applies
+	var subscription models.Subscription
+	var discount *models.Discount
+	if db.Where("user_id = ?", userID).Limit(1).Find(&subscription).RowsAffected > 0 {
+		discount, _ = billing.ActiveDiscount(db, subscription.ID, time.Now())
+	}
+
 	// Add features list for each plan
 	plansWithFeatures := make([]map[string]interface{}, len(plans))
 	for i, plan := range plans {
@@ -194,7 +347,7 @@ func getAvailablePlans(c *fiber.Ctx) error {
 		if plan.InvoiceLimit == -1 {
 			features = append(features, "Unlimited invoices")
 		} else {
-			features = append(features, fmt.Sprintf("Up to %d invoices/month", plan.InvoiceLimit))
+			features = append(features, fmt.Sprintf("Up to %d invoices/%s", plan.InvoiceLimit, plan.Interval))
 		}
 
 		if plan.ClientLimit == -1 {
@@ -240,18 +393,68 @@ func getAvailablePlans(c *fiber.Ctx) error {
 			"currency": plan.Currency,
 			"interval": plan.Interval,
 			"features": features,
-			"popular":  plan.Name == "Pro", // Mark Pro as popular
+			"popular":  models.BasePlanID(plan.ID) == "PLN-PRO", // Mark Pro as popular
+		}
+		if discount != nil && discount.Coupon.AppliesTo(plan.ID) {
+			plansWithFeatures[i]["discounted_price"] = billing.DiscountedPrice(plan, discount.Coupon)
+			plansWithFeatures[i]["discount_ends_at"] = discount.EndsAt
 		}
 	}
 
 	return c.JSON(fiber.Map{"plans": plansWithFeatures})
 }
 
+// previewSubscriptionChange shows what changeSubscription would charge and
+// when the new plan would take effect, without changing anything
+func previewSubscriptionChange(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	var changeData struct {
+		PlanID string `json:"plan_id"`
+	}
+	if err := c.BodyParser(&changeData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+
+	var plan models.Plan
+	if err := db.First(&plan, "id = ?", changeData.PlanID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Plan not found"})
+	}
+
+	var subscription models.Subscription
+	db.Preload("Plan").Where("user_id = ?", userID).Limit(1).Find(&subscription)
+
+	if err := requireOffered(db, plan, subscription.PlanID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	discount, err := subscriptionDiscount(db, subscription, now)
+	if err != nil {
+		return err
+	}
+	return c.JSON(billing.PreviewChange(subscription, plan, discount, now))
+}
+
+// subscriptionDiscount is the discount active on the subscription, or nil
+// when there is none or no subscription yet
+func subscriptionDiscount(db *gorm.DB, subscription models.Subscription, now time.Time) (*models.Discount, error) {
+	if subscription.ID == "" {
+		return nil, nil
+	}
+	return billing.ActiveDiscount(db, subscription.ID, now)
+}
+
 func changeSubscription(c *fiber.Ctx) error {
 	userID, err := middleware.GetUserIDFromContext(c)
 	if err != nil {
 		return err
 	}
+	db := middleware.DB(c)
 
 	var changeData struct {
 		PlanID string `json:"plan_id"`
@@ -263,45 +466,217 @@ func changeSubscription(c *fiber.Ctx) error {
 
 	// Verify plan exists
 	var plan models.Plan
-	if err := config.DB.First(&plan, "id = ?", changeData.PlanID).Error; err != nil {
+	if err := db.First(&plan, "id = ?", changeData.PlanID).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Plan not found"})
 	}
 
-	// In production, this would integrate with payment processor (Stripe, etc.)
 	var subscription models.Subscription
-	if err := config.DB.First(&subscription, "user_id = ?", userID).Error; err != nil {
+	var before interface{}
+	found := db.Preload("Plan").First(&subscription, "user_id = ?", userID).Error == nil
+
+	if err := requireOffered(db, plan, subscription.PlanID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	discount, err := subscriptionDiscount(db, subscription, now)
+	if err != nil {
+		return err
+	}
+	preview := billing.PreviewChange(subscription, plan, discount, now)
+
+	// With a payment provider, paid plans are bought through checkout and
+	// changed on the provider; the webhooks confirm the local state
+	billedByProvider := false
+	if billing.DefaultProvider != nil {
+		billedByProvider = subscription.ProviderSubscriptionID != "" && subscription.HasAccess(now)
+
+		switch {
+		case billedByProvider && plan.ProviderPriceID == "":
+			return c.Status(409).JSON(fiber.Map{"error": "Cancel the paid subscription from the billing portal to switch to this plan"})
+
+		case billedByProvider:
+			// The price is changed once the local change is committed, below
+
+		case plan.ProviderPriceID != "":
+			user, err := middleware.GetUserFromContext(c)
+			if err != nil {
+				return err
+			}
+			session, err := startCheckout(c, user, &plan)
+			if err != nil {
+				return err
+			}
+			return c.Status(402).JSON(fiber.Map{
+				"error":        "Payment required",
+				"checkout_url": session.URL,
+				"session_id":   session.ID,
+			})
+		}
+	}
+
+	message := "Subscription updated successfully"
+	if !found {
 		// Create new subscription
 		subscription = models.Subscription{
 			ID:               models.GenerateSubscriptionID(),
 			UserID:           userID,
 			PlanID:           changeData.PlanID,
-			Status:           "active",
-			CurrentPeriodEnd: time.Now().AddDate(0, 1, 0),
+			Status:           models.SubscriptionActive,
+			CurrentPeriodEnd: billing.AddInterval(now, plan.Interval, 1),
 		}
-		if err := config.DB.Create(&subscription).Error; err != nil {
+		if err := db.Create(&subscription).Error; err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to create subscription"})
 		}
 	} else {
 		// Update existing subscription
-		subscription.PlanID = changeData.PlanID
-		subscription.UpdatedAt = time.Now()
-		if err := config.DB.Save(&subscription).Error; err != nil {
+		before = subscription
+		subscription.UpdatedAt = now
+
+		switch {
+		case preview.Timing == billing.ChangeAtPeriodEnd:
+			// Downgrades wait for the end of the paid period
+			subscription.ScheduledPlanID = plan.ID
+			message = "Plan will change at the end of the current period"
+
+		case !subscription.HasAccess(now):
+			// Choosing a plan after the trial expired or the subscription
+			// ended starts a new billing period
+			subscription.PlanID = plan.ID
+			subscription.Status = models.SubscriptionActive
+			subscription.CurrentPeriodEnd = preview.NewPeriodEnd
+			subscription.CancelAtPeriodEnd = false
+			subscription.CanceledAt = nil
+			subscription.ScheduledPlanID = ""
+
+		default:
+			// Upgrades, and switching back to the current plan, which drops
+			// a scheduled downgrade. Only a new interval moves the billing date.
+			if subscription.Plan.Interval != plan.Interval {
+				subscription.CurrentPeriodEnd = preview.NewPeriodEnd
+			}
+			subscription.PlanID = plan.ID
+			subscription.ScheduledPlanID = ""
+		}
+		if subscription.PlanID == plan.ID {
+			subscription.Plan = plan
+		}
+
+		if err := db.Omit(clause.Associations).Save(&subscription).Error; err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to update subscription"})
 		}
 	}
 
+	if billedByProvider {
+		// Downgrades keep the current price until renewal, so there is
+		// nothing to prorate
+		prorate := preview.Timing == billing.ChangeImmediate
+		if err := changeProviderPrice(c, before.(models.Subscription), subscription, plan, prorate); err != nil {
+			return err
+		}
+	}
+
 	// Log the subscription change
-	usageLog := models.UsageLog{
-		ID:          models.GenerateUsageLogID(),
+	usage.Track(usage.Event{
 		UserID:      userID,
-		FeatureType: "subscription_changed",
-		Count:       1,
+		FeatureType: usage.SubscriptionChanged,
 		Metadata:    `{"new_plan_id":"` + changeData.PlanID + `"}`,
+	})
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.SubscriptionChanged,
+		TargetType: "subscription",
+		TargetID:   subscription.ID,
+		Before:     before,
+		After:      subscription,
+	})
+
+	// Without a payment provider nothing is charged or credited, so the
+	// proration is only a preview of what a provider would collect
+	return c.JSON(fiber.Map{
+		"message":             message,
+		"subscription":        subscription,
+		"proration":           preview,
+		"proration_collected": billing.DefaultProvider != nil,
+	})
+}
+
+// changeProviderPrice moves a provider-billed subscription to the plan's price
+// once the local change has committed, so the provider never bills for a
+// change that rolled back. If the provider refuses, the local change is
+// reverted to keep the plan in line with what is billed.
+func changeProviderPrice(c *fiber.Ctx, previous, changed models.Subscription, plan models.Plan, prorate bool) error {
+	return middleware.AfterCommit(c, func() error {
+		err := billing.DefaultProvider.ChangePrice(c.UserContext(), previous.ProviderSubscriptionID, plan.ProviderPriceID, prorate)
+		if err == nil {
+			return nil
+		}
+		fmt.Printf("Plan change failed for %s: %v\n", previous.ID, err)
+
+		if err := config.DB.Omit(clause.Associations).Save(&previous).Error; err != nil {
+			fmt.Printf("Error reverting plan change for %s: %v\n", previous.ID, err)
+		} else {
+			audit.RecordSystem(config.DB, audit.Entry{
+				UserID:     previous.UserID,
+				ActorID:    "billing",
+				Action:     audit.SubscriptionUpdated,
+				TargetType: "subscription",
+				TargetID:   previous.ID,
+				Before:     changed,
+				After:      previous,
+			})
+		}
+		return fiber.NewError(502, "Failed to change plan with the payment provider")
+	})
+}
+
+// cancelSubscription schedules the subscription to end when the current
+// period does. The lifecycle worker applies it.
+func cancelSubscription(c *fiber.Ctx) error {
+	return setCancelAtPeriodEnd(c, true)
+}
+
+// resumeSubscription undoes a scheduled cancellation
+func resumeSubscription(c *fiber.Ctx) error {
+	return setCancelAtPeriodEnd(c, false)
+}
+
+func setCancelAtPeriodEnd(c *fiber.Ctx, cancel bool) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	var subscription models.Subscription
+	if err := db.First(&subscription, "user_id = ?", userID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Subscription not found"})
 	}
-	config.DB.Create(&usageLog)
 
+	if !subscription.HasAccess(time.Now()) {
+		return c.Status(400).JSON(fiber.Map{"error": "Subscription is not active", "status": subscription.Status})
+	}
+
+	before := subscription
+	subscription.CancelAtPeriodEnd = cancel
+	if err := db.Save(&subscription).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update subscription"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.SubscriptionChanged,
+		TargetType: "subscription",
+		TargetID:   subscription.ID,
+		Before:     before,
+		After:      subscription,
+	})
+
+	message := "Subscription will be canceled at the end of the current period"
+	if !cancel {
+		message = "Scheduled cancellation removed"
+	}
 	return c.JSON(fiber.Map{
-		"message":      "Subscription updated successfully",
+		"message":      message,
 		"subscription": subscription,
 	})
 }
@@ -312,14 +687,19 @@ func updatePreferences(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
+	db := middleware.DB(c)
 
 	var updateData models.UserPreferences
 	if err := c.BodyParser(&updateData); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
 	}
+	if err := normalizeLanguage(&updateData.Language); err != nil {
+		return err
+	}
 
 	var preferences models.UserPreferences
-	if err := config.DB.First(&preferences, "user_id = ?", userID).Error; err != nil {
+	var before interface{}
+	if err := db.First(&preferences, "user_id = ?", userID).Error; err != nil {
 		// Create new preferences
 		preferences = models.UserPreferences{
 			ID:                 models.GeneratePreferencesID(),
@@ -334,11 +714,12 @@ func updatePreferences(c *fiber.Ctx) error {
 			Currency:           updateData.Currency,
 			Timezone:           updateData.Timezone,
 		}
-		if err := config.DB.Create(&preferences).Error; err != nil {
+		if err := db.Create(&preferences).Error; err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to create preferences"})
 		}
 	} else {
 		// Update existing preferences
+		before = preferences
 		if updateData.Theme != "" {
 			preferences.Theme = updateData.Theme
 		}
@@ -356,11 +737,19 @@ func updatePreferences(c *fiber.Ctx) error {
 		if updateData.Timezone != "" {
 			preferences.Timezone = updateData.Timezone
 		}
-		if err := config.DB.Save(&preferences).Error; err != nil {
+		if err := db.Save(&preferences).Error; err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to update preferences"})
 		}
 	}
 
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.PreferencesUpdated,
+		TargetType: "preferences",
+		TargetID:   preferences.ID,
+		Before:     before,
+		After:      preferences,
+	})
+
 	return c.JSON(fiber.Map{
 		"message":     "Preferences updated successfully",
 		"preferences": preferences,
@@ -372,9 +761,10 @@ func getPreferences(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
+	db := middleware.DB(c)
 
 	var preferences models.UserPreferences
-	if err := config.DB.First(&preferences, "user_id = ?", userID).Error; err != nil {
+	if err := db.First(&preferences, "user_id = ?", userID).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Preferences not found"})
 	}
 
@@ -387,12 +777,13 @@ func getUsageAnalytics(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
+	db := middleware.DB(c)
 
 	// Get last 30 days of analytics data
 	thirtyDaysAgo := time.Now().AddDate(0, 0, -30)
 
 	var analyticsData []models.AnalyticsData
-	if err := config.DB.Where("user_id = ? AND date >= ?", userID, thirtyDaysAgo).
+	if err := db.Where("user_id = ? AND date >= ?", userID, thirtyDaysAgo).
 		Order("date ASC").
 		Find(&analyticsData).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch analytics data"})
@@ -410,30 +801,32 @@ func getAnalyticsDashboard(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
+	db := middleware.DB(c)
+
+	scope, _, err := businessScope(c)
+	if err != nil {
+		return err
+	}
 
 	// Get current month stats
 	startOfMonth := time.Now().AddDate(0, 0, -time.Now().Day()+1)
 
 	var invoiceCount int64
-	config.DB.Model(&models.Invoice{}).Where("user_id = ? AND created_at >= ?", userID, startOfMonth).Count(&invoiceCount)
+	db.Model(&models.Invoice{}).Scopes(scope).Where("user_id = ? AND created_at >= ?", userID, startOfMonth).Count(&invoiceCount)
 
 	var clientCount int64
-	config.DB.Model(&models.Client{}).Where("user_id = ? AND created_at >= ?", userID, startOfMonth).Count(&clientCount)
-
-	// Get usage logs
-	var usageLogs []models.UsageLog
-	config.DB.Where("user_id = ? AND timestamp >= ?", userID, startOfMonth).Find(&usageLogs)
+	db.Model(&models.Client{}).Scopes(scope).Where("user_id = ? AND created_at >= ?", userID, startOfMonth).Count(&clientCount)
 
-	messagesCount := 0
-	for _, log := range usageLogs {
-		if log.FeatureType == "message_sent" {
-			messagesCount += log.Count
-		}
+	// Get daily usage counters
+	totals, err := usage.Totals(db, userID, startOfMonth)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch usage"})
 	}
+	messagesCount := totals[usage.MessageSent]
 
 	// Calculate revenue from actual invoices
 	var totalRevenue float64
-	config.DB.Model(&models.Invoice{}).
+	db.Model(&models.Invoice{}).Scopes(scope).
 		Where("user_id = ? AND created_at >= ?", userID, startOfMonth).
 		Select("COALESCE(SUM(amount), 0)").
 		Scan(&totalRevenue)
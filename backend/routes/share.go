@@ -0,0 +1,277 @@
+package routes
+
+import (
+	"billow-backend/audit"
+	"billow-backend/config"
+	"billow-backend/i18n"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"billow-backend/policy"
+	"billow-backend/portal"
+	"billow-backend/render"
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// maxShareDays caps how long a share link may be set to last
+const maxShareDays = 365
+
+// shareInvoice creates a public link to the invoice. The token is only shown
+// in this response; afterwards the link can be listed and revoked but not
+// recovered.
+func shareInvoice(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+	var invoice models.Invoice
+	if err := findOwned(c, policy.InvoiceShare, &invoice, c.Params("id")); err != nil {
+		return err
+	}
+
+	var shareData struct {
+		ExpiresInDays int `json:"expires_in_days"` // 0 never expires
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&shareData); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if shareData.ExpiresInDays < 0 || shareData.ExpiresInDays > maxShareDays {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("expires_in_days must be between 0 and %d", maxShareDays)})
+	}
+
+	token := portal.NewToken()
+	share := models.InvoiceShare{
+		ID:        models.GenerateInvoiceShareID(),
+		UserID:    invoice.UserID,
+		InvoiceID: invoice.ID,
+		TokenHash: portal.HashToken(token),
+	}
+	if shareData.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, shareData.ExpiresInDays)
+		share.ExpiresAt = &expires
+	}
+	if err := db.Create(&share).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to share invoice"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.InvoiceShared,
+		TargetType: "invoice",
+		TargetID:   invoice.ID,
+		After:      share,
+	})
+
+	return c.Status(201).JSON(fiber.Map{
+		"id":         share.ID,
+		"url":        apiURL("/api/public/invoices/" + token),
+		"expires_at": share.ExpiresAt,
+	})
+}
+
+// getInvoiceShares lists the invoice's links and its most recent views
+func getInvoiceShares(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+	var invoice models.Invoice
+	if err := findOwned(c, policy.InvoiceShare, &invoice, c.Params("id")); err != nil {
+		return err
+	}
+
+	var shares []models.InvoiceShare
+	if err := db.Where("invoice_id = ?", invoice.ID).Order("created_at DESC").Find(&shares).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch share links"})
+	}
+	var views []models.InvoiceView
+	if err := db.Where("invoice_id = ?", invoice.ID).Order("viewed_at DESC").Limit(50).Find(&views).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch views"})
+	}
+
+	return c.JSON(fiber.Map{
+		"shares":         shares,
+		"views":          views,
+		"viewed_at":      invoice.ViewedAt,
+		"last_viewed_at": invoice.LastViewedAt,
+		"view_count":     invoice.ViewCount,
+	})
+}
+
+func revokeInvoiceShare(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+	var invoice models.Invoice
+	if err := findOwned(c, policy.InvoiceShare, &invoice, c.Params("id")); err != nil {
+		return err
+	}
+
+	var share models.InvoiceShare
+	if err := db.First(&share, "id = ? AND invoice_id = ?", c.Params("shareId"), invoice.ID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Share link not found"})
+	}
+	if share.RevokedAt == nil {
+		before := share
+		now := time.Now()
+		share.RevokedAt = &now
+		if err := db.Model(&share).Update("revoked_at", now).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to revoke share link"})
+		}
+		audit.Record(c, db, audit.Entry{
+			Action:     audit.InvoiceUnshared,
+			TargetType: "invoice",
+			TargetID:   invoice.ID,
+			Before:     before,
+			After:      share,
+		})
+	}
+
+	return c.JSON(fiber.Map{"message": "Share link revoked"})
+}
+
+// viewSharedInvoice renders a shared invoice as a page, or as a PDF with
+// ?format=pdf, and records the view. The page links to the PDF, so only page
+// loads count as views. Unknown, expired and revoked links all look the same.
+func viewSharedInvoice(c *fiber.Ctx) error {
+	now := time.Now()
+
+	// There is no session, so the link is resolved on the owner connection
+	var share models.InvoiceShare
+	if err := config.DB.First(&share, "token_hash = ?", portal.HashToken(c.Params("token"))).Error; err != nil || !share.ActiveAt(now) {
+		return c.Status(404).JSON(fiber.Map{"error": "This link is invalid or has expired"})
+	}
+
+	var invoice models.Invoice
+	if err := config.DB.Preload("Client").Preload("User").
+		First(&invoice, "id = ? AND user_id = ?", share.InvoiceID, share.UserID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "This link is invalid or has expired"})
+	}
+
+	if c.Method() == fiber.MethodGet && c.Query("format") != "pdf" {
+		if err := recordInvoiceView(config.DB, share, c, now); err != nil {
+			fmt.Printf("Recording view of invoice %s failed: %v\n", invoice.ID, err)
+		}
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set("X-Robots-Tag", "noindex, nofollow")
+	c.Set("Referrer-Policy", "no-referrer")
+
+	if c.Query("format") == "pdf" {
+		c.Set(fiber.HeaderContentType, "application/pdf")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename="%s.pdf"`, invoice.ID))
+		return c.Send(invoicePDF(config.DB, invoice, invoice.User))
+	}
+
+	// Invoices with a template of their own are rendered with it, falling
+	// back to the standard page if it fails
+	if src, ok := invoiceTemplateSource(config.DB, invoice); ok {
+		data := templateData(config.DB, invoice)
+		data.PDFURL = c.Path() + "?format=pdf"
+		page, err := render.Render(src, data)
+		if err == nil {
+			return sendTemplatePage(c, page)
+		}
+		fmt.Printf("Rendering template of invoice %s failed: %v\n", invoice.ID, err)
+	}
+
+	locale := i18n.InvoiceLocale(config.DB, invoice)
+	var page bytes.Buffer
+	if err := sharedInvoicePage.Execute(&page, fiber.Map{
+		"Invoice":     invoice,
+		"Locale":      locale,
+		"Language":    i18n.Language(locale),
+		"Issuer":      businessName(config.DB, invoice.User, invoice.BusinessID),
+		"Brand":       sharedPageBrand(activeBranding(config.DB, invoice.UserID)),
+		"InvoiceDate": i18n.FormatDate(locale, invoice.InvoiceDate),
+		"DueDate":     i18n.FormatDate(locale, invoice.DueDate),
+		"Amount":      i18n.FormatMoney(locale, invoice.Amount, invoice.CurrencyType),
+		"PDFURL":      c.Path() + "?format=pdf",
+	}); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to render invoice"})
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Send(page.Bytes())
+}
+
+// recordInvoiceView stores who opened the link and bumps the invoice's view
+// counters
+func recordInvoiceView(db *gorm.DB, share models.InvoiceShare, c *fiber.Ctx, now time.Time) error {
+	userAgent := c.Get(fiber.HeaderUserAgent)
+	if len(userAgent) > 500 {
+		userAgent = strings.ToValidUTF8(userAgent[:500], "")
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		view := models.InvoiceView{
+			ID:        models.GenerateInvoiceViewID(),
+			UserID:    share.UserID,
+			InvoiceID: share.InvoiceID,
+			ShareID:   share.ID,
+			IP:        audit.ClientIP(c),
+			UserAgent: userAgent,
+			ViewedAt:  now,
+		}
+		if err := tx.Create(&view).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Invoice{}).Where("id = ? AND user_id = ?", share.InvoiceID, share.UserID).Updates(map[string]interface{}{
+			"viewed_at":      gorm.Expr("COALESCE(viewed_at, ?)", now),
+			"last_viewed_at": now,
+			"view_count":     gorm.Expr("view_count + 1"),
+		}).Error
+	})
+}
+
+// sharedPageBrand styles the shared invoice page. Colors and fonts are
+// validated when saved, so they are safe to put into CSS.
+func sharedPageBrand(branding *models.Branding) fiber.Map {
+	brand := fiber.Map{"Font": brandFonts["helvetica"].CSS, "Color": template.CSS("#1f2937"), "WhiteLabel": branding != nil}
+	if branding != nil {
+		if font, ok := brandFonts[branding.Font]; ok {
+			brand["Font"] = font.CSS
+		}
+		if branding.PrimaryColor != "" {
+			brand["Color"] = template.CSS(branding.PrimaryColor)
+		}
+	}
+	return brand
+}
+
+var sharedInvoicePage = template.Must(template.New("invoice").Funcs(template.FuncMap{"t": i18n.T}).Parse(`<!DOCTYPE html>
+<html lang="{{.Language}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>{{t .Locale "Invoice"}} {{.Invoice.DisplayNumber}}</title>
+<style>
+body { font-family: {{.Brand.Font}}; color: #1f2937; max-width: 720px; margin: 40px auto; padding: 0 20px; }
+h1 { font-size: 28px; margin-bottom: 4px; color: {{.Brand.Color}}; }
+.muted { color: #6b7280; }
+.parties { display: flex; justify-content: space-between; margin: 32px 0; }
+table { width: 100%; border-collapse: collapse; }
+td { padding: 8px 0; border-top: 1px solid #e5e7eb; }
+td:last-child { text-align: right; }
+.total td { font-size: 20px; font-weight: bold; }
+.notes { white-space: pre-line; margin-top: 32px; }
+</style>
+</head>
+<body>
+<h1>{{t .Locale "Invoice"}}</h1>
+<div class="muted">{{.Invoice.DisplayNumber}}</div>
+<div class="parties">
+<div><div class="muted">{{t .Locale "From"}}</div><div>{{.Issuer}}</div><div>{{.Invoice.User.Email}}</div></div>
+<div><div class="muted">{{t .Locale "Bill to"}}</div><div>{{.Invoice.Client.Name}}</div>{{with .Invoice.Client.Company}}<div>{{.}}</div>{{end}}{{range .Invoice.Client.BillingAddress.Lines}}<div>{{.}}</div>{{end}}</div>
+</div>
+<table>
+<tr><td>{{t .Locale "Invoice date"}}</td><td>{{.InvoiceDate}}</td></tr>
+<tr><td>{{t .Locale "Due date"}}</td><td>{{.DueDate}}</td></tr>
+<tr><td>{{t .Locale "Status"}}</td><td>{{t .Locale .Invoice.Status}}</td></tr>
+<tr class="total"><td>{{t .Locale "Amount due"}}</td><td>{{.Amount}}</td></tr>
+</table>
+{{with .Invoice.Notes}}<p class="notes muted">{{.}}</p>{{end}}
+<p><a href="{{.PDFURL}}">{{t .Locale "Download PDF"}}</a></p>
+{{if not .Brand.WhiteLabel}}<p class="muted">{{t .Locale "Sent with"}} <a href="https://billow.app">Billow</a></p>{{end}}
+</body>
+</html>
+`))
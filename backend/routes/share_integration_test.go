@@ -0,0 +1,72 @@
+//go:build integration
+
+package routes
+
+import (
+	"billow-backend/models"
+	"billow-backend/portal"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSharedInvoiceLink(t *testing.T) {
+	db := openTestDB(t)
+	expired := time.Now().Add(-time.Hour)
+	seed(t, db,
+		&models.User{ID: "USR-SH", ClerkID: "clerk_sh", Email: "owner@share.test"},
+		&models.Client{ID: "CLI-SH", UserID: "USR-SH", Name: "Share client"},
+		&models.Invoice{ID: "INV-SH", UserID: "USR-SH", ClientID: "CLI-SH", InvoiceDate: "2026-01-01", DueDate: "2026-01-31", Amount: 100, Status: "unpaid"},
+		&models.InvoiceShare{ID: "SHR-SH-EXPIRED", UserID: "USR-SH", InvoiceID: "INV-SH", TokenHash: portal.HashToken("expired-token"), ExpiresAt: &expired},
+	)
+	t.Cleanup(func() {
+		db.Where("invoice_id = ?", "INV-SH").Delete(&models.InvoiceView{})
+		db.Where("invoice_id = ?", "INV-SH").Delete(&models.InvoiceShare{})
+	})
+
+	app := setupApp()
+	request := func(method, path string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-User-ID", "USR-SH")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		return resp
+	}
+
+	resp := request("POST", "/api/invoices/INV-SH/share")
+	var share struct{ ID, URL string }
+	if err := json.NewDecoder(resp.Body).Decode(&share); err != nil || resp.StatusCode != 201 {
+		t.Fatalf("share = %d, %v", resp.StatusCode, err)
+	}
+	link := share.URL[strings.Index(share.URL, "/api/public/"):]
+
+	// Opening the page and then its PDF link is one view
+	if resp := request("GET", link); resp.StatusCode != 200 {
+		t.Errorf("page = %d", resp.StatusCode)
+	}
+	if resp := request("GET", link+"?format=pdf"); resp.StatusCode != 200 {
+		t.Errorf("pdf = %d", resp.StatusCode)
+	}
+	var invoice models.Invoice
+	db.First(&invoice, "id = ?", "INV-SH")
+	var views int64
+	db.Model(&models.InvoiceView{}).Where("invoice_id = ?", "INV-SH").Count(&views)
+	if invoice.ViewCount != 1 || views != 1 || invoice.ViewedAt == nil {
+		t.Errorf("view count = %d with %d views, viewed at %v; want 1", invoice.ViewCount, views, invoice.ViewedAt)
+	}
+
+	if resp := request("DELETE", "/api/invoices/INV-SH/shares/"+share.ID); resp.StatusCode != 200 {
+		t.Fatalf("revoke = %d", resp.StatusCode)
+	}
+	for _, path := range []string{link, link + "?format=pdf", "/api/public/invoices/expired-token", "/api/public/invoices/unknown-token"} {
+		if resp := request("GET", path); resp.StatusCode != 404 {
+			t.Errorf("GET %s = %d, want 404", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package routes
+
+import (
+	"billow-backend/i18n"
+	"billow-backend/mailer"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"billow-backend/pdf"
+	"billow-backend/policy"
+	"billow-backend/statement"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// clientAccount builds the client's statement of account for ?from= and ?to=
+// (YYYY-MM-DD, both optional)
+func clientAccount(c *fiber.Ctx, db *gorm.DB, client models.Client) (statement.Account, error) {
+	from, to := c.Query("from"), c.Query("to")
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return statement.Account{}, fiber.NewError(400, "Dates must be YYYY-MM-DD")
+		}
+	}
+
+	var invoices []models.Invoice
+	if err := db.Where("user_id = ? AND client_id = ?", client.UserID, client.ID).Find(&invoices).Error; err != nil {
+		return statement.Account{}, fiber.NewError(500, "Failed to build statement")
+	}
+	var payments []models.Payment
+	if len(invoices) > 0 {
+		ids := make([]string, len(invoices))
+		for i, invoice := range invoices {
+			ids[i] = invoice.ID
+		}
+		if err := db.Where("invoice_id IN ?", ids).Find(&payments).Error; err != nil {
+			return statement.Account{}, fiber.NewError(500, "Failed to build statement")
+		}
+	}
+	return statement.BuildAccount(client, invoices, payments, from, to, time.Now()), nil
+}
+
+// statementPDF renders the statement in the client's language, styled like
+// the business's invoices
+func statementPDF(db *gorm.DB, client models.Client, account statement.Account) []byte {
+	opts := documentOptions(db, client.UserID, client.BusinessID, i18n.ClientLocale(db, client))
+	return pdf.Statement(account, client, client.User, opts)
+}
+
+// getClientStatement returns the client's statement of account as JSON, or
+// with ?format=pdf or csv as a file
+func getClientStatement(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+	var client models.Client
+	if err := findOwned(c, policy.ClientRead, &client, c.Params("id")); err != nil {
+		return err
+	}
+	format := c.Query("format", "json")
+	if format != "json" && format != "pdf" && format != "csv" {
+		return c.Status(400).JSON(fiber.Map{"error": "Format must be json, pdf or csv"})
+	}
+
+	account, err := clientAccount(c, db, client)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "pdf":
+		db.Preload("User").First(&client, "id = ?", client.ID)
+		c.Set(fiber.HeaderContentType, "application/pdf")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="statement-%s.pdf"`, client.ID))
+		return c.Send(statementPDF(db, client, account))
+	case "csv":
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="statement-%s.csv"`, client.ID))
+		return c.Send(statementCSV(account))
+	}
+	return c.JSON(account)
+}
+
+// statementCSV writes one row per entry between the opening and closing
+// balances of each currency
+func statementCSV(account statement.Account) []byte {
+	amount := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write([]string{"date", "kind", "number", "reference", "due_date", "currency", "amount", "paid", "balance"})
+	for _, balance := range account.Opening {
+		w.Write([]string{account.From, "opening", "", "", "", balance.Currency, "", "", amount(balance.Outstanding)})
+	}
+	for _, e := range account.Entries {
+		w.Write([]string{e.Date, e.Kind, e.Number, e.Reference, e.DueDate, e.Currency, amount(e.Amount), amount(e.Paid), amount(e.Balance)})
+	}
+	for _, balance := range account.Closing {
+		w.Write([]string{account.To, "closing", "", "", "", balance.Currency, "", "", amount(balance.Outstanding)})
+	}
+	w.Flush()
+	return b.Bytes()
+}
+
+// sendClientStatement emails the statement of account as a PDF to the
+// client's billing contacts
+func sendClientStatement(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+	var client models.Client
+	if err := findOwned(c, policy.ClientStatement, &client, c.Params("id")); err != nil {
+		return err
+	}
+	db.Preload("User").Preload("Contacts").First(&client, "id = ?", client.ID)
+	if len(client.BillingEmails()) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "The client has no email address"})
+	}
+
+	account, err := clientAccount(c, db, client)
+	if err != nil {
+		return err
+	}
+
+	locale := i18n.ClientLocale(db, client)
+	issuer := businessName(db, client.User, client.BusinessID)
+	msg := statementMessage(client, account, issuer, locale)
+	msg.Attachments = []mailer.Attachment{{
+		Filename:    fmt.Sprintf("statement-%s.pdf", client.ID),
+		ContentType: "application/pdf",
+		Data:        statementPDF(db, client, account),
+	}}
+	mailer.SendAsync(brandedMessage(msg, activeBranding(db, client.UserID), locale))
+
+	return c.Status(202).JSON(fiber.Map{"message": "Statement sent", "to": msg.To})
+}
+
+// statementMessage writes the email the statement is attached to
+func statementMessage(client models.Client, account statement.Account, issuer, locale string) mailer.Message {
+	t := func(msg string, args ...interface{}) string { return i18n.T(locale, msg, args...) }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n%s\n", t("Hello %s,", client.Name), t("Please find your statement of account attached."))
+	for _, balance := range account.Closing {
+		if balance.Outstanding > 0 {
+			fmt.Fprintf(&b, "%s\n", t("Balance due: %s", i18n.FormatMoney(locale, balance.Outstanding, balance.Currency)))
+		}
+	}
+	fmt.Fprintf(&b, "\n%s\n%s\n", t("Kind regards,"), issuer)
+
+	return mailer.Message{
+		To:      client.BillingEmails(),
+		Subject: t("Statement of account from %s", issuer),
+		Body:    b.String(),
+	}
+}
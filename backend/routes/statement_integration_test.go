@@ -0,0 +1,65 @@
+//go:build integration
+
+package routes
+
+import (
+	"billow-backend/models"
+	"billow-backend/portal"
+	"billow-backend/statement"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPortalStatementMatchesAccount(t *testing.T) {
+	db := openTestDB(t)
+	seed(t, db,
+		&models.User{ID: "USR-ST", ClerkID: "clerk_st", Email: "owner@statement.test"},
+		&models.Client{ID: "CLI-ST", UserID: "USR-ST", Name: "Statement client"},
+		&models.Invoice{ID: "INV-ST-1", UserID: "USR-ST", ClientID: "CLI-ST", InvoiceDate: "2024-02-01", DueDate: "2024-03-01", Amount: 100, Status: "unpaid", CurrencyType: "USD"},
+		&models.Invoice{ID: "INV-ST-2", UserID: "USR-ST", ClientID: "CLI-ST", InvoiceDate: "2024-03-01", DueDate: "2024-03-31", Amount: 200, Status: "unpaid", CurrencyType: "USD"},
+		&models.Payment{ID: "PAY-ST", UserID: "USR-ST", InvoiceID: "INV-ST-2", Amount: 150, Currency: "USD", Gateway: "manual", Reference: "st-1", PaidAt: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		&models.PortalSession{ID: "PSN-ST", TokenHash: portal.HashToken("statement-token"), UserID: "USR-ST", ClientID: "CLI-ST", ExpiresAt: time.Now().Add(time.Hour)},
+	)
+
+	app := setupApp()
+	get := func(path, header, value string) statement.Account {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set(header, value)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		if resp.StatusCode != 200 {
+			t.Fatalf("GET %s = %d", path, resp.StatusCode)
+		}
+		var account statement.Account
+		if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+			t.Fatal(err)
+		}
+		return account
+	}
+
+	// The client sees the payments on their statement, as the business does
+	business := get("/api/clients/CLI-ST/statement?from=2024-03-01", "X-User-ID", "USR-ST")
+	client := get("/api/portal/account/statement?from=2024-03-01", "X-Portal-Token", "statement-token")
+	if !reflect.DeepEqual(client, business) {
+		t.Errorf("portal statement = %+v\nwant %+v", client, business)
+	}
+	if len(client.Entries) != 2 || client.Entries[1].Kind != statement.EntryPayment || client.Entries[1].Balance != 150 {
+		t.Errorf("portal entries = %+v", client.Entries)
+	}
+
+	req := httptest.NewRequest("GET", "/api/portal/account/statement?from=March", nil)
+	req.Header.Set("X-Portal-Token", "statement-token")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 400 {
+		t.Errorf("invalid date = %d, want 400", resp.StatusCode)
+	}
+}
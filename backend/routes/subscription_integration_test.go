@@ -0,0 +1,77 @@
+//go:build integration
+
+package routes
+
+import (
+	"billow-backend/billing"
+	"billow-backend/config"
+	"billow-backend/models"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// priceProvider records the plan the subscription had committed when its
+// price was changed, and fails the change when err is set
+type priceProvider struct {
+	billing.Provider
+	err       error
+	committed string
+}
+
+func (p *priceProvider) ChangePrice(ctx context.Context, subscriptionID, priceID string, prorate bool) error {
+	var sub models.Subscription
+	config.DB.First(&sub, "provider_subscription_id = ?", subscriptionID)
+	p.committed = sub.PlanID
+	return p.err
+}
+
+// A provider-billed plan change is committed before the provider is asked to
+// bill it, and reverted when the provider refuses
+func TestChangeSubscriptionCallsProviderAfterCommit(t *testing.T) {
+	db := openTestDB(t)
+	seed(t, db,
+		&models.Plan{ID: "PLN-SC-BASIC", Code: "PLN-SC-BASIC", Name: "Basic", Price: 10, Interval: "month", ProviderPriceID: "price_sc_basic"},
+		&models.Plan{ID: "PLN-SC-PRO", Code: "PLN-SC-PRO", Name: "Pro", Price: 20, Interval: "month", ProviderPriceID: "price_sc_pro"},
+		&models.User{ID: "USR-SC", ClerkID: "clerk_sc", Email: "sc@subscription.test"},
+		&models.Subscription{ID: "SUB-SC", UserID: "USR-SC", PlanID: "PLN-SC-BASIC", Status: models.SubscriptionActive,
+			CurrentPeriodEnd: time.Now().AddDate(0, 0, 15), ProviderSubscriptionID: "sub_sc"},
+	)
+	previous := billing.DefaultProvider
+	t.Cleanup(func() { billing.DefaultProvider = previous })
+
+	app := setupApp()
+	for _, tt := range []struct {
+		name     string
+		err      error
+		status   int
+		wantPlan string
+	}{
+		{"provider refuses", errors.New("card declined"), 502, "PLN-SC-BASIC"},
+		{"provider accepts", nil, 200, "PLN-SC-PRO"},
+	} {
+		provider := &priceProvider{err: tt.err}
+		billing.DefaultProvider = provider
+
+		req := httptest.NewRequest("POST", "/api/subscription/change", strings.NewReader(`{"plan_id":"PLN-SC-PRO"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", "USR-SC")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+		if provider.committed != "PLN-SC-PRO" {
+			t.Errorf("%s: provider called with %q committed, want PLN-SC-PRO", tt.name, provider.committed)
+		}
+		var sub models.Subscription
+		if err := db.First(&sub, "id = ?", "SUB-SC").Error; err != nil || sub.PlanID != tt.wantPlan {
+			t.Errorf("%s: subscription %+v, %v; want plan %s", tt.name, sub, err, tt.wantPlan)
+		}
+	}
+}
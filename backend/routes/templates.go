@@ -0,0 +1,310 @@
+package routes
+
+import (
+	"billow-backend/audit"
+	"billow-backend/i18n"
+	"billow-backend/middleware"
+	"billow-backend/models"
+	"billow-backend/policy"
+	"billow-backend/render"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// templateCSP keeps scripts out of pages rendered from user templates, which
+// are served from the API's origin
+const templateCSP = "default-src 'none'; style-src 'unsafe-inline'; img-src data: https:; font-src https:"
+
+// findTemplate loads one of the user's templates
+func findTemplate(db *gorm.DB, userID, templateID string) (models.InvoiceTemplate, error) {
+	var tmpl models.InvoiceTemplate
+	err := db.First(&tmpl, "id = ? AND user_id = ?", templateID, userID).Error
+	return tmpl, err
+}
+
+// checkTemplateID makes sure a template chosen for a client or invoice
+// belongs to the user
+func checkTemplateID(db *gorm.DB, userID, templateID string) error {
+	if templateID == "" {
+		return nil
+	}
+	if _, err := findTemplate(db.Select("id"), userID, templateID); err != nil {
+		return fiber.NewError(400, "Invalid template selected")
+	}
+	return nil
+}
+
+// invoiceTemplateSource picks the template the invoice is rendered with: its
+// own, else its client's, else the user's default. Without any it returns the
+// built-in template and false.
+func invoiceTemplateSource(db *gorm.DB, invoice models.Invoice) (string, bool) {
+	for _, templateID := range []string{invoice.TemplateID, invoice.Client.TemplateID} {
+		if templateID == "" {
+			continue
+		}
+		if tmpl, err := findTemplate(db, invoice.UserID, templateID); err == nil {
+			return tmpl.Body, true
+		}
+	}
+	var tmpl models.InvoiceTemplate
+	if err := db.First(&tmpl, "user_id = ? AND is_default = ?", invoice.UserID, true).Error; err == nil {
+		return tmpl.Body, true
+	}
+	return render.DefaultSource, false
+}
+
+// templateData is what templates see of the invoice. The invoice must have
+// its client and user loaded.
+func templateData(db *gorm.DB, invoice models.Invoice) render.Data {
+	data := render.Data{
+		Locale: i18n.InvoiceLocale(db, invoice),
+		Invoice: render.Invoice{
+			Number:   invoice.DisplayNumber(),
+			Date:     invoice.InvoiceDate,
+			DueDate:  invoice.DueDate,
+			Status:   invoice.Status,
+			Amount:   invoice.Amount,
+			Currency: invoice.CurrencyType,
+			Notes:    invoice.Notes,
+		},
+		From: render.Party{Name: businessName(db, invoice.User, invoice.BusinessID), Email: invoice.User.Email},
+		To: render.Party{
+			Name:    invoice.Client.Name,
+			Company: invoice.Client.Company,
+			Email:   invoice.Client.Email,
+			Phone:   invoice.Client.Phone,
+			Address: invoice.Client.BillingAddress.Lines(),
+		},
+	}
+	if business, err := loadBusiness(db.Omit("logo"), invoice.UserID, invoice.BusinessID); err == nil {
+		data.From.Company = business.LegalName
+		if business.Email != "" {
+			data.From.Email = business.Email
+		}
+		data.From.Phone = business.Phone
+		data.From.TaxNumber = business.TaxNumber
+		data.From.Address = business.Address.Lines()
+	}
+	return data
+}
+
+// sendTemplatePage sends HTML rendered from a user template
+func sendTemplatePage(c *fiber.Ctx, page []byte) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set("Content-Security-Policy", templateCSP)
+	return c.Send(page)
+}
+
+// renderError turns a failed render into a response explaining what went wrong
+func renderError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, render.ErrTimeout):
+		return c.Status(422).JSON(fiber.Map{"error": "The template took too long to render"})
+	case errors.Is(err, render.ErrTooLarge):
+		return c.Status(422).JSON(fiber.Map{"error": "The template's output is too large"})
+	}
+	return c.Status(422).JSON(fiber.Map{"error": err.Error()})
+}
+
+// getInvoiceHTML renders the invoice as a page with its template
+func getInvoiceHTML(c *fiber.Ctx) error {
+	db := middleware.DB(c)
+	var invoice models.Invoice
+	if err := findOwned(c, policy.InvoiceRead, &invoice, c.Params("id")); err != nil {
+		return err
+	}
+	db.Preload("Client").Preload("User").First(&invoice, "id = ?", invoice.ID)
+
+	src, _ := invoiceTemplateSource(db, invoice)
+	page, err := render.Render(src, templateData(db, invoice))
+	if err != nil {
+		return renderError(c, err)
+	}
+	return sendTemplatePage(c, page)
+}
+
+// getInvoiceTemplates lists the user's templates along with the built-in one
+// to start new templates from
+func getInvoiceTemplates(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	var templates []models.InvoiceTemplate
+	if err := middleware.DB(c).Where("user_id = ?", userID).Order("is_default DESC, name ASC").Find(&templates).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch templates"})
+	}
+	return c.JSON(fiber.Map{"templates": templates, "default_body": render.DefaultSource})
+}
+
+func getInvoiceTemplate(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := findTemplate(middleware.DB(c), userID, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Template not found"})
+	}
+	return c.JSON(tmpl)
+}
+
+func createInvoiceTemplate(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	tmpl := models.InvoiceTemplate{ID: models.GenerateInvoiceTemplateID(), UserID: userID}
+	return saveInvoiceTemplate(c, middleware.DB(c), tmpl, nil, 201)
+}
+
+func updateInvoiceTemplate(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	tmpl, err := findTemplate(db, userID, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Template not found"})
+	}
+	return saveInvoiceTemplate(c, db, tmpl, tmpl, 200)
+}
+
+// saveInvoiceTemplate validates the template in the body and stores it in tmpl
+func saveInvoiceTemplate(c *fiber.Ctx, db *gorm.DB, tmpl models.InvoiceTemplate, before interface{}, status int) error {
+	var updateData models.InvoiceTemplate
+	if err := c.BodyParser(&updateData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+	name := strings.TrimSpace(updateData.Name)
+	if name == "" || len(name) > 100 {
+		return c.Status(400).JSON(fiber.Map{"error": "Name is required and must be at most 100 characters"})
+	}
+	if _, err := render.Parse(updateData.Body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid template: " + err.Error()})
+	}
+
+	tmpl.Name = name
+	tmpl.Body = updateData.Body
+	if updateData.IsDefault && !tmpl.IsDefault {
+		if err := db.Model(&models.InvoiceTemplate{}).Where("user_id = ? AND id <> ?", tmpl.UserID, tmpl.ID).
+			Update("is_default", false).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to save template"})
+		}
+	}
+	tmpl.IsDefault = updateData.IsDefault
+	if err := db.Save(&tmpl).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save template"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ProfileUpdated,
+		TargetType: "invoice_template",
+		TargetID:   tmpl.ID,
+		Before:     before,
+		After:      tmpl,
+	})
+
+	return c.Status(status).JSON(tmpl)
+}
+
+// deleteInvoiceTemplate removes the template; clients and invoices that used
+// it fall back to the default
+func deleteInvoiceTemplate(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	tmpl, err := findTemplate(db, userID, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Template not found"})
+	}
+	for _, model := range []interface{}{&models.Client{}, &models.Invoice{}} {
+		if err := db.Model(model).Where("user_id = ? AND template_id = ?", userID, tmpl.ID).
+			Update("template_id", "").Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to delete template"})
+		}
+	}
+	if err := db.Delete(&tmpl).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete template"})
+	}
+
+	audit.Record(c, db, audit.Entry{
+		Action:     audit.ProfileUpdated,
+		TargetType: "invoice_template",
+		TargetID:   tmpl.ID,
+		Before:     tmpl,
+	})
+
+	return c.JSON(fiber.Map{"message": "Template deleted successfully"})
+}
+
+// maxPreviewLines caps the address lines of data sent to preview a template
+// with; real addresses have a handful
+const maxPreviewLines = 10
+
+// previewInvoiceTemplate renders a template before it is saved. The body
+// gives the template, or the ID of a saved one, and what to render it with:
+// sample data by default, the given data, or one of the user's invoices.
+func previewInvoiceTemplate(c *fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	db := middleware.DB(c)
+
+	var previewData struct {
+		Body       string       `json:"body"`
+		TemplateID string       `json:"template_id"`
+		InvoiceID  string       `json:"invoice_id"`
+		Data       *render.Data `json:"data"`
+	}
+	if err := c.BodyParser(&previewData); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request data"})
+	}
+
+	src := previewData.Body
+	if previewData.TemplateID != "" {
+		tmpl, err := findTemplate(db, userID, previewData.TemplateID)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Template not found"})
+		}
+		src = tmpl.Body
+	}
+	parsed, err := render.Parse(src)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid template: " + err.Error()})
+	}
+
+	data := render.Sample()
+	switch {
+	case previewData.InvoiceID != "":
+		var invoice models.Invoice
+		if err := findOwned(c, policy.InvoiceRead, &invoice, previewData.InvoiceID); err != nil {
+			return err
+		}
+		db.Preload("Client").Preload("User").First(&invoice, "id = ?", invoice.ID)
+		data = templateData(db, invoice)
+	case previewData.Data != nil:
+		if len(previewData.Data.From.Address) > maxPreviewLines || len(previewData.Data.To.Address) > maxPreviewLines {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Addresses can have at most %d lines", maxPreviewLines)})
+		}
+		data = *previewData.Data
+	}
+
+	page, err := parsed.Execute(data)
+	if err != nil {
+		return renderError(c, err)
+	}
+	return sendTemplatePage(c, page)
+}
@@ -0,0 +1,33 @@
+//go:build integration
+
+package routes
+
+import (
+	"billow-backend/models"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Data sent to preview with can't make the template's ranges run for long
+func TestPreviewTemplateCapsDataLines(t *testing.T) {
+	db := openTestDB(t)
+	seed(t, db, &models.User{ID: "USR-PV", ClerkID: "clerk_pv", Email: "pv@preview.test"})
+
+	app := setupApp()
+	for lines, status := range map[int]int{maxPreviewLines: 200, maxPreviewLines + 1: 400} {
+		address := `"` + strings.Repeat(`x","`, lines-1) + `x"`
+		body := fmt.Sprintf(`{"body":"{{range .To.Address}}{{.}}{{end}}","data":{"to":{"address":[%s]}}}`, address)
+		req := httptest.NewRequest("POST", "/api/settings/templates/preview", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", "USR-PV")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != status {
+			t.Errorf("%d address lines: status %d, want %d", lines, resp.StatusCode, status)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package statement
+
+import (
+	"billow-backend/models"
+	"sort"
+	"time"
+)
+
+// Kinds of account entries
+const (
+	EntryInvoice = "invoice"
+	EntryPayment = "payment"
+)
+
+// Entry is one invoice or payment on a statement of account with the running
+// balance of its currency
+type Entry struct {
+	Date      string  `json:"date"`
+	Kind      string  `json:"kind"`
+	InvoiceID string  `json:"invoice_id"`
+	Number    string  `json:"number"`
+	Reference string  `json:"reference,omitempty"` // the gateway's payment ID
+	DueDate   string  `json:"due_date,omitempty"`
+	Currency  string  `json:"currency"`
+	Amount    float64 `json:"amount"` // charged by an invoice
+	Paid      float64 `json:"paid"`   // received by a payment
+	Balance   float64 `json:"balance"`
+}
+
+// Account is a client's statement of account over a date range: what they
+// owed at the start, each invoice and payment in the range, and what they owe
+// at the end. Billow has no credit notes yet, so nothing but payments
+// reduces what an invoice charged.
+type Account struct {
+	ClientID string    `json:"client_id"`
+	Client   string    `json:"client"`
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to,omitempty"`
+	Opening  []Balance `json:"opening"`
+	Entries  []Entry   `json:"entries"`
+	Closing  []Balance `json:"closing"`
+}
+
+// BuildAccount produces the statement of account of client from its invoices
+// and the payments recorded against them, for dates from..to inclusive.
+// Either bound may be empty to leave the range open. Invoices marked paid
+// without payments covering them are taken as settled on the day they were
+// last updated.
+func BuildAccount(client models.Client, invoices []models.Invoice, payments []models.Payment, from, to string, now time.Time) Account {
+	byID := map[string]models.Invoice{}
+	var entries []Entry
+	for _, inv := range invoices {
+		byID[inv.ID] = inv
+		entries = append(entries, Entry{
+			Date:      inv.InvoiceDate,
+			Kind:      EntryInvoice,
+			InvoiceID: inv.ID,
+			Number:    inv.DisplayNumber(),
+			DueDate:   inv.DueDate,
+			Currency:  currencyOf(inv.CurrencyType),
+			Amount:    inv.Amount,
+		})
+	}
+
+	recorded := map[string]float64{}
+	for _, p := range payments {
+		inv, ok := byID[p.InvoiceID]
+		if !ok {
+			continue
+		}
+		recorded[p.InvoiceID] += p.Amount
+		entries = append(entries, paymentEntry(inv, p.PaidAt, p.Amount, p.Reference))
+	}
+	for _, inv := range invoices {
+		if rest := round(inv.Amount - recorded[inv.ID]); IsPaid(inv) && rest > 0 {
+			entries = append(entries, paymentEntry(inv, inv.UpdatedAt, rest, ""))
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.Kind != b.Kind {
+			return a.Kind == EntryInvoice
+		}
+		return a.InvoiceID < b.InvoiceID
+	})
+
+	a := Account{ClientID: client.ID, Client: client.Name, From: from, To: to, Entries: []Entry{}}
+	var before, during []Entry
+	for _, e := range entries {
+		switch {
+		case from != "" && e.Date < from:
+			before = append(before, e)
+		case to != "" && e.Date > to:
+		default:
+			during = append(during, e)
+		}
+	}
+
+	a.Opening = tally(before, byID, from)
+	running := map[string]float64{}
+	for _, b := range a.Opening {
+		running[b.Currency] = b.Outstanding
+	}
+	for _, e := range during {
+		running[e.Currency] = round(running[e.Currency] + e.Amount - e.Paid)
+		e.Balance = running[e.Currency]
+		a.Entries = append(a.Entries, e)
+	}
+
+	// Invoices are overdue at the end of the range, or today if that's earlier
+	day := now.Format(dateLayout)
+	if end, err := time.Parse(dateLayout, to); err == nil && to < day {
+		day = end.AddDate(0, 0, 1).Format(dateLayout)
+	}
+	a.Closing = tally(append(before, during...), byID, day)
+	return a
+}
+
+func paymentEntry(inv models.Invoice, paidAt time.Time, amount float64, reference string) Entry {
+	date := inv.InvoiceDate
+	if !paidAt.IsZero() {
+		date = paidAt.Format(dateLayout)
+	}
+	return Entry{
+		Date:      date,
+		Kind:      EntryPayment,
+		InvoiceID: inv.ID,
+		Number:    inv.DisplayNumber(),
+		Reference: reference,
+		Currency:  currencyOf(inv.CurrencyType),
+		Paid:      amount,
+	}
+}
+
+// tally totals entries per currency. What is left to pay on invoices due
+// before day counts as overdue.
+func tally(entries []Entry, invoices map[string]models.Invoice, day string) []Balance {
+	byCurrency := map[string]*Balance{}
+	left := map[string]float64{}
+	for _, e := range entries {
+		b := balanceFor(byCurrency, e.Currency)
+		b.Invoiced += e.Amount
+		b.Paid += e.Paid
+		b.Outstanding += e.Amount - e.Paid
+		left[e.InvoiceID] += e.Amount - e.Paid
+	}
+	for id, amount := range left {
+		inv := invoices[id]
+		if _, err := time.Parse(dateLayout, inv.DueDate); err == nil && inv.DueDate < day && round(amount) > 0 {
+			byCurrency[currencyOf(inv.CurrencyType)].Overdue += amount
+		}
+	}
+	return sorted(byCurrency)
+}
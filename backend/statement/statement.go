@@ -0,0 +1,89 @@
+// Package statement summarizes a client's invoices into balances and
+// statements of account
+package statement
+
+import (
+	"billow-backend/models"
+	"math"
+	"sort"
+	"time"
+)
+
+// dateLayout is how invoice and due dates are stored
+const dateLayout = "2006-01-02"
+
+// Balance totals a client's invoices in one currency
+type Balance struct {
+	Currency    string  `json:"currency"`
+	Invoiced    float64 `json:"invoiced"`
+	Paid        float64 `json:"paid"`
+	Outstanding float64 `json:"outstanding"`
+	Overdue     float64 `json:"overdue"`
+}
+
+// IsPaid reports whether the invoice has been settled
+func IsPaid(inv models.Invoice) bool {
+	return inv.Status == "paid"
+}
+
+// IsOverdue reports whether an unpaid invoice is past its due date on day
+func IsOverdue(inv models.Invoice, day time.Time) bool {
+	if IsPaid(inv) {
+		return false
+	}
+	if inv.Status == "overdue" {
+		return true
+	}
+	_, err := time.Parse(dateLayout, inv.DueDate)
+	return err == nil && inv.DueDate < day.Format(dateLayout)
+}
+
+// Balances totals invoices per currency, in currency order
+func Balances(invoices []models.Invoice, now time.Time) []Balance {
+	byCurrency := map[string]*Balance{}
+	for _, inv := range invoices {
+		b := balanceFor(byCurrency, inv.CurrencyType)
+		b.Invoiced += inv.Amount
+		if IsPaid(inv) {
+			b.Paid += inv.Amount
+			continue
+		}
+		b.Outstanding += inv.Amount
+		if IsOverdue(inv, now) {
+			b.Overdue += inv.Amount
+		}
+	}
+	return sorted(byCurrency)
+}
+
+func balanceFor(byCurrency map[string]*Balance, currency string) *Balance {
+	currency = currencyOf(currency)
+	b, ok := byCurrency[currency]
+	if !ok {
+		b = &Balance{Currency: currency}
+		byCurrency[currency] = b
+	}
+	return b
+}
+
+func sorted(byCurrency map[string]*Balance) []Balance {
+	balances := []Balance{}
+	for _, b := range byCurrency {
+		b.Invoiced, b.Paid = round(b.Invoiced), round(b.Paid)
+		b.Outstanding, b.Overdue = round(b.Outstanding), round(b.Overdue)
+		balances = append(balances, *b)
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Currency < balances[j].Currency })
+	return balances
+}
+
+func currencyOf(currency string) string {
+	if currency == "" {
+		return "USD"
+	}
+	return currency
+}
+
+func round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,59 @@
+package statement
+
+import (
+	"billow-backend/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildAccount(t *testing.T) {
+	now := time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC)
+	client := models.Client{ID: "CLT-1", Name: "Acme"}
+	invoices := []models.Invoice{
+		{ID: "INV-1", Number: "A-0001", InvoiceDate: "2024-02-01", DueDate: "2024-03-01", Status: "unpaid", Amount: 100, CurrencyType: "USD"},
+		{ID: "INV-2", Number: "A-0002", InvoiceDate: "2024-03-01", DueDate: "2024-03-31", Status: "paid", Amount: 200, CurrencyType: "USD", UpdatedAt: time.Date(2024, 3, 20, 9, 0, 0, 0, time.UTC)},
+		{ID: "INV-3", Number: "A-0003", InvoiceDate: "2024-03-10", DueDate: "2024-04-10", Status: "unpaid", Amount: 300, CurrencyType: "USD"},
+		{ID: "INV-4", Number: "A-0004", InvoiceDate: "2024-05-01", DueDate: "2024-06-01", Status: "unpaid", Amount: 50, CurrencyType: "USD"},
+	}
+	payments := []models.Payment{
+		{InvoiceID: "INV-1", Amount: 40, PaidAt: time.Date(2024, 2, 20, 0, 0, 0, 0, time.UTC), Reference: "pi_1"},
+		{InvoiceID: "INV-2", Amount: 150, PaidAt: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), Reference: "pi_2"},
+		{InvoiceID: "INV-3", Amount: 100, PaidAt: time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), Reference: "pi_3"},
+	}
+
+	a := BuildAccount(client, invoices, payments, "2024-03-01", "2024-04-30", now)
+
+	if want := []Balance{{Currency: "USD", Invoiced: 100, Paid: 40, Outstanding: 60, Overdue: 0}}; !reflect.DeepEqual(a.Opening, want) {
+		t.Errorf("opening = %+v", a.Opening)
+	}
+
+	type row struct {
+		Date, Kind, Number string
+		Balance            float64
+	}
+	var rows []row
+	for _, e := range a.Entries {
+		rows = append(rows, row{e.Date, e.Kind, e.Number, e.Balance})
+	}
+	want := []row{
+		{"2024-03-01", EntryInvoice, "A-0002", 260},
+		{"2024-03-05", EntryPayment, "A-0002", 110},
+		{"2024-03-10", EntryInvoice, "A-0003", 410},
+		{"2024-03-20", EntryPayment, "A-0002", 360}, // the rest, marked paid by hand
+		{"2024-04-02", EntryPayment, "A-0003", 260},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("entries = %+v", rows)
+	}
+
+	// What is left on INV-1 and INV-3 is past due; INV-4 is after the range
+	if want := []Balance{{Currency: "USD", Invoiced: 600, Paid: 340, Outstanding: 260, Overdue: 260}}; !reflect.DeepEqual(a.Closing, want) {
+		t.Errorf("closing = %+v", a.Closing)
+	}
+
+	// Overdue is judged at the end of the range
+	if closing := BuildAccount(client, invoices, payments, "", "2024-03-31", now).Closing; closing[0].Overdue != 60 {
+		t.Errorf("overdue at the end of March = %v, want 60", closing[0].Overdue)
+	}
+}
@@ -0,0 +1,221 @@
+package usage
+
+import (
+	"billow-backend/models"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Feature types recorded in UsageLog
+const (
+	InvoiceCreated      = "invoice_created"
+	ClientCreated       = "client_created"
+	MessageSent         = "message_sent"
+	ImageGenerated      = "image_generated"
+	SubscriptionChanged = "subscription_changed"
+)
+
+// Event is one unit of feature usage
+type Event struct {
+	UserID      string
+	FeatureType string
+	Count       int
+	Metadata    string
+	Timestamp   time.Time
+}
+
+// Pipeline buffers usage events and writes them in batches from a single
+// worker, so recording usage never blocks a request on the database.
+type Pipeline struct {
+	db            *gorm.DB
+	events        chan Event
+	batchSize     int
+	flushInterval time.Duration
+	dropped       atomic.Int64
+	done          chan struct{}
+
+	// closed is set under the write lock by Shutdown; Enqueue holds the read
+	// lock while sending so it never sends on the closed channel
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewPipeline creates a pipeline holding up to bufferSize pending events and
+// starts its worker
+func NewPipeline(db *gorm.DB, bufferSize, batchSize int, flushInterval time.Duration) *Pipeline {
+	p := &Pipeline{
+		db:            db,
+		events:        make(chan Event, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Enqueue adds an event without blocking. When the buffer is full, or the
+// pipeline has been shut down, the event is dropped and counted rather than
+// slowing the request down.
+func (p *Pipeline) Enqueue(event Event) {
+	if event.Count == 0 {
+		event.Count = 1
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		p.dropped.Add(1)
+		return
+	}
+	select {
+	case p.events <- event:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+// Shutdown stops accepting events and waits for the buffered ones to be
+// written, or for ctx to expire. Requests still running may keep calling
+// Enqueue; their events are dropped.
+func (p *Pipeline) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.events)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pipeline) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, p.batchSize)
+	for {
+		select {
+		case event, ok := <-p.events:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= p.batchSize {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			p.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush writes the raw log rows and folds the batch into the per-day counters
+// in one transaction
+func (p *Pipeline) flush(batch []Event) {
+	if dropped := p.dropped.Swap(0); dropped > 0 {
+		fmt.Printf("Usage pipeline buffer full, dropped %d events\n", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	logs := make([]models.UsageLog, len(batch))
+	totals := map[models.UsageCounter]int{}
+	for i, event := range batch {
+		logs[i] = models.UsageLog{
+			ID:          models.GenerateUsageLogID(),
+			UserID:      event.UserID,
+			FeatureType: event.FeatureType,
+			Count:       event.Count,
+			Metadata:    event.Metadata,
+			Timestamp:   event.Timestamp,
+		}
+		key := models.UsageCounter{
+			UserID:      event.UserID,
+			Day:         Day(event.Timestamp),
+			FeatureType: event.FeatureType,
+		}
+		totals[key] += event.Count
+	}
+
+	counters := make([]models.UsageCounter, 0, len(totals))
+	for key, count := range totals {
+		key.Count = count
+		counters = append(counters, key)
+	}
+
+	err := p.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(&logs, 100).Error; err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "day"}, {Name: "feature_type"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"count":      gorm.Expr("usage_counters.count + excluded.count"),
+				"updated_at": gorm.Expr("excluded.updated_at"),
+			}),
+		}).Create(&counters).Error
+	})
+	if err != nil {
+		fmt.Printf("Error writing %d usage events: %v\n", len(batch), err)
+	}
+}
+
+// Day truncates t to the UTC calendar day its usage is counted on
+func Day(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Totals sums the per-day counters for a user from since (inclusive) onwards
+func Totals(db *gorm.DB, userID string, since time.Time) (map[string]int, error) {
+	var rows []struct {
+		FeatureType string
+		Total       int
+	}
+	err := db.Model(&models.UsageCounter{}).
+		Select("feature_type, SUM(count) AS total").
+		Where("user_id = ? AND day >= ?", userID, Day(since)).
+		Group("feature_type").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[string]int{}
+	for _, row := range rows {
+		totals[row.FeatureType] = row.Total
+	}
+	return totals, nil
+}
+
+// Default is the pipeline used by the HTTP handlers, set up in main
+var Default *Pipeline
+
+// Track enqueues an event on the default pipeline
+func Track(event Event) {
+	if Default == nil {
+		return
+	}
+	Default.Enqueue(event)
+}
@@ -0,0 +1,130 @@
+package usage
+
+import (
+	"billow-backend/models"
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// pool stands in for the database; in dry-run mode no query reaches it
+type pool struct{ gorm.ConnPool }
+
+func (*pool) BeginTx(context.Context, *sql.TxOptions) (gorm.ConnPool, error) { return &txPool{}, nil }
+
+type txPool struct{ gorm.ConnPool }
+
+func (*txPool) Commit() error   { return nil }
+func (*txPool) Rollback() error { return nil }
+
+// writes collects what the pipeline inserts
+type writes struct {
+	mu       sync.Mutex
+	logs     []int // size of each batch of log rows
+	counters []models.UsageCounter
+}
+
+func (w *writes) batches() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]int(nil), w.logs...)
+}
+
+func recordingDB(t *testing.T) (*gorm.DB, *writes) {
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: &pool{}}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &writes{}
+	db.Callback().Create().After("gorm:create").Register("test:record", func(tx *gorm.DB) {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		switch rows := tx.Statement.Dest.(type) {
+		case *[]models.UsageLog:
+			w.logs = append(w.logs, len(*rows))
+		case []models.UsageLog:
+			w.logs = append(w.logs, len(rows))
+		case *[]models.UsageCounter:
+			w.counters = append(w.counters, *rows...)
+		}
+	})
+	return db, w
+}
+
+func TestPipelineBatchesAndFlushesOnShutdown(t *testing.T) {
+	db, w := recordingDB(t)
+	p := NewPipeline(db, 100, 3, time.Hour)
+
+	day := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		p.Enqueue(Event{UserID: "USR-1", FeatureType: InvoiceCreated, Timestamp: day})
+	}
+	p.Enqueue(Event{UserID: "USR-1", FeatureType: ClientCreated, Count: 2, Timestamp: day.Add(20 * time.Hour)})
+
+	// Two full batches are written without waiting for the ticker
+	deadline := time.Now().Add(time.Second)
+	for len(w.batches()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("batches written: %v", w.batches())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.batches(); len(got) != 3 || got[0] != 3 || got[1] != 3 || got[2] != 2 {
+		t.Errorf("batches written: %v, want [3 3 2]", got)
+	}
+
+	totals := map[string]int{}
+	for _, c := range w.counters {
+		totals[c.FeatureType+" "+c.Day.Format("2006-01-02")] += c.Count
+	}
+	if totals["invoice_created 2026-03-01"] != 7 || totals["client_created 2026-03-02"] != 2 || len(totals) != 2 {
+		t.Errorf("daily counters: %v", totals)
+	}
+}
+
+func TestPipelineDropsInsteadOfBlocking(t *testing.T) {
+	// No worker drains the buffer
+	p := &Pipeline{events: make(chan Event, 2), done: make(chan struct{})}
+	for i := 0; i < 5; i++ {
+		p.Enqueue(Event{UserID: "USR-1", FeatureType: InvoiceCreated})
+	}
+	if dropped := p.dropped.Load(); dropped != 3 {
+		t.Errorf("dropped %d events, want 3", dropped)
+	}
+}
+
+func TestPipelineEnqueueDuringShutdown(t *testing.T) {
+	db, _ := recordingDB(t)
+	p := NewPipeline(db, 10, 5, time.Hour)
+
+	// Requests still running when the server stops waiting for them
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				p.Enqueue(Event{UserID: "USR-1", FeatureType: InvoiceCreated})
+			}
+		}()
+	}
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	before := p.dropped.Load()
+	p.Enqueue(Event{UserID: "USR-1", FeatureType: InvoiceCreated})
+	if p.dropped.Load() != before+1 {
+		t.Error("event after shutdown wasn't counted as dropped")
+	}
+}
@@ -3,7 +3,7 @@ import { useUser } from '@clerk/clerk-react';
 import { Card } from '../ui/Card';
 import { Button } from '../ui/Button';
 import { Modal } from '../ui/Modal';
-import { Client, ClientRevenueData } from '../../types';
+import { Address, Client, ClientRevenueData } from '../../types';
 import api from '../../utils/api';
 import { Search, Plus, TrendingUp, Calendar, DollarSign, User, Mail, X, Building, AlertCircle } from 'lucide-react';
 
@@ -12,9 +12,30 @@ interface NewClient {
   email: string;
   phone?: string;
   company?: string;
-  address?: string;
+  billing_address: Address;
 }
 
+const emptyAddress: Address = {
+  line1: '',
+  line2: '',
+  city: '',
+  region: '',
+  postal_code: '',
+  country: ''
+};
+
+// addressLines formats an address for display, skipping empty parts
+const addressLines = (address?: Address) => {
+  if (!address) return [];
+  return [
+    address.line1,
+    address.line2,
+    `${address.postal_code} ${address.city}`.trim(),
+    address.region,
+    address.country
+  ].filter(line => line && line.trim() !== '');
+};
+
 export const Clients: React.FC = () => {
   const { user: clerkUser } = useUser();
   const [clients, setClients] = useState<Client[]>([]);
@@ -30,7 +51,7 @@ export const Clients: React.FC = () => {
     email: '',
     phone: '',
     company: '',
-    address: ''
+    billing_address: emptyAddress
   });
 
   // Configure axios to use Clerk ID
@@ -94,6 +115,13 @@ export const Clients: React.FC = () => {
     }));
   };
 
+  const handleAddressChange = (field: keyof Address, value: string) => {
+    setNewClient(prev => ({
+      ...prev,
+      billing_address: { ...prev.billing_address, [field]: value }
+    }));
+  };
+
   const showError = (message: string) => {
     setError(message);
     setTimeout(() => setError(null), 5000);
@@ -158,7 +186,7 @@ export const Clients: React.FC = () => {
       email: '',
       phone: '',
       company: '',
-      address: ''
+      billing_address: emptyAddress
     });
   };
 
@@ -431,7 +459,7 @@ export const Clients: React.FC = () => {
             </div>
 
             {/* Contact Information */}
-            {(selectedClient.phone || selectedClient.address) && (
+            {(selectedClient.phone || addressLines(selectedClient.billing_address).length > 0) && (
               <div className="pt-4 border-t border-gray-200 dark:border-gray-700">
                 <h4 className="text-lg font-semibold text-gray-900 dark:text-white mb-3">Contact Information</h4>
                 <div className="space-y-2">
@@ -441,10 +469,10 @@ export const Clients: React.FC = () => {
                       <span className="text-sm text-gray-900 dark:text-white">{selectedClient.phone}</span>
                     </div>
                   )}
-                  {selectedClient.address && (
+                  {addressLines(selectedClient.billing_address).length > 0 && (
                     <div className="flex items-start space-x-2">
-                      <span className="text-sm text-gray-600 dark:text-gray-400">Address:</span>
-                      <span className="text-sm text-gray-900 dark:text-white">{selectedClient.address}</span>
+                      <span className="text-sm text-gray-600 dark:text-gray-400">Billing address:</span>
+                      <span className="text-sm text-gray-900 dark:text-white whitespace-pre-line">{addressLines(selectedClient.billing_address).join('\n')}</span>
                     </div>
                   )}
                 </div>
@@ -537,17 +565,71 @@ export const Clients: React.FC = () => {
               />
             </div>
 
-            {/* Address */}
+            {/* Billing address */}
             <div className="md:col-span-2">
-              <label className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">
-                Address
+              <label className="block text-sm font-medium text-gray-700 dark:text-gray-300">
+                Billing Address
               </label>
-              <textarea
-                value={newClient.address}
-                onChange={(e) => handleInputChange('address', e.target.value)}
-                rows={3}
+            </div>
+            <div className="md:col-span-2">
+              <input
+                type="text"
+                aria-label="Address line 1"
+                value={newClient.billing_address.line1}
+                onChange={(e) => handleAddressChange('line1', e.target.value)}
+                className="w-full px-4 py-3 bg-white dark:bg-gray-800 border border-gray-300 dark:border-gray-600 rounded-xl focus:ring-2 focus:ring-blue-500 focus:border-blue-500 transition-colors"
+                placeholder="Street and number"
+              />
+            </div>
+            <div className="md:col-span-2">
+              <input
+                type="text"
+                aria-label="Address line 2"
+                value={newClient.billing_address.line2}
+                onChange={(e) => handleAddressChange('line2', e.target.value)}
+                className="w-full px-4 py-3 bg-white dark:bg-gray-800 border border-gray-300 dark:border-gray-600 rounded-xl focus:ring-2 focus:ring-blue-500 focus:border-blue-500 transition-colors"
+                placeholder="Suite, floor, building"
+              />
+            </div>
+            <div>
+              <input
+                type="text"
+                aria-label="Postal code"
+                value={newClient.billing_address.postal_code}
+                onChange={(e) => handleAddressChange('postal_code', e.target.value)}
+                className="w-full px-4 py-3 bg-white dark:bg-gray-800 border border-gray-300 dark:border-gray-600 rounded-xl focus:ring-2 focus:ring-blue-500 focus:border-blue-500 transition-colors"
+                placeholder="10115"
+              />
+            </div>
+            <div>
+              <input
+                type="text"
+                aria-label="City"
+                value={newClient.billing_address.city}
+                onChange={(e) => handleAddressChange('city', e.target.value)}
+                className="w-full px-4 py-3 bg-white dark:bg-gray-800 border border-gray-300 dark:border-gray-600 rounded-xl focus:ring-2 focus:ring-blue-500 focus:border-blue-500 transition-colors"
+                placeholder="Berlin"
+              />
+            </div>
+            <div>
+              <input
+                type="text"
+                aria-label="Region"
+                value={newClient.billing_address.region}
+                onChange={(e) => handleAddressChange('region', e.target.value)}
+                className="w-full px-4 py-3 bg-white dark:bg-gray-800 border border-gray-300 dark:border-gray-600 rounded-xl focus:ring-2 focus:ring-blue-500 focus:border-blue-500 transition-colors"
+                placeholder="State or province"
+              />
+            </div>
+            <div>
+              <input
+                type="text"
+                aria-label="Country"
+                value={newClient.billing_address.country}
+                onChange={(e) => handleAddressChange('country', e.target.value.toUpperCase())}
+                maxLength={2}
                 className="w-full px-4 py-3 bg-white dark:bg-gray-800 border border-gray-300 dark:border-gray-600 rounded-xl focus:ring-2 focus:ring-blue-500 focus:border-blue-500 transition-colors"
-                placeholder="Full address"
+                placeholder="Two-letter code, e.g. DE"
               />
             </div>
           </div>
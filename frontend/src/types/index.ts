@@ -8,17 +8,54 @@ export interface Invoice {
   currency_type: string;
   status: 'paid' | 'unpaid' | 'overdue' | 'processing';
   due_date: string;
+  payment_terms?: PaymentTerms;
+  tax_rates?: TaxRate[];
+  notes?: string;
+  language?: string;
   created_at?: string;
   updated_at?: string;
 }
 
+export type PaymentTerms = 'due_on_receipt' | 'net_7' | 'net_15' | 'net_30' | 'net_45' | 'net_60' | 'eom';
+
+export interface TaxRate {
+  label: string;
+  rate: number; // percent
+}
+
+export interface Address {
+  line1: string;
+  line2: string;
+  city: string;
+  region: string;
+  postal_code: string;
+  country: string; // ISO 3166-1 alpha-2
+}
+
+export interface ClientContact {
+  id: string;
+  client_id: string;
+  name: string;
+  role: string;
+  email: string;
+  phone: string;
+  is_billing_contact: boolean;
+}
+
 export interface Client {
   id: string;
   name: string;
   email: string;
   phone?: string;
   company?: string;
-  address?: string;
+  billing_address?: Address;
+  shipping_address?: Address;
+  contacts?: ClientContact[];
+  language?: string;
+  default_currency?: string;
+  payment_terms?: PaymentTerms;
+  default_tax_rates?: TaxRate[];
+  default_notes?: string;
   total_invoiced: number;
   total_paid: number;
   invoice_count: number;